@@ -0,0 +1,361 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminController es la consola de administración: lo que el soporte hace con las
+// cuentas sin tocar la base de datos. Cada acción queda en el registro de auditoría.
+type AdminController struct {
+	DB *sql.DB
+}
+
+// ListUsers godoc
+// @Summary Buscar usuarios
+// @Description Lista los usuarios por páginas, sin contraseñas, con sus roles y si están desactivados. q busca en el email y en el nombre. Necesita users:view.
+// @Tags admin
+// @Produce json
+// @Param q query string false "Texto a buscar en email o nombre"
+// @Param page query int false "Página, desde 1"
+// @Param page_size query int false "Usuarios por página (20 por defecto, 100 como mucho)"
+// @Success 200 {object} models.UserList
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /admin/users [get]
+func (ac *AdminController) ListUsers(c *gin.Context) {
+	page, err := services.ParsePage(c.Query("page"), c.Query("page_size"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userModel := models.UserModel{DB: ac.DB}
+	users, total, err := userModel.Search(c.Query("q"), page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading users"})
+		return
+	}
+	c.JSON(http.StatusOK, models.UserList{Users: users, Total: total, Page: page})
+}
+
+// DisableUser godoc
+// @Summary Desactivar una cuenta
+// @Description La cuenta deja de poder entrar de ninguna forma y se cierran todas sus sesiones; sus PAT no valen mientras esté desactivada. No se puede desactivar la propia cuenta ni la del último owner. Necesita users:disable.
+// @Tags admin
+// @Produce json
+// @Param id path int true "ID del usuario"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /admin/users/{id}/disable [post]
+func (ac *AdminController) DisableUser(c *gin.Context) {
+	user, ok := adminTarget(c, ac.DB, "No tienes permisos para desactivar este usuario")
+	if !ok {
+		return
+	}
+	if user.Id == c.GetInt("userID") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No puedes desactivar tu propia cuenta"})
+		return
+	}
+	roleModel := models.RoleModel{DB: ac.DB}
+	lastOwner, err := roleModel.IsLastOwner(user.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if lastOwner {
+		c.JSON(http.StatusConflict, gin.H{"error": models.ErrLastOwner.Error()})
+		return
+	}
+
+	userModel := models.UserModel{DB: ac.DB}
+	if err := userModel.SetDisabled(user.Id, true); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error disabling user"})
+		return
+	}
+	recordAudit(c, ac.DB, models.AuditUserDisabled, user.Id, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "User disabled"})
+}
+
+// EnableUser godoc
+// @Summary Reactivar una cuenta
+// @Description Vuelve a dejar entrar a una cuenta desactivada. Necesita users:disable.
+// @Tags admin
+// @Produce json
+// @Param id path int true "ID del usuario"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /admin/users/{id}/enable [post]
+func (ac *AdminController) EnableUser(c *gin.Context) {
+	user, ok := adminTarget(c, ac.DB, "No tienes permisos para reactivar este usuario")
+	if !ok {
+		return
+	}
+	userModel := models.UserModel{DB: ac.DB}
+	if err := userModel.SetDisabled(user.Id, false); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error enabling user"})
+		return
+	}
+	recordAudit(c, ac.DB, models.AuditUserEnabled, user.Id, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "User enabled"})
+}
+
+// ForceLogout godoc
+// @Summary Cerrar todas las sesiones de un usuario
+// @Description Revoca todas sus sesiones: sus tokens dejan de valer al momento. Los PAT no son sesiones y siguen valiendo. Necesita sessions:revoke.
+// @Tags admin
+// @Produce json
+// @Param id path int true "ID del usuario"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /admin/users/{id}/logout [post]
+func (ac *AdminController) ForceLogout(c *gin.Context) {
+	user, ok := adminTarget(c, ac.DB, "No tienes permisos para cerrar las sesiones de este usuario")
+	if !ok {
+		return
+	}
+	sessionModel := models.SessionModel{DB: ac.DB}
+	if err := sessionModel.RevokeAll(user.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error closing sessions"})
+		return
+	}
+	recordAudit(c, ac.DB, models.AuditUserLoggedOut, user.Id, nil)
+	if user.Id == c.GetInt("userID") {
+		clearSessionCookies(c)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Sessions closed"})
+}
+
+// RequirePasswordChange godoc
+// @Summary Obligar a cambiar la contraseña
+// @Description Cierra las sesiones del usuario y su próximo login con contraseña no abre sesión hasta que elija una nueva (password_change_required). Las bóvedas zero-knowledge no se pueden cambiar así: 409. Necesita users:reset_credentials.
+// @Tags admin
+// @Produce json
+// @Param id path int true "ID del usuario"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /admin/users/{id}/require-password-change [post]
+func (ac *AdminController) RequirePasswordChange(c *gin.Context) {
+	user, ok := adminTarget(c, ac.DB, "No tienes permisos para cambiar las credenciales de este usuario")
+	if !ok {
+		return
+	}
+	// La contraseña maestra de una bóveda zero-knowledge cifra la bóveda: el servidor no la puede cambiar
+	if user.VaultMode == models.VaultModeZeroKnowledge {
+		c.JSON(http.StatusConflict, gin.H{"error": "Zero-knowledge vaults cannot be forced to change the password"})
+		return
+	}
+	userModel := models.UserModel{DB: ac.DB}
+	if err := userModel.RequirePasswordChange(user.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error requiring password change"})
+		return
+	}
+	recordAudit(c, ac.DB, models.AuditPasswordChangeRequired, user.Id, nil)
+	c.JSON(http.StatusOK, gin.H{"message": "The user must change the password at the next login"})
+}
+
+// ResetMFA godoc
+// @Summary Quitar el 2FA de un usuario
+// @Description Borra su secreto TOTP y sus códigos de recuperación, para quien ha perdido el móvil. Con MFA_REQUIRED tendrá que darlo de alta otra vez. Necesita users:reset_credentials.
+// @Tags admin
+// @Produce json
+// @Param id path int true "ID del usuario"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /admin/users/{id}/reset-2fa [post]
+func (ac *AdminController) ResetMFA(c *gin.Context) {
+	user, ok := adminTarget(c, ac.DB, "No tienes permisos para cambiar las credenciales de este usuario")
+	if !ok {
+		return
+	}
+	mfaModel := models.MFAModel{DB: ac.DB}
+	if err := mfaModel.Reset(user.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resetting 2FA"})
+		return
+	}
+	recordAudit(c, ac.DB, models.AuditMFAReset, user.Id, gin.H{"totp_was_enabled": user.TOTPEnabled})
+	c.JSON(http.StatusOK, gin.H{"message": "2FA removed"})
+}
+
+// PromoteAdmin godoc
+// @Summary Hacer admin a un usuario
+// @Description Le da el rol admin. Como en /users/{id}/roles, hay que tener todos los permisos del rol. Necesita roles:assign.
+// @Tags admin
+// @Produce json
+// @Param id path int true "ID del usuario"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /admin/users/{id}/admin [put]
+func (ac *AdminController) PromoteAdmin(c *gin.Context) {
+	user, ok := adminTarget(c, ac.DB, "No tienes permisos para cambiar los roles de este usuario")
+	if !ok || !authorizeRoleChange(c, ac.DB, []string{services.RoleAdmin}) {
+		return
+	}
+	roleModel := models.RoleModel{DB: ac.DB}
+	if err := roleModel.GrantRole(user.Id, services.RoleAdmin); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error granting role"})
+		return
+	}
+	recordAudit(c, ac.DB, models.AuditRolesChanged, user.Id, gin.H{"granted": []string{services.RoleAdmin}})
+	c.JSON(http.StatusOK, gin.H{"message": "User promoted to admin"})
+}
+
+// DemoteAdmin godoc
+// @Summary Quitar el admin a un usuario
+// @Description Le quita el rol admin; el resto de roles no cambia. Necesita roles:assign y tener todos los permisos del usuario y del rol.
+// @Tags admin
+// @Produce json
+// @Param id path int true "ID del usuario"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /admin/users/{id}/admin [delete]
+func (ac *AdminController) DemoteAdmin(c *gin.Context) {
+	user, ok := adminTarget(c, ac.DB, "No tienes permisos para cambiar los roles de este usuario")
+	if !ok || !authorizeRoleChange(c, ac.DB, []string{services.RoleAdmin}) {
+		return
+	}
+	roleModel := models.RoleModel{DB: ac.DB}
+	if err := roleModel.RevokeRole(user.Id, services.RoleAdmin); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error revoking role"})
+		return
+	}
+	recordAudit(c, ac.DB, models.AuditRolesChanged, user.Id, gin.H{"revoked": []string{services.RoleAdmin}})
+	c.JSON(http.StatusOK, gin.H{"message": "Admin role removed"})
+}
+
+// GetAuditLog godoc
+// @Summary Registro de auditoría
+// @Description Lo que ha hecho cada administrador, lo más reciente primero y por páginas. Se puede filtrar por quién, sobre quién y qué acción. Necesita audit:view.
+// @Tags admin
+// @Produce json
+// @Param actor_id query int false "ID de quien hizo la acción"
+// @Param target_user_id query int false "ID del usuario afectado"
+// @Param action query string false "Acción, por ejemplo user.disable"
+// @Param page query int false "Página, desde 1"
+// @Param page_size query int false "Entradas por página (20 por defecto, 100 como mucho)"
+// @Success 200 {object} models.AuditList
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /admin/audit [get]
+func (ac *AdminController) GetAuditLog(c *gin.Context) {
+	page, err := services.ParsePage(c.Query("page"), c.Query("page_size"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter := models.AuditFilter{Action: c.Query("action")}
+	var ok bool
+	if filter.ActorID, ok = queryUserID(c, "actor_id"); !ok {
+		return
+	}
+	if filter.TargetUserID, ok = queryUserID(c, "target_user_id"); !ok {
+		return
+	}
+
+	auditModel := models.AuditModel{DB: ac.DB}
+	entries, total, err := auditModel.List(filter, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading audit log"})
+		return
+	}
+	c.JSON(http.StatusOK, models.AuditList{Entries: entries, Total: total, Page: page})
+}
+
+// adminTarget carga el usuario del :id de la ruta y comprueba que quien hace la
+// petición puede gestionarlo (ver authorizeTarget). Si no responde y devuelve false.
+func adminTarget(c *gin.Context, db *sql.DB, message string) (*models.User, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return nil, false
+	}
+	userModel := models.UserModel{DB: db}
+	user, err := userModel.GetByID(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Usuario no encontrado"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return nil, false
+	}
+	if !authorizeTarget(c, db, user.Id, message) {
+		return nil, false
+	}
+	return user, true
+}
+
+// queryUserID lee un ID de usuario opcional de la query (0 si no viene). Si no es
+// válido responde 400 y devuelve false.
+func queryUserID(c *gin.Context, param string) (int, bool) {
+	value := c.Query(param)
+	if value == "" {
+		return 0, true
+	}
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be a user ID"})
+		return 0, false
+	}
+	return id, true
+}
+
+// recordAudit apunta en el registro de auditoría la acción de quien hace la petición.
+// La acción ya está hecha: si falla el registro se queda al menos en el log.
+func recordAudit(c *gin.Context, db *sql.DB, action string, targetUserID int, details any) {
+	auditModel := models.AuditModel{DB: db}
+	actorID := c.GetInt("userID")
+	if err := auditModel.Record(actorID, action, targetUserID, details, c.ClientIP()); err != nil {
+		log.Printf("Error recording audit %s by user %d on user %d: %v", action, actorID, targetUserID, err)
+	}
+}
+
+// abortIfDisabled corta el login de una cuenta desactivada. Va después de comprobar
+// las credenciales: a quien no las tiene no se le dice si la cuenta existe.
+func abortIfDisabled(c *gin.Context, user *models.User) bool {
+	if !user.Disabled {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled", "account_disabled": true})
+	return true
+}
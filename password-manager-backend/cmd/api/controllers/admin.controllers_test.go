@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// serveAdmin hace POST /admin/users/:id/<action> como el usuario 10 con los permisos dados
+func serveAdmin(id string, permissions []string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/admin/users/:id/disable", func(c *gin.Context) {
+		c.Set("userID", 10)
+		c.Set("permissions", permissions)
+	}, handler)
+	req := httptest.NewRequest(http.MethodPost, "/admin/users/"+id+"/disable", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+var userColumns = []string{"id", "email", "username", "icon", "roles", "password", "vault_mode",
+	"token_version", "totp_enabled", "email_verified", "disabled", "must_change_password"}
+
+// expectTarget espera la carga del usuario 7 y de sus permisos
+func expectTarget(mock sqlmock.Sqlmock, permissions ...string) {
+	mock.ExpectQuery(q("FROM users WHERE id = ?")).WithArgs(7).WillReturnRows(sqlmock.NewRows(userColumns).
+		AddRow(7, "luis@example.com", "luis", "", "user", "hash", models.VaultModeServer, 1, false, true, false, false))
+	rows := sqlmock.NewRows([]string{"permission"})
+	for _, permission := range permissions {
+		rows.AddRow(permission)
+	}
+	mock.ExpectQuery(q("SELECT DISTINCT rp.permission FROM user_roles")).WithArgs(7).WillReturnRows(rows)
+}
+
+func TestDisableUserRecordsAudit(t *testing.T) {
+	db, mock := newMockDB(t)
+	ac := AdminController{DB: db}
+
+	expectTarget(mock)
+	mock.ExpectQuery(q("SELECT COUNT(*), COALESCE(SUM(ur.user_id = ?), 0) FROM user_roles")).
+		WillReturnRows(sqlmock.NewRows([]string{"owners", "is_owner"}).AddRow(1, 0))
+	mock.ExpectBegin()
+	mock.ExpectExec(q("UPDATE users SET disabled_at = COALESCE(disabled_at, CURRENT_TIMESTAMP) WHERE id = ?")).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ?")).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("DELETE FROM sessions WHERE user_id = ?")).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// Quién, qué, a quién y desde dónde
+	mock.ExpectExec(q("INSERT INTO audit_log")).
+		WithArgs(10, models.AuditUserDisabled, 7, []byte(nil), "192.0.2.1").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	w := serveAdmin("7", []string{services.PermUsersDisable}, ac.DisableUser)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDisableUserDeniedRecordsNothing(t *testing.T) {
+	db, mock := newMockDB(t)
+	ac := AdminController{DB: db}
+
+	// El objetivo tiene permisos que el admin no tiene: ni se desactiva ni se audita
+	expectTarget(mock, services.PermRolesAssign)
+
+	w := serveAdmin("7", []string{services.PermUsersDisable}, ac.DisableUser)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EmailVerificationController struct {
+	DB *sql.DB
+}
+
+// VerifyEmail godoc
+// @Summary Confirmar un email
+// @Description Gasta el token del correo de verificación. Con el del registro la cuenta queda verificada; con el de un cambio de email, la cuenta pasa a usar el email nuevo. El token solo vale una vez y caduca en EMAIL_VERIFICATION_TTL (24 horas por defecto).
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param token body models.VerifyEmailRequest true "Token del correo"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/auth/verify-email [post]
+func (ec *EmailVerificationController) VerifyEmail(c *gin.Context) {
+	var body models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No body sent or malformed body"})
+		return
+	}
+
+	verificationModel := models.EmailVerificationModel{DB: ec.DB}
+	verification, err := verificationModel.Confirm(body.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrEmailVerificationInvalid):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, models.ErrEmailTaken):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error verifying email"})
+		}
+		return
+	}
+
+	if verification.Purpose == services.EmailVerificationPurposeChange {
+		log.Printf("Email of user %d changed after confirmation", verification.UserId)
+		c.JSON(http.StatusOK, gin.H{"message": "Email changed", "email": verification.Email})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified", "email": verification.Email})
+}
+
+// ResendVerification godoc
+// @Summary Reenviar el correo de verificación
+// @Description Envía otro enlace para verificar el email de la cuenta. La respuesta es la misma esté o no registrado el email, y como mucho sale un correo por minuto.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param email body models.ResendVerificationRequest true "Email de la cuenta"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/auth/resend-verification [post]
+func (ec *EmailVerificationController) ResendVerification(c *gin.Context) {
+	var body models.ResendVerificationRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No body sent or malformed body"})
+		return
+	}
+	accepted := gin.H{"message": "If the email is registered and not verified yet, a new link is on its way"}
+
+	userModel := models.UserModel{DB: ec.DB}
+	user, err := userModel.GetUserFromEmail(body.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error requesting email verification"})
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	if err := sendEmailVerification(ec.DB, user); err != nil && !errors.Is(err, models.ErrEmailVerificationTooSoon) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error requesting email verification"})
+		return
+	}
+	c.JSON(http.StatusAccepted, accepted)
+}
+
+// sendEmailVerification crea el enlace de verificación del email de la cuenta y lo envía
+func sendEmailVerification(db *sql.DB, user *models.User) error {
+	verificationModel := models.EmailVerificationModel{DB: db}
+	token, err := verificationModel.Create(user.Id, user.Email, services.EmailVerificationPurposeVerify)
+	if err != nil {
+		return err
+	}
+	sendMailInBackground(user.Id, services.EmailVerificationMail(user.Email, token))
+	return nil
+}
+
+// requestEmailChange deja pendiente el email nuevo: el enlace va al nuevo y el
+// actual recibe un aviso. Nada cambia hasta que se confirma con VerifyEmail.
+func requestEmailChange(db *sql.DB, user *models.User, newEmail string) error {
+	verificationModel := models.EmailVerificationModel{DB: db}
+	token, err := verificationModel.Create(user.Id, newEmail, services.EmailVerificationPurposeChange)
+	if err != nil {
+		return err
+	}
+	sendMailInBackground(user.Id, services.EmailChangeMail(newEmail, token))
+	sendMailInBackground(user.Id, services.EmailChangeNoticeMail(user.Email, newEmail))
+	return nil
+}
+
+// sendMailInBackground envía sin hacer esperar a la respuesta: lo que tarda el
+// SMTP delataría si el email existe
+func sendMailInBackground(userID int, mail services.Mail) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := services.GetMailer().Send(ctx, mail); err != nil {
+			log.Printf("Error sending mail %q to user %d: %v", mail.Subject, userID, err)
+		}
+	}()
+}
+
+// abortIfEmailNotVerified corta el login de una cuenta sin el email verificado si
+// REQUIRE_VERIFIED_EMAIL lo exige. Va después de comprobar la credencial para no
+// delatar qué cuentas existen.
+func abortIfEmailNotVerified(c *gin.Context, user *models.User) bool {
+	if user.EmailVerified || !services.RequireVerifiedEmail() {
+		return false
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":                       "Email not verified",
+		"email_verification_required": true,
+	})
+	return true
+}
@@ -0,0 +1,425 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type EmergencyController struct {
+	DB *sql.DB
+}
+
+// Quién hace la petición respecto al acceso de emergencia
+const (
+	emergencyAsGrantor = "grantor"
+	emergencyAsGrantee = "grantee"
+	emergencyAsEither  = "either"
+)
+
+// loadEmergencyAccess busca el acceso del parámetro :id y comprueba que el usuario
+// logueado sea la parte indicada. Si algo falla ya ha respondido y devuelve nil.
+func (ec *EmergencyController) loadEmergencyAccess(c *gin.Context, as string) *models.EmergencyAccess {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return nil
+	}
+
+	emergencyModel := models.EmergencyAccessModel{DB: ec.DB}
+	access, err := emergencyModel.GetByID(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Acceso de emergencia no encontrado"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return nil
+	}
+
+	userID := c.GetInt("userID")
+	isGrantor := access.GrantorId == userID
+	isGrantee := access.GranteeId == userID
+	if (as == emergencyAsGrantor && !isGrantor) || (as == emergencyAsGrantee && !isGrantee) ||
+		(as == emergencyAsEither && !isGrantor && !isGrantee) {
+		// 404 para no desvelar accesos de otros usuarios
+		c.JSON(http.StatusNotFound, gin.H{"error": "Acceso de emergencia no encontrado"})
+		return nil
+	}
+	return access
+}
+
+// transition aplica el cambio de estado y responde con el acceso actualizado
+func (ec *EmergencyController) transition(c *gin.Context, as string, to string) {
+	access := ec.loadEmergencyAccess(c, as)
+	if access == nil {
+		return
+	}
+
+	emergencyModel := models.EmergencyAccessModel{DB: ec.DB}
+	if err := emergencyModel.Transition(access, to); err != nil {
+		if errors.Is(err, models.ErrEmergencyTransition) {
+			c.JSON(http.StatusConflict, gin.H{"error": "No se puede pasar de " + access.Status + " a " + to})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	access, err := emergencyModel.GetByID(access.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, access)
+}
+
+// InviteEmergencyContact godoc
+// @Summary Nombrar un contacto de emergencia
+// @Description Invita a otro usuario registrado como contacto de emergencia con acceso view o takeover. Si pide acceso y no lo rechazas en wait_days días se le concede.
+// @Tags emergency
+// @Accept json
+// @Produce json
+// @Param contact body models.InviteEmergencyContactRequest true "Email del contacto, tipo de acceso y días de espera"
+// @Success 201 {object} models.EmergencyAccess
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /emergency [post]
+func (ec *EmergencyController) InviteEmergencyContact(c *gin.Context) {
+	var body models.InviteEmergencyContactRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// En zero-knowledge no podemos cambiar la contraseña sin perder la bóveda
+	if body.AccessType == services.EmergencyAccessTakeover && c.GetString("vaultMode") == models.VaultModeZeroKnowledge {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Takeover is not available for zero_knowledge vaults"})
+		return
+	}
+
+	userModel := models.UserModel{DB: ec.DB}
+	grantee, err := userModel.GetUserFromEmail(body.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Usuario no encontrado"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	userID := c.GetInt("userID")
+	if grantee.Id == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No puedes ser tu propio contacto de emergencia"})
+		return
+	}
+
+	waitDays := body.WaitDays
+	if waitDays == 0 {
+		waitDays = services.DefaultEmergencyWaitDays()
+	}
+
+	emergencyModel := models.EmergencyAccessModel{DB: ec.DB}
+	access := models.EmergencyAccess{
+		GrantorId:  userID,
+		GranteeId:  grantee.Id,
+		AccessType: body.AccessType,
+		WaitDays:   waitDays,
+	}
+	if err := emergencyModel.Insert(&access); err != nil {
+		if errors.Is(err, models.ErrEmergencyExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	created, err := emergencyModel.GetByID(access.Id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, created)
+}
+
+// GetEmergencyContacts godoc
+// @Summary Mis contactos de emergencia
+// @Description Lista los usuarios que has nombrado contacto de emergencia y el estado de cada uno
+// @Tags emergency
+// @Produce json
+// @Success 200 {array} models.EmergencyAccess
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /emergency/contacts [get]
+func (ec *EmergencyController) GetEmergencyContacts(c *gin.Context) {
+	emergencyModel := models.EmergencyAccessModel{DB: ec.DB}
+	accesses, err := emergencyModel.GetByGrantor(c.GetInt("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, accesses)
+}
+
+// GetEmergencyGrantors godoc
+// @Summary Usuarios que confían en mí
+// @Description Lista los usuarios que te han nombrado contacto de emergencia
+// @Tags emergency
+// @Produce json
+// @Success 200 {array} models.EmergencyAccess
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /emergency/grantors [get]
+func (ec *EmergencyController) GetEmergencyGrantors(c *gin.Context) {
+	emergencyModel := models.EmergencyAccessModel{DB: ec.DB}
+	accesses, err := emergencyModel.GetByGrantee(c.GetInt("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, accesses)
+}
+
+// AcceptEmergencyInvite godoc
+// @Summary Aceptar una invitación de emergencia
+// @Description El contacto acepta ser contacto de emergencia (invited -> accepted)
+// @Tags emergency
+// @Produce json
+// @Param id path int true "ID del acceso de emergencia"
+// @Success 200 {object} models.EmergencyAccess
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /emergency/{id}/accept [post]
+func (ec *EmergencyController) AcceptEmergencyInvite(c *gin.Context) {
+	ec.transition(c, emergencyAsGrantee, services.EmergencyAccepted)
+}
+
+// RequestEmergencyAccess godoc
+// @Summary Pedir acceso de emergencia
+// @Description El contacto pide acceso a las notas del dueño; empieza la cuenta atrás de wait_days
+// @Tags emergency
+// @Produce json
+// @Param id path int true "ID del acceso de emergencia"
+// @Success 200 {object} models.EmergencyAccess
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /emergency/{id}/request [post]
+func (ec *EmergencyController) RequestEmergencyAccess(c *gin.Context) {
+	ec.transition(c, emergencyAsGrantee, services.EmergencyRequested)
+}
+
+// ApproveEmergencyAccess godoc
+// @Summary Conceder el acceso sin esperar
+// @Description El dueño concede el acceso pedido antes de que termine la espera
+// @Tags emergency
+// @Produce json
+// @Param id path int true "ID del acceso de emergencia"
+// @Success 200 {object} models.EmergencyAccess
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /emergency/{id}/approve [post]
+func (ec *EmergencyController) ApproveEmergencyAccess(c *gin.Context) {
+	ec.transition(c, emergencyAsGrantor, services.EmergencyGranted)
+}
+
+// RejectEmergencyAccess godoc
+// @Summary Rechazar o retirar el acceso
+// @Description El dueño rechaza una petición pendiente o retira un acceso ya concedido
+// @Tags emergency
+// @Produce json
+// @Param id path int true "ID del acceso de emergencia"
+// @Success 200 {object} models.EmergencyAccess
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /emergency/{id}/reject [post]
+func (ec *EmergencyController) RejectEmergencyAccess(c *gin.Context) {
+	ec.transition(c, emergencyAsGrantor, services.EmergencyRejected)
+}
+
+// DeleteEmergencyAccess godoc
+// @Summary Eliminar un contacto de emergencia
+// @Description Cualquiera de las dos partes puede borrar la relación
+// @Tags emergency
+// @Produce json
+// @Param id path int true "ID del acceso de emergencia"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /emergency/{id} [delete]
+func (ec *EmergencyController) DeleteEmergencyAccess(c *gin.Context) {
+	access := ec.loadEmergencyAccess(c, emergencyAsEither)
+	if access == nil {
+		return
+	}
+
+	emergencyModel := models.EmergencyAccessModel{DB: ec.DB}
+	if err := emergencyModel.DeleteByID(access.Id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Acceso de emergencia eliminado"})
+}
+
+// loadGrantedAccess carga un acceso del que el usuario es contacto y que ya está concedido
+func (ec *EmergencyController) loadGrantedAccess(c *gin.Context) *models.EmergencyAccess {
+	access := ec.loadEmergencyAccess(c, emergencyAsGrantee)
+	if access == nil {
+		return nil
+	}
+	if access.Status != services.EmergencyGranted {
+		c.JSON(http.StatusForbidden, gin.H{"error": "El acceso de emergencia no está concedido"})
+		return nil
+	}
+	return access
+}
+
+// GetEmergencyNotes godoc
+// @Summary Notas del dueño
+// @Description Devuelve las notas del dueño a un contacto con el acceso concedido
+// @Tags emergency
+// @Produce json
+// @Param id path int true "ID del acceso de emergencia"
+// @Success 200 {array} models.Notes
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /emergency/{id}/notes [get]
+func (ec *EmergencyController) GetEmergencyNotes(c *gin.Context) {
+	access := ec.loadGrantedAccess(c)
+	if access == nil {
+		return
+	}
+
+	notesModel := models.NotesModel{DB: ec.DB}
+	notes, err := notesModel.GetByUserID(access.GrantorId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, notes)
+}
+
+// GetEmergencyNoteSecret godoc
+// @Summary Revelar la contraseña de una nota del dueño
+// @Description Descifra la contraseña de una nota del dueño para un contacto con el acceso concedido
+// @Tags emergency
+// @Produce json
+// @Param id path int true "ID del acceso de emergencia"
+// @Param noteId path int true "ID de la nota"
+// @Success 200 {object} models.NoteSecretResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /emergency/{id}/notes/{noteId}/secret [get]
+func (ec *EmergencyController) GetEmergencyNoteSecret(c *gin.Context) {
+	access := ec.loadGrantedAccess(c)
+	if access == nil {
+		return
+	}
+
+	noteID, err := strconv.Atoi(c.Param("noteId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	notesModel := models.NotesModel{DB: ec.DB}
+	note, err := notesModel.GetByID(noteID)
+	if err != nil || note.UserId != access.GrantorId {
+		if err == nil || errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Nota no encontrada"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if !note.HasPassword {
+		c.JSON(http.StatusNotFound, gin.H{"error": "La nota no tiene contraseña"})
+		return
+	}
+
+	password, err := notesModel.RevealPassword(note)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo descifrar la contraseña"})
+		return
+	}
+	defer password.Destroy()
+	log.Printf("Emergency access %d: user %d revealed note %d", access.Id, access.GranteeId, note.Id)
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, models.NoteSecretResponse{NoteID: note.Id, Password: password.Exposed()})
+}
+
+// EmergencyTakeover godoc
+// @Summary Tomar el control de la cuenta
+// @Description Con un acceso takeover concedido, cambia la contraseña del dueño, le quita el TOTP y revoca sus tokens. El acceso queda en taken_over y no se puede volver a usar.
+// @Tags emergency
+// @Accept json
+// @Produce json
+// @Param id path int true "ID del acceso de emergencia"
+// @Param takeover body models.EmergencyTakeoverRequest true "Contraseña nueva del dueño"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /emergency/{id}/takeover [post]
+func (ec *EmergencyController) EmergencyTakeover(c *gin.Context) {
+	var body models.EmergencyTakeoverRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer body.NewPassword.Destroy()
+	if err := body.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	access := ec.loadGrantedAccess(c)
+	if access == nil {
+		return
+	}
+	if access.AccessType != services.EmergencyAccessTakeover {
+		c.JSON(http.StatusForbidden, gin.H{"error": "El acceso de emergencia es solo de lectura"})
+		return
+	}
+
+	hashedPassword, err := services.HashPassword(body.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong hashing the password"})
+		return
+	}
+
+	emergencyModel := models.EmergencyAccessModel{DB: ec.DB}
+	if err := emergencyModel.Takeover(access, hashedPassword); err != nil {
+		if errors.Is(err, models.ErrEmergencyTransition) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "El acceso de emergencia no está concedido"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	log.Printf("Emergency access %d: user %d took over account %d", access.Id, access.GranteeId, access.GrantorId)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Contraseña del dueño cambiada y sesiones revocadas"})
+}
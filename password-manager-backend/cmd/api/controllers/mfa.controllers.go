@@ -0,0 +1,321 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFAController struct {
+	DB *sql.DB
+}
+
+// VerifyMFA godoc
+// @Summary Segundo paso del login
+// @Description Canjea el mfa_token del login por una sesión con un código de la app de autenticación o con uno de los códigos de recuperación, que solo valen una vez. Si hay que cambiar la contraseña devuelve password_change_required como /users/login.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param mfa body models.MFALoginRequest true "Token del primer paso y código"
+// @Success 202 {object} map[string]interface{}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/auth/mfa [post]
+func (mc *MFAController) VerifyMFA(c *gin.Context) {
+	var body models.MFALoginRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No body sent or malformed body"})
+		return
+	}
+
+	claims, err := models.DecodificarMFAToken(body.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	userModel := models.UserModel{DB: mc.DB}
+	user, err := userModel.GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no encontrado"})
+		return
+	}
+	// Un cambio de contraseña entre los dos pasos invalida el token del primero
+	if claims.TokenVersion != user.TokenVersion || !user.TOTPEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token revocado"})
+		return
+	}
+
+	// Un código de 6 cifras se adivina a base de intentos: mismo limitador que el login
+	throttleModel := models.ThrottleModel{DB: mc.DB}
+	throttleKeys := mfaThrottleKeys(c, user.Id)
+	if abortIfThrottled(c, &throttleModel, throttleKeys...) {
+		return
+	}
+
+	mfaModel := models.MFAModel{DB: mc.DB}
+	usedRecoveryCode := body.Code == ""
+	if usedRecoveryCode {
+		err = mfaModel.UseRecoveryCode(user.Id, body.RecoveryCode)
+	} else {
+		err = mfaModel.Verify(user.Id, body.Code)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrMFAInvalidCode) {
+			recordFailedAttempt(&throttleModel, throttleKeys...)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error verifying MFA code"})
+		}
+		return
+	}
+	if err := throttleModel.Reset(throttleKeys[0]); err != nil {
+		log.Printf("Error resetting MFA attempts of user %d: %v", user.Id, err)
+	}
+	if abortIfDisabled(c, user) {
+		return
+	}
+	if user.MustChangePassword {
+		respondPasswordChangeRequired(c, user)
+		return
+	}
+
+	tokens, err := openSession(c, mc.DB, user, true, body.SessionMode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo generar el token"})
+		return
+	}
+	// Avisar de cuántos códigos quedan para que el usuario regenere a tiempo
+	if usedRecoveryCode {
+		if left, err := mfaModel.RemainingRecoveryCodes(user.Id); err == nil {
+			tokens["recovery_codes_left"] = left
+		}
+	}
+	user.Password = ""
+	tokens["user"] = user
+	c.JSON(http.StatusAccepted, tokens)
+}
+
+// EnrollTOTP godoc
+// @Summary Empezar el alta del 2FA
+// @Description Genera un secreto TOTP y su URI otpauth:// para la app de autenticación. El 2FA no se activa hasta confirmar el primer código; repetir el alta antes de confirmar sustituye el secreto.
+// @Tags 2fa
+// @Produce json
+// @Success 200 {object} models.MFAEnrollResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/2fa/enroll [post]
+func (mc *MFAController) EnrollTOTP(c *gin.Context) {
+	userModel := models.UserModel{DB: mc.DB}
+	user, err := userModel.GetByID(c.GetInt("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding user"})
+		return
+	}
+
+	mfaModel := models.MFAModel{DB: mc.DB}
+	secret, err := mfaModel.Enroll(user.Id)
+	if err != nil {
+		if errors.Is(err, models.ErrMFAAlreadyEnabled) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error enrolling TOTP"})
+		}
+		return
+	}
+	defer secret.Destroy()
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, models.MFAEnrollResponse{
+		Secret:     services.EncodeTOTPSecret(secret),
+		OtpauthURI: services.TOTPProvisioningURI(secret, services.TOTPIssuer(), user.Email),
+	})
+}
+
+// ConfirmTOTP godoc
+// @Summary Confirmar el alta del 2FA
+// @Description Activa el 2FA con el primer código de la app y devuelve los códigos de recuperación, que solo se enseñan esta vez. Cierra el resto de sesiones del usuario y abre una nueva con segundo factor.
+// @Tags 2fa
+// @Accept json
+// @Produce json
+// @Param code body models.MFACodeRequest true "Código de la app"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/2fa/confirm [post]
+func (mc *MFAController) ConfirmTOTP(c *gin.Context) {
+	var body models.MFACodeRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetInt("userID")
+	mfaModel := models.MFAModel{DB: mc.DB}
+	var codes []string
+	if !mc.checkCode(c, userID, func() (err error) {
+		codes, err = mfaModel.Confirm(userID, body.Code)
+		return err
+	}) {
+		return
+	}
+
+	// Las sesiones abiertas solo con contraseña dejan de valer
+	refreshModel := models.RefreshTokenModel{DB: mc.DB}
+	if err := refreshModel.RevokeUser(userID); err != nil {
+		log.Printf("Error revoking sessions of user %d after enabling 2FA: %v", userID, err)
+	}
+	userModel := models.UserModel{DB: mc.DB}
+	user, err := userModel.GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding user"})
+		return
+	}
+	tokens, err := openSession(c, mc.DB, user, true, currentSessionMode(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo generar el token"})
+		return
+	}
+	tokens["recovery_codes"] = codes
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Regenerar los códigos de recuperación
+// @Description Invalida los códigos de recuperación anteriores y devuelve otros nuevos, que solo se enseñan esta vez
+// @Tags 2fa
+// @Accept json
+// @Produce json
+// @Param code body models.MFACodeRequest true "Código de la app"
+// @Success 200 {object} models.MFARecoveryCodesResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/2fa/recovery-codes [post]
+func (mc *MFAController) RegenerateRecoveryCodes(c *gin.Context) {
+	var body models.MFACodeRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetInt("userID")
+	mfaModel := models.MFAModel{DB: mc.DB}
+	var codes []string
+	if !mc.checkCode(c, userID, func() (err error) {
+		codes, err = mfaModel.RegenerateRecoveryCodes(userID, body.Code)
+		return err
+	}) {
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, models.MFARecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// DisableTOTP godoc
+// @Summary Desactivar el 2FA
+// @Description Quita el segundo factor y sus códigos de recuperación. No se permite mientras la política exija MFA (MFA_REQUIRED).
+// @Tags 2fa
+// @Accept json
+// @Produce json
+// @Param code body models.MFACodeRequest true "Código de la app"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/2fa [delete]
+func (mc *MFAController) DisableTOTP(c *gin.Context) {
+	if services.MFARequired() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "MFA is required by policy"})
+		return
+	}
+	var body models.MFACodeRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetInt("userID")
+	mfaModel := models.MFAModel{DB: mc.DB}
+	if !mc.checkCode(c, userID, func() error {
+		return mfaModel.Disable(userID, body.Code)
+	}) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "2FA disabled"})
+}
+
+// mfaThrottleKeys son las claves del limitador de los códigos de 2FA del usuario
+func mfaThrottleKeys(c *gin.Context, userID int) []models.ThrottleKey {
+	return []models.ThrottleKey{
+		{Scope: services.ThrottleScopeMFA, Subject: strconv.Itoa(userID)},
+		{Scope: services.ThrottleScopeIP, Subject: c.ClientIP()},
+	}
+}
+
+// checkCode pasa check, que comprueba un código de la app, por el mismo limitador
+// que VerifyMFA: con una sesión robada no se puede probar códigos hasta quitar el
+// 2FA. Si devuelve false ya ha respondido.
+func (mc *MFAController) checkCode(c *gin.Context, userID int, check func() error) bool {
+	throttleModel := models.ThrottleModel{DB: mc.DB}
+	keys := mfaThrottleKeys(c, userID)
+	if abortIfThrottled(c, &throttleModel, keys...) {
+		return false
+	}
+	if err := check(); err != nil {
+		if errors.Is(err, models.ErrMFAInvalidCode) {
+			recordFailedAttempt(&throttleModel, keys...)
+		}
+		abortMFAError(c, err)
+		return false
+	}
+	if err := throttleModel.Reset(keys[0]); err != nil {
+		log.Printf("Error resetting MFA attempts of user %d: %v", userID, err)
+	}
+	return true
+}
+
+// abortMFAError responde a los errores de MFAModel
+func abortMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrMFAInvalidCode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, models.ErrMFANotEnrolled), errors.Is(err, models.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error verifying MFA code"})
+	}
+}
+
+// currentSessionMode indica cómo recibió el cliente su sesión: con cabecera
+// Authorization es modo token y sin ella viene de la cookie
+func currentSessionMode(c *gin.Context) string {
+	if c.GetHeader("Authorization") != "" {
+		return models.SessionModeToken
+	}
+	return models.SessionModeCookie
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMFACodeEndpointsThrottled(t *testing.T) {
+	t.Setenv("MFA_REQUIRED", "false")
+	db, mock := newMockDB(t)
+	mc := MFAController{DB: db}
+	asUser := func(c *gin.Context) { c.Set("userID", 1) }
+
+	handlers := map[string]gin.HandlerFunc{
+		"confirm":        mc.ConfirmTOTP,
+		"recovery codes": mc.RegenerateRecoveryCodes,
+		"disable":        mc.DisableTOTP,
+	}
+	for name, handler := range handlers {
+		// Con los intentos de 2FA bloqueados ni se mira el código
+		mock.ExpectQuery(q("FROM auth_throttle WHERE scope = ? AND subject = ?")).WithArgs("mfa", "1").
+			WillReturnRows(sqlmock.NewRows(throttleColumns).AddRow(10, 5, 600))
+		mock.ExpectQuery(q("FROM auth_throttle WHERE scope = ? AND subject = ?")).WithArgs("ip", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(throttleColumns))
+
+		w := serve(http.MethodPost, "/users/2fa", `{"code": "123456"}`, asUser, handler)
+		if w.Code != http.StatusTooManyRequests {
+			t.Errorf("%s: expected 429, got %d: %s", name, w.Code, w.Body.String())
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
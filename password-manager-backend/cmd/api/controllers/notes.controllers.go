@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"database/sql"
+	"log"
 	"net/http"
 	"password-manager-backend/cmd/api/models"
 	"password-manager-backend/cmd/api/services"
@@ -115,19 +116,30 @@ func (nc *NotesController) CreateNote(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	defer note.Password.Destroy()
 
 	// Forzar el userID del contexto
 	note.UserId = userID.(int)
 
-	// Hashear la contraseña antes de guardar
-	hashedPassword, err := services.HashPassword(note.Password)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al hashear la contraseña"})
+	// En bóvedas zero-knowledge los campos tienen que llegar cifrados por el cliente
+	vaultMode := c.GetString("vaultMode")
+	if err := note.ValidateFor(vaultMode); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	note.Password = hashedPassword
+	note.ClientEncrypted = vaultMode == models.VaultModeZeroKnowledge
 
+	// Cifrar la contraseña antes de guardar
 	notesModel := models.NotesModel{DB: nc.DB}
+	if err := notesModel.SetPassword(&note, note.Password); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al cifrar la contraseña"})
+		return
+	}
+	if err := notesModel.EncryptFields(&note); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al cifrar la nota"})
+		return
+	}
+
 	if err := notesModel.Insert(&note); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creando la nota: " + err.Error()})
 		return
@@ -170,6 +182,11 @@ func (nc *NotesController) UpdateNote(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	defer note.Password.Destroy()
+	if err := note.ValidateFor(c.GetString("vaultMode")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	notesModel := models.NotesModel{DB: nc.DB}
 	existingNote, err := notesModel.GetByID(id)
@@ -188,28 +205,28 @@ func (nc *NotesController) UpdateNote(c *gin.Context) {
 		return
 	}
 
-	// Hashear la contraseña antes de actualizar si viene en el body
-	if note.Password != "" {
-		hashedPassword, err := services.HashPassword(note.Password)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al hashear la contraseña"})
+	existingNote.NoteText = note.NoteText
+	existingNote.Username = note.Username
+	existingNote.Url = note.Url
+
+	// Cifrar la contraseña antes de actualizar si viene en el body, si no se mantiene la anterior
+	if !note.Password.IsEmpty() {
+		if err := notesModel.SetPassword(existingNote, note.Password); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al cifrar la contraseña"})
 			return
 		}
-		note.Password = hashedPassword
-	} else {
-		note.Password = existingNote.Password // mantener la anterior
+	}
+	if err := notesModel.EncryptFields(existingNote); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error al cifrar la nota"})
+		return
 	}
 
-	err = notesModel.UpdateByID(id, note.NoteText, note.Username, note.Password)
+	err = notesModel.UpdateByID(id, existingNote)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	existingNote.NoteText = note.NoteText
-	existingNote.Username = note.Username
-	existingNote.Password = note.Password
-
 	c.JSON(http.StatusOK, existingNote)
 }
 
@@ -264,6 +281,67 @@ func (nc *NotesController) DeleteNote(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Nota eliminada correctamente"})
 }
 
+// GetNoteSecret godoc
+// @Summary Revelar la contraseña de una nota
+// @Description Descifra y devuelve la contraseña guardada si la nota pertenece al usuario logueado
+// @Tags notes
+// @Produce json
+// @Param id path int true "ID de la nota"
+// @Success 200 {object} models.NoteSecretResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /notes/{id}/secret [get]
+func (nc *NotesController) GetNoteSecret(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no autorizado"})
+		return
+	}
+
+	idParam := c.Param("id")
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+
+	notesModel := models.NotesModel{DB: nc.DB}
+	note, err := notesModel.GetByID(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Nota no encontrada"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// Solo el dueño puede ver la contraseña descifrada
+	if note.UserId != userID.(int) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No tienes acceso a esta nota"})
+		return
+	}
+
+	if !note.HasPassword {
+		c.JSON(http.StatusNotFound, gin.H{"error": "La nota no tiene contraseña"})
+		return
+	}
+
+	password, err := notesModel.RevealPassword(note)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo descifrar la contraseña"})
+		return
+	}
+	defer password.Destroy()
+
+	// Que ni el navegador ni los proxies guarden la respuesta
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, models.NoteSecretResponse{NoteID: note.Id, Password: password.Exposed()})
+}
+
 // GetNotesByUserID godoc
 // @Summary Obtener notas de un usuario específico
 // @Description Devuelve todas las notas de un usuario dado
@@ -299,10 +377,11 @@ func (nc *NotesController) GetNotesByUserID(c *gin.Context) {
 
 // SearchNotes godoc
 // @Summary Buscar notas por texto
-// @Description Devuelve todas las notas que contengan el texto buscado
+// @Description Devuelve las notas cuyo texto contenga la búsqueda o cuyo username, email o dominio empiece por ella (índices ciegos, mínimo 3 caracteres para prefijos)
 // @Tags notes
 // @Produce json
 // @Param q query string true "Texto a buscar"
+// @Param field query string false "Buscar solo en username, email o domain"
 // @Success 200 {array} models.Notes
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
@@ -323,8 +402,20 @@ func (nc *NotesController) SearchNotes(c *gin.Context) {
 
 	userID := user.(int)
 
+	// El servidor no puede buscar dentro de blobs cifrados por el cliente
+	if c.GetString("vaultMode") == models.VaultModeZeroKnowledge {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Las bóvedas zero-knowledge se buscan en el cliente"})
+		return
+	}
+
+	field := c.Query("field")
+	if field != "" && !services.ValidBlindField(field) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "field debe ser username, email o domain"})
+		return
+	}
+
 	notesModel := models.NotesModel{DB: nc.DB}
-	notes, err := notesModel.SearchByText(userID, query)
+	notes, err := notesModel.SearchByText(userID, query, field)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -340,7 +431,7 @@ func (nc *NotesController) SearchNotes(c *gin.Context) {
 
 // VerifyNotePassword godoc
 // @Summary Verificar contraseña de una nota
-// @Description Valida la contraseña proporcionada para una nota específica
+// @Description Valida la contraseña proporcionada para una nota específica. Los fallos seguidos obligan a esperar cada vez más (429 con Retry-After).
 // @Tags notes
 // @Accept json
 // @Produce json
@@ -349,6 +440,7 @@ func (nc *NotesController) SearchNotes(c *gin.Context) {
 // @Failure 400 {object} models.ErrorResponse
 // @Failure 401 {object} models.ErrorResponse
 // @Failure 404 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Security ApiKeyAuth
 // @Router /notes/verify-password [post]
@@ -360,14 +452,15 @@ func (nc *NotesController) VerifyNotePassword(c *gin.Context) {
 	}
 
 	var body struct {
-		NoteID   int    `json:"note_id"`
-		Password string `json:"password"`
+		NoteID   int              `json:"note_id"`
+		Password *services.Secret `json:"password"`
 	}
 
 	if err := c.ShouldBindJSON(&body); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	defer body.Password.Destroy()
 
 	notesModel := models.NotesModel{DB: nc.DB}
 	note, err := notesModel.GetByID(body.NoteID)
@@ -386,12 +479,37 @@ func (nc *NotesController) VerifyNotePassword(c *gin.Context) {
 		return
 	}
 
-	// Validar contraseña usando tu servicio de hashing
-	passwordValid := services.CheckPassword(body.Password, note.Password)
-	if !passwordValid {
+	// Una contraseña cifrada por el cliente solo la puede comprobar el cliente
+	if note.ClientEncrypted {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Las notas zero-knowledge se verifican en el cliente"})
+		return
+	}
+
+	// Mismo limitador que el login: por usuario y por IP
+	throttleModel := models.ThrottleModel{DB: nc.DB}
+	throttleKeys := []models.ThrottleKey{
+		{Scope: services.ThrottleScopeNote, Subject: strconv.Itoa(note.UserId)},
+		{Scope: services.ThrottleScopeIP, Subject: c.ClientIP()},
+	}
+	if abortIfThrottled(c, &throttleModel, throttleKeys...) {
+		return
+	}
+
+	// Descifrar la contraseña guardada y compararla en tiempo constante
+	stored, err := notesModel.RevealPassword(note)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo descifrar la contraseña"})
+		return
+	}
+	defer stored.Destroy()
+	if !note.HasPassword || !stored.Equal(body.Password) {
+		recordFailedAttempt(&throttleModel, throttleKeys...)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Contraseña incorrecta"})
 		return
 	}
+	if err := throttleModel.Reset(throttleKeys[0]); err != nil {
+		log.Printf("Error resetting note password attempts of user %d: %v", note.UserId, err)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Contraseña correcta"})
 }
@@ -0,0 +1,223 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OIDCController struct {
+	DB *sql.DB
+}
+
+// GetAuthMethods godoc
+// @Summary Formas de iniciar sesión
+// @Description Indica si el despliegue acepta email y contraseña y si hay un proveedor de SSO (OIDC), con el nombre para el botón
+// @Tags users
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /users/auth/methods [get]
+func (oc *OIDCController) GetAuthMethods(c *gin.Context) {
+	methods := gin.H{"password": services.PasswordLoginEnabled(), "oidc": false}
+	if provider, err := services.GetOIDCProvider(); err == nil {
+		methods["oidc"] = true
+		methods["oidc_name"] = provider.Config().Name
+	}
+	c.JSON(http.StatusOK, methods)
+}
+
+// BeginOIDCLogin godoc
+// @Summary Empezar el login con SSO
+// @Description Devuelve la URL del proveedor OIDC a la que hay que mandar el navegador (authorization code con PKCE). Deja una cookie que ata el login a este navegador; caduca en 10 minutos.
+// @Tags users
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 502 {object} models.ErrorResponse
+// @Router /users/auth/oidc/begin [post]
+func (oc *OIDCController) BeginOIDCLogin(c *gin.Context) {
+	oc.beginOIDC(c, 0)
+}
+
+// BeginOIDCLink godoc
+// @Summary Enlazar la cuenta con el SSO
+// @Description Como /users/auth/oidc/begin, pero la identidad que vuelva por /users/auth/oidc/callback se enlaza a la cuenta de la sesión en vez de abrir otra. Es la única forma de usar el SSO con una cuenta que ya existía: nunca se enlaza por email. Las bóvedas zero-knowledge no se pueden enlazar.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Failure 502 {object} models.ErrorResponse
+// @Router /users/auth/oidc/link [post]
+func (oc *OIDCController) BeginOIDCLink(c *gin.Context) {
+	if c.GetString("vaultMode") == models.VaultModeZeroKnowledge {
+		c.JSON(http.StatusForbidden, gin.H{"error": models.ErrIdentityZeroKnowledge.Error()})
+		return
+	}
+	oc.beginOIDC(c, c.GetInt("userID"))
+}
+
+// beginOIDC manda al proveedor; con linkUserID la vuelta enlaza en vez de abrir sesión
+func (oc *OIDCController) beginOIDC(c *gin.Context, linkUserID int) {
+	provider, err := services.GetOIDCProvider()
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	state, err := services.NewOIDCNonce()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting single sign-on"})
+		return
+	}
+	nonce, err := services.NewOIDCNonce()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting single sign-on"})
+		return
+	}
+	verifier, err := services.NewPKCEVerifier()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting single sign-on"})
+		return
+	}
+
+	authURL, err := provider.AuthorizationURL(c.Request.Context(), state, nonce, services.PKCEChallenge(verifier))
+	if err != nil {
+		log.Printf("OIDC discovery failed: %v", err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider unavailable"})
+		return
+	}
+	oidcModel := models.OIDCModel{DB: oc.DB}
+	if err := oidcModel.SaveLogin(state, nonce, verifier, linkUserID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting single sign-on"})
+		return
+	}
+
+	// Lax: la vuelta del proveedor es una navegación desde otro sitio
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(services.OIDCCookieName, state, int(services.OIDCLoginTTL().Seconds()), services.OIDCCookiePath, "", services.CookieSecure(), true)
+	c.JSON(http.StatusOK, gin.H{"authorization_url": authURL})
+}
+
+// FinishOIDCLogin godoc
+// @Summary Terminar el login con SSO
+// @Description Canjea el code que el proveedor devolvió al frontend, verifica el ID token con el JWKS del proveedor y abre la sesión. La primera vez crea la cuenta; si ya hay una con ese email responde 409 y hay que entrar y enlazarla con /users/auth/oidc/link. Si el login empezó en /users/auth/oidc/link enlaza la identidad y responde 200. Con OIDC_ADMIN_GROUPS el rol admin se sincroniza con los grupos en cada login. Si la cuenta tiene 2FA y el proveedor no pidió segundo factor responde mfa_required como /users/login.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param callback body models.OIDCCallbackRequest true "code y state de la redirect URI"
+// @Success 200 {object} map[string]string
+// @Success 202 {object} map[string]interface{}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/auth/oidc/callback [post]
+func (oc *OIDCController) FinishOIDCLogin(c *gin.Context) {
+	provider, err := services.GetOIDCProvider()
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	var body models.OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No body sent or malformed body"})
+		return
+	}
+
+	// El state tiene que venir del mismo navegador que empezó el login
+	bound, _ := c.Cookie(services.OIDCCookieName)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(services.OIDCCookieName, "", -1, services.OIDCCookiePath, "", services.CookieSecure(), true)
+	if !services.ValidCSRF(bound, body.State) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": models.ErrOIDCLoginInvalid.Error()})
+		return
+	}
+	oidcModel := models.OIDCModel{DB: oc.DB}
+	login, err := oidcModel.ConsumeLogin(body.State)
+	if err != nil {
+		if errors.Is(err, models.ErrOIDCLoginInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finishing single sign-on"})
+		}
+		return
+	}
+
+	rawIDToken, err := provider.Exchange(c.Request.Context(), body.Code, login.CodeVerifier)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed"})
+		return
+	}
+	identity, err := provider.VerifyIDToken(c.Request.Context(), rawIDToken, login.Nonce)
+	if err != nil {
+		log.Printf("OIDC ID token rejected: %v", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Single sign-on failed"})
+		return
+	}
+	identityModel := models.IdentityModel{DB: oc.DB}
+
+	if login.LinkUserId != 0 {
+		if err := identityModel.Link(login.LinkUserId, identity); err != nil {
+			switch {
+			case errors.Is(err, models.ErrIdentityLinked):
+				c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			case errors.Is(err, models.ErrIdentityZeroKnowledge):
+				c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			default:
+				log.Printf("Error linking identity from %s to user %d: %v", identity.Issuer, login.LinkUserId, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finishing single sign-on"})
+			}
+			return
+		}
+		log.Printf("User %d linked an identity from %s", login.LinkUserId, identity.Issuer)
+		c.JSON(http.StatusOK, gin.H{"message": "Identity linked"})
+		return
+	}
+
+	admin, manageAdmin := provider.IsAdmin(identity.Groups)
+	user, created, err := identityModel.ProvisionUser(identity, admin, manageAdmin)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrIdentityAccountExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, models.ErrIdentityEmailMissing):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			log.Printf("Error provisioning user from %s: %v", identity.Issuer, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finishing single sign-on"})
+		}
+		return
+	}
+	if created {
+		log.Printf("User %d provisioned from %s", user.Id, identity.Issuer)
+	}
+	if abortIfDisabled(c, user) || abortIfEmailNotVerified(c, user) {
+		return
+	}
+
+	// El segundo factor del proveedor vale; si no lo hubo, se pide el TOTP propio
+	if user.TOTPEnabled && !identity.MFA {
+		respondMFAChallenge(c, user)
+		return
+	}
+	tokens, err := openSession(c, oc.DB, user, identity.MFA, body.SessionMode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo generar el token"})
+		return
+	}
+	user.Password = ""
+	tokens["user"] = user
+	c.JSON(http.StatusAccepted, tokens)
+}
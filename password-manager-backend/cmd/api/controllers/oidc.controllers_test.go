@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIdP configura un proveedor OIDC local cuyo token endpoint devuelve un ID
+// token de ana firmado para el nonce "nonce"
+func mockIdP(t *testing.T) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(services.JWKS{Keys: []services.JWK{{
+			Kty: "OKP", Crv: "Ed25519", Kid: "idp1", Use: "sig", Alg: "EdDSA", X: base64.RawURLEncoding.EncodeToString(public),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+			"iss":            server.URL,
+			"aud":            "vault",
+			"sub":            "sub-1",
+			"nonce":          "nonce",
+			"email":          "ana@example.com",
+			"email_verified": true,
+			"iat":            time.Now().Unix(),
+			"exp":            time.Now().Add(5 * time.Minute).Unix(),
+		})
+		token.Header["kid"] = "idp1"
+		raw, err := token.SignedString(private)
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": raw})
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	services.SetOIDCProvider(services.NewOIDCProvider(services.OIDCConfig{
+		Issuer:      server.URL,
+		ClientID:    "vault",
+		RedirectURL: "http://localhost:3000/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+	}, nil))
+	t.Cleanup(func() { services.SetOIDCProvider(nil) })
+}
+
+// expectOIDCLogin espera que se gaste el state "state"; linkUser es el usuario
+// que empezó un enlace, o nil en un login
+func expectOIDCLogin(mock sqlmock.Sqlmock, linkUser any) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(q("FROM oidc_logins WHERE state = ? FOR UPDATE")).WithArgs("state").WillReturnRows(
+		sqlmock.NewRows([]string{"nonce", "code_verifier", "link_user_id", "expired"}).AddRow("nonce", "verifier", linkUser, false))
+	mock.ExpectExec(q("DELETE FROM oidc_logins WHERE state = ?")).WithArgs("state").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// serveCallback manda el code y el state al callback desde el navegador que empezó el login
+func serveCallback(oc *OIDCController) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/users/auth/oidc/callback", oc.FinishOIDCLogin)
+	req := httptest.NewRequest(http.MethodPost, "/users/auth/oidc/callback", strings.NewReader(`{"code": "code", "state": "state"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: services.OIDCCookieName, Value: "state"})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestOIDCCallbackRefusesExistingEmail(t *testing.T) {
+	mockIdP(t)
+	db, mock := newMockDB(t)
+	oc := OIDCController{DB: db}
+
+	// La identidad no está enlazada y ya hay una cuenta con su email: ni se
+	// enlaza ni se abre sesión
+	expectOIDCLogin(mock, nil)
+	mock.ExpectBegin()
+	mock.ExpectQuery(q("SELECT user_id FROM user_identities")).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectQuery(q("SELECT id FROM users WHERE email = ? FOR UPDATE")).WithArgs("ana@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectRollback()
+
+	w := serveCallback(&oc)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+	if body := decode(t, w); body["token"] != nil {
+		t.Errorf("unexpected session %v", body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOIDCCallbackLinksRequestingUser(t *testing.T) {
+	mockIdP(t)
+	db, mock := newMockDB(t)
+	oc := OIDCController{DB: db}
+
+	expectOIDCLogin(mock, 1)
+	mock.ExpectBegin()
+	mock.ExpectQuery(q("SELECT vault_mode FROM users WHERE id = ?")).WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"vault_mode"}).AddRow(models.VaultModeServer))
+	mock.ExpectQuery(q("SELECT user_id FROM user_identities")).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectExec(q("INSERT INTO user_identities")).WithArgs(sqlmock.AnyArg(), "sub-1", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := serveCallback(&oc)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if body := decode(t, w); body["token"] != nil {
+		t.Errorf("linking must not open a session, got %v", body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBeginOIDCLinkRefusesZeroKnowledge(t *testing.T) {
+	mockIdP(t)
+	oc := OIDCController{}
+
+	w := serve(http.MethodPost, "/users/auth/oidc/link", "", func(c *gin.Context) {
+		c.Set("userID", 1)
+		c.Set("vaultMode", models.VaultModeZeroKnowledge)
+	}, oc.BeginOIDCLink)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+	}
+}
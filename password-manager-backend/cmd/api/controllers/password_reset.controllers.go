@@ -0,0 +1,189 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PasswordResetController struct {
+	DB *sql.DB
+}
+
+// ForgotPassword godoc
+// @Summary Pedir un enlace para restablecer la contraseña
+// @Description Envía al email un enlace de un solo uso que caduca en PASSWORD_RESET_TTL (30 minutos por defecto). La respuesta es la misma esté o no registrado el email. Las bóvedas zero-knowledge no se pueden restablecer así: reciben un correo que les remite al kit de recuperación.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param email body models.ForgotPasswordRequest true "Email de la cuenta"
+// @Success 202 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/auth/forgot-password [post]
+func (pc *PasswordResetController) ForgotPassword(c *gin.Context) {
+	var body models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No body sent or malformed body"})
+		return
+	}
+	accepted := gin.H{"message": "If the email is registered, a link to reset the password is on its way"}
+
+	userModel := models.UserModel{DB: pc.DB}
+	user, err := userModel.GetUserFromEmail(body.Email)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error requesting password reset"})
+		return
+	}
+
+	// El token se crea también en zero-knowledge, aunque no sirva, para que el
+	// límite de un correo por minuto valga igual para todos
+	resetModel := models.PasswordResetModel{DB: pc.DB}
+	token, err := resetModel.Create(user.Id)
+	if errors.Is(err, models.ErrPasswordResetTooSoon) {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error requesting password reset"})
+		return
+	}
+
+	mail := services.PasswordResetMail(user.Email, token)
+	if user.VaultMode == models.VaultModeZeroKnowledge {
+		mail = services.ZeroKnowledgeResetMail(user.Email)
+	}
+	// En segundo plano: lo que tarda el SMTP delataría que el email existe
+	go func(userID int) {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := services.GetMailer().Send(ctx, mail); err != nil {
+			log.Printf("Error sending password reset mail to user %d: %v", userID, err)
+		}
+	}(user.Id)
+
+	c.JSON(http.StatusAccepted, accepted)
+}
+
+// ResetPassword godoc
+// @Summary Restablecer la contraseña
+// @Description Cambia la contraseña con el token del correo. El token solo vale una vez y todas las sesiones abiertas se cierran.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param reset body models.ResetPasswordRequest true "Token del correo y contraseña nueva"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/auth/reset-password [post]
+func (pc *PasswordResetController) ResetPassword(c *gin.Context) {
+	var body models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No body sent or malformed body"})
+		return
+	}
+	defer body.Destroy()
+	if err := body.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := services.HashPassword(body.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong hashing the password"})
+		return
+	}
+	resetModel := models.PasswordResetModel{DB: pc.DB}
+	user, err := resetModel.Reset(body.Token, hashedPassword)
+	if err != nil {
+		if errors.Is(err, models.ErrPasswordResetInvalid) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error resetting password"})
+		}
+		return
+	}
+	log.Printf("Password of user %d reset by email, sessions revoked", user.Id)
+
+	// Quien demuestra tener el email ya no tiene que esperar al bloqueo de login
+	throttleModel := models.ThrottleModel{DB: pc.DB}
+	loginKey := models.ThrottleKey{Scope: services.ThrottleScopeLogin, Subject: services.NormalizeThrottleSubject(user.Email)}
+	if err := throttleModel.Reset(loginKey); err != nil {
+		log.Printf("Error resetting login attempts of user %d: %v", user.Id, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, log in again with the new password"})
+}
+
+// ChangeRequiredPassword godoc
+// @Summary Cambiar la contraseña obligada
+// @Description Elige la contraseña nueva con el password_change_token del login cuando un administrador ha obligado a cambiarla. Tiene que ser distinta de la actual. Se cierran todas las sesiones: después hay que entrar con la nueva.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param change body models.ChangeRequiredPasswordRequest true "Token del login y contraseña nueva"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/auth/change-password [post]
+func (pc *PasswordResetController) ChangeRequiredPassword(c *gin.Context) {
+	var body models.ChangeRequiredPasswordRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No body sent or malformed body"})
+		return
+	}
+	defer body.Destroy()
+	if err := body.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	claims, err := models.DecodificarPasswordChangeToken(body.PasswordChangeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+	userModel := models.UserModel{DB: pc.DB}
+	user, err := userModel.GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no encontrado"})
+		return
+	}
+	if services.CheckPassword(body.NewPassword, user.Password) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The new password must be different from the current one"})
+		return
+	}
+
+	hashedPassword, err := services.HashPassword(body.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong hashing the password"})
+		return
+	}
+	if err := userModel.ChangeRequiredPassword(user.Id, claims.TokenVersion, hashedPassword); err != nil {
+		if errors.Is(err, models.ErrPasswordResetInvalid) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token revocado"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error changing password"})
+		}
+		return
+	}
+	log.Printf("User %d changed the password required by an administrator", user.Id)
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, log in again with the new password"})
+}
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// setJWTKeys firma los tokens del test con una clave Ed25519 nueva
+func setJWTKeys(t *testing.T) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := services.NewJWTKeySet("test", nil, func(string) ([]byte, error) {
+		return []byte(base64.StdEncoding.EncodeToString(der)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetJWTKeySet(set)
+	t.Cleanup(func() { services.SetJWTKeySet(nil) })
+}
+
+func TestChangeRequiredPasswordLooksUpUserByID(t *testing.T) {
+	setJWTKeys(t)
+	t.Setenv("ARGON2_MEMORY", "1024")
+	t.Setenv("ARGON2_ITERATIONS", "1")
+	t.Setenv("ARGON2_PARALLELISM", "1")
+	db, mock := newMockDB(t)
+	pc := PasswordResetController{DB: db}
+
+	token, err := models.GenerarPasswordChangeToken(7, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := services.HashPassword(services.SecretFromString("12345678"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// El usuario sale del sub del token, no de un email que puede haber cambiado
+	mock.ExpectQuery(q("FROM users WHERE id = ?")).WithArgs(7).WillReturnRows(sqlmock.NewRows(userColumns).
+		AddRow(7, "ana@example.com", "ana", "", "user", current, models.VaultModeServer, 1, false, true, false, true))
+
+	w := serve(http.MethodPost, "/users/auth/change-password",
+		`{"password_change_token": "`+token+`", "new_password": "12345678"}`, pc.ChangeRequiredPassword)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for the same password, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
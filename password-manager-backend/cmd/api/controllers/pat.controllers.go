@@ -0,0 +1,104 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PersonalAccessTokenController struct {
+	DB *sql.DB
+}
+
+// CreatePersonalAccessToken godoc
+// @Summary Crear un token de acceso personal
+// @Description Crea un token para scripts y CI con los permisos indicados (notes:read, notes:write, users:read, users:write). Se usa como "Authorization: Bearer pat_...". El token solo se enseña en esta respuesta; caduca a los expires_in_days (90 por defecto, 365 como mucho) y deja de valer si cambia la contraseña. Solo cuenta como segundo factor si esta sesión lo tiene.
+// @Tags tokens
+// @Accept json
+// @Produce json
+// @Param token body models.CreatePersonalAccessTokenRequest true "Nombre, permisos y caducidad"
+// @Success 201 {object} models.PersonalAccessTokenResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/tokens [post]
+func (pc *PersonalAccessTokenController) CreatePersonalAccessToken(c *gin.Context) {
+	var body models.CreatePersonalAccessTokenRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	scopes, err := services.NormalizeScopes(body.Scopes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userModel := models.UserModel{DB: pc.DB}
+	user, err := userModel.GetByID(c.GetInt("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding user"})
+		return
+	}
+	patModel := models.PersonalAccessTokenModel{DB: pc.DB}
+	pat, err := patModel.Create(user, body.Name, scopes, c.GetBool("mfa"), services.PersonalAccessTokenTTL(body.ExpiresInDays))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating token"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, pat)
+}
+
+// GetPersonalAccessTokens godoc
+// @Summary Mis tokens de acceso personal
+// @Description Lista los tokens vigentes, con sus permisos y la fecha de último uso
+// @Tags tokens
+// @Produce json
+// @Success 200 {array} models.PersonalAccessToken
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/tokens [get]
+func (pc *PersonalAccessTokenController) GetPersonalAccessTokens(c *gin.Context) {
+	patModel := models.PersonalAccessTokenModel{DB: pc.DB}
+	pats, err := patModel.GetByUserID(c.GetInt("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading tokens"})
+		return
+	}
+	c.JSON(http.StatusOK, pats)
+}
+
+// RevokePersonalAccessToken godoc
+// @Summary Revocar un token de acceso personal
+// @Tags tokens
+// @Param id path int true "ID del token"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/tokens/{id} [delete]
+func (pc *PersonalAccessTokenController) RevokePersonalAccessToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+	patModel := models.PersonalAccessTokenModel{DB: pc.DB}
+	if err := patModel.Revoke(c.GetInt("userID"), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error revoking token"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
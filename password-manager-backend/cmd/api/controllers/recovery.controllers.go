@@ -0,0 +1,181 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RecoveryController struct {
+	DB *sql.DB
+}
+
+// CreateRecoveryKit godoc
+// @Summary Generar kit de recuperación
+// @Description Genera un secreto de recuperación y lo reparte en trozos de Shamir (N de M). Los trozos solo se devuelven esta vez y sustituyen a los de un kit anterior.
+// @Tags recovery
+// @Accept json
+// @Produce json
+// @Param kit body models.CreateRecoveryKitRequest true "Número de trozos y umbral"
+// @Success 201 {object} models.RecoveryKitResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /recovery/kit [post]
+func (rc *RecoveryController) CreateRecoveryKit(c *gin.Context) {
+	// En zero-knowledge el servidor no puede recuperar la bóveda: cambiar la
+	// contraseña dejaría al usuario con notas que nadie puede descifrar
+	if c.GetString("vaultMode") == models.VaultModeZeroKnowledge {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Recovery kits are not available for zero_knowledge vaults"})
+		return
+	}
+
+	var body models.CreateRecoveryKitRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	shares, secretHash, err := services.NewRecoveryKit(body.Shares, body.Threshold)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	recoveryModel := models.RecoveryKitModel{DB: rc.DB}
+	err = recoveryModel.Replace(&models.RecoveryKit{
+		UserId:      c.GetInt("userID"),
+		SecretHash:  secretHash,
+		Threshold:   body.Threshold,
+		TotalShares: body.Shares,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving recovery kit"})
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusCreated, models.RecoveryKitResponse{
+		Threshold: body.Threshold,
+		Shares:    shares,
+	})
+}
+
+// GetRecoveryKit godoc
+// @Summary Estado del kit de recuperación
+// @Description Indica si el usuario tiene un kit activo y cómo se repartió, sin devolver los trozos
+// @Tags recovery
+// @Produce json
+// @Success 200 {object} models.RecoveryKit
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /recovery/kit [get]
+func (rc *RecoveryController) GetRecoveryKit(c *gin.Context) {
+	recoveryModel := models.RecoveryKitModel{DB: rc.DB}
+	kit, err := recoveryModel.GetByUserID(c.GetInt("userID"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No recovery kit"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding recovery kit"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, kit)
+}
+
+// RecoverAccount godoc
+// @Summary Recuperar la cuenta con los trozos
+// @Description Reconstruye el secreto con los trozos enviados, cambia la contraseña y revoca todos los tokens. El kit queda gastado.
+// @Tags recovery
+// @Accept json
+// @Produce json
+// @Param recover body models.RecoverAccountRequest true "Email, trozos y contraseña nueva"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /recovery/recover [post]
+func (rc *RecoveryController) RecoverAccount(c *gin.Context) {
+	var body models.RecoverAccountRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No body sent or malformed body"})
+		return
+	}
+	defer body.NewPassword.Destroy()
+	if err := body.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Los trozos se limitan como una contraseña, por email (exista o no) y por IP
+	throttleModel := models.ThrottleModel{DB: rc.DB}
+	throttleKeys := []models.ThrottleKey{
+		{Scope: services.ThrottleScopeRecovery, Subject: services.NormalizeThrottleSubject(body.Email)},
+		{Scope: services.ThrottleScopeIP, Subject: c.ClientIP()},
+	}
+	if abortIfThrottled(c, &throttleModel, throttleKeys...) {
+		return
+	}
+
+	// Email inexistente, sin kit o trozos malos dan el mismo error
+	invalid := func() {
+		recordFailedAttempt(&throttleModel, throttleKeys...)
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidRecoveryShares.Error()})
+	}
+
+	userModel := models.UserModel{DB: rc.DB}
+	user, err := userModel.GetUserFromEmail(body.Email)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			invalid()
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error recovering account"})
+		}
+		return
+	}
+
+	recoveryModel := models.RecoveryKitModel{DB: rc.DB}
+	kit, err := recoveryModel.GetByUserID(user.Id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			invalid()
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error recovering account"})
+		}
+		return
+	}
+
+	if err := services.VerifyRecoveryShares(body.Shares, kit.SecretHash); err != nil {
+		invalid()
+		return
+	}
+
+	hashedPassword, err := services.HashPassword(body.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong hashing the password"})
+		return
+	}
+	if err := recoveryModel.Recover(kit, hashedPassword); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			invalid()
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error recovering account"})
+		}
+		return
+	}
+	log.Printf("Account %d recovered with recovery kit, sessions revoked", user.Id)
+
+	if err := throttleModel.Reset(throttleKeys[0]); err != nil {
+		log.Printf("Error resetting recovery attempts of user %d: %v", user.Id, err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, log in again with the new password"})
+}
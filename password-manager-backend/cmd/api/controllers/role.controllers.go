@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RoleController struct {
+	DB *sql.DB
+}
+
+// GetRoles godoc
+// @Summary Roles
+// @Description Lista los roles con los permisos que da cada uno. Necesita roles:view.
+// @Tags roles
+// @Produce json
+// @Success 200 {array} models.Role
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/roles [get]
+func (rc *RoleController) GetRoles(c *gin.Context) {
+	roleModel := models.RoleModel{DB: rc.DB}
+	roles, err := roleModel.GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading roles"})
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// SetUserRoles godoc
+// @Summary Cambiar los roles de un usuario
+// @Description Sustituye los roles del usuario. Necesita roles:assign, tener todos los permisos del usuario y todos los de cada rol que se da o se quita: nadie reparte lo que no tiene. Siempre tiene que quedar algún owner.
+// @Tags roles
+// @Accept json
+// @Produce json
+// @Param id path int true "ID del usuario"
+// @Param roles body models.SetUserRolesRequest true "Roles"
+// @Success 200 {object} models.User
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/{id}/roles [put]
+func (rc *RoleController) SetUserRoles(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+	var req models.SetUserRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	slices.Sort(req.Roles)
+	req.Roles = slices.Compact(req.Roles)
+
+	userModel := models.UserModel{DB: rc.DB}
+	user, err := userModel.GetByID(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Usuario no encontrado"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if !authorizeTarget(c, rc.DB, id, "No tienes permisos para cambiar los roles de este usuario") {
+		return
+	}
+
+	// Los roles que se dan o se quitan
+	var changed []string
+	for _, role := range req.Roles {
+		if !slices.Contains(user.Roles, role) {
+			changed = append(changed, role)
+		}
+	}
+	for _, role := range user.Roles {
+		if !slices.Contains(req.Roles, role) {
+			changed = append(changed, role)
+		}
+	}
+	if !authorizeRoleChange(c, rc.DB, changed) {
+		return
+	}
+
+	roleModel := models.RoleModel{DB: rc.DB}
+	if err := roleModel.SetUserRoles(id, req.Roles); err != nil {
+		switch {
+		case errors.Is(err, models.ErrUnknownRole):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, models.ErrLastOwner):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	recordAudit(c, rc.DB, models.AuditRolesChanged, id, gin.H{"from": user.Roles, "to": req.Roles})
+
+	user.Roles = req.Roles
+	if !canSeeCredentials(c, user.Id) {
+		user.Password = ""
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// authorizeRoleChange comprueba que quien hace la petición tiene todos los permisos
+// de los roles que da o quita. Si no responde 400 o 403 y devuelve false.
+func authorizeRoleChange(c *gin.Context, db *sql.DB, changed []string) bool {
+	roleModel := models.RoleModel{DB: db}
+	permissions, err := roleModel.PermissionsOfRoles(changed)
+	if errors.Is(err, models.ErrUnknownRole) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if missing := services.MissingPermissions(c.GetStringSlice("permissions"), permissions); len(missing) > 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "No puedes dar ni quitar un rol con permisos que no tienes", "missing_permissions": missing})
+		return false
+	}
+	return true
+}
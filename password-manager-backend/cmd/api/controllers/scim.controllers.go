@@ -0,0 +1,746 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SCIMController es el aprovisionamiento desde el IdP (SCIM 2.0). Las peticiones no
+// llevan usuario: en el registro de auditoría quedan con actor_id 0.
+type SCIMController struct {
+	DB *sql.DB
+}
+
+const (
+	scimUsersPath  = "/scim/v2/Users"
+	scimGroupsPath = "/scim/v2/Groups"
+)
+
+// ServiceProviderConfig godoc
+// @Summary Configuración del servidor SCIM
+// @Description Lo que admite el servidor: PATCH y filtros sí; bulk, ordenar y ETag no.
+// @Tags scim
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} services.SCIMError
+// @Security ApiKeyAuth
+// @Router /scim/v2/ServiceProviderConfig [get]
+func (sc *SCIMController) ServiceProviderConfig(c *gin.Context) {
+	scimJSON(c, http.StatusOK, services.SCIMServiceProviderConfig())
+}
+
+// ListUsers godoc
+// @Summary Listar usuarios (SCIM)
+// @Description Página de usuarios que cumplen filter. Se puede filtrar por id, userName, emails.value, externalId y active con eq, ne, co, sw, ew y pr unidos con and.
+// @Tags scim
+// @Produce json
+// @Param filter query string false "Filtro, p. ej. userName eq \"alice\""
+// @Param startIndex query int false "Primer resultado, desde 1"
+// @Param count query int false "Resultados por página (máximo 100)"
+// @Success 200 {object} services.SCIMListResponse
+// @Failure 400 {object} services.SCIMError
+// @Failure 401 {object} services.SCIMError
+// @Failure 500 {object} services.SCIMError
+// @Security ApiKeyAuth
+// @Router /scim/v2/Users [get]
+func (sc *SCIMController) ListUsers(c *gin.Context) {
+	filter, page, ok := scimListParams(c)
+	if !ok {
+		return
+	}
+	scimModel := models.SCIMModel{DB: sc.DB}
+	accounts, total, err := scimModel.ListUsers(filter, page)
+	if errors.Is(err, services.ErrSCIMInvalidFilter) {
+		scimBadRequest(c, err)
+		return
+	}
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Error loading users")
+		return
+	}
+
+	users := make([]services.SCIMUser, 0, len(accounts))
+	for i := range accounts {
+		users = append(users, toSCIMUser(&accounts[i]))
+	}
+	scimJSON(c, http.StatusOK, services.SCIMListResponse{
+		Schemas:      []string{services.SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   page.StartIndex,
+		ItemsPerPage: len(users),
+		Resources:    users,
+	})
+}
+
+// GetUser godoc
+// @Summary Ver un usuario (SCIM)
+// @Tags scim
+// @Produce json
+// @Param id path int true "ID del usuario"
+// @Success 200 {object} services.SCIMUser
+// @Failure 401 {object} services.SCIMError
+// @Failure 404 {object} services.SCIMError
+// @Security ApiKeyAuth
+// @Router /scim/v2/Users/{id} [get]
+func (sc *SCIMController) GetUser(c *gin.Context) {
+	account, ok := scimTargetUser(c, sc.DB)
+	if !ok {
+		return
+	}
+	scimJSON(c, http.StatusOK, toSCIMUser(account))
+}
+
+// CreateUser godoc
+// @Summary Crear un usuario (SCIM)
+// @Description Crea la cuenta con el email ya verificado, el rol user y una contraseña aleatoria que nadie conoce: se entra por SSO, LDAP o restableciendo la contraseña. Con active a false se crea desactivada.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param user body services.SCIMUser true "Usuario"
+// @Success 201 {object} services.SCIMUser
+// @Failure 400 {object} services.SCIMError
+// @Failure 401 {object} services.SCIMError
+// @Failure 409 {object} services.SCIMError
+// @Failure 500 {object} services.SCIMError
+// @Security ApiKeyAuth
+// @Router /scim/v2/Users [post]
+func (sc *SCIMController) CreateUser(c *gin.Context) {
+	var req services.SCIMUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	if err := req.Validate(); err != nil {
+		scimBadRequest(c, err)
+		return
+	}
+
+	account := &models.SCIMAccount{
+		UserName:   req.UserName,
+		Email:      req.PrimaryEmail(),
+		ExternalID: req.ExternalID,
+		Active:     req.IsActive(),
+	}
+	scimModel := models.SCIMModel{DB: sc.DB}
+	if err := scimModel.CreateUser(account); err != nil {
+		if errors.Is(err, models.ErrSCIMConflict) {
+			scimError(c, http.StatusConflict, "uniqueness", err.Error())
+		} else {
+			scimError(c, http.StatusInternalServerError, "", "Error creating user")
+		}
+		return
+	}
+	recordAudit(c, sc.DB, models.AuditSCIMUserCreated, account.Id, gin.H{"user_name": account.UserName, "external_id": account.ExternalID, "active": account.Active})
+
+	created, err := scimModel.GetUser(account.Id)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Error loading user")
+		return
+	}
+	user := toSCIMUser(created)
+	c.Header("Location", user.Meta.Location)
+	scimJSON(c, http.StatusCreated, user)
+}
+
+// ReplaceUser godoc
+// @Summary Sustituir un usuario (SCIM)
+// @Description Cambia userName, el email principal y externalId. active a false desprovisiona la cuenta: queda desactivada y se cierran sus sesiones al momento. Sin active se queda como está.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path int true "ID del usuario"
+// @Param user body services.SCIMUser true "Usuario"
+// @Success 200 {object} services.SCIMUser
+// @Failure 400 {object} services.SCIMError
+// @Failure 401 {object} services.SCIMError
+// @Failure 404 {object} services.SCIMError
+// @Failure 409 {object} services.SCIMError
+// @Failure 500 {object} services.SCIMError
+// @Security ApiKeyAuth
+// @Router /scim/v2/Users/{id} [put]
+func (sc *SCIMController) ReplaceUser(c *gin.Context) {
+	account, ok := scimTargetUser(c, sc.DB)
+	if !ok {
+		return
+	}
+	var req services.SCIMUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	if req.Active == nil {
+		req.Active = &account.Active
+	}
+	sc.saveUser(c, account, req)
+}
+
+// PatchUser godoc
+// @Summary Modificar un usuario (SCIM)
+// @Description Operaciones add, replace y remove sobre active, userName, emails y externalId; los demás atributos se ignoran. replace de active a false desprovisiona la cuenta.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path int true "ID del usuario"
+// @Param patch body services.SCIMPatchRequest true "Operaciones"
+// @Success 200 {object} services.SCIMUser
+// @Failure 400 {object} services.SCIMError
+// @Failure 401 {object} services.SCIMError
+// @Failure 404 {object} services.SCIMError
+// @Failure 409 {object} services.SCIMError
+// @Failure 500 {object} services.SCIMError
+// @Security ApiKeyAuth
+// @Router /scim/v2/Users/{id} [patch]
+func (sc *SCIMController) PatchUser(c *gin.Context) {
+	account, ok := scimTargetUser(c, sc.DB)
+	if !ok {
+		return
+	}
+	var req services.SCIMPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	user := toSCIMUser(account)
+	if err := services.ApplySCIMUserPatch(&user, req.Operations); err != nil {
+		scimBadRequest(c, err)
+		return
+	}
+	sc.saveUser(c, account, user)
+}
+
+// DeleteUser godoc
+// @Summary Desprovisionar un usuario (SCIM)
+// @Description No borra nada: desactiva la cuenta y cierra sus sesiones al momento, y sus notas se conservan. Se reactiva con active a true. El último owner no se puede desprovisionar.
+// @Tags scim
+// @Param id path int true "ID del usuario"
+// @Success 204
+// @Failure 401 {object} services.SCIMError
+// @Failure 404 {object} services.SCIMError
+// @Failure 409 {object} services.SCIMError
+// @Failure 500 {object} services.SCIMError
+// @Security ApiKeyAuth
+// @Router /scim/v2/Users/{id} [delete]
+func (sc *SCIMController) DeleteUser(c *gin.Context) {
+	account, ok := scimTargetUser(c, sc.DB)
+	if !ok {
+		return
+	}
+	if account.Active {
+		if !scimCanDeprovision(c, sc.DB, account.Id) || !sc.setActive(c, account.Id, false) {
+			return
+		}
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// saveUser guarda los cambios de user sobre account y responde con el usuario
+func (sc *SCIMController) saveUser(c *gin.Context, account *models.SCIMAccount, user services.SCIMUser) {
+	if err := user.Validate(); err != nil {
+		scimBadRequest(c, err)
+		return
+	}
+	// Antes de cambiar nada: al último owner no se le desprovisiona
+	if account.Active && !user.IsActive() && !scimCanDeprovision(c, sc.DB, account.Id) {
+		return
+	}
+
+	updated := *account
+	updated.UserName = user.UserName
+	updated.Email = user.PrimaryEmail()
+	updated.ExternalID = user.ExternalID
+	if updated.UserName != account.UserName || updated.Email != account.Email || updated.ExternalID != account.ExternalID {
+		scimModel := models.SCIMModel{DB: sc.DB}
+		if err := scimModel.UpdateUser(&updated); err != nil {
+			if errors.Is(err, models.ErrSCIMConflict) {
+				scimError(c, http.StatusConflict, "uniqueness", err.Error())
+			} else {
+				scimError(c, http.StatusInternalServerError, "", "Error updating user")
+			}
+			return
+		}
+		recordAudit(c, sc.DB, models.AuditSCIMUserUpdated, account.Id, gin.H{
+			"from": gin.H{"user_name": account.UserName, "email": account.Email, "external_id": account.ExternalID},
+			"to":   gin.H{"user_name": updated.UserName, "email": updated.Email, "external_id": updated.ExternalID},
+		})
+	}
+	if user.IsActive() != account.Active && !sc.setActive(c, account.Id, user.IsActive()) {
+		return
+	}
+
+	scimModel := models.SCIMModel{DB: sc.DB}
+	saved, err := scimModel.GetUser(account.Id)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Error loading user")
+		return
+	}
+	scimJSON(c, http.StatusOK, toSCIMUser(saved))
+}
+
+// setActive reactiva o desprovisiona la cuenta. Desprovisionar la desactiva y
+// cierra todas sus sesiones; nunca se borra el usuario ni sus datos. Antes hay que
+// comprobar scimCanDeprovision.
+func (sc *SCIMController) setActive(c *gin.Context, userID int, active bool) bool {
+	userModel := models.UserModel{DB: sc.DB}
+	if err := userModel.SetDisabled(userID, !active); err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Error updating user")
+		return false
+	}
+	action := models.AuditSCIMUserReactivated
+	if !active {
+		action = models.AuditSCIMUserDeprovisioned
+	}
+	recordAudit(c, sc.DB, action, userID, nil)
+	return true
+}
+
+// scimCanDeprovision responde 409 y devuelve false si el usuario es el último owner
+func scimCanDeprovision(c *gin.Context, db *sql.DB, userID int) bool {
+	roleModel := models.RoleModel{DB: db}
+	lastOwner, err := roleModel.IsLastOwner(userID)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Error checking owners")
+		return false
+	}
+	if lastOwner {
+		scimError(c, http.StatusConflict, "", models.ErrLastOwner.Error())
+		return false
+	}
+	return true
+}
+
+// ListGroups godoc
+// @Summary Listar grupos (SCIM)
+// @Description Los grupos son los roles. Se puede filtrar por id y displayName; excludedAttributes=members no trae los miembros.
+// @Tags scim
+// @Produce json
+// @Param filter query string false "Filtro, p. ej. displayName eq \"finance\""
+// @Param startIndex query int false "Primer resultado, desde 1"
+// @Param count query int false "Resultados por página (máximo 100)"
+// @Param excludedAttributes query string false "members para no traer los miembros"
+// @Success 200 {object} services.SCIMListResponse
+// @Failure 400 {object} services.SCIMError
+// @Failure 401 {object} services.SCIMError
+// @Failure 500 {object} services.SCIMError
+// @Security ApiKeyAuth
+// @Router /scim/v2/Groups [get]
+func (sc *SCIMController) ListGroups(c *gin.Context) {
+	filter, page, ok := scimListParams(c)
+	if !ok {
+		return
+	}
+	scimModel := models.SCIMModel{DB: sc.DB}
+	records, total, err := scimModel.ListGroups(filter, page, scimWithMembers(c))
+	if errors.Is(err, services.ErrSCIMInvalidFilter) {
+		scimBadRequest(c, err)
+		return
+	}
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Error loading groups")
+		return
+	}
+
+	groups := make([]services.SCIMGroup, 0, len(records))
+	for i := range records {
+		groups = append(groups, toSCIMGroup(&records[i]))
+	}
+	scimJSON(c, http.StatusOK, services.SCIMListResponse{
+		Schemas:      []string{services.SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   page.StartIndex,
+		ItemsPerPage: len(groups),
+		Resources:    groups,
+	})
+}
+
+// GetGroup godoc
+// @Summary Ver un grupo (SCIM)
+// @Tags scim
+// @Produce json
+// @Param id path int true "ID del rol"
+// @Param excludedAttributes query string false "members para no traer los miembros"
+// @Success 200 {object} services.SCIMGroup
+// @Failure 401 {object} services.SCIMError
+// @Failure 404 {object} services.SCIMError
+// @Security ApiKeyAuth
+// @Router /scim/v2/Groups/{id} [get]
+func (sc *SCIMController) GetGroup(c *gin.Context) {
+	group, ok := scimTargetGroup(c, sc.DB, scimWithMembers(c))
+	if !ok {
+		return
+	}
+	scimJSON(c, http.StatusOK, toSCIMGroup(group))
+}
+
+// CreateGroup godoc
+// @Summary Crear un grupo (SCIM)
+// @Description Crea un rol sin permisos con ese nombre; los permisos se los da un administrador.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param group body services.SCIMGroup true "Grupo"
+// @Success 201 {object} services.SCIMGroup
+// @Failure 400 {object} services.SCIMError
+// @Failure 401 {object} services.SCIMError
+// @Failure 409 {object} services.SCIMError
+// @Failure 500 {object} services.SCIMError
+// @Security ApiKeyAuth
+// @Router /scim/v2/Groups [post]
+func (sc *SCIMController) CreateGroup(c *gin.Context) {
+	var req services.SCIMGroup
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	if err := services.ValidSCIMGroupName(req.DisplayName); err != nil {
+		scimBadRequest(c, err)
+		return
+	}
+	members, err := scimMemberIDs(req.Members)
+	if err != nil {
+		scimBadRequest(c, err)
+		return
+	}
+
+	scimModel := models.SCIMModel{DB: sc.DB}
+	id, err := scimModel.CreateGroup(req.DisplayName)
+	if err != nil {
+		if errors.Is(err, models.ErrSCIMConflict) {
+			scimError(c, http.StatusConflict, "uniqueness", err.Error())
+		} else {
+			scimError(c, http.StatusInternalServerError, "", "Error creating group")
+		}
+		return
+	}
+	if len(members) > 0 {
+		if err := scimModel.UpdateGroupMembers(id, members, nil); err != nil {
+			// Sin sus miembros el grupo no sirve: que el IdP lo vuelva a intentar entero
+			if delErr := scimModel.DeleteGroup(id); delErr != nil {
+				scimError(c, http.StatusInternalServerError, "", "Error creating group")
+				return
+			}
+			scimMembersError(c, err)
+			return
+		}
+	}
+	recordAudit(c, sc.DB, models.AuditSCIMGroupCreated, 0, gin.H{"group_id": id, "name": req.DisplayName, "members": members})
+
+	group, err := scimModel.GetGroup(id, true)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Error loading group")
+		return
+	}
+	created := toSCIMGroup(group)
+	c.Header("Location", created.Meta.Location)
+	scimJSON(c, http.StatusCreated, created)
+}
+
+// ReplaceGroup godoc
+// @Summary Sustituir un grupo (SCIM)
+// @Description Cambia el nombre y los miembros del rol. Los roles de serie no se renombran y los miembros de owner solo se cambian desde la consola de administración.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path int true "ID del rol"
+// @Param group body services.SCIMGroup true "Grupo"
+// @Success 200 {object} services.SCIMGroup
+// @Failure 400 {object} services.SCIMError
+// @Failure 401 {object} services.SCIMError
+// @Failure 404 {object} services.SCIMError
+// @Failure 409 {object} services.SCIMError
+// @Failure 500 {object} services.SCIMError
+// @Security ApiKeyAuth
+// @Router /scim/v2/Groups/{id} [put]
+func (sc *SCIMController) ReplaceGroup(c *gin.Context) {
+	record, ok := scimTargetGroup(c, sc.DB, true)
+	if !ok {
+		return
+	}
+	var req services.SCIMGroup
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	sc.saveGroup(c, record, req)
+}
+
+// PatchGroup godoc
+// @Summary Modificar un grupo (SCIM)
+// @Description Operaciones add, replace y remove sobre members (también members[value eq "id"]) y displayName. Mismas reglas que al sustituirlo.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path int true "ID del rol"
+// @Param patch body services.SCIMPatchRequest true "Operaciones"
+// @Success 200 {object} services.SCIMGroup
+// @Failure 400 {object} services.SCIMError
+// @Failure 401 {object} services.SCIMError
+// @Failure 404 {object} services.SCIMError
+// @Failure 409 {object} services.SCIMError
+// @Failure 500 {object} services.SCIMError
+// @Security ApiKeyAuth
+// @Router /scim/v2/Groups/{id} [patch]
+func (sc *SCIMController) PatchGroup(c *gin.Context) {
+	record, ok := scimTargetGroup(c, sc.DB, true)
+	if !ok {
+		return
+	}
+	var req services.SCIMPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+	group := toSCIMGroup(record)
+	if err := services.ApplySCIMGroupPatch(&group, req.Operations); err != nil {
+		scimBadRequest(c, err)
+		return
+	}
+	sc.saveGroup(c, record, group)
+}
+
+// DeleteGroup godoc
+// @Summary Borrar un grupo (SCIM)
+// @Description Borra el rol y sus miembros lo pierden. Los roles de serie no se borran.
+// @Tags scim
+// @Param id path int true "ID del rol"
+// @Success 204
+// @Failure 400 {object} services.SCIMError
+// @Failure 401 {object} services.SCIMError
+// @Failure 404 {object} services.SCIMError
+// @Failure 500 {object} services.SCIMError
+// @Security ApiKeyAuth
+// @Router /scim/v2/Groups/{id} [delete]
+func (sc *SCIMController) DeleteGroup(c *gin.Context) {
+	record, ok := scimTargetGroup(c, sc.DB, false)
+	if !ok {
+		return
+	}
+	if record.BuiltIn {
+		scimError(c, http.StatusBadRequest, "mutability", "Built-in roles cannot be deleted")
+		return
+	}
+	scimModel := models.SCIMModel{DB: sc.DB}
+	if err := scimModel.DeleteGroup(record.Id); err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Error deleting group")
+		return
+	}
+	recordAudit(c, sc.DB, models.AuditSCIMGroupDeleted, 0, gin.H{"group_id": record.Id, "name": record.Name})
+	c.Status(http.StatusNoContent)
+}
+
+// saveGroup guarda los cambios de group sobre record y responde con el grupo
+func (sc *SCIMController) saveGroup(c *gin.Context, record *models.SCIMGroupRecord, group services.SCIMGroup) {
+	if err := services.ValidSCIMGroupName(group.DisplayName); err != nil {
+		scimBadRequest(c, err)
+		return
+	}
+	members, err := scimMemberIDs(group.Members)
+	if err != nil {
+		scimBadRequest(c, err)
+		return
+	}
+	var add, remove []int
+	for _, id := range members {
+		if !slices.ContainsFunc(record.Members, func(m models.SCIMMember) bool { return m.Id == id }) {
+			add = append(add, id)
+		}
+	}
+	for _, member := range record.Members {
+		if !slices.Contains(members, member.Id) {
+			remove = append(remove, member.Id)
+		}
+	}
+	renamed := group.DisplayName != record.Name
+	if renamed && record.BuiltIn {
+		scimError(c, http.StatusBadRequest, "mutability", "Built-in roles cannot be renamed")
+		return
+	}
+	// Quién es owner lo deciden los owners, no el IdP
+	if record.Name == services.RoleOwner && len(add)+len(remove) > 0 {
+		scimError(c, http.StatusBadRequest, "mutability", "Owners are managed from the admin console")
+		return
+	}
+
+	scimModel := models.SCIMModel{DB: sc.DB}
+	if renamed {
+		if err := scimModel.RenameGroup(record.Id, group.DisplayName); err != nil {
+			if errors.Is(err, models.ErrSCIMConflict) {
+				scimError(c, http.StatusConflict, "uniqueness", err.Error())
+			} else {
+				scimError(c, http.StatusInternalServerError, "", "Error updating group")
+			}
+			return
+		}
+	}
+	if len(add)+len(remove) > 0 {
+		if err := scimModel.UpdateGroupMembers(record.Id, add, remove); err != nil {
+			scimMembersError(c, err)
+			return
+		}
+	}
+	if renamed || len(add)+len(remove) > 0 {
+		recordAudit(c, sc.DB, models.AuditSCIMGroupUpdated, 0, gin.H{
+			"group_id": record.Id, "name": group.DisplayName, "added": add, "removed": remove,
+		})
+	}
+
+	saved, err := scimModel.GetGroup(record.Id, true)
+	if err != nil {
+		scimError(c, http.StatusInternalServerError, "", "Error loading group")
+		return
+	}
+	scimJSON(c, http.StatusOK, toSCIMGroup(saved))
+}
+
+// scimMembersError responde al error de UpdateGroupMembers
+func scimMembersError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, models.ErrSCIMUnknownMember):
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+	case errors.Is(err, models.ErrLastOwner):
+		scimError(c, http.StatusConflict, "", err.Error())
+	default:
+		scimError(c, http.StatusInternalServerError, "", "Error updating group members")
+	}
+}
+
+// scimListParams lee filter, startIndex y count; si no valen responde 400
+func scimListParams(c *gin.Context) (services.SCIMFilter, services.SCIMPage, bool) {
+	filter, err := services.ParseSCIMFilter(c.Query("filter"))
+	if err != nil {
+		scimBadRequest(c, err)
+		return nil, services.SCIMPage{}, false
+	}
+	page, err := services.ParseSCIMPage(c.Query("startIndex"), c.Query("count"))
+	if err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return nil, services.SCIMPage{}, false
+	}
+	return filter, page, true
+}
+
+// scimWithMembers indica si hay que traer los miembros de los grupos
+func scimWithMembers(c *gin.Context) bool {
+	for _, attribute := range strings.Split(c.Query("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attribute), "members") {
+			return false
+		}
+	}
+	return true
+}
+
+// scimTargetUser carga el usuario de :id; si no existe responde 404
+func scimTargetUser(c *gin.Context, db *sql.DB) (*models.SCIMAccount, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		scimError(c, http.StatusNotFound, "", "User not found")
+		return nil, false
+	}
+	scimModel := models.SCIMModel{DB: db}
+	account, err := scimModel.GetUser(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			scimError(c, http.StatusNotFound, "", "User not found")
+		} else {
+			scimError(c, http.StatusInternalServerError, "", "Error loading user")
+		}
+		return nil, false
+	}
+	return account, true
+}
+
+// scimTargetGroup carga el rol de :id; si no existe responde 404
+func scimTargetGroup(c *gin.Context, db *sql.DB, withMembers bool) (*models.SCIMGroupRecord, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		scimError(c, http.StatusNotFound, "", "Group not found")
+		return nil, false
+	}
+	scimModel := models.SCIMModel{DB: db}
+	group, err := scimModel.GetGroup(id, withMembers)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			scimError(c, http.StatusNotFound, "", "Group not found")
+		} else {
+			scimError(c, http.StatusInternalServerError, "", "Error loading group")
+		}
+		return nil, false
+	}
+	return group, true
+}
+
+// scimMemberIDs convierte los miembros de un grupo en IDs de usuario
+func scimMemberIDs(members []services.SCIMRef) ([]int, error) {
+	ids := []int{}
+	for _, member := range members {
+		id, err := strconv.Atoi(member.Value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: member %q is not a user id", services.ErrSCIMInvalidValue, member.Value)
+		}
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func toSCIMUser(account *models.SCIMAccount) services.SCIMUser {
+	id := strconv.Itoa(account.Id)
+	active := account.Active
+	groups := make([]services.SCIMRef, 0, len(account.Groups))
+	for _, group := range account.Groups {
+		groupID := strconv.Itoa(group.Id)
+		groups = append(groups, services.SCIMRef{Value: groupID, Display: group.Name, Ref: scimGroupsPath + "/" + groupID})
+	}
+	return services.SCIMUser{
+		Schemas:     []string{services.SCIMSchemaUser},
+		Id:          id,
+		ExternalID:  account.ExternalID,
+		UserName:    account.UserName,
+		DisplayName: account.UserName,
+		Emails:      []services.SCIMEmail{{Value: account.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Groups:      groups,
+		Meta:        &services.SCIMMeta{ResourceType: "User", Location: scimUsersPath + "/" + id},
+	}
+}
+
+func toSCIMGroup(group *models.SCIMGroupRecord) services.SCIMGroup {
+	id := strconv.Itoa(group.Id)
+	var members []services.SCIMRef
+	for _, member := range group.Members {
+		memberID := strconv.Itoa(member.Id)
+		members = append(members, services.SCIMRef{Value: memberID, Display: member.UserName, Ref: scimUsersPath + "/" + memberID})
+	}
+	return services.SCIMGroup{
+		Schemas:     []string{services.SCIMSchemaGroup},
+		Id:          id,
+		DisplayName: group.Name,
+		Members:     members,
+		Meta:        &services.SCIMMeta{ResourceType: "Group", Location: scimGroupsPath + "/" + id},
+	}
+}
+
+// scimJSON responde con el tipo de contenido de SCIM
+func scimJSON(c *gin.Context, status int, body any) {
+	c.Header("Content-Type", services.SCIMContentType)
+	c.JSON(status, body)
+}
+
+// scimError responde con un error en el formato de SCIM
+func scimError(c *gin.Context, status int, scimType, detail string) {
+	scimJSON(c, status, services.NewSCIMError(status, scimType, detail))
+}
+
+// scimBadRequest responde 400 a un error de filtro, path o valor
+func scimBadRequest(c *gin.Context, err error) {
+	scimError(c, http.StatusBadRequest, services.SCIMErrorType(err), err.Error())
+}
@@ -0,0 +1,151 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"password-manager-backend/cmd/api/models"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// serveSCIM manda la petición del IdP a /scim/v2/Users/:id
+func serveSCIM(method, id, body string, handler gin.HandlerFunc) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Handle(method, "/scim/v2/Users/:id", handler)
+	req := httptest.NewRequest(method, "/scim/v2/Users/"+id, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/scim+json")
+	req.RemoteAddr = "192.0.2.1:1234"
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+var scimAccountColumns = []string{"id", "username", "email", "external_id", "active", "groups"}
+
+// expectSCIMAccount espera la lectura del usuario 7
+func expectSCIMAccount(mock sqlmock.Sqlmock, username, externalID string, active bool) {
+	mock.ExpectQuery(q("FROM users WHERE id = ?")).WithArgs(7).WillReturnRows(sqlmock.NewRows(scimAccountColumns).
+		AddRow(7, username, "luis@example.com", externalID, active, "2:user"))
+}
+
+// expectLastOwner espera la comprobación de si el usuario 7 es el último owner
+func expectLastOwner(mock sqlmock.Sqlmock, last bool) {
+	isOwner := 0
+	if last {
+		isOwner = 1
+	}
+	mock.ExpectQuery(q("SELECT COUNT(*), COALESCE(SUM(ur.user_id = ?), 0) FROM user_roles")).
+		WillReturnRows(sqlmock.NewRows([]string{"owners", "is_owner"}).AddRow(1, isOwner))
+}
+
+// expectDeprovision espera que se desactive el usuario 7, se cierren sus sesiones
+// y quede en el registro como acción del IdP
+func expectDeprovision(mock sqlmock.Sqlmock) {
+	mock.ExpectBegin()
+	mock.ExpectExec(q("UPDATE users SET disabled_at = COALESCE(disabled_at, CURRENT_TIMESTAMP) WHERE id = ?")).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ?")).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(q("DELETE FROM sessions WHERE user_id = ?")).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(q("INSERT INTO audit_log")).
+		WithArgs(0, models.AuditSCIMUserDeprovisioned, 7, []byte(nil), "192.0.2.1").WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func TestSCIMPatchUser(t *testing.T) {
+	db, mock := newMockDB(t)
+	sc := SCIMController{DB: db}
+
+	expectSCIMAccount(mock, "luis", "", true)
+	mock.ExpectExec(q("UPDATE users SET token_version = token_version + (email <> ?)")).
+		WithArgs("luis@example.com", "luis.g", "luis@example.com", "ext-7", 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("INSERT INTO audit_log")).
+		WithArgs(0, models.AuditSCIMUserUpdated, 7, sqlmock.AnyArg(), "192.0.2.1").WillReturnResult(sqlmock.NewResult(1, 1))
+	expectSCIMAccount(mock, "luis.g", "ext-7", true)
+
+	w := serveSCIM(http.MethodPatch, "7", `{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [
+		{"op": "replace", "path": "userName", "value": "luis.g"},
+		{"op": "add", "value": {"externalId": "ext-7"}}]}`, sc.PatchUser)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if body := decode(t, w); body["userName"] != "luis.g" || body["externalId"] != "ext-7" || body["active"] != true {
+		t.Errorf("unexpected user %v", body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSCIMPatchDeprovisions(t *testing.T) {
+	db, mock := newMockDB(t)
+	sc := SCIMController{DB: db}
+
+	// replace de active a false: la cuenta se desactiva pero no se borra
+	expectSCIMAccount(mock, "luis", "", true)
+	expectLastOwner(mock, false)
+	expectDeprovision(mock)
+	expectSCIMAccount(mock, "luis", "", false)
+
+	w := serveSCIM(http.MethodPatch, "7", `{"Operations": [{"op": "replace", "path": "active", "value": false}]}`, sc.PatchUser)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if body := decode(t, w); body["active"] != false {
+		t.Errorf("expected an inactive user, got %v", body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSCIMPatchInvalid(t *testing.T) {
+	db, mock := newMockDB(t)
+	sc := SCIMController{DB: db}
+
+	// Una operación inválida no cambia nada
+	expectSCIMAccount(mock, "luis", "", true)
+	w := serveSCIM(http.MethodPatch, "7", `{"Operations": [{"op": "remove", "path": "userName"}]}`, sc.PatchUser)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSCIMDeleteUser(t *testing.T) {
+	db, mock := newMockDB(t)
+	sc := SCIMController{DB: db}
+
+	expectSCIMAccount(mock, "luis", "", true)
+	expectLastOwner(mock, false)
+	expectDeprovision(mock)
+	if w := serveSCIM(http.MethodDelete, "7", "", sc.DeleteUser); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d: %s", w.Code, w.Body.String())
+	}
+
+	// Ya desprovisionado: no hay nada más que hacer
+	expectSCIMAccount(mock, "luis", "", false)
+	if w := serveSCIM(http.MethodDelete, "7", "", sc.DeleteUser); w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for an inactive user, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSCIMDeleteLastOwner(t *testing.T) {
+	db, mock := newMockDB(t)
+	sc := SCIMController{DB: db}
+
+	expectSCIMAccount(mock, "luis", "", true)
+	expectLastOwner(mock, true)
+	if w := serveSCIM(http.MethodDelete, "7", "", sc.DeleteUser); w.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"password-manager-backend/cmd/api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionController struct {
+	DB *sql.DB
+}
+
+// GetSessions godoc
+// @Summary Mis sesiones abiertas
+// @Description Lista los dispositivos con sesión abierta: nombre, user-agent, IP, inicio y última actividad. current marca la sesión de la petición.
+// @Tags sessions
+// @Produce json
+// @Success 200 {array} models.Session
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/me/sessions [get]
+func (sc *SessionController) GetSessions(c *gin.Context) {
+	sessionModel := models.SessionModel{DB: sc.DB}
+	sessions, err := sessionModel.GetByUserID(c.GetInt("userID"), c.GetString("sessionID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession godoc
+// @Summary Cerrar una sesión
+// @Description Cierra la sesión de otro dispositivo (o la actual): sus tokens dejan de valer al momento.
+// @Tags sessions
+// @Param id path string true "ID de la sesión"
+// @Success 200 {object} map[string]string
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/me/sessions/{id} [delete]
+func (sc *SessionController) RevokeSession(c *gin.Context) {
+	sessionModel := models.SessionModel{DB: sc.DB}
+	if err := sessionModel.Revoke(c.GetInt("userID"), c.Param("id")); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error closing session"})
+		}
+		return
+	}
+	if c.Param("id") == c.GetString("sessionID") {
+		clearSessionCookies(c)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Session closed"})
+}
+
+// RevokeOtherSessions godoc
+// @Summary Cerrar las demás sesiones
+// @Description Cierra todas las sesiones del usuario menos la de la petición
+// @Tags sessions
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/me/sessions [delete]
+func (sc *SessionController) RevokeOtherSessions(c *gin.Context) {
+	sessionModel := models.SessionModel{DB: sc.DB}
+	closed, err := sessionModel.RevokeOthers(c.GetInt("userID"), c.GetString("sessionID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error closing sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Other sessions closed", "closed": closed})
+}
@@ -4,10 +4,12 @@ import (
 	"database/sql"
 	"errors"
 	"log"
+	"math"
 	"net/http"
 	"password-manager-backend/cmd/api/models"
 	"password-manager-backend/cmd/api/services"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,20 +20,17 @@ type UserController struct {
 
 // GetAllUsers godoc
 // @Summary Obtener todos los usuarios
-// @Description Devuelve la lista de usuarios, ocultando la contraseña si no eres admin ni el propio usuario
+// @Description Devuelve la lista de usuarios con sus roles. Necesita el permiso users:view; el hash de la contraseña solo sale con users:view_credentials o en el propio usuario.
 // @Tags users
 // @Produce json
 // @Success 200 {array} models.User
+// @Failure 403 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Security ApiKeyAuth
 // @Router /users [get]
 func (uc *UserController) GetAllUsers(c *gin.Context) {
 	userModel := models.UserModel{DB: uc.DB}
 
-	// Usuario que hace la petición (lo dejó guardado tu middleware IsLogged)
-	requesterID, _ := c.Get("userID")
-	requesterAdmin, _ := c.Get("isAdmin")
-
 	users, err := userModel.GetAll()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -41,8 +40,7 @@ func (uc *UserController) GetAllUsers(c *gin.Context) {
 	// Convertir la lista en algo seguro para devolver
 	var safeUsers []models.User
 	for _, u := range users {
-		// Si no eres admin y no es tu usuario => ocultar password
-		if !(requesterAdmin.(bool) || strconv.Itoa(u.Id) == requesterID.(string)) {
+		if !canSeeCredentials(c, u.Id) {
 			u.Password = ""
 		}
 		safeUsers = append(safeUsers, u)
@@ -65,8 +63,14 @@ func (uc *UserController) GetAllUsers(c *gin.Context) {
 func (uc *UserController) RegisterUser(c *gin.Context) {
 	req, _ := c.Get("registerRequest")       // Es una funcion de clave valor en el contexto y simplemente la obtenemos
 	register := req.(models.RegisterRequest) // Lo convertimos al tipo de dato que querremos
+	defer register.Destroy()
+	// En zero-knowledge nunca llega la contraseña maestra, guardamos el hash de su hash derivado
+	credential := register.Password
+	if register.VaultMode == models.VaultModeZeroKnowledge {
+		credential = register.AuthHash
+	}
 	// Hashear password
-	hashedPassword, err := services.HashPassword(register.Password)
+	hashedPassword, err := services.HashPassword(credential)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Something went wrong hashing the password"})
 		return
@@ -75,10 +79,12 @@ func (uc *UserController) RegisterUser(c *gin.Context) {
 	icon := "https://avatar.iran.liara.run/username?username=" + register.Username
 	// Crear instancia
 	user := models.User{
-		Email:    register.Email,
-		Password: string(hashedPassword),
-		Username: register.Username,
-		Icon:     icon,
+		Email:     register.Email,
+		Password:  string(hashedPassword),
+		Username:  register.Username,
+		Icon:      icon,
+		VaultMode: register.VaultMode,
+		Kdf:       register.Kdf,
 	}
 	// Crear UserModel usando la conexión de la DB
 	userModel := models.UserModel{DB: uc.DB} // .DB() devuelve *sql.DB
@@ -88,19 +94,44 @@ func (uc *UserController) RegisterUser(c *gin.Context) {
 		return
 	}
 
+	// Crear la clave de datos del usuario (envuelta con la clave maestra).
+	// Las bóvedas zero-knowledge no la necesitan: el servidor no cifra nada suyo.
+	if user.VaultMode == models.VaultModeServer {
+		userKeyModel := models.UserKeyModel{DB: uc.DB}
+		if _, err := userKeyModel.Create(user.Id); err != nil {
+			// Sin clave no puede guardar secretos, deshacemos el registro
+			if delErr := userModel.DeleteUserByID(user.Id); delErr != nil {
+				log.Printf("Error rolling back user %d: %v", user.Id, delErr)
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error creating user key"})
+			return
+		}
+	}
+
+	// La cuenta queda sin verificar hasta que se abra el enlace del correo; si falla
+	// el envío se puede pedir otro con /users/auth/resend-verification
+	if err := sendEmailVerification(uc.DB, &user); err != nil {
+		log.Printf("Error creating email verification for user %d: %v", user.Id, err)
+	}
+
+	user.Password = ""
 	c.JSON(http.StatusCreated, user)
 }
 
 // LoginUser godoc
 // @Summary Login de usuario
-// @Description Inicia sesión y devuelve token JWT
+// @Description Inicia sesión y devuelve token JWT. Si el usuario tiene 2FA activo devuelve mfa_required y un mfa_token de 5 minutos que se canjea por la sesión en /users/auth/mfa. Un email desconocido y una contraseña incorrecta dan la misma respuesta. Tras varios fallos seguidos (por cuenta o por IP) hay que esperar cada vez más y al final la cuenta se bloquea un rato: 429 con Retry-After. Con REQUIRE_VERIFIED_EMAIL una cuenta sin el email verificado recibe 403 con email_verification_required. Con PASSWORD_LOGIN_ENABLED=false responde siempre 403: solo se entra por SSO o con passkey. Con LDAP_URL, si el email no es de una cuenta local o la contraseña no es la suya, se prueba contra el directorio; la primera vez se crea la cuenta. Si el directorio no responde devuelve 503. Una cuenta desactivada recibe 403 con account_disabled. Si un administrador ha obligado a cambiar la contraseña no abre sesión: devuelve password_change_required y un password_change_token de 10 minutos para /users/auth/change-password.
 // @Tags users
 // @Accept json
 // @Produce json
 // @Param login body models.LoginRequest true "Datos de login"
 // @Success 202 {object} map[string]interface{}
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 429 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
+// @Failure 503 {object} models.ErrorResponse
 // @Router /users/login [post]
 func (uc *UserController) LoginUser(c *gin.Context) {
 	var body models.LoginRequest
@@ -109,52 +140,372 @@ func (uc *UserController) LoginUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No body sent or malformed body"})
 		return
 	}
-	log.Printf("Login request body: %+v", body)
+	defer body.Destroy()
+	if !services.PasswordLoginEnabled() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password login is disabled, use single sign-on"})
+		return
+	}
+	if err := body.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	userModel := models.UserModel{DB: uc.DB}
-	// Buscar el usuario por email
-	user, err := userModel.GetUserFromEmail(body.Email)
+	throttleModel := models.ThrottleModel{DB: uc.DB}
+	throttleKeys := models.LoginThrottleKeys(body.Email, c.ClientIP())
+	if abortIfThrottled(c, &throttleModel, throttleKeys...) {
+		return
+	}
+
+	user, err := models.Authenticate(loginAuthenticators(uc.DB), &body)
+	if errors.Is(err, models.ErrInvalidCredentials) {
+		abortInvalidCredentials(c, &throttleModel, throttleKeys...)
+		return
+	}
 	if err != nil {
-		var errorString string
-		switch {
-		// Si no ha encontrado columnas
-		case errors.Is(err, sql.ErrNoRows):
-			errorString = "Email not found check the email"
-		default:
-			errorString = "Error finding email"
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": errorString})
+		log.Printf("Error checking credentials: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Authentication service unavailable"})
+		return
+	}
+	// La IP no se perdona: un acierto con una cuenta propia no limpia los fallos con otras
+	if err := throttleModel.Reset(throttleKeys[0]); err != nil {
+		log.Printf("Error resetting login attempts of user %d: %v", user.Id, err)
+	}
+	if abortIfDisabled(c, user) || abortIfEmailNotVerified(c, user) {
 		return
 	}
 
-	// Validar la contraseña
-	passwordValid := services.CheckPassword(body.Password, user.Password)
-	if !passwordValid {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Incorrect password try again"})
+	// Con 2FA activo la contraseña solo da derecho a pedir el segundo factor
+	if user.TOTPEnabled {
+		respondMFAChallenge(c, user)
+		return
+	}
+	if user.MustChangePassword {
+		respondPasswordChangeRequired(c, user)
 		return
 	}
-	// Crear el Token
-	token, err := models.GenerarToken(user.Email, user.Admin)
+	tokens, err := openSession(c, uc.DB, user, false, body.SessionMode)
 	if err != nil {
-		// Si hay error al generar el token
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo generar el token"})
 		return
 	}
 	user.Password = ""
+	tokens["user"] = user
+	c.JSON(http.StatusAccepted, tokens)
+}
+
+// loginAuthenticators son las fuentes de cuentas del login, en orden: primero las
+// cuentas locales y después el directorio LDAP si está configurado
+func loginAuthenticators(db *sql.DB) []models.Authenticator {
+	authenticators := []models.Authenticator{&models.LocalAuthenticator{DB: db}}
+	if directory, err := services.GetLDAPDirectory(); err == nil {
+		authenticators = append(authenticators, &models.DirectoryAuthenticator{DB: db, Directory: directory})
+	}
+	return authenticators
+}
+
+// respondMFAChallenge responde al primer paso de un login con 2FA: un mfa_token de
+// 5 minutos que se canjea por la sesión en /users/auth/mfa
+func respondMFAChallenge(c *gin.Context, user *models.User) {
+	mfaToken, err := models.GenerarMFAToken(user.Id, user.TokenVersion)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo generar el token"})
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{
+		"mfa_required": true,
+		"mfa_token":    mfaToken,
+		"expires_in":   models.MFAChallengeTTL(),
+	})
+}
+
+// respondPasswordChangeRequired responde al login de una cuenta obligada a cambiar la
+// contraseña: en vez de la sesión, un password_change_token de 10 minutos que solo
+// sirve para elegir la nueva en /users/auth/change-password
+func respondPasswordChangeRequired(c *gin.Context, user *models.User) {
+	token, err := models.GenerarPasswordChangeToken(user.Id, user.TokenVersion)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo generar el token"})
+		return
+	}
 	c.JSON(http.StatusAccepted, gin.H{
-		"user":  user,
-		"token": token,
+		"password_change_required": true,
+		"password_change_token":    token,
+		"expires_in":               models.PasswordChangeTTL(),
 	})
 }
 
+// abortIfThrottled responde 429 con Retry-After si alguna de las claves tiene que esperar
+func abortIfThrottled(c *gin.Context, throttleModel *models.ThrottleModel, keys ...models.ThrottleKey) bool {
+	wait, err := throttleModel.RetryAfter(keys...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error checking attempts"})
+		return true
+	}
+	if wait <= 0 {
+		return false
+	}
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many attempts, try again later", "retry_after": seconds})
+	return true
+}
+
+// recordFailedAttempt cuenta un fallo en el limitador; si no se puede guardar se
+// registra y la petición sigue con su respuesta normal
+func recordFailedAttempt(throttleModel *models.ThrottleModel, keys ...models.ThrottleKey) {
+	if err := throttleModel.RecordFailure(keys...); err != nil {
+		log.Printf("Error recording failed attempt: %v", err)
+	}
+}
+
+// abortInvalidCredentials es la única respuesta de un login fallido, exista o no el email
+func abortInvalidCredentials(c *gin.Context, throttleModel *models.ThrottleModel, keys ...models.ThrottleKey) {
+	recordFailedAttempt(throttleModel, keys...)
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+}
+
+// GetLockedAccounts godoc
+// @Summary Cuentas bloqueadas
+// @Description Lista los emails bloqueados ahora mismo por fallos de login. user_id es null si el email no es de ningún usuario.
+// @Tags users
+// @Produce json
+// @Success 200 {array} models.LockedAccount
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/locked [get]
+func (uc *UserController) GetLockedAccounts(c *gin.Context) {
+	throttleModel := models.ThrottleModel{DB: uc.DB}
+	accounts, err := throttleModel.GetLockedAccounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading locked accounts"})
+		return
+	}
+	c.JSON(http.StatusOK, accounts)
+}
+
+// openSession abre una sesión nueva (token de acceso corto y refresh token
+// rotativo) y la apunta con el dispositivo de la petición. En modo cookie pasa los
+// tokens a cookies y los quita de la respuesta.
+func openSession(c *gin.Context, db *sql.DB, user *models.User, mfa bool, sessionMode string) (gin.H, error) {
+	sessionID, err := services.NewTokenFamily()
+	if err != nil {
+		return nil, err
+	}
+	sessionModel := models.SessionModel{DB: db}
+	if err := sessionModel.Create(sessionID, user.Id, c.Request.UserAgent(), c.ClientIP()); err != nil {
+		return nil, err
+	}
+	tokens, err := issueTokens(db, user, sessionID, "", mfa)
+	if err != nil {
+		return nil, err
+	}
+	if sessionMode == models.SessionModeCookie {
+		if err := setSessionCookies(c, tokens); err != nil {
+			return nil, err
+		}
+	}
+	return tokens, nil
+}
+
+// setSessionCookies pasa el token de acceso y el refresh token de la respuesta a
+// cookies HttpOnly, para que nunca estén al alcance de JavaScript, y añade el token
+// CSRF que el cliente tiene que devolver en la cabecera X-CSRF-Token
+func setSessionCookies(c *gin.Context, tokens gin.H) error {
+	// Se mantiene el CSRF de la sesión para no romper peticiones en curso al renovar
+	csrf, err := c.Cookie(services.CSRFCookieName)
+	if err != nil || csrf == "" {
+		if csrf, err = services.NewCSRFToken(); err != nil {
+			return err
+		}
+	}
+
+	secure := services.CookieSecure()
+	accessAge := int(services.AccessTokenTTL().Seconds())
+	refreshAge := int(services.RefreshTokenTTL().Seconds())
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(services.SessionCookieName, tokens["token"].(string), accessAge, "/", "", secure, true)
+	c.SetCookie(services.RefreshCookieName, tokens["refresh_token"].(string), refreshAge, services.RefreshCookiePath, "", secure, true)
+	c.SetCookie(services.CSRFCookieName, csrf, refreshAge, "/", "", secure, false)
+
+	delete(tokens, "token")
+	delete(tokens, "refresh_token")
+	tokens["csrf_token"] = csrf
+	return nil
+}
+
+// clearSessionCookies borra las cookies de sesión del navegador
+func clearSessionCookies(c *gin.Context) {
+	secure := services.CookieSecure()
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(services.SessionCookieName, "", -1, "/", "", secure, true)
+	c.SetCookie(services.RefreshCookieName, "", -1, services.RefreshCookiePath, "", secure, true)
+	c.SetCookie(services.CSRFCookieName, "", -1, "/", "", secure, false)
+}
+
+// issueTokens firma el token de acceso de la sesión y, si no se pasa uno ya rotado,
+// emite su primer refresh token. mfa indica si la sesión se abrió con segundo factor.
+func issueTokens(db *sql.DB, user *models.User, sessionID, refreshToken string, mfa bool) (gin.H, error) {
+	token, err := models.GenerarToken(user.Id, user.TokenVersion, sessionID, mfa)
+	if err != nil {
+		return nil, err
+	}
+	if refreshToken == "" {
+		refreshModel := models.RefreshTokenModel{DB: db}
+		refreshToken, err = refreshModel.Issue(user.Id, user.TokenVersion, sessionID, mfa)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(services.AccessTokenTTL().Seconds()),
+	}, nil
+}
+
+// RefreshToken godoc
+// @Summary Renovar el token de acceso
+// @Description Gasta el refresh token y devuelve un token de acceso nuevo junto al siguiente refresh token. Presentar un refresh token ya usado revoca la sesión entera. En modo cookie el refresh token se lee de su cookie, el body va vacío y hace falta la cabecera X-CSRF-Token.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param refresh body models.RefreshRequest true "Refresh token"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/auth/refresh [post]
+func (uc *UserController) RefreshToken(c *gin.Context) {
+	var body models.RefreshRequest
+	refreshCookie, _ := c.Cookie(services.RefreshCookieName)
+	cookieMode := refreshCookie != ""
+	if cookieMode {
+		csrf, _ := c.Cookie(services.CSRFCookieName)
+		if !services.ValidCSRF(csrf, c.GetHeader(services.CSRFHeaderName)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "CSRF token missing or invalid"})
+			return
+		}
+		body.RefreshToken = services.SecretFromString(refreshCookie)
+	} else if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No body sent or malformed body"})
+		return
+	}
+	defer body.RefreshToken.Destroy()
+
+	refreshModel := models.RefreshTokenModel{DB: uc.DB}
+	rotated, next, err := refreshModel.Rotate(body.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrRefreshTokenReused):
+			log.Printf("Refresh token reused for user %d, session %s revoked", rotated.UserId, rotated.FamilyId)
+			clearSessionCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": models.ErrRefreshTokenInvalid.Error()})
+		case errors.Is(err, models.ErrRefreshTokenInvalid):
+			clearSessionCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case errors.Is(err, models.ErrRefreshAccountDisabled):
+			log.Printf("Refresh token of disabled user %d, session %s revoked", rotated.UserId, rotated.FamilyId)
+			clearSessionCookies(c)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Account disabled", "account_disabled": true})
+		case errors.Is(err, models.ErrRefreshEmailNotVerified):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email not verified", "email_verification_required": true})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error refreshing token"})
+		}
+		return
+	}
+
+	userModel := models.UserModel{DB: uc.DB}
+	user, err := userModel.GetByID(rotated.UserId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error refreshing token"})
+		return
+	}
+	sessionModel := models.SessionModel{DB: uc.DB}
+	if err := sessionModel.Touch(rotated.FamilyId, c.ClientIP()); err != nil {
+		log.Printf("Error updating session of user %d: %v", user.Id, err)
+	}
+	tokens, err := issueTokens(uc.DB, user, rotated.FamilyId, next, rotated.MFA)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo generar el token"})
+		return
+	}
+	if cookieMode {
+		if err := setSessionCookies(c, tokens); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo generar el token"})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout godoc
+// @Summary Cerrar sesión
+// @Description Revoca la sesión del token: su refresh token y los tokens de acceso emitidos dejan de valer. En modo cookie borra además las cookies de sesión.
+// @Tags users
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/auth/logout [post]
+func (uc *UserController) Logout(c *gin.Context) {
+	refreshModel := models.RefreshTokenModel{DB: uc.DB}
+	if err := refreshModel.RevokeFamily(c.GetString("sessionID")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error closing session"})
+		return
+	}
+	clearSessionCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Session closed"})
+}
+
+// Prelogin godoc
+// @Summary Parámetros de derivación de la contraseña maestra
+// @Description Devuelve el algoritmo, la sal y los costes del KDF con los que el cliente deriva su hash de autenticación. Los emails sin bóveda zero-knowledge reciben parámetros deterministas para no revelar qué cuentas existen.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param prelogin body models.PreloginRequest true "Email del usuario"
+// @Success 200 {object} models.PreloginResponse
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/auth/prelogin [post]
+func (uc *UserController) Prelogin(c *gin.Context) {
+	var body models.PreloginRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No body sent or malformed body"})
+		return
+	}
+
+	userModel := models.UserModel{DB: uc.DB}
+	kdf, err := userModel.GetKdfParams(body.Email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding kdf parameters"})
+			return
+		}
+		fake, err := services.FakeKdfParams(body.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding kdf parameters"})
+			return
+		}
+		kdf = &fake
+	}
+
+	c.JSON(http.StatusOK, models.PreloginResponse{Kdf: *kdf})
+}
+
 // GetUserByID godoc
 // @Summary Obtener usuario por ID
-// @Description Devuelve un usuario por su ID, oculta la contraseña si no tiene permisos
+// @Description Devuelve un usuario por su ID. El de otro usuario necesita users:view; la contraseña solo sale con users:view_credentials o en el propio usuario.
 // @Tags users
 // @Produce json
 // @Param id path int true "ID del usuario"
 // @Success 200 {object} models.User
 // @Failure 400 {object} models.ErrorResponse
+// @Failure 403 {object} models.ErrorResponse
 // @Failure 404 {object} models.ErrorResponse
 // @Failure 500 {object} models.ErrorResponse
 // @Security ApiKeyAuth
@@ -177,9 +528,7 @@ func (uc *UserController) GetUserByID(c *gin.Context) {
 		return
 	}
 
-	// Revisar si puede ver la contraseña
-	canSeePassword, exists := c.Get("canSeePassword")
-	if !exists || canSeePassword == false {
+	if !canSeeCredentials(c, user.Id) {
 		user.Password = "" // ocultar contraseña si no puede verla
 	}
 
@@ -188,7 +537,7 @@ func (uc *UserController) GetUserByID(c *gin.Context) {
 
 // GetMe godoc
 // @Summary Obtener mi información
-// @Description Devuelve la información del usuario logueado, incluyendo la contraseña
+// @Description Devuelve la información del usuario logueado, incluyendo la contraseña, sus roles y los permisos que le dan
 // @Tags users
 // @Produce json
 // @Success 200 {object} models.User
@@ -217,12 +566,15 @@ func (uc *UserController) GetMe(c *gin.Context) {
 		return
 	}
 
+	// Los mismos que ha cargado IsLogged, para que el frontend sepa qué enseñar
+	user.Permissions = c.GetStringSlice("permissions")
+
 	c.JSON(http.StatusOK, user)
 }
 
 // UpdateUser godoc
 // @Summary Actualizar usuario
-// @Description Actualiza el nombre de usuario y el email de un usuario específico
+// @Description Actualiza el nombre de usuario y el email de un usuario específico. El de otro usuario necesita users:update y tener todos los permisos que tiene él. Un email distinto no se guarda al momento: se envía un enlace al nuevo (y un aviso al actual) y el cambio se hace al confirmarlo en /users/auth/verify-email.
 // @Tags users
 // @Accept json
 // @Produce json
@@ -232,19 +584,12 @@ func (uc *UserController) GetMe(c *gin.Context) {
 // @Failure 400 {object} models.ErrorResponse "ID inválido o body incorrecto"
 // @Failure 403 {object} models.ErrorResponse "No tienes permisos para actualizar este usuario"
 // @Failure 404 {object} models.ErrorResponse "Usuario no encontrado"
+// @Failure 409 {object} models.ErrorResponse "El email ya lo usa otra cuenta"
+// @Failure 429 {object} models.ErrorResponse "Cambio de email pedido hace menos de un minuto"
 // @Failure 500 {object} models.ErrorResponse "Error interno del servidor"
 // @Security ApiKeyAuth
 // @Router /users/{id} [put]
 func (uc *UserController) UpdateUser(c *gin.Context) {
-	// Verificar permisos desde el middleware
-	canSee, exists := c.Get("canSeePassword")
-	if !exists || canSee.(bool) == false {
-		c.JSON(http.StatusForbidden, gin.H{
-			"error": "No tienes permisos para actualizar este usuario",
-		})
-		return
-	}
-
 	// Obtener ID desde la URL
 	idParam := c.Param("id")
 	id, err := strconv.Atoi(idParam)
@@ -252,6 +597,9 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
 		return
 	}
+	if !authorizeTarget(c, uc.DB, id, "No tienes permisos para actualizar este usuario") {
+		return
+	}
 
 	// Parsear body
 	var req models.UpdateUserRequest
@@ -262,7 +610,7 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 
 	// Usar el modelo
 	userModel := models.UserModel{DB: uc.DB}
-	err = userModel.UpdateUserByID(id, req)
+	user, err := userModel.GetByID(id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Usuario no encontrado"})
@@ -272,31 +620,61 @@ func (uc *UserController) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	// Un email nuevo no se guarda hasta que se confirme desde él
+	emailChange := req.Email != "" && !strings.EqualFold(req.Email, user.Email)
+	if emailChange {
+		other, err := userModel.GetUserFromEmail(req.Email)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if other != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": models.ErrEmailTaken.Error()})
+			return
+		}
+		if err := requestEmailChange(uc.DB, user, req.Email); err != nil {
+			if errors.Is(err, models.ErrEmailVerificationTooSoon) {
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+	}
+
+	if err := userModel.UpdateUserByID(id, req); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Lo que un administrador cambia de otro usuario queda en el registro de auditoría
+	if id != c.GetInt("userID") {
+		recordAudit(c, uc.DB, models.AuditUserUpdated, id, gin.H{"username": req.Userame, "email": req.Email})
+	}
+
+	if emailChange {
+		c.JSON(http.StatusOK, gin.H{
+			"message":              "Usuario actualizado. El email nuevo se guardará al abrir el enlace que le hemos enviado",
+			"email_change_pending": req.Email,
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Usuario actualizado correctamente"})
 }
 
 // DeleteUser godoc
 // @Summary Eliminar usuario
-// @Description Elimina un usuario específico
+// @Description Elimina un usuario específico. Cada uno puede borrar su cuenta; la de otro necesita users:delete y tener todos los permisos que tiene él. El último owner no se puede borrar.
 // @Tags users
 // @Param id path int true "ID del usuario"
 // @Success 200 {object} map[string]string "Usuario eliminado correctamente"
 // @Failure 400 {object} models.ErrorResponse "ID inválido"
 // @Failure 403 {object} models.ErrorResponse "No tienes permisos para eliminar este usuario"
 // @Failure 404 {object} models.ErrorResponse "Usuario no encontrado"
+// @Failure 409 {object} models.ErrorResponse "Es el último owner"
 // @Failure 500 {object} models.ErrorResponse "Error interno del servidor"
 // @Security ApiKeyAuth
 // @Router /users/{id} [delete]
 func (uc *UserController) DeleteUser(c *gin.Context) {
-	// Verificar permisos desde el middleware
-	canSee, exists := c.Get("canSeePassword")
-	if !exists || canSee.(bool) == false {
-		c.JSON(http.StatusForbidden, gin.H{
-			"error": "No tienes permisos para eliminar este usuario",
-		})
-		return
-	}
-
 	// Obtener ID desde la URL
 	idParam := c.Param("id")
 	id, err := strconv.Atoi(idParam)
@@ -304,6 +682,20 @@ func (uc *UserController) DeleteUser(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
 		return
 	}
+	if !authorizeTarget(c, uc.DB, id, "No tienes permisos para eliminar este usuario") {
+		return
+	}
+	// Sin owner nadie podría volver a repartir los roles
+	roleModel := models.RoleModel{DB: uc.DB}
+	lastOwner, err := roleModel.IsLastOwner(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if lastOwner {
+		c.JSON(http.StatusConflict, gin.H{"error": models.ErrLastOwner.Error()})
+		return
+	}
 
 	// Usar el modelo
 	userModel := models.UserModel{DB: uc.DB}
@@ -316,6 +708,36 @@ func (uc *UserController) DeleteUser(c *gin.Context) {
 		}
 		return
 	}
+	if id != c.GetInt("userID") {
+		recordAudit(c, uc.DB, models.AuditUserDeleted, id, nil)
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Usuario eliminado correctamente"})
 }
+
+// canSeeCredentials indica si quien hace la petición puede ver el hash de la
+// contraseña del usuario: el suyo o con users:view_credentials
+func canSeeCredentials(c *gin.Context, userID int) bool {
+	return userID == c.GetInt("userID") ||
+		services.HasPermissions(c.GetStringSlice("permissions"), services.PermUsersViewCredentials)
+}
+
+// authorizeTarget comprueba que quien hace la petición puede gestionar al usuario:
+// si es otro, tiene que tener todos sus permisos (un admin no toca a un owner). Si
+// no puede responde 403 con message y devuelve false.
+func authorizeTarget(c *gin.Context, db *sql.DB, targetID int, message string) bool {
+	if targetID == c.GetInt("userID") {
+		return true
+	}
+	roleModel := models.RoleModel{DB: db}
+	target, err := roleModel.GetUserPermissions(targetID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if missing := services.MissingPermissions(c.GetStringSlice("permissions"), target); len(missing) > 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": message, "missing_permissions": missing})
+		return false
+	}
+	return true
+}
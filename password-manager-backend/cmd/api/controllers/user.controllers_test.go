@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newMockDB devuelve una base de datos falsa que se cierra al acabar el test
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+// q escapa una consulta para compararla literalmente
+func q(query string) string {
+	return regexp.QuoteMeta(query)
+}
+
+// serve pasa la petición por un router con la ruta indicada y devuelve la respuesta
+func serve(method, path string, body string, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Handle(method, path, handlers...)
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", w.Body.String(), err)
+	}
+	return body
+}
+
+var refreshColumns = []string{"id", "user_id", "family_id", "token_version", "mfa", "created_at",
+	"used", "revoked", "expired", "current_version", "disabled", "email_verified"}
+
+func TestRefreshTokenDisabledAccount(t *testing.T) {
+	db, mock := newMockDB(t)
+	uc := UserController{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q("FROM refresh_tokens rt JOIN users u")).WillReturnRows(sqlmock.NewRows(refreshColumns).
+		AddRow(9, 1, "family", 2, true, "2026-01-01 00:00:00", false, false, false, 2, true, true))
+	mock.ExpectExec(q("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = ?")).
+		WithArgs("family").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := serve(http.MethodPost, "/users/auth/refresh", `{"refresh_token": "refresh"}`, uc.RefreshToken)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", w.Code, w.Body.String())
+	}
+	if body := decode(t, w); body["account_disabled"] != true || body["token"] != nil {
+		t.Errorf("unexpected response %v", body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefreshTokenReused(t *testing.T) {
+	db, mock := newMockDB(t)
+	uc := UserController{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q("FROM refresh_tokens rt JOIN users u")).WillReturnRows(sqlmock.NewRows(refreshColumns).
+		AddRow(9, 1, "family", 2, true, "2026-01-01 00:00:00", true, false, false, 2, false, true))
+	mock.ExpectExec(q("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = ?")).
+		WithArgs("family").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	w := serve(http.MethodPost, "/users/auth/refresh", `{"refresh_token": "refresh"}`, uc.RefreshToken)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+var throttleColumns = []string{"failures", "since_last_failure", "locked_for"}
+
+func TestLoginLockedOut(t *testing.T) {
+	db, mock := newMockDB(t)
+	uc := UserController{DB: db}
+
+	// Con la cuenta bloqueada ni se comprueba la contraseña
+	mock.ExpectQuery(q("FROM auth_throttle WHERE scope = ? AND subject = ?")).WithArgs("login", "ana@example.com").
+		WillReturnRows(sqlmock.NewRows(throttleColumns).AddRow(10, 5, 600))
+	mock.ExpectQuery(q("FROM auth_throttle WHERE scope = ? AND subject = ?")).WithArgs("ip", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(throttleColumns))
+
+	w := serve(http.MethodPost, "/users/login", `{"email": "Ana@example.com", "password": "correct horse battery"}`, uc.LoginUser)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Retry-After"); got != "600" {
+		t.Errorf("expected Retry-After 600, got %q", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoginRecordsFailure(t *testing.T) {
+	db, mock := newMockDB(t)
+	uc := UserController{DB: db}
+
+	mock.ExpectQuery(q("FROM auth_throttle")).WillReturnRows(sqlmock.NewRows(throttleColumns))
+	mock.ExpectQuery(q("FROM auth_throttle")).WillReturnRows(sqlmock.NewRows(throttleColumns))
+	mock.ExpectQuery(q("FROM users WHERE email = ?")).WithArgs("nobody@example.com").WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectQuery(q("FROM auth_throttle")).WillReturnRows(sqlmock.NewRows(throttleColumns))
+	mock.ExpectExec(q("INSERT INTO auth_throttle")).WithArgs("login", "nobody@example.com", 1, 0, 0, 1, 0, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(q("FROM auth_throttle")).WillReturnRows(sqlmock.NewRows(throttleColumns))
+	mock.ExpectExec(q("INSERT INTO auth_throttle")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	w := serve(http.MethodPost, "/users/login", `{"email": "nobody@example.com", "password": "correct horse battery"}`, uc.LoginUser)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
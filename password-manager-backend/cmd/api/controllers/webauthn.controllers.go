@@ -0,0 +1,275 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebAuthnController struct {
+	DB *sql.DB
+}
+
+// BeginPasskeyRegistration godoc
+// @Summary Empezar el registro de una passkey
+// @Description Devuelve las opciones para navigator.credentials.create y el session_id del reto, que caduca a los 5 minutos. La passkey se crea residente y con verificación de usuario.
+// @Tags passkeys
+// @Produce json
+// @Success 200 {object} models.PasskeyOptionsResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/passkeys/register/begin [post]
+func (wc *WebAuthnController) BeginPasskeyRegistration(c *gin.Context) {
+	userModel := models.UserModel{DB: wc.DB}
+	user, err := userModel.GetByID(c.GetInt("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding user"})
+		return
+	}
+	webauthnModel := models.WebAuthnModel{DB: wc.DB}
+	passkeyUser, err := webauthnModel.PasskeyUser(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading passkeys"})
+		return
+	}
+
+	wa, err := services.NewWebAuthn()
+	if err != nil {
+		log.Printf("WebAuthn configuration error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Passkeys are not configured"})
+		return
+	}
+	creation, session, err := services.BeginPasskeyRegistration(wa, passkeyUser)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting passkey registration"})
+		return
+	}
+	sessionID, err := webauthnModel.SaveSession(user.Id, models.CeremonyRegistration, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting passkey registration"})
+		return
+	}
+	c.JSON(http.StatusOK, models.PasskeyOptionsResponse{SessionID: sessionID, Options: creation})
+}
+
+// FinishPasskeyRegistration godoc
+// @Summary Terminar el registro de una passkey
+// @Description Valida la respuesta de navigator.credentials.create contra el reto y guarda la clave pública con el nombre elegido
+// @Tags passkeys
+// @Accept json
+// @Produce json
+// @Param passkey body models.PasskeyRegisterFinishRequest true "Respuesta del autenticador"
+// @Success 201 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 409 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/passkeys/register/finish [post]
+func (wc *WebAuthnController) FinishPasskeyRegistration(c *gin.Context) {
+	var body models.PasskeyRegisterFinishRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := c.GetInt("userID")
+	webauthnModel := models.WebAuthnModel{DB: wc.DB}
+	session, err := webauthnModel.ConsumeSession(body.SessionID, userID, models.CeremonyRegistration)
+	if err != nil {
+		abortPasskeySessionError(c, err)
+		return
+	}
+	userModel := models.UserModel{DB: wc.DB}
+	user, err := userModel.GetByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error finding user"})
+		return
+	}
+	passkeyUser, err := webauthnModel.PasskeyUser(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading passkeys"})
+		return
+	}
+
+	wa, err := services.NewWebAuthn()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Passkeys are not configured"})
+		return
+	}
+	credential, err := services.FinishPasskeyRegistration(wa, passkeyUser, session, body.Credential)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid passkey response"})
+		return
+	}
+	if err := webauthnModel.SaveCredential(userID, body.Name, credential); err != nil {
+		if errors.Is(err, models.ErrPasskeyExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving passkey"})
+		}
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"message": "Passkey registered"})
+}
+
+// GetPasskeys godoc
+// @Summary Mis passkeys
+// @Description Lista las passkeys del usuario. clone_warning indica que se detectó un autenticador clonado y la passkey ya no sirve.
+// @Tags passkeys
+// @Produce json
+// @Success 200 {array} models.Passkey
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/passkeys [get]
+func (wc *WebAuthnController) GetPasskeys(c *gin.Context) {
+	webauthnModel := models.WebAuthnModel{DB: wc.DB}
+	passkeys, err := webauthnModel.GetByUserID(c.GetInt("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading passkeys"})
+		return
+	}
+	c.JSON(http.StatusOK, passkeys)
+}
+
+// DeletePasskey godoc
+// @Summary Borrar una passkey
+// @Tags passkeys
+// @Param id path int true "ID de la passkey"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 404 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Security ApiKeyAuth
+// @Router /users/passkeys/{id} [delete]
+func (wc *WebAuthnController) DeletePasskey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ID inválido"})
+		return
+	}
+	webauthnModel := models.WebAuthnModel{DB: wc.DB}
+	if err := webauthnModel.DeleteCredential(c.GetInt("userID"), id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Passkey not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error deleting passkey"})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey deleted"})
+}
+
+// BeginPasskeyLogin godoc
+// @Summary Empezar el login con passkey
+// @Description Devuelve las opciones para navigator.credentials.get. No hace falta el email: el autenticador ofrece las passkeys que tenga para este dominio.
+// @Tags users
+// @Produce json
+// @Success 200 {object} models.PasskeyOptionsResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/auth/passkey/begin [post]
+func (wc *WebAuthnController) BeginPasskeyLogin(c *gin.Context) {
+	wa, err := services.NewWebAuthn()
+	if err != nil {
+		log.Printf("WebAuthn configuration error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Passkeys are not configured"})
+		return
+	}
+	assertion, session, err := services.BeginPasskeyLogin(wa)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting passkey login"})
+		return
+	}
+	webauthnModel := models.WebAuthnModel{DB: wc.DB}
+	sessionID, err := webauthnModel.SaveSession(0, models.CeremonyLogin, session)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error starting passkey login"})
+		return
+	}
+	c.JSON(http.StatusOK, models.PasskeyOptionsResponse{SessionID: sessionID, Options: assertion})
+}
+
+// FinishPasskeyLogin godoc
+// @Summary Terminar el login con passkey
+// @Description Verifica la firma del autenticador y abre una sesión. La passkey exige verificación de usuario, así que la sesión cuenta como abierta con segundo factor. Un contador de firmas que no avanza bloquea la passkey. En bóvedas zero-knowledge el cliente sigue necesitando la contraseña maestra para descifrar.
+// @Tags users
+// @Accept json
+// @Produce json
+// @Param passkey body models.PasskeyFinishRequest true "Respuesta del autenticador"
+// @Success 202 {object} map[string]interface{}
+// @Failure 400 {object} models.ErrorResponse
+// @Failure 401 {object} models.ErrorResponse
+// @Failure 500 {object} models.ErrorResponse
+// @Router /users/auth/passkey/finish [post]
+func (wc *WebAuthnController) FinishPasskeyLogin(c *gin.Context) {
+	var body models.PasskeyFinishRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No body sent or malformed body"})
+		return
+	}
+
+	webauthnModel := models.WebAuthnModel{DB: wc.DB}
+	session, err := webauthnModel.ConsumeSession(body.SessionID, 0, models.CeremonyLogin)
+	if err != nil {
+		abortPasskeySessionError(c, err)
+		return
+	}
+	wa, err := services.NewWebAuthn()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Passkeys are not configured"})
+		return
+	}
+
+	var user *models.User
+	lookup := func(rawID, userHandle []byte) (*services.PasskeyUser, error) {
+		found, passkeyUser, err := webauthnModel.GetByHandle(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		user = found
+		return passkeyUser, nil
+	}
+	_, credential, err := services.FinishPasskeyLogin(wa, session, body.Credential, lookup)
+	if errors.Is(err, services.ErrPasskeyCloned) {
+		log.Printf("Passkey sign count regression for user %d, passkey blocked", user.Id)
+		if err := webauthnModel.UpdateAfterLogin(credential); err != nil {
+			log.Printf("Error blocking cloned passkey of user %d: %v", user.Id, err)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid passkey"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid passkey"})
+		return
+	}
+	if err := webauthnModel.UpdateAfterLogin(credential); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error saving passkey"})
+		return
+	}
+	if abortIfDisabled(c, user) || abortIfEmailNotVerified(c, user) {
+		return
+	}
+
+	tokens, err := openSession(c, wc.DB, user, true, body.SessionMode)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "No se pudo generar el token"})
+		return
+	}
+	user.Password = ""
+	tokens["user"] = user
+	c.JSON(http.StatusAccepted, tokens)
+}
+
+// abortPasskeySessionError responde a los errores de ConsumeSession
+func abortPasskeySessionError(c *gin.Context, err error) {
+	if errors.Is(err, models.ErrWebAuthnSessionInvalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Error loading passkey session"})
+}
@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"net/http"
+	"password-manager-backend/cmd/api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireSCIMToken deja pasar solo al IdP, con el bearer token de SCIM_TOKEN. Las
+// rutas SCIM no aceptan sesiones ni PAT: nadie entra a ellas como usuario.
+func RequireSCIMToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", services.SCIMContentType)
+		// Sin SCIM_TOKEN las rutas no existen
+		if !services.SCIMEnabled() {
+			c.AbortWithStatusJSON(http.StatusNotFound, services.NewSCIMError(http.StatusNotFound, "", services.ErrSCIMDisabled.Error()))
+			return
+		}
+		token, err := services.ParseBearerToken(c.GetHeader("Authorization"))
+		if err == nil {
+			err = services.CheckSCIMToken(token)
+		}
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="scim"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, services.NewSCIMError(http.StatusUnauthorized, "", err.Error()))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,30 @@
+package middlewares
+
+import (
+	"net/http"
+	"password-manager-backend/cmd/api/services"
+	"strings"
+	"testing"
+)
+
+func TestRequireSCIMToken(t *testing.T) {
+	token := strings.Repeat("s", services.SCIMMinTokenLength)
+	t.Cleanup(func() { services.SetSCIMToken("") })
+
+	// Sin SCIM_TOKEN las rutas no existen
+	services.SetSCIMToken("")
+	if w := serve("Bearer "+token, RequireSCIMToken()); w.Code != http.StatusNotFound {
+		t.Errorf("expected 404 with SCIM disabled, got %d", w.Code)
+	}
+
+	services.SetSCIMToken(token)
+	for _, authorization := range []string{"", "Bearer wrong", token} {
+		w := serve(authorization, RequireSCIMToken())
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%q: expected 401 with WWW-Authenticate, got %d", authorization, w.Code)
+		}
+	}
+	if w := serve("Bearer "+token, RequireSCIMToken()); w.Code != http.StatusOK {
+		t.Errorf("expected 200 with the token, got %d: %s", w.Code, w.Body.String())
+	}
+}
@@ -1,13 +1,51 @@
 package middlewares
 
 import (
-	"fmt"
+	"errors"
+	"log"
 	"net/http"
 	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
+var errCSRF = errors.New("CSRF token missing or invalid")
+
+// requestToken saca el JWT de la cabecera "Authorization: Bearer" o, en modo sesión,
+// de la cookie HttpOnly. Con la cookie, las peticiones que cambian algo tienen que
+// repetir el token CSRF en la cabecera X-CSRF-Token.
+func requestToken(c *gin.Context) (string, error) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		return services.ParseBearerToken(header)
+	}
+	token, err := c.Cookie(services.SessionCookieName)
+	if err != nil || token == "" {
+		return "", services.ErrMissingBearer
+	}
+	if !services.IsSafeMethod(c.Request.Method) {
+		csrf, _ := c.Cookie(services.CSRFCookieName)
+		if !services.ValidCSRF(csrf, c.GetHeader(services.CSRFHeaderName)) {
+			return "", errCSRF
+		}
+	}
+	return token, nil
+}
+
+// abortTokenError responde al error de requestToken
+func abortTokenError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrMissingBearer):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Falta token"})
+	case errors.Is(err, errCSRF):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	}
+	c.Abort()
+}
+
 func ValidateRegisterRequest() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req models.RegisterRequest
@@ -20,6 +58,15 @@ func ValidateRegisterRequest() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
+		// Validar que los campos cuadren con el modo de bóveda
+		if err := req.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error":   "Invalid request",
+				"details": err.Error(),
+			})
+			c.Abort()
+			return
+		}
 		// Guardar la request validada en el contexto para el controller
 		c.Set("registerRequest", req)
 
@@ -27,101 +74,186 @@ func ValidateRegisterRequest() gin.HandlerFunc {
 	}
 }
 
-func ValidateAdmin() gin.HandlerFunc {
+// RequirePermission va después de IsLogged: la petición sigue solo si los roles
+// del usuario dan todos los permisos pedidos
+func RequirePermission(permissions ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Obtener el header
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header missing"})
-			c.Abort()
+		if !services.HasPermissions(c.GetStringSlice("permissions"), permissions...) {
+			abortMissingPermission(c, permissions)
 			return
 		}
-		// Decodificar el token
-		claims, err := models.DecodificarToken(authHeader)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
-			c.Abort()
+		c.Next()
+	}
+}
+
+// RequireSelfOrPermission es RequirePermission para las rutas /:id de usuarios: el
+// propio usuario siempre puede entrar a la suya
+func RequireSelfOrPermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Param("id") == strconv.Itoa(c.GetInt("userID")) {
+			c.Next()
 			return
 		}
-
-		// Verificar si es admin
-		if !claims.Admin {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
-			c.Abort()
+		if !services.HasPermissions(c.GetStringSlice("permissions"), permissions...) {
+			abortMissingPermission(c, permissions)
 			return
 		}
-
-		// Guardar claims en contexto
-		c.Set("userClaims", claims)
 		c.Next()
 	}
 }
 
-func IsLogged(userModel *models.UserModel) gin.HandlerFunc {
+func abortMissingPermission(c *gin.Context, permissions []string) {
+	c.JSON(http.StatusForbidden, gin.H{"error": "Permission required", "required_permissions": permissions})
+	c.Abort()
+}
+
+// setPermissions guarda en el contexto los permisos de los roles del usuario
+func setPermissions(c *gin.Context, userModel *models.UserModel, userID int) bool {
+	roleModel := models.RoleModel{DB: userModel.DB}
+	permissions, err := roleModel.GetUserPermissions(userID)
+	if err != nil {
+		log.Printf("Error loading permissions of user %d: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Error comprobando los permisos"})
+		c.Abort()
+		return false
+	}
+	c.Set("permissions", permissions)
+	return true
+}
+
+// IsLogged acepta una sesión (JWT) o un token de acceso personal. scopes son los
+// permisos que necesita la ruta con un PAT; sin scopes la ruta no admite PAT.
+func IsLogged(userModel *models.UserModel, scopes ...string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Falta token"})
-			c.Abort()
+		token, err := requestToken(c)
+		if err != nil {
+			abortTokenError(c, err)
+			return
+		}
+		if services.IsPersonalAccessToken(token) {
+			authenticatePersonalAccessToken(c, userModel, token, scopes)
 			return
 		}
 
-		// Validar token y obtener email
-		email, err := models.ValidarToken(authHeader)
+		// Validar token y obtener los claims
+		claims, err := models.DecodificarToken(token)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			c.Abort()
 			return
 		}
 
-		// Buscar usuario
-		user, err := userModel.GetUserFromEmail(email)
+		// Buscar usuario por su ID: el email puede haber pasado a otra cuenta
+		userID, err := claims.UserID()
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			c.Abort()
+			return
+		}
+		user, err := userModel.GetByID(userID)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no encontrado"})
 			c.Abort()
 			return
 		}
 
-		// Guardar en contexto
-		c.Set("userID", user.Id)
-		c.Set("isAdmin", user.Admin)
-
-		c.Next()
-	}
-}
-
-func CanSeePassword(userModel *models.UserModel) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Obtener token
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Falta token"})
+		// Una cuenta desactivada no entra aunque le quede un token sin caducar
+		if user.Disabled {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Account disabled"})
 			c.Abort()
 			return
 		}
-
-		// Validar token y obtener email
-		email, err := models.ValidarToken(authHeader)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		// Tokens emitidos antes de un cambio de contraseña o recuperación ya no valen
+		if claims.TokenVersion != user.TokenVersion {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token revocado"})
 			c.Abort()
 			return
 		}
 
-		// Buscar usuario por email
-		user, err := userModel.GetUserFromEmail(email)
+		// La sesión del token tiene que seguir abierta (sin logout ni reutilización)
+		refreshModel := models.RefreshTokenModel{DB: userModel.DB}
+		active, err := refreshModel.IsFamilyActive(user.Id, claims.SessionID)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Usuario no encontrado"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error comprobando la sesión"})
+			c.Abort()
+			return
+		}
+		if !active {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token revocado"})
 			c.Abort()
 			return
 		}
+		// Última actividad para la lista de sesiones; si falla la petición sigue
+		sessionModel := models.SessionModel{DB: userModel.DB}
+		if err := sessionModel.Touch(claims.SessionID, c.ClientIP()); err != nil {
+			log.Printf("Error updating session of user %d: %v", user.Id, err)
+		}
 
-		// Guardar en contexto si puede ver la contraseña
-		paramID := c.Param("id")
-		if user.Admin || paramID == fmt.Sprintf("%d", user.Id) {
-			c.Set("canSeePassword", true)
+		if !setPermissions(c, userModel, user.Id) {
+			return
+		}
+		// Guardar en contexto
+		c.Set("userID", user.Id)
+		c.Set("sessionID", claims.SessionID)
+		c.Set("vaultMode", user.VaultMode)
+		c.Set("mfa", claims.MFA)
+		c.Set("totpEnabled", user.TOTPEnabled)
+
+		c.Next()
+	}
+}
+
+// authenticatePersonalAccessToken es la parte de IsLogged para los PAT
+func authenticatePersonalAccessToken(c *gin.Context, userModel *models.UserModel, token string, scopes []string) {
+	if len(scopes) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Personal access tokens are not allowed on this route"})
+		c.Abort()
+		return
+	}
+
+	patModel := models.PersonalAccessTokenModel{DB: userModel.DB}
+	user, pat, err := patModel.Authenticate(token)
+	if err != nil {
+		if errors.Is(err, models.ErrPersonalAccessTokenInvalid) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		} else {
-			c.Set("canSeePassword", false)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Error comprobando el token"})
+		}
+		c.Abort()
+		return
+	}
+	if !services.HasScopes(pat.Scopes, scopes) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient scope", "required_scopes": scopes})
+		c.Abort()
+		return
+	}
+
+	// Los scopes del PAT limitan las rutas; dentro de ellas mandan los roles del dueño
+	if !setPermissions(c, userModel, user.Id) {
+		return
+	}
+	c.Set("userID", user.Id)
+	c.Set("vaultMode", user.VaultMode)
+	// El PAT vale como segundo factor solo si la sesión que lo creó lo tenía
+	c.Set("mfa", pat.MFA)
+	c.Set("totpEnabled", user.TOTPEnabled)
+	c.Set("scopes", pat.Scopes)
+
+	c.Next()
+}
+
+// RequireMFA va después de IsLogged en las rutas que tocan credenciales: con la
+// política MFA_REQUIRED activa solo se entra con una sesión abierta con segundo
+// factor. Quien aún no tiene 2FA recibe mfa_enrollment_required para darlo de alta.
+func RequireMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if services.MFARequired() && !c.GetBool("mfa") {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":                   "MFA required",
+				"mfa_enrollment_required": !c.GetBool("totpEnabled"),
+			})
+			c.Abort()
+			return
 		}
 		c.Next()
 	}
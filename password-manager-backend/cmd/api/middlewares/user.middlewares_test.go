@@ -0,0 +1,274 @@
+package middlewares
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newMockDB devuelve una base de datos falsa que se cierra al acabar el test
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+// serve pasa una petición GET /route con la cabecera Authorization por los
+// middlewares; la ruta responde 200 si llega al final
+func serve(authorization string, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	r := gin.New()
+	handlers = append(handlers, func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/route", handlers...)
+	req := httptest.NewRequest(http.MethodGet, "/route", nil)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+const selectPAT = "FROM personal_access_tokens pat JOIN users u ON u.id = pat.user_id"
+
+var patColumns = []string{"id", "scopes", "mfa", "user_id", "email", "username", "vault_mode", "token_version", "totp_enabled"}
+
+// expectPAT espera la búsqueda de un PAT del usuario 1 con esos scopes y ese mfa
+func expectPAT(mock sqlmock.Sqlmock, token, scopes string, mfa bool) {
+	mock.ExpectQuery(regexp.QuoteMeta(selectPAT)).WithArgs(services.HashPersonalAccessToken(token)).WillReturnRows(
+		sqlmock.NewRows(patColumns).AddRow(4, scopes, mfa, 1, "ana@example.com", "ana", models.VaultModeServer, 1, true))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE personal_access_tokens SET last_used_at")).WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+// expectPermissions espera la carga de los permisos de los roles del usuario 1
+func expectPermissions(mock sqlmock.Sqlmock, permissions ...string) {
+	rows := sqlmock.NewRows([]string{"permission"})
+	for _, permission := range permissions {
+		rows.AddRow(permission)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT DISTINCT rp.permission FROM user_roles")).WithArgs(1).WillReturnRows(rows)
+}
+
+func newPAT(t *testing.T) string {
+	t.Helper()
+	token, _, err := services.NewPersonalAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestPersonalAccessTokenScopes(t *testing.T) {
+	db, mock := newMockDB(t)
+	userModel := models.UserModel{DB: db}
+	token := newPAT(t)
+
+	// La ruta no admite PAT: ni se busca el token
+	if w := serve("Bearer "+token, IsLogged(&userModel)); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 on a route without scopes, got %d", w.Code)
+	}
+
+	expectPAT(mock, token, services.ScopeNotesRead, true)
+	if w := serve("Bearer "+token, IsLogged(&userModel, services.ScopeNotesWrite)); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a missing scope, got %d", w.Code)
+	}
+
+	expectPAT(mock, token, services.ScopeNotesRead+" "+services.ScopeNotesWrite, true)
+	expectPermissions(mock)
+	if w := serve("Bearer "+token, IsLogged(&userModel, services.ScopeNotesWrite)); w.Code != http.StatusOK {
+		t.Errorf("expected 200 with the scope, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPersonalAccessTokenRejected(t *testing.T) {
+	db, mock := newMockDB(t)
+	userModel := models.UserModel{DB: db}
+	token := newPAT(t)
+
+	// Revocado, caducado, de antes de un cambio de contraseña o de una cuenta
+	// desactivada: la consulta no lo encuentra
+	mock.ExpectQuery(regexp.QuoteMeta(selectPAT) + ".*u.disabled_at IS NULL").WillReturnRows(sqlmock.NewRows(patColumns))
+	if w := serve("Bearer "+token, IsLogged(&userModel, services.ScopeNotesRead)); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPersonalAccessTokenMFA(t *testing.T) {
+	t.Setenv("MFA_REQUIRED", "true")
+	db, mock := newMockDB(t)
+	userModel := models.UserModel{DB: db}
+	token := newPAT(t)
+
+	// Creado desde una sesión sin segundo factor: no pasa RequireMFA
+	expectPAT(mock, token, services.ScopeNotesRead, false)
+	expectPermissions(mock)
+	if w := serve("Bearer "+token, IsLogged(&userModel, services.ScopeNotesRead), RequireMFA()); w.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for a PAT without MFA, got %d", w.Code)
+	}
+
+	expectPAT(mock, token, services.ScopeNotesRead, true)
+	expectPermissions(mock)
+	if w := serve("Bearer "+token, IsLogged(&userModel, services.ScopeNotesRead), RequireMFA()); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for a PAT with MFA, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// serveUser pasa una petición GET /users/:id por los middlewares
+func serveUser(id, authorization string, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	r := gin.New()
+	handlers = append(handlers, func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/users/:id", handlers...)
+	req := httptest.NewRequest(http.MethodGet, "/users/"+id, nil)
+	req.Header.Set("Authorization", authorization)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRequirePermission(t *testing.T) {
+	db, mock := newMockDB(t)
+	userModel := models.UserModel{DB: db}
+	token := newPAT(t)
+
+	// Los permisos salen de los roles del usuario en la base de datos
+	expectPAT(mock, token, services.ScopeUsersRead, true)
+	expectPermissions(mock, services.PermUsersView)
+	if w := serve("Bearer "+token, IsLogged(&userModel, services.ScopeUsersRead), RequirePermission(services.PermUsersView)); w.Code != http.StatusOK {
+		t.Errorf("expected 200 with the permission, got %d: %s", w.Code, w.Body.String())
+	}
+
+	expectPAT(mock, token, services.ScopeUsersRead, true)
+	expectPermissions(mock, services.PermUsersView)
+	w := serve("Bearer "+token, IsLogged(&userModel, services.ScopeUsersRead), RequirePermission(services.PermUsersView, services.PermLockoutsView))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 without every permission, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), services.PermLockoutsView) {
+		t.Errorf("expected the required permissions in the response, got %s", w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRequireSelfOrPermission(t *testing.T) {
+	cases := []struct {
+		name        string
+		id          string
+		permissions []string
+		want        int
+	}{
+		{"own user", "1", nil, http.StatusOK},
+		{"other user", "2", nil, http.StatusForbidden},
+		{"other user with permission", "2", []string{services.PermUsersView}, http.StatusOK},
+		{"own id with a leading zero", "01", nil, http.StatusForbidden},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			userModel := models.UserModel{DB: db}
+			token := newPAT(t)
+
+			expectPAT(mock, token, services.ScopeUsersRead, true)
+			expectPermissions(mock, c.permissions...)
+			w := serveUser(c.id, "Bearer "+token, IsLogged(&userModel, services.ScopeUsersRead), RequireSelfOrPermission(services.PermUsersView))
+			if w.Code != c.want {
+				t.Errorf("expected %d, got %d: %s", c.want, w.Code, w.Body.String())
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// setJWTKeys firma los tokens del test con una clave Ed25519 nueva
+func setJWTKeys(t *testing.T) {
+	t.Helper()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := services.NewJWTKeySet("test", nil, func(string) ([]byte, error) {
+		return []byte(base64.StdEncoding.EncodeToString(der)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	services.SetJWTKeySet(set)
+	t.Cleanup(func() { services.SetJWTKeySet(nil) })
+}
+
+var userColumns = []string{"id", "email", "username", "icon", "roles", "password", "vault_mode",
+	"token_version", "totp_enabled", "email_verified", "disabled", "must_change_password"}
+
+func TestIsLoggedSession(t *testing.T) {
+	setJWTKeys(t)
+	token, err := models.GenerarToken(1, 2, "family", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name   string
+		active bool
+		want   int
+	}{
+		{"open session", true, http.StatusOK},
+		// La familia no es de este usuario o ya se cerró
+		{"foreign or closed session", false, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			userModel := models.UserModel{DB: db}
+
+			// El usuario sale del sub, no del email
+			mock.ExpectQuery(regexp.QuoteMeta("FROM users WHERE id = ?")).WithArgs(1).WillReturnRows(sqlmock.NewRows(userColumns).
+				AddRow(1, "ana@example.com", "ana", "", "user", "hash", models.VaultModeServer, 2, false, true, false, false))
+			mock.ExpectQuery(regexp.QuoteMeta("WHERE family_id = ? AND user_id = ?")).WithArgs("family", 1).
+				WillReturnRows(sqlmock.NewRows([]string{"active"}).AddRow(c.active))
+			if c.active {
+				mock.ExpectExec(regexp.QuoteMeta("UPDATE sessions SET last_seen_at")).WillReturnResult(sqlmock.NewResult(0, 1))
+				expectPermissions(mock)
+			}
+			if w := serve("Bearer "+token, IsLogged(&userModel)); w.Code != c.want {
+				t.Errorf("expected %d, got %d: %s", c.want, w.Code, w.Body.String())
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
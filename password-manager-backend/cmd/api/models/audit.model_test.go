@@ -0,0 +1,32 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const insertAudit = "INSERT INTO audit_log (actor_id, action, target_user_id, details, ip) VALUES (?, ?, ?, ?, ?)"
+
+func TestAuditRecord(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := AuditModel{DB: db}
+
+	mock.ExpectExec(q(insertAudit)).
+		WithArgs(10, AuditRolesChanged, 7, []byte(`{"granted":["admin"]}`), "192.0.2.1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if err := m.Record(10, AuditRolesChanged, 7, map[string][]string{"granted": {"admin"}}, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Las acciones del IdP van con actor 0 y las de grupos sin usuario afectado
+	mock.ExpectExec(q(insertAudit)).
+		WithArgs(0, AuditSCIMGroupDeleted, nil, sqlmock.AnyArg(), "192.0.2.1").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	if err := m.Record(0, AuditSCIMGroupDeleted, 0, map[string]int{"group_id": 3}, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -2,8 +2,11 @@ package models
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"os"
+	"password-manager-backend/cmd/api/services"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -13,62 +16,291 @@ type RegisterRequestModel struct {
 	DB *sql.DB
 }
 
+// Modos de bóveda: en "server" el servidor cifra los secretos, en "zero_knowledge"
+// el cliente los cifra antes de enviarlos y el servidor solo guarda blobs opacos
+const (
+	VaultModeServer        = "server"
+	VaultModeZeroKnowledge = "zero_knowledge"
+)
+
 type RegisterRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	Username string `json:"username" binding:"required,min=3,max=32"`
-	Password string `json:"password" binding:"required,min=8,max=64"`
+	Email    string           `json:"email" binding:"required,email"`
+	Username string           `json:"username" binding:"required,min=3,max=32"`
+	Password *services.Secret `json:"password"` // ver ValidatePassword
+	// Solo en modo zero_knowledge: hash derivado en el cliente y parámetros del KDF
+	AuthHash  *services.Secret    `json:"auth_hash"`
+	VaultMode string              `json:"vault_mode" binding:"omitempty,oneof=server zero_knowledge"`
+	Kdf       *services.KdfParams `json:"kdf"`
+}
+
+// Destroy borra de memoria las credenciales de la petición
+func (r *RegisterRequest) Destroy() {
+	r.Password.Destroy()
+	r.AuthHash.Destroy()
+}
+
+// ValidatePassword aplica a una contraseña los límites de siempre (8 a 64 caracteres).
+// Los campos *services.Secret no pasan por las reglas min/max del binding.
+func ValidatePassword(field string, password *services.Secret) error {
+	if n := password.RuneCount(); n < 8 || n > 64 {
+		return fmt.Errorf("%s must be between 8 and 64 characters", field)
+	}
+	return nil
+}
+
+// validateAuthHash comprueba que el hash derivado en el cliente sea base64 de 44 a 88 caracteres
+func validateAuthHash(authHash *services.Secret) error {
+	if n := authHash.Len(); n < 44 || n > 88 {
+		return errors.New("auth_hash must be between 44 and 88 characters")
+	}
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(authHash.Len()))
+	_, err := base64.StdEncoding.Decode(decoded, authHash.Bytes())
+	clear(decoded)
+	if err != nil {
+		return errors.New("auth_hash must be base64")
+	}
+	return nil
+}
+
+// Validate comprueba que los campos cuadren con el modo de bóveda elegido
+func (r *RegisterRequest) Validate() error {
+	if r.VaultMode == "" {
+		r.VaultMode = VaultModeServer
+	}
+	if r.VaultMode == VaultModeZeroKnowledge {
+		if !r.Password.IsEmpty() {
+			return errors.New("zero_knowledge vaults must not send the master password")
+		}
+		if r.AuthHash.IsEmpty() || r.Kdf == nil {
+			return errors.New("zero_knowledge vaults need auth_hash and kdf")
+		}
+		if err := validateAuthHash(r.AuthHash); err != nil {
+			return err
+		}
+		return r.Kdf.Validate()
+	}
+	if r.Password.IsEmpty() {
+		return errors.New("password is required")
+	}
+	if err := ValidatePassword("password", r.Password); err != nil {
+		return err
+	}
+	if !r.AuthHash.IsEmpty() || r.Kdf != nil {
+		return errors.New("auth_hash and kdf are only valid for zero_knowledge vaults")
+	}
+	return nil
 }
 
 type LoginRequestModel struct {
 	DB *sql.DB
 }
 
+// Cómo recibe el cliente la sesión: tokens en el body o cookies HttpOnly con CSRF
+const (
+	SessionModeToken  = "token"
+	SessionModeCookie = "cookie"
+)
+
 type LoginRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8,max=64"`
+	Email       string           `json:"email" binding:"required,email"`
+	Password    *services.Secret `json:"password" binding:"required_without=AuthHash"`
+	AuthHash    *services.Secret `json:"auth_hash" binding:"required_without=Password"`
+	SessionMode string           `json:"session_mode" binding:"omitempty,oneof=token cookie"`
 }
 
-// Claims define el contenido del JWT
+// Validate aplica los límites de longitud de las credenciales que se hayan enviado
+func (r *LoginRequest) Validate() error {
+	if !r.Password.IsEmpty() {
+		if err := ValidatePassword("password", r.Password); err != nil {
+			return err
+		}
+	}
+	if !r.AuthHash.IsEmpty() {
+		return validateAuthHash(r.AuthHash)
+	}
+	return nil
+}
+
+// Destroy borra de memoria las credenciales de la petición
+func (r *LoginRequest) Destroy() {
+	r.Password.Destroy()
+	r.AuthHash.Destroy()
+}
+
+type PreloginRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// PreloginResponse dice al cliente cómo derivar su hash de autenticación
+type PreloginResponse struct {
+	Kdf services.KdfParams `json:"kdf"`
+}
+
+// Claims define el contenido del JWT. El usuario va en sub (ver UserID). Los
+// permisos no van en el token: se leen de los roles en cada petición, así quitar
+// un rol tiene efecto al momento.
 type Claims struct {
-	Email string `json:"email"`
-	Admin bool   `json:"admin"`
+	// Se compara con users.token_version: al subirla se invalidan los tokens emitidos
+	TokenVersion int `json:"ver"`
+	// Familia de refresh tokens (sesión) de la que sale el token, ver RefreshTokenModel
+	SessionID string `json:"sid"`
+	// La sesión se abrió con segundo factor
+	MFA bool `json:"mfa"`
+	// Vacío en los tokens de acceso; "mfa" en el token del segundo paso del login
+	Purpose string `json:"purpose,omitempty"`
 	jwt.RegisteredClaims
 }
 
-var jwtKey = []byte(os.Getenv("JWT_SECRET"))
+// Propósito del token que devuelve el login cuando falta el segundo factor
+const tokenPurposeMFA = "mfa"
 
-// GenerarToken crea un token con el email
-func GenerarToken(email string, isAdmin bool) (string, error) {
+// mfaChallengeTTL es lo que tiene el usuario para introducir el código
+const mfaChallengeTTL = 5 * time.Minute
+
+// Propósito del token que devuelve el login cuando hay que cambiar la contraseña
+const tokenPurposePasswordChange = "password_change"
+
+// passwordChangeTTL es lo que tiene el usuario para elegir la contraseña nueva
+const passwordChangeTTL = 10 * time.Minute
+
+// keyFunc busca la clave pública del "kid" del token. El algoritmo tiene que ser
+// el de esa clave: así no se acepta un token HS256 firmado con la clave pública.
+func keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	keys, err := services.CurrentJWTKeySet()
+	if err != nil {
+		return nil, fmt.Errorf("JWT keys unavailable: %w", err)
+	}
+	key, err := keys.VerificationKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+// GenerarToken crea un token de acceso de corta duración para una sesión. El
+// usuario va por su ID en sub: el email puede cambiar y pasar a otra cuenta.
+func GenerarToken(userID, tokenVersion int, sessionID string, mfa bool) (string, error) {
 	claims := &Claims{
-		Email: email,
-		Admin: isAdmin,
+		TokenVersion: tokenVersion,
+		SessionID:    sessionID,
+		MFA:          mfa,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			Subject:   strconv.Itoa(userID),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(services.AccessTokenTTL())),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			Issuer:    "mi-app",
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	return signClaims(claims)
+}
 
+// GenerarMFAToken crea el token del primer paso del login: prueba que la contraseña
+// era correcta pero solo sirve para canjearlo por una sesión en /auth/mfa
+func GenerarMFAToken(userID, tokenVersion int) (string, error) {
+	claims := &Claims{
+		TokenVersion: tokenVersion,
+		Purpose:      tokenPurposeMFA,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(userID),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(mfaChallengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "mi-app",
+		},
+	}
+	return signClaims(claims)
 }
 
-func ValidarToken(tokenStr string) (string, error) {
-	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
-	if err != nil || !token.Valid {
-		return "", fmt.Errorf("Invalid token: %v", err)
+// GenerarPasswordChangeToken crea el token del login de una cuenta obligada a cambiar
+// la contraseña: solo sirve para elegir la nueva en /auth/change-password
+func GenerarPasswordChangeToken(userID, tokenVersion int) (string, error) {
+	claims := &Claims{
+		TokenVersion: tokenVersion,
+		Purpose:      tokenPurposePasswordChange,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(userID),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(passwordChangeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    "mi-app",
+		},
+	}
+	return signClaims(claims)
+}
+
+// PasswordChangeTTL es la validez del token de cambio de contraseña, en segundos
+func PasswordChangeTTL() int {
+	return int(passwordChangeTTL.Seconds())
+}
+
+// MFAChallengeTTL es la validez del token del segundo paso, en segundos
+func MFAChallengeTTL() int {
+	return int(mfaChallengeTTL.Seconds())
+}
+
+// UserID es el usuario del token, ver GenerarToken
+func (c *Claims) UserID() (int, error) {
+	id, err := strconv.Atoi(c.Subject)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("Invalid token: bad subject")
 	}
-	return claims.Email, nil
+	return id, nil
 }
 
+func signClaims(claims *Claims) (string, error) {
+	keys, err := services.CurrentJWTKeySet()
+	if err != nil {
+		return "", fmt.Errorf("JWT signing key unavailable: %w", err)
+	}
+	kid, alg, key := keys.SigningKey()
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(alg), claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+// DecodificarToken valida un token de acceso. Los tokens con propósito (como el del
+// segundo paso del login) no abren sesión.
 func DecodificarToken(tokenStr string) (*Claims, error) {
+	claims, err := parseClaims(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != "" {
+		return nil, fmt.Errorf("Invalid token: not an access token")
+	}
+	return claims, nil
+}
+
+// DecodificarMFAToken valida el token del segundo paso del login
+func DecodificarMFAToken(tokenStr string) (*Claims, error) {
+	claims, err := parseClaims(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != tokenPurposeMFA {
+		return nil, fmt.Errorf("Invalid token: not an MFA token")
+	}
+	return claims, nil
+}
+
+// DecodificarPasswordChangeToken valida el token de cambio de contraseña obligado
+func DecodificarPasswordChangeToken(tokenStr string) (*Claims, error) {
+	claims, err := parseClaims(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != tokenPurposePasswordChange {
+		return nil, fmt.Errorf("Invalid token: not a password change token")
+	}
+	return claims, nil
+}
+
+func parseClaims(tokenStr string) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtKey, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenStr, claims, keyFunc,
+		jwt.WithValidMethods([]string{services.JWTAlgEdDSA, services.JWTAlgRS256}))
 	if err != nil || !token.Valid {
 		return nil, fmt.Errorf("Invalid token: %v", err)
 	}
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"password-manager-backend/cmd/api/services"
+)
+
+// ErrInvalidCredentials: ninguna fuente de cuentas acepta el email y la contraseña
+var ErrInvalidCredentials = errors.New("invalid email or password")
+
+// Authenticator comprueba las credenciales de un login contra una fuente de cuentas
+type Authenticator interface {
+	// Authenticate devuelve el usuario de las credenciales o ErrInvalidCredentials.
+	// Cualquier otro error es de la propia fuente (base de datos, directorio caído...).
+	Authenticate(body *LoginRequest) (*User, error)
+}
+
+// Authenticate prueba las fuentes en orden y devuelve el primer usuario que las
+// acepta. Si ninguna lo hace pero alguna ha fallado devuelve ese error y no
+// ErrInvalidCredentials: con el directorio caído no hay que contar fallos al usuario.
+func Authenticate(authenticators []Authenticator, body *LoginRequest) (*User, error) {
+	var sourceErr error
+	for _, authenticator := range authenticators {
+		user, err := authenticator.Authenticate(body)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) && sourceErr == nil {
+			sourceErr = err
+		}
+	}
+	if sourceErr != nil {
+		return nil, sourceErr
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// LocalAuthenticator comprueba la contraseña guardada en users
+type LocalAuthenticator struct {
+	DB *sql.DB
+}
+
+func (a *LocalAuthenticator) Authenticate(body *LoginRequest) (*User, error) {
+	userModel := UserModel{DB: a.DB}
+	user, err := userModel.GetUserFromEmail(body.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if user == nil {
+		// Gastar lo mismo que con una contraseña incorrecta para no delatar el email
+		credential := body.Password
+		if credential.IsEmpty() {
+			credential = body.AuthHash
+		}
+		services.CheckDummyPassword(credential)
+		return nil, ErrInvalidCredentials
+	}
+
+	// Las bóvedas zero-knowledge se autentican con el hash derivado en el cliente
+	credential := body.Password
+	if user.VaultMode == VaultModeZeroKnowledge {
+		credential = body.AuthHash
+	}
+	if !services.CheckPassword(credential, user.Password) {
+		return nil, ErrInvalidCredentials
+	}
+
+	// Migrar hashes bcrypt o con costes antiguos ahora que tenemos la contraseña
+	if services.NeedsRehash(user.Password) {
+		if newHash, err := services.HashPassword(credential); err != nil {
+			log.Printf("Error rehashing password for user %d: %v", user.Id, err)
+		} else if err := userModel.UpdatePassword(user.Id, newHash); err != nil {
+			log.Printf("Error saving rehashed password for user %d: %v", user.Id, err)
+		}
+	}
+	return user, nil
+}
+
+// DirectoryAuthenticator comprueba la contraseña contra el directorio LDAP. La
+// primera vez crea el usuario, como el login con SSO; nunca lo enlaza a una cuenta
+// local con el mismo email. Los grupos del directorio deciden el admin si hay
+// LDAP_ADMIN_GROUPS.
+type DirectoryAuthenticator struct {
+	DB        *sql.DB
+	Directory *services.LDAPDirectory
+}
+
+func (a *DirectoryAuthenticator) Authenticate(body *LoginRequest) (*User, error) {
+	identity, err := a.Directory.Authenticate(body.Email, body.Password)
+	if errors.Is(err, services.ErrLDAPInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	admin, manageAdmin := a.Directory.IsAdmin(identity.Groups)
+	identityModel := IdentityModel{DB: a.DB}
+	user, created, err := identityModel.ProvisionUser(identity, admin, manageAdmin)
+	if errors.Is(err, ErrIdentityEmailMissing) {
+		// Una entrada sin email no puede ser una cuenta
+		return nil, ErrInvalidCredentials
+	}
+	if errors.Is(err, ErrIdentityAccountExists) {
+		// La cuenta local no es de quien tenga la entrada del directorio: no se dice
+		// que existe
+		log.Printf("Directory login of %s refused: a local account already has the email", identity.Subject)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error provisioning directory user: %w", err)
+	}
+	if created {
+		log.Printf("User %d provisioned from %s", user.Id, identity.Issuer)
+	}
+	return user, nil
+}
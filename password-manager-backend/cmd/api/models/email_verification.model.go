@@ -0,0 +1,176 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"password-manager-backend/cmd/api/services"
+	"time"
+)
+
+type EmailVerificationModel struct {
+	DB *sql.DB
+}
+
+var (
+	// ErrEmailVerificationInvalid cubre tokens desconocidos, caducados o ya usados
+	ErrEmailVerificationInvalid = errors.New("invalid or expired verification token")
+	// ErrEmailVerificationTooSoon indica que la cuenta acaba de recibir otro correo
+	ErrEmailVerificationTooSoon = errors.New("email verification requested too recently")
+	// ErrEmailTaken indica que otra cuenta ya usa el email
+	ErrEmailTaken = errors.New("email already in use")
+)
+
+// EmailVerification es un enlace ya confirmado
+type EmailVerification struct {
+	UserId int
+	// Email confirmado: el de la cuenta o el nuevo si Purpose es change
+	Email   string
+	Purpose string
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// Create genera un enlace de verificación de email para el usuario. purpose es
+// services.EmailVerificationPurposeVerify (email de la cuenta) o
+// services.EmailVerificationPurposeChange (email nuevo). Los enlaces anteriores del
+// mismo tipo dejan de valer; si el último se pidió hace menos de
+// EmailVerificationCooldown devuelve ErrEmailVerificationTooSoon.
+func (m *EmailVerificationModel) Create(userID int, email, purpose string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// Bloquear al usuario serializa dos peticiones simultáneas a la misma cuenta
+	var id int
+	if err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE id = ? FOR UPDATE", userID).Scan(&id); err != nil {
+		return "", err
+	}
+	var recent bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM email_verifications
+		WHERE user_id = ? AND purpose = ? AND created_at > CURRENT_TIMESTAMP - INTERVAL ? SECOND)`,
+		userID, purpose, int(services.EmailVerificationCooldown().Seconds()),
+	).Scan(&recent)
+	if err != nil {
+		return "", err
+	}
+	if recent {
+		return "", ErrEmailVerificationTooSoon
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE email_verifications SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND purpose = ? AND used_at IS NULL",
+		userID, purpose,
+	); err != nil {
+		return "", err
+	}
+	token, hash, err := services.NewEmailVerificationToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO email_verifications (user_id, email, purpose, token_hash, expires_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP + INTERVAL ? SECOND)`,
+		userID, email, purpose, hash, int(services.EmailVerificationTTL().Seconds()),
+	)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Confirm gasta el token en una sola transacción. Un enlace verify marca el email
+// de la cuenta como verificado si sigue siendo el mismo al que se envió; uno change
+// guarda el email nuevo ya verificado y cierra las sesiones abiertas con el
+// anterior, o devuelve ErrEmailTaken si otra cuenta lo registró mientras tanto.
+func (m *EmailVerificationModel) Confirm(token string) (*EmailVerification, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var tokenID int
+	var currentEmail string
+	verification := &EmailVerification{}
+	err = tx.QueryRowContext(ctx,
+		`SELECT t.id, t.user_id, t.email, t.purpose, u.email
+		FROM email_verifications t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ? AND t.used_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP
+		FOR UPDATE`,
+		services.HashEmailVerificationToken(token),
+	).Scan(&tokenID, &verification.UserId, &verification.Email, &verification.Purpose, &currentEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEmailVerificationInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch verification.Purpose {
+	case services.EmailVerificationPurposeVerify:
+		// El email cambió después de enviar el enlace: ya no demuestra nada
+		if verification.Email != currentEmail {
+			return nil, ErrEmailVerificationInvalid
+		}
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE users SET email_verified = TRUE WHERE id = ?", verification.UserId,
+		); err != nil {
+			return nil, err
+		}
+	case services.EmailVerificationPurposeChange:
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE users SET email = ?, email_verified = TRUE, token_version = token_version + 1 WHERE id = ?",
+			verification.Email, verification.UserId,
+		); err != nil {
+			if isDuplicateKey(err) {
+				return nil, ErrEmailTaken
+			}
+			return nil, err
+		}
+	default:
+		return nil, ErrEmailVerificationInvalid
+	}
+
+	// Con el email confirmado ningún otro enlace pendiente de la cuenta vale ya
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE email_verifications SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND used_at IS NULL",
+		verification.UserId,
+	); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return verification, nil
+}
+
+// DeleteExpired borra los enlaces caducados, usados o no
+func (m *EmailVerificationModel) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM email_verifications WHERE expires_at < CURRENT_TIMESTAMP")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
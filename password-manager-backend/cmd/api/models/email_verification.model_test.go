@@ -0,0 +1,34 @@
+package models
+
+import (
+	"password-manager-backend/cmd/api/services"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestConfirmEmailChange(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := EmailVerificationModel{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q("FROM email_verifications t JOIN users u")).WithArgs(services.HashEmailVerificationToken("token")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "email", "purpose", "email"}).
+			AddRow(3, 1, "new@example.com", services.EmailVerificationPurposeChange, "old@example.com"))
+	// Los tokens emitidos con el email anterior dejan de valer
+	mock.ExpectExec(q("UPDATE users SET email = ?, email_verified = TRUE, token_version = token_version + 1 WHERE id = ?")).
+		WithArgs("new@example.com", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("UPDATE email_verifications SET used_at")).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	verification, err := m.Confirm("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verification.UserId != 1 || verification.Email != "new@example.com" {
+		t.Errorf("unexpected verification %+v", verification)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
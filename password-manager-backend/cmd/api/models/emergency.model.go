@@ -0,0 +1,253 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"password-manager-backend/cmd/api/services"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type EmergencyAccessModel struct {
+	DB *sql.DB
+}
+
+var (
+	ErrEmergencyExists     = errors.New("this user is already an emergency contact")
+	ErrEmergencyTransition = errors.New("invalid emergency access status change")
+)
+
+// EmergencyAccess relaciona al dueño de las notas (grantor) con su contacto (grantee)
+type EmergencyAccess struct {
+	Id           int        `json:"id"`
+	GrantorId    int        `json:"grantor_id"`
+	GrantorEmail string     `json:"grantor_email"`
+	GranteeId    int        `json:"grantee_id"`
+	GranteeEmail string     `json:"grantee_email"`
+	AccessType   string     `json:"access_type"`
+	WaitDays     int        `json:"wait_days"`
+	Status       string     `json:"status"`
+	RequestedAt  *time.Time `json:"requested_at,omitempty"`
+	GrantedAt    *time.Time `json:"granted_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+type InviteEmergencyContactRequest struct {
+	Email      string `json:"email" binding:"required,email"`
+	AccessType string `json:"access_type" binding:"required,oneof=view takeover"`
+	WaitDays   int    `json:"wait_days" binding:"omitempty,min=1,max=90"`
+}
+
+type EmergencyTakeoverRequest struct {
+	NewPassword *services.Secret `json:"new_password" binding:"required"`
+}
+
+func (r *EmergencyTakeoverRequest) Validate() error {
+	return ValidatePassword("new_password", r.NewPassword)
+}
+
+const emergencyAccessSelect = `SELECT e.id, e.grantor_id, grantor.email, e.grantee_id, grantee.email,
+	e.access_type, e.wait_days, e.status, e.requested_at, e.granted_at, e.created_at
+	FROM emergency_access e
+	JOIN users grantor ON grantor.id = e.grantor_id
+	JOIN users grantee ON grantee.id = e.grantee_id`
+
+func scanEmergencyAccess(row rowScanner) (*EmergencyAccess, error) {
+	var e EmergencyAccess
+	var requestedAt, grantedAt, createdAt []byte
+	err := row.Scan(&e.Id, &e.GrantorId, &e.GrantorEmail, &e.GranteeId, &e.GranteeEmail,
+		&e.AccessType, &e.WaitDays, &e.Status, &requestedAt, &grantedAt, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	e.RequestedAt = parseNullableTime(requestedAt)
+	e.GrantedAt = parseNullableTime(grantedAt)
+	e.CreatedAt, _ = parseTime(createdAt)
+	return &e, nil
+}
+
+func parseNullableTime(b []byte) *time.Time {
+	if b == nil {
+		return nil
+	}
+	t, err := parseTime(b)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// isDuplicateKey detecta el error de MySQL por violar una clave UNIQUE
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
+
+// Insert crea la invitación en estado invited
+func (m *EmergencyAccessModel) Insert(e *EmergencyAccess) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "INSERT INTO emergency_access (grantor_id, grantee_id, access_type, wait_days, status) VALUES (?, ?, ?, ?, ?)"
+	result, err := m.DB.ExecContext(ctx, query, e.GrantorId, e.GranteeId, e.AccessType, e.WaitDays, services.EmergencyInvited)
+	if err != nil {
+		if isDuplicateKey(err) {
+			return ErrEmergencyExists
+		}
+		return fmt.Errorf("error inserting emergency access: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting last insert id: %w", err)
+	}
+	e.Id = int(id)
+	e.Status = services.EmergencyInvited
+	return nil
+}
+
+// GetByID obtiene un acceso de emergencia, concediéndolo antes si la espera ya pasó
+func (m *EmergencyAccessModel) GetByID(id int) (*EmergencyAccess, error) {
+	// No dependemos de que la tarea de fondo haya pasado ya por esta fila
+	if _, err := m.grantExpired("AND id = ?", id); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanEmergencyAccess(m.DB.QueryRowContext(ctx, emergencyAccessSelect+" WHERE e.id = ?", id))
+}
+
+// GetByGrantor lista los contactos de emergencia que ha nombrado el usuario
+func (m *EmergencyAccessModel) GetByGrantor(userID int) ([]EmergencyAccess, error) {
+	return m.list("e.grantor_id = ?", userID)
+}
+
+// GetByGrantee lista los usuarios que han nombrado contacto de emergencia al usuario
+func (m *EmergencyAccessModel) GetByGrantee(userID int) ([]EmergencyAccess, error) {
+	return m.list("e.grantee_id = ?", userID)
+}
+
+func (m *EmergencyAccessModel) list(where string, userID int) ([]EmergencyAccess, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, emergencyAccessSelect+" WHERE "+where+" ORDER BY e.id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accesses := []EmergencyAccess{}
+	for rows.Next() {
+		e, err := scanEmergencyAccess(rows)
+		if err != nil {
+			return nil, err
+		}
+		accesses = append(accesses, *e)
+	}
+	return accesses, rows.Err()
+}
+
+// Transition cambia el estado si la máquina de estados lo permite. La condición
+// sobre el estado actual evita pisar un cambio hecho a la vez por la otra parte.
+func (m *EmergencyAccessModel) Transition(e *EmergencyAccess, to string) error {
+	if !services.CanTransitionEmergency(e.Status, to) {
+		return ErrEmergencyTransition
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE emergency_access SET status = ?,
+		requested_at = CASE WHEN ? = 'requested' THEN CURRENT_TIMESTAMP ELSE requested_at END,
+		granted_at = CASE WHEN ? = 'granted' THEN CURRENT_TIMESTAMP ELSE NULL END
+		WHERE id = ? AND status = ?`
+	result, err := m.DB.ExecContext(ctx, query, to, to, to, e.Id, e.Status)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEmergencyTransition
+	}
+	e.Status = to
+	return nil
+}
+
+// GrantExpired concede las peticiones cuyo dueño no las rechazó a tiempo
+func (m *EmergencyAccessModel) GrantExpired() (int64, error) {
+	return m.grantExpired("")
+}
+
+func (m *EmergencyAccessModel) grantExpired(extra string, args ...any) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE emergency_access SET status = 'granted', granted_at = CURRENT_TIMESTAMP
+		WHERE status = 'requested' AND requested_at + INTERVAL wait_days DAY <= CURRENT_TIMESTAMP ` + extra
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Takeover cambia la contraseña del dueño, le quita el TOTP y revoca sus tokens,
+// sus refresh tokens y sus sesiones en una sola transacción. Sin quitar el TOTP
+// el contacto tendría la contraseña pero no podría entrar. El acceso pasa a taken_over, así que solo
+// sirve una vez: otra petición a la vez no encuentra el acceso concedido.
+func (m *EmergencyAccessModel) Takeover(e *EmergencyAccess, hashedPassword string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE emergency_access SET status = ?
+		WHERE id = ? AND grantor_id = ? AND status = ? AND access_type = ?`,
+		services.EmergencyTakenOver, e.Id, e.GrantorId, services.EmergencyGranted, services.EmergencyAccessTakeover,
+	)
+	if err != nil {
+		return err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrEmergencyTransition
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET password = ?, token_version = token_version + 1 WHERE id = ?",
+		hashedPassword, e.GrantorId,
+	); err != nil {
+		return err
+	}
+	if err := clearTOTP(ctx, tx, e.GrantorId); err != nil {
+		return err
+	}
+	if err := revokeUserSessions(ctx, tx, e.GrantorId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteByID borra el acceso de emergencia
+func (m *EmergencyAccessModel) DeleteByID(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM emergency_access WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"errors"
+	"password-manager-backend/cmd/api/services"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const grantExpiredSQL = "UPDATE emergency_access SET status = 'granted', granted_at = CURRENT_TIMESTAMP"
+
+var emergencyColumns = []string{"id", "grantor_id", "grantor_email", "grantee_id", "grantee_email",
+	"access_type", "wait_days", "status", "requested_at", "granted_at", "created_at"}
+
+func TestGrantExpired(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := EmergencyAccessModel{DB: db}
+
+	mock.ExpectExec(q(grantExpiredSQL) + `.*DAY <= CURRENT_TIMESTAMP\s*$`).WithoutArgs().WillReturnResult(sqlmock.NewResult(0, 2))
+	granted, err := m.GrantExpired()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if granted != 2 {
+		t.Errorf("expected 2 granted requests, got %d", granted)
+	}
+
+	// GetByID concede antes de leer aunque la tarea de fondo no haya pasado
+	mock.ExpectExec(q(grantExpiredSQL) + ".*AND id = \\?").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(q("WHERE e.id = ?")).WithArgs(5).WillReturnRows(sqlmock.NewRows(emergencyColumns).
+		AddRow(5, 1, "owner@example.com", 2, "contact@example.com", services.EmergencyAccessTakeover, 7,
+			services.EmergencyGranted, mockTime, mockTime, mockTime))
+	e, err := m.GetByID(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Status != services.EmergencyGranted || e.GrantedAt == nil {
+		t.Errorf("expected a granted access, got %+v", e)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEmergencyTransition(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := EmergencyAccessModel{DB: db}
+	e := &EmergencyAccess{Id: 5, Status: services.EmergencyRequested}
+
+	// El dueño concede a mano
+	mock.ExpectExec(q("UPDATE emergency_access SET status = ?")).
+		WithArgs(services.EmergencyGranted, services.EmergencyGranted, services.EmergencyGranted, 5, services.EmergencyRequested).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := m.Transition(e, services.EmergencyGranted); err != nil {
+		t.Fatal(err)
+	}
+	if e.Status != services.EmergencyGranted {
+		t.Errorf("expected status granted, got %s", e.Status)
+	}
+
+	// Un cambio que la máquina de estados no permite no llega a la base de datos
+	if err := m.Transition(e, services.EmergencyAccepted); !errors.Is(err, ErrEmergencyTransition) {
+		t.Errorf("expected ErrEmergencyTransition, got %v", err)
+	}
+
+	// La otra parte cambió el estado a la vez
+	mock.ExpectExec(q("UPDATE emergency_access SET status = ?")).WillReturnResult(sqlmock.NewResult(0, 0))
+	if err := m.Transition(e, services.EmergencyRejected); !errors.Is(err, ErrEmergencyTransition) {
+		t.Errorf("expected ErrEmergencyTransition, got %v", err)
+	}
+	if e.Status != services.EmergencyGranted {
+		t.Errorf("a lost race must not change the status, got %s", e.Status)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEmergencyTakeover(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := EmergencyAccessModel{DB: db}
+	e := &EmergencyAccess{Id: 5, GrantorId: 1}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(q("UPDATE emergency_access SET status = ?")).
+		WithArgs(services.EmergencyTakenOver, 5, 1, services.EmergencyGranted, services.EmergencyAccessTakeover).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("UPDATE users SET password = ?, token_version = token_version + 1 WHERE id = ?")).
+		WithArgs("new-hash", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("UPDATE users SET totp_secret_ciphertext = NULL")).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("DELETE FROM mfa_recovery_codes WHERE user_id = ?")).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec(q("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ?")).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(q("DELETE FROM sessions WHERE user_id = ?")).WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := m.Takeover(e, "new-hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sin un acceso takeover concedido (o ya usado) no se cambia nada
+	mock.ExpectBegin()
+	mock.ExpectExec(q("UPDATE emergency_access SET status = ?")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if err := m.Takeover(e, "new-hash"); !errors.Is(err, ErrEmergencyTransition) {
+		t.Errorf("expected ErrEmergencyTransition, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
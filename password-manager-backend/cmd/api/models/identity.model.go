@@ -0,0 +1,213 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"password-manager-backend/cmd/api/services"
+	"time"
+)
+
+// IdentityModel enlaza las cuentas de fuera (OIDC, LDAP) con los usuarios, ver user_identities
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+var (
+	// ErrIdentityEmailMissing: sin email no se puede crear la cuenta
+	ErrIdentityEmailMissing = errors.New("the identity provider did not send an email")
+	// ErrIdentityAccountExists: ya hay una cuenta local con ese email. Nunca se enlaza
+	// por email; el dueño tiene que entrar y enlazarla él, ver Link.
+	ErrIdentityAccountExists = errors.New("an account with this email already exists, sign in and link it first")
+	// ErrIdentityLinked: la identidad ya es de otro usuario
+	ErrIdentityLinked = errors.New("this identity is already linked to another account")
+	// ErrIdentityZeroKnowledge: una bóveda zero-knowledge se abre con la contraseña
+	// maestra, no con un proveedor
+	ErrIdentityZeroKnowledge = errors.New("zero-knowledge accounts cannot sign in with an identity provider")
+)
+
+// ProvisionUser devuelve el usuario de la identidad del proveedor (just-in-time):
+//   - si la identidad ya está enlazada, su usuario
+//   - si no hay nadie con su email, un usuario nuevo con una contraseña aleatoria
+//     que nadie conoce y su clave de datos. El email queda verificado solo si el
+//     proveedor lo verificó.
+//   - si ya hay alguien con ese email, ErrIdentityAccountExists: quien controle el
+//     email en el proveedor no tiene por qué ser el dueño de la cuenta
+//
+// Con manageAdmin el rol admin se da o se quita según admin en cada login.
+// created indica que el usuario es nuevo.
+func (m *IdentityModel) ProvisionUser(identity *services.ExternalIdentity, admin, manageAdmin bool) (user *User, created bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	err = tx.QueryRowContext(ctx,
+		"SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ? FOR UPDATE",
+		identity.Issuer, identity.Subject,
+	).Scan(&userID)
+	switch {
+	case err == nil:
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE user_identities SET last_login_at = CURRENT_TIMESTAMP WHERE issuer = ? AND subject = ?",
+			identity.Issuer, identity.Subject,
+		); err != nil {
+			return nil, false, err
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		if identity.Email == "" {
+			return nil, false, ErrIdentityEmailMissing
+		}
+		var existing int
+		err = tx.QueryRowContext(ctx, "SELECT id FROM users WHERE email = ? FOR UPDATE", identity.Email).Scan(&existing)
+		if err == nil {
+			return nil, false, ErrIdentityAccountExists
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return nil, false, err
+		}
+		if userID, err = insertExternalUser(ctx, tx, identity); err != nil {
+			if isDuplicateKey(err) {
+				// Otro registro con el mismo email ganó la carrera
+				return nil, false, ErrIdentityAccountExists
+			}
+			return nil, false, err
+		}
+		created = true
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO user_identities (issuer, subject, user_id, last_login_at) VALUES (?, ?, ?, CURRENT_TIMESTAMP)",
+			identity.Issuer, identity.Subject, userID,
+		); err != nil {
+			return nil, false, err
+		}
+	default:
+		return nil, false, err
+	}
+
+	if manageAdmin {
+		if admin {
+			err = grantRole(ctx, tx, userID, services.RoleAdmin)
+		} else {
+			err = revokeRole(ctx, tx, userID, services.RoleAdmin)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	userModel := UserModel{DB: m.DB}
+	if created {
+		// Igual que en el registro: sin clave de datos no puede guardar secretos
+		userKeyModel := UserKeyModel{DB: m.DB}
+		if _, err := userKeyModel.Create(userID); err != nil {
+			if delErr := userModel.DeleteUserByID(userID); delErr != nil {
+				log.Printf("Error rolling back user %d: %v", userID, delErr)
+			}
+			return nil, false, fmt.Errorf("error creating user key: %w", err)
+		}
+	}
+	user, err = userModel.GetByID(userID)
+	if err != nil {
+		return nil, false, err
+	}
+	return user, created, nil
+}
+
+// Link enlaza la identidad al usuario que lo ha pedido desde su sesión. Volver a
+// enlazar la misma identidad al mismo usuario no es un error. Los roles no se
+// tocan hasta el siguiente login con el proveedor.
+func (m *IdentityModel) Link(userID int, identity *services.ExternalIdentity) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var vaultMode string
+	if err := tx.QueryRowContext(ctx, "SELECT vault_mode FROM users WHERE id = ? FOR UPDATE", userID).Scan(&vaultMode); err != nil {
+		return err
+	}
+	if vaultMode == VaultModeZeroKnowledge {
+		return ErrIdentityZeroKnowledge
+	}
+
+	var owner int
+	err = tx.QueryRowContext(ctx,
+		"SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ? FOR UPDATE",
+		identity.Issuer, identity.Subject,
+	).Scan(&owner)
+	switch {
+	case err == nil && owner == userID:
+		return nil
+	case err == nil:
+		return ErrIdentityLinked
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO user_identities (issuer, subject, user_id) VALUES (?, ?, ?)",
+		identity.Issuer, identity.Subject, userID,
+	); err != nil {
+		if isDuplicateKey(err) {
+			return ErrIdentityLinked
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// insertExternalUser crea el usuario de una identidad nueva. Si el nombre ya está
+// cogido prueba con un sufijo aleatorio.
+func insertExternalUser(ctx context.Context, tx *sql.Tx, identity *services.ExternalIdentity) (int, error) {
+	// Nadie conoce esta contraseña: la cuenta solo entra por el proveedor (o con
+	// restablecer contraseña si el despliegue lo permite)
+	password, err := services.RandomSecret(32)
+	if err != nil {
+		return 0, err
+	}
+	defer password.Destroy()
+	hashedPassword, err := services.HashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	base := services.ExternalUsername(identity)
+	username := base
+	for attempt := 0; ; attempt++ {
+		result, err := tx.ExecContext(ctx,
+			`INSERT INTO users (email, password, username, icon, vault_mode, email_verified)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			identity.Email, hashedPassword, username,
+			"https://avatar.iran.liara.run/username?username="+username, VaultModeServer, identity.EmailVerified,
+		)
+		if err == nil {
+			id, err := result.LastInsertId()
+			if err != nil {
+				return 0, err
+			}
+			_, err = grantDefaultRoles(ctx, tx, int(id))
+			return int(id), err
+		}
+		if !isDuplicateKey(err) || attempt == 3 {
+			return 0, err
+		}
+		suffix, err := services.NewOIDCNonce()
+		if err != nil {
+			return 0, err
+		}
+		username = base[:min(len(base), 27)] + "-" + suffix[:4]
+	}
+}
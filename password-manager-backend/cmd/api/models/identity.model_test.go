@@ -0,0 +1,168 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"password-manager-backend/cmd/api/services"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const selectIdentity = "SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ? FOR UPDATE"
+
+var userColumns = []string{"id", "email", "username", "icon", "roles", "password", "vault_mode",
+	"token_version", "totp_enabled", "email_verified", "disabled", "must_change_password"}
+
+func newIdentity(emailVerified bool) *services.ExternalIdentity {
+	return &services.ExternalIdentity{
+		Issuer:        "https://idp.example.com",
+		Subject:       "sub-1",
+		Email:         "ana@example.com",
+		EmailVerified: emailVerified,
+		Username:      "ana",
+	}
+}
+
+// expectGetUser espera la lectura final del usuario id
+func expectGetUser(mock sqlmock.Sqlmock, id int, emailVerified bool) {
+	mock.ExpectQuery(q("FROM users WHERE id = ?")).WithArgs(id).WillReturnRows(sqlmock.NewRows(userColumns).
+		AddRow(id, "ana@example.com", "ana", "", "user", "hash", VaultModeServer, 1, false, emailVerified, false, false))
+}
+
+func TestProvisionLinkedIdentity(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := IdentityModel{DB: db}
+	identity := newIdentity(true)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectIdentity)).WithArgs(identity.Issuer, identity.Subject).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7))
+	mock.ExpectExec(q("UPDATE user_identities SET last_login_at")).WillReturnResult(sqlmock.NewResult(0, 1))
+	// Los grupos ya no dan admin: se quita
+	mock.ExpectExec(q("DELETE ur FROM user_roles ur")).WithArgs(7, services.RoleAdmin).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectGetUser(mock, 7, true)
+
+	user, created, err := m.ProvisionUser(identity, false, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created || user.Id != 7 {
+		t.Errorf("expected existing user 7, got %+v created=%v", user, created)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProvisionRefusesExistingEmail(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := IdentityModel{DB: db}
+
+	// Aunque el proveedor diga que el email está verificado, la cuenta local no
+	// se enlaza ni se toca su rol
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectIdentity)).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectQuery(q("SELECT id FROM users WHERE email = ? FOR UPDATE")).WithArgs("ana@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectRollback()
+
+	if _, _, err := m.ProvisionUser(newIdentity(true), true, true); !errors.Is(err, ErrIdentityAccountExists) {
+		t.Errorf("expected ErrIdentityAccountExists, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProvisionNewUser(t *testing.T) {
+	setMasterKeys(t)
+	db, mock := newMockDB(t)
+	m := IdentityModel{DB: db}
+	identity := newIdentity(false)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectIdentity)).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectQuery(q("SELECT id FROM users WHERE email = ?")).WillReturnError(sql.ErrNoRows)
+	// El email sin verificar por el proveedor queda sin verificar
+	mock.ExpectExec(q("INSERT INTO users")).
+		WithArgs("ana@example.com", sqlmock.AnyArg(), "ana", sqlmock.AnyArg(), VaultModeServer, false).
+		WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectExec(q("INSERT IGNORE INTO user_roles")).WithArgs(8, services.RoleUser).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(q("SELECT COUNT(*) FROM user_roles")).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectExec(q("INSERT INTO user_identities")).WithArgs(identity.Issuer, identity.Subject, 8).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(q("INSERT INTO user_keys")).WithArgs(8, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(3, 1))
+	expectGetUser(mock, 8, false)
+
+	user, created, err := m.ProvisionUser(identity, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created || user.Id != 8 || user.EmailVerified {
+		t.Errorf("expected new unverified user 8, got %+v created=%v", user, created)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProvisionRequiresEmail(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := IdentityModel{DB: db}
+	identity := newIdentity(true)
+	identity.Email = ""
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectIdentity)).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectRollback()
+	if _, _, err := m.ProvisionUser(identity, false, false); !errors.Is(err, ErrIdentityEmailMissing) {
+		t.Errorf("expected ErrIdentityEmailMissing, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLinkIdentity(t *testing.T) {
+	cases := []struct {
+		name      string
+		vaultMode string
+		owner     *sqlmock.Rows
+		insert    bool
+		want      error
+	}{
+		{"new link", VaultModeServer, sqlmock.NewRows([]string{"user_id"}), true, nil},
+		{"already linked to the user", VaultModeServer, sqlmock.NewRows([]string{"user_id"}).AddRow(1), false, nil},
+		{"linked to someone else", VaultModeServer, sqlmock.NewRows([]string{"user_id"}).AddRow(2), false, ErrIdentityLinked},
+		{"zero-knowledge", VaultModeZeroKnowledge, nil, false, ErrIdentityZeroKnowledge},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			m := IdentityModel{DB: db}
+			identity := newIdentity(true)
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(q("SELECT vault_mode FROM users WHERE id = ? FOR UPDATE")).WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"vault_mode"}).AddRow(c.vaultMode))
+			if c.owner != nil {
+				mock.ExpectQuery(q(selectIdentity)).WithArgs(identity.Issuer, identity.Subject).WillReturnRows(c.owner)
+			}
+			if c.insert {
+				mock.ExpectExec(q("INSERT INTO user_identities (issuer, subject, user_id)")).
+					WithArgs(identity.Issuer, identity.Subject, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+			if err := m.Link(1, identity); !errors.Is(err, c.want) {
+				t.Errorf("expected %v, got %v", c.want, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
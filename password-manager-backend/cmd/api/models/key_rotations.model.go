@@ -0,0 +1,279 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"password-manager-backend/cmd/api/services"
+	"time"
+)
+
+type KeyRotationModel struct {
+	DB *sql.DB
+}
+
+// Fases de una rotación, en el orden en el que se ejecutan
+const (
+	RotationPhaseUserKeys = "user_keys" // re-envolver las claves de datos con la clave maestra nueva
+	RotationPhaseNotes    = "notes"     // pasar las notas antiguas (clave maestra) a la clave de su dueño
+	RotationPhaseDone     = "done"
+)
+
+// KeyRotation guarda el progreso de una rotación para poder reanudarla si se corta
+type KeyRotation struct {
+	Id            int
+	TargetVersion int
+	Phase         string
+	LastId        int
+	Processed     int
+	StartedAt     time.Time
+	UpdatedAt     time.Time
+	CompletedAt   *time.Time
+}
+
+const keyRotationColumns = "id, target_version, phase, last_id, processed, started_at, updated_at, completed_at"
+
+func scanKeyRotation(row rowScanner) (*KeyRotation, error) {
+	var r KeyRotation
+	var startedAt, updatedAt, completedAt []byte
+	if err := row.Scan(&r.Id, &r.TargetVersion, &r.Phase, &r.LastId, &r.Processed, &startedAt, &updatedAt, &completedAt); err != nil {
+		return nil, err
+	}
+	r.StartedAt, _ = parseTime(startedAt)
+	r.UpdatedAt, _ = parseTime(updatedAt)
+	if completedAt != nil {
+		if t, err := parseTime(completedAt); err == nil {
+			r.CompletedAt = &t
+		}
+	}
+	return &r, nil
+}
+
+// GetOrStart devuelve la rotación hacia targetVersion, creándola si no existe
+func (m *KeyRotationModel) GetOrStart(targetVersion int) (*KeyRotation, error) {
+	r, err := scanKeyRotation(m.DB.QueryRow("SELECT "+keyRotationColumns+" FROM key_rotations WHERE target_version = ?", targetVersion))
+	if err == nil {
+		return r, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	_, err = m.DB.Exec("INSERT INTO key_rotations (target_version, phase) VALUES (?, ?)", targetVersion, RotationPhaseUserKeys)
+	if err != nil {
+		return nil, fmt.Errorf("error starting key rotation: %w", err)
+	}
+	return scanKeyRotation(m.DB.QueryRow("SELECT "+keyRotationColumns+" FROM key_rotations WHERE target_version = ?", targetVersion))
+}
+
+// GetAll lista todas las rotaciones, la más reciente primero
+func (m *KeyRotationModel) GetAll() ([]KeyRotation, error) {
+	rows, err := m.DB.Query("SELECT " + keyRotationColumns + " FROM key_rotations ORDER BY target_version DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rotations []KeyRotation
+	for rows.Next() {
+		r, err := scanKeyRotation(rows)
+		if err != nil {
+			return nil, err
+		}
+		rotations = append(rotations, *r)
+	}
+	return rotations, rows.Err()
+}
+
+// saveProgress guarda en qué punto va la rotación dentro de la misma transacción que el lote
+func saveProgress(tx *sql.Tx, r *KeyRotation) error {
+	_, err := tx.Exec(
+		"UPDATE key_rotations SET phase = ?, last_id = ?, processed = ?, completed_at = ? WHERE id = ?",
+		r.Phase, r.LastId, r.Processed, r.CompletedAt, r.Id,
+	)
+	return err
+}
+
+// advance pasa a la siguiente fase y reinicia el cursor
+func (m *KeyRotationModel) advance(r *KeyRotation, next string) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	r.Phase = next
+	r.LastId = 0
+	if next == RotationPhaseDone {
+		now := time.Now()
+		r.CompletedAt = &now
+	}
+	if err := saveProgress(tx, r); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RunBatch procesa un lote de la fase actual y devuelve cuántas filas ha leído.
+// Cuando una fase no tiene más filas pasa a la siguiente. r solo cambia si el lote
+// se guarda: tras un error se puede reintentar con el mismo r o con el que se lea
+// de la base de datos.
+func (m *KeyRotationModel) RunBatch(r *KeyRotation, batchSize int) (int, error) {
+	switch r.Phase {
+	case RotationPhaseUserKeys:
+		n, err := m.rewrapUserKeys(r, batchSize)
+		if err == nil && n == 0 {
+			err = m.advance(r, RotationPhaseNotes)
+		}
+		return n, err
+	case RotationPhaseNotes:
+		n, err := m.reencryptLegacyNotes(r, batchSize)
+		if err == nil && n == 0 {
+			err = m.advance(r, RotationPhaseDone)
+		}
+		return n, err
+	case RotationPhaseDone:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unknown rotation phase %q", r.Phase)
+	}
+}
+
+// rewrapUserKeys re-envuelve un lote de claves de usuario con la versión objetivo
+func (m *KeyRotationModel) rewrapUserKeys(r *KeyRotation, batchSize int) (int, error) {
+	progress := *r
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		"SELECT id, user_id, wrapped_key, nonce, master_key_version, created_at FROM user_keys WHERE id > ? ORDER BY id LIMIT ? FOR UPDATE",
+		progress.LastId, batchSize,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var keys []UserKey
+	for rows.Next() {
+		k, err := scanUserKey(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		keys = append(keys, *k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, k := range keys {
+		progress.LastId = k.Id
+		if k.MasterKeyVersion == progress.TargetVersion {
+			continue
+		}
+		rewrapped, err := services.RewrapDataKey(&services.EncryptedSecret{
+			Ciphertext: k.WrappedKey,
+			Nonce:      k.Nonce,
+			KeyVersion: k.MasterKeyVersion,
+		}, userKeyAAD(k.UserId))
+		if err != nil {
+			return 0, fmt.Errorf("error rewrapping user key %d: %w", k.Id, err)
+		}
+		if rewrapped.KeyVersion != progress.TargetVersion {
+			return 0, fmt.Errorf("current key version is %d, expected %d", rewrapped.KeyVersion, progress.TargetVersion)
+		}
+		result, err := tx.Exec(
+			"UPDATE user_keys SET wrapped_key = ?, nonce = ?, master_key_version = ? WHERE id = ?",
+			rewrapped.Ciphertext, rewrapped.Nonce, rewrapped.KeyVersion, k.Id,
+		)
+		if err != nil {
+			return 0, err
+		}
+		// Solo cuenta lo que de verdad se ha cambiado
+		if n, _ := result.RowsAffected(); n > 0 {
+			progress.Processed++
+		}
+	}
+
+	if err := saveProgress(tx, &progress); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	*r = progress
+	return len(keys), nil
+}
+
+// reencryptLegacyNotes cifra con la clave del dueño las notas que aún usan la clave
+// maestra. Una nota que cambia mientras tanto corta el lote justo antes de ella: el
+// siguiente la vuelve a leer y, si sigue siendo antigua, la cifra entonces.
+func (m *KeyRotationModel) reencryptLegacyNotes(r *KeyRotation, batchSize int) (int, error) {
+	progress := *r
+	rows, err := m.DB.Query(
+		"SELECT "+noteColumns+" FROM notes WHERE id > ? AND password_ciphertext IS NOT NULL AND user_key_id IS NULL AND client_encrypted = FALSE ORDER BY id LIMIT ?",
+		progress.LastId, batchSize,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var notes []Notes
+	for rows.Next() {
+		n, err := scanNote(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		notes = append(notes, *n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	notesModel := NotesModel{DB: m.DB}
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for i := range notes {
+		note := &notes[i]
+		oldNonce := note.PasswordNonce
+		password, err := notesModel.RevealPassword(note)
+		if err != nil {
+			return 0, fmt.Errorf("error decrypting note %d: %w", note.Id, err)
+		}
+		err = notesModel.SetPassword(note, password)
+		password.Destroy()
+		if err != nil {
+			return 0, fmt.Errorf("error encrypting note %d: %w", note.Id, err)
+		}
+		// Si la nota cambió mientras tanto no la pisamos
+		result, err := tx.Exec(
+			"UPDATE notes SET password_ciphertext = ?, password_nonce = ?, key_version = NULL, user_key_id = ? WHERE id = ? AND user_key_id IS NULL AND password_nonce = ?",
+			note.PasswordCiphertext, note.PasswordNonce, note.UserKeyId, note.Id, oldNonce,
+		)
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			break
+		}
+		progress.LastId = note.Id
+		progress.Processed++
+	}
+
+	if err := saveProgress(tx, &progress); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	*r = progress
+	return len(notes), nil
+}
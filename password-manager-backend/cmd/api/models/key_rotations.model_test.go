@@ -0,0 +1,189 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"password-manager-backend/cmd/api/services"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	selectUserKeysBatch = "SELECT id, user_id, wrapped_key, nonce, master_key_version, created_at FROM user_keys WHERE id > ?"
+	selectLegacyNotes   = "FROM notes WHERE id > ? AND password_ciphertext IS NOT NULL"
+	updateUserKey       = "UPDATE user_keys SET wrapped_key = ?"
+	updateNoteSQL       = "UPDATE notes SET password_ciphertext = ?"
+	updateRotation      = "UPDATE key_rotations SET phase = ?"
+	mockTime            = "2026-01-01 00:00:00"
+)
+
+// setMasterKeys deja configuradas dos versiones de clave maestra con la 2 como actual
+func setMasterKeys(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"ENCRYPTION_KEY", "ENCRYPTION_KEY_V2"} {
+		key, err := services.GenerateMasterKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv(name, key)
+	}
+	t.Setenv("ENCRYPTION_KEY_VERSION", "1")
+}
+
+func wrappedUserKey(t *testing.T, userID int) *services.EncryptedSecret {
+	t.Helper()
+	dataKey, err := services.GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dataKey.Destroy()
+	wrapped, err := services.WrapDataKey(dataKey, userKeyAAD(userID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wrapped
+}
+
+// newMockDB devuelve una base de datos falsa que se cierra al acabar el test
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+// q escapa una consulta para compararla literalmente
+func q(query string) string {
+	return regexp.QuoteMeta(query)
+}
+
+func TestRunBatchResumesAfterInterruption(t *testing.T) {
+	setMasterKeys(t)
+	first, second := wrappedUserKey(t, 1), wrappedUserKey(t, 2)
+	t.Setenv("ENCRYPTION_KEY_VERSION", "2")
+
+	db, mock := newMockDB(t)
+	m := &KeyRotationModel{DB: db}
+	keyRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "user_id", "wrapped_key", "nonce", "master_key_version", "created_at"}).
+			AddRow(10, 1, first.Ciphertext, first.Nonce, 1, mockTime).
+			AddRow(11, 2, second.Ciphertext, second.Nonce, 1, mockTime)
+	}
+	r := &KeyRotation{Id: 1, TargetVersion: 2, Phase: RotationPhaseUserKeys}
+
+	// El lote se corta a mitad: no se guarda nada y r no avanza
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectUserKeysBatch)).WithArgs(0, 2).WillReturnRows(keyRows())
+	mock.ExpectExec(q(updateUserKey)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(updateUserKey)).WillReturnError(errors.New("connection lost"))
+	mock.ExpectRollback()
+	if _, err := m.RunBatch(r, 2); err == nil {
+		t.Fatal("expected the interrupted batch to fail")
+	}
+	if r.LastId != 0 || r.Processed != 0 {
+		t.Fatalf("interrupted batch changed the progress: %+v", r)
+	}
+
+	// Al reanudar se repite el mismo lote y solo cuenta lo que se ha actualizado
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectUserKeysBatch)).WithArgs(0, 2).WillReturnRows(keyRows())
+	mock.ExpectExec(q(updateUserKey)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(updateUserKey)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(updateRotation)).WithArgs(RotationPhaseUserKeys, 11, 1, nil, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	n, err := m.RunBatch(r, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || r.LastId != 11 || r.Processed != 1 {
+		t.Fatalf("unexpected progress after resuming: n=%d %+v", n, r)
+	}
+
+	// Sin más claves pasa a la fase de notas
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectUserKeysBatch)).WithArgs(11, 2).WillReturnRows(sqlmock.NewRows(nil))
+	mock.ExpectExec(q(updateRotation)).WithArgs(RotationPhaseUserKeys, 11, 1, nil, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(q(updateRotation)).WithArgs(RotationPhaseNotes, 0, 1, nil, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if n, err := m.RunBatch(r, 2); err != nil || n != 0 {
+		t.Fatalf("expected an empty batch, got n=%d err=%v", n, err)
+	}
+	if r.Phase != RotationPhaseNotes || r.LastId != 0 {
+		t.Errorf("expected to move to the notes phase, got %+v", r)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRunBatchRetriesNoteChangedMidBatch(t *testing.T) {
+	setMasterKeys(t)
+	userKey := wrappedUserKey(t, 1)
+	legacy := func() *services.EncryptedSecret {
+		secret, err := services.EncryptSecret(services.NewSecret([]byte("hunter2")), PasswordAAD(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return secret
+	}
+	changed, untouched := legacy(), legacy()
+
+	db, mock := newMockDB(t)
+	m := &KeyRotationModel{DB: db}
+	noteRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "user_id", "note_text", "username", "username_ciphertext", "username_nonce", "url_ciphertext", "url_nonce",
+			"password_ciphertext", "password_nonce", "key_version", "user_key_id", "client_encrypted", "created_at"})
+	}
+	expectOwnerKey := func() {
+		mock.ExpectQuery(q("FROM user_keys WHERE user_id = ?")).WithArgs(1).WillReturnRows(
+			sqlmock.NewRows([]string{"id", "user_id", "wrapped_key", "nonce", "master_key_version", "created_at"}).
+				AddRow(7, 1, userKey.Ciphertext, userKey.Nonce, 1, mockTime))
+	}
+	r := &KeyRotation{Id: 1, TargetVersion: 1, Phase: RotationPhaseNotes, Processed: 5}
+
+	// La primera nota cambió entre la lectura y el UPDATE: el lote se corta antes de ella
+	mock.ExpectQuery(q(selectLegacyNotes)).WithArgs(0, 10).WillReturnRows(noteRows().
+		AddRow(20, 1, "", nil, nil, nil, nil, nil, changed.Ciphertext, changed.Nonce, 1, nil, false, mockTime).
+		AddRow(21, 1, "", nil, nil, nil, nil, nil, untouched.Ciphertext, untouched.Nonce, 1, nil, false, mockTime))
+	mock.ExpectBegin()
+	expectOwnerKey()
+	mock.ExpectExec(q(updateNoteSQL)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 7, 20, changed.Nonce).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(q(updateRotation)).WithArgs(RotationPhaseNotes, 0, 5, nil, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	n, err := m.RunBatch(r, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 || r.LastId != 0 || r.Processed != 5 {
+		t.Fatalf("the changed note should be retried: n=%d %+v", n, r)
+	}
+
+	// En el siguiente lote la nota vuelve a leerse y se cifra con su nonce nuevo
+	changed = legacy()
+	mock.ExpectQuery(q(selectLegacyNotes)).WithArgs(0, 10).WillReturnRows(noteRows().
+		AddRow(20, 1, "", nil, nil, nil, nil, nil, changed.Ciphertext, changed.Nonce, 1, nil, false, mockTime).
+		AddRow(21, 1, "", nil, nil, nil, nil, nil, untouched.Ciphertext, untouched.Nonce, 1, nil, false, mockTime))
+	mock.ExpectBegin()
+	expectOwnerKey()
+	mock.ExpectExec(q(updateNoteSQL)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 7, 20, changed.Nonce).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectOwnerKey()
+	mock.ExpectExec(q(updateNoteSQL)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 7, 21, untouched.Nonce).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q(updateRotation)).WithArgs(RotationPhaseNotes, 21, 7, nil, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if _, err := m.RunBatch(r, 10); err != nil {
+		t.Fatal(err)
+	}
+	if r.LastId != 21 || r.Processed != 7 {
+		t.Errorf("unexpected progress %+v", r)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
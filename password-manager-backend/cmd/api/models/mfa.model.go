@@ -0,0 +1,318 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"password-manager-backend/cmd/api/services"
+	"time"
+)
+
+type MFAModel struct {
+	DB *sql.DB
+}
+
+// Número de códigos de recuperación que se entregan al activar el 2FA
+const MFARecoveryCodeCount = 10
+
+var (
+	// ErrMFAInvalidCode cubre códigos incorrectos, caducados o ya usados
+	ErrMFAInvalidCode = errors.New("invalid MFA code")
+	// ErrMFANotEnrolled indica que el usuario no ha empezado el alta del TOTP
+	ErrMFANotEnrolled = errors.New("TOTP is not enrolled")
+	// ErrMFAAlreadyEnabled impide pisar un TOTP ya confirmado con un alta nueva
+	ErrMFAAlreadyEnabled = errors.New("TOTP is already enabled")
+)
+
+// MFALoginRequest es el segundo paso del login: el token del primer paso y un
+// código de la app o uno de recuperación
+type MFALoginRequest struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code" binding:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code" binding:"required_without=Code"`
+	SessionMode  string `json:"session_mode" binding:"omitempty,oneof=token cookie"`
+}
+
+// MFACodeRequest confirma una operación sobre el 2FA con un código de la app
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// MFAEnrollResponse es lo que el usuario mete en su app de autenticación
+type MFAEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+// MFARecoveryCodesResponse enseña los códigos de recuperación una sola vez
+type MFARecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// totpAAD liga el secreto cifrado a su usuario
+func totpAAD(userID int) []byte {
+	return []byte(fmt.Sprintf("users.totp:user=%d", userID))
+}
+
+// dataKey desenvuelve la clave de datos del usuario, con la que se cifra el secreto
+// TOTP; así se re-envuelve con las rotaciones de la clave maestra
+func (m *MFAModel) dataKey(userID int) (*services.Secret, error) {
+	userKeyModel := UserKeyModel{DB: m.DB}
+	key, err := userKeyModel.GetOrCreate(userID)
+	if err != nil {
+		return nil, err
+	}
+	return key.Unwrap()
+}
+
+// Enroll guarda un secreto TOTP nuevo pendiente de confirmar y lo devuelve para
+// enseñárselo al usuario. El llamador lo destruye.
+func (m *MFAModel) Enroll(userID int) (*services.Secret, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	secret, err := services.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := m.dataKey(userID)
+	if err != nil {
+		secret.Destroy()
+		return nil, err
+	}
+	defer dataKey.Destroy()
+	ciphertext, nonce, err := services.SealAESGCM(dataKey.Bytes(), secret.Bytes(), totpAAD(userID))
+	if err != nil {
+		secret.Destroy()
+		return nil, err
+	}
+
+	result, err := m.DB.ExecContext(ctx,
+		`UPDATE users SET totp_secret_ciphertext = ?, totp_secret_nonce = ?, totp_last_step = 0
+		WHERE id = ? AND totp_enabled = FALSE`,
+		ciphertext, nonce, userID,
+	)
+	if err != nil {
+		secret.Destroy()
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		secret.Destroy()
+		return nil, ErrMFAAlreadyEnabled
+	}
+	return secret, nil
+}
+
+// checkTOTP valida el código con el secreto del usuario y apunta su paso para que
+// no se pueda repetir. enabled indica si se espera un TOTP confirmado o uno pendiente.
+func (m *MFAModel) checkTOTP(ctx context.Context, tx *sql.Tx, userID int, code string, enabled bool) error {
+	// La clave se lee fuera de la transacción antes de bloquear la fila del usuario
+	dataKey, err := m.dataKey(userID)
+	if err != nil {
+		return err
+	}
+	defer dataKey.Destroy()
+
+	var ciphertext, nonce []byte
+	var isEnabled bool
+	var lastStep int64
+	err = tx.QueryRowContext(ctx,
+		"SELECT totp_secret_ciphertext, totp_secret_nonce, totp_enabled, totp_last_step FROM users WHERE id = ? FOR UPDATE",
+		userID,
+	).Scan(&ciphertext, &nonce, &isEnabled, &lastStep)
+	if err != nil {
+		return err
+	}
+	if ciphertext == nil {
+		return ErrMFANotEnrolled
+	}
+	if isEnabled != enabled {
+		if isEnabled {
+			return ErrMFAAlreadyEnabled
+		}
+		return ErrMFANotEnrolled
+	}
+
+	plain, err := services.OpenAESGCM(dataKey.Bytes(), ciphertext, nonce, totpAAD(userID))
+	if err != nil {
+		return err
+	}
+	secret := services.NewSecret(plain)
+	defer secret.Destroy()
+
+	step, ok := services.ValidateTOTP(secret, code, time.Now(), lastStep)
+	if !ok {
+		return ErrMFAInvalidCode
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE users SET totp_last_step = ? WHERE id = ?", step, userID)
+	return err
+}
+
+// Confirm activa el TOTP pendiente con su primer código y devuelve los códigos de
+// recuperación en claro, que no se vuelven a poder consultar
+func (m *MFAModel) Confirm(userID int, code string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := m.checkTOTP(ctx, tx, userID, code, false); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET totp_enabled = TRUE WHERE id = ?", userID); err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// Verify comprueba un código del TOTP ya activo
+func (m *MFAModel) Verify(userID int, code string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.checkTOTP(ctx, tx, userID, code, true); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseRecoveryCode gasta un código de recuperación. Cada código vale una sola vez.
+func (m *MFAModel) UseRecoveryCode(userID int, code string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		`UPDATE mfa_recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		userID, services.HashMFARecoveryCode(code),
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrMFAInvalidCode
+	}
+	return nil
+}
+
+// RemainingRecoveryCodes cuenta los códigos de recuperación sin usar
+func (m *MFAModel) RemainingRecoveryCodes(userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = ? AND used_at IS NULL",
+		userID,
+	).Scan(&count)
+	return count, err
+}
+
+// RegenerateRecoveryCodes invalida los códigos anteriores y emite otros nuevos,
+// previa comprobación de un código de la app
+func (m *MFAModel) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := m.checkTOTP(ctx, tx, userID, code, true); err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return codes, tx.Commit()
+}
+
+// Disable quita el segundo factor, previa comprobación de un código de la app
+func (m *MFAModel) Disable(userID int, code string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.checkTOTP(ctx, tx, userID, code, true); err != nil {
+		return err
+	}
+	if err := clearTOTP(ctx, tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Reset quita el segundo factor sin pedir ningún código, para quien ha perdido el
+// móvil y los códigos de recuperación. Solo desde la consola de administración.
+func (m *MFAModel) Reset(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := clearTOTP(ctx, tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// clearTOTP borra el secreto TOTP y los códigos de recuperación
+func clearTOTP(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE users SET totp_secret_ciphertext = NULL, totp_secret_nonce = NULL,
+		totp_enabled = FALSE, totp_last_step = 0 WHERE id = ?`,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = ?", userID)
+	return err
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int) ([]string, error) {
+	codes, err := services.GenerateMFARecoveryCodes(MFARecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES (?, ?)",
+			userID, services.HashMFARecoveryCode(code),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
@@ -4,7 +4,10 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"password-manager-backend/cmd/api/services"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type NotesModel struct {
@@ -12,61 +15,319 @@ type NotesModel struct {
 }
 
 type Notes struct {
-	Id        int       `json:"id"`
-	UserId    int       `json:"user_id"`
-	NoteText  string    `json:"note_text" binding:"required,min=3,max=255"`
-	Username  string    `json:"username" binding:"required,min=3,max=255"`
-	Password  string    `json:"password"` // Lo puedes llenar luego con hash
-	CreatedAt time.Time `json:"created_at"`
+	Id       int              `json:"id"`
+	UserId   int              `json:"user_id"`
+	NoteText string           `json:"note_text" binding:"required,min=3,max=1024"` // ver ValidateFor
+	Username string           `json:"username" binding:"required,min=3,max=1024"`
+	Url      string           `json:"url,omitempty" binding:"omitempty,max=1024"`
+	Password *services.Secret `json:"password,omitempty"` // Solo de entrada, se guarda cifrada
+	// La contraseña cifrada nunca se serializa, se obtiene con /notes/:id/secret
+	HasPassword        bool   `json:"has_password"`
+	PasswordCiphertext []byte `json:"-"`
+	PasswordNonce      []byte `json:"-"`
+	KeyVersion         int    `json:"-"` // Solo en notas cifradas directamente con la clave maestra
+	UserKeyId          int    `json:"-"` // Clave de datos del dueño con la que se cifró
+	// username y url se guardan cifrados con la misma clave, ver EncryptFields
+	UsernameCiphertext []byte `json:"-"`
+	UsernameNonce      []byte `json:"-"`
+	UrlCiphertext      []byte `json:"-"`
+	UrlNonce           []byte `json:"-"`
+	// En bóvedas zero-knowledge todos los campos llegan ya cifrados por el cliente
+	ClientEncrypted bool      `json:"client_encrypted"`
+	CreatedAt       time.Time `json:"created_at"`
+
+	searchTerms []services.BlindTerm // índices ciegos calculados en EncryptFields
 }
 
-// parseTime convierte []byte a time.Time
-func parseTime(b []byte) (time.Time, error) {
-	if b == nil {
-		return time.Time{}, nil
+// Columnas que leen todas las consultas de notas, en el orden de scanNote
+const noteColumns = "id, user_id, note_text, username, username_ciphertext, username_nonce, url_ciphertext, url_nonce, password_ciphertext, password_nonce, key_version, user_key_id, client_encrypted, created_at"
+
+// rowScanner lo cumplen tanto *sql.Row como *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanNote lee una fila con noteColumns. username y url siguen cifrados, ver openFields.
+func scanNote(row rowScanner) (*Notes, error) {
+	var n Notes
+	var username sql.NullString
+	var keyVersion sql.NullInt64
+	var userKeyID sql.NullInt64
+	var createdAt []byte
+
+	if err := row.Scan(&n.Id, &n.UserId, &n.NoteText, &username, &n.UsernameCiphertext, &n.UsernameNonce, &n.UrlCiphertext, &n.UrlNonce,
+		&n.PasswordCiphertext, &n.PasswordNonce, &keyVersion, &userKeyID, &n.ClientEncrypted, &createdAt); err != nil {
+		return nil, err
 	}
-	return time.Parse("2006-01-02 15:04:05", string(b))
+
+	// En zero-knowledge y en filas sin migrar el username está en su columna
+	if username.Valid {
+		n.Username = username.String
+	}
+	if n.ClientEncrypted {
+		n.Url = string(n.UrlCiphertext)
+	}
+	if keyVersion.Valid {
+		n.KeyVersion = int(keyVersion.Int64)
+	}
+	if userKeyID.Valid {
+		n.UserKeyId = int(userKeyID.Int64)
+	}
+	n.HasPassword = len(n.PasswordCiphertext) > 0
+	if t, err := parseTime(createdAt); err == nil {
+		n.CreatedAt = t
+	}
+
+	return &n, nil
 }
 
-// GetAll obtiene todas las notas
-func (m *NotesModel) GetAll() ([]Notes, error) {
-	rows, err := m.DB.Query("SELECT id, user_id, note_text, username, password, created_at FROM notes")
+// PasswordAAD son los datos autenticados con los que se cifra la contraseña de
+// una nota: la ligan a su dueño para que no se pueda copiar a otra cuenta
+func PasswordAAD(userID int) []byte {
+	return []byte(fmt.Sprintf("notes.password:user=%d", userID))
+}
+
+// fieldAAD hace lo mismo para el resto de campos cifrados
+func fieldAAD(field string, userID int) []byte {
+	return []byte(fmt.Sprintf("notes.%s:user=%d", field, userID))
+}
+
+// ValidateFor aplica los límites de cada modo de bóveda: en modo servidor los
+// campos son texto normal, en zero-knowledge tienen que ser blobs cifrados
+func (n *Notes) ValidateFor(vaultMode string) error {
+	if vaultMode == VaultModeZeroKnowledge {
+		if !services.ValidEncString(n.NoteText) || !services.ValidEncString(n.Username) {
+			return errors.New("note_text and username must be client encrypted")
+		}
+		if n.Url != "" && !services.ValidEncString(n.Url) {
+			return errors.New("url must be client encrypted")
+		}
+		if !n.Password.IsEmpty() && !services.ValidEncString(string(n.Password.Bytes())) {
+			return errors.New("password must be client encrypted")
+		}
+		return nil
+	}
+	if utf8.RuneCountInString(n.NoteText) > 255 || utf8.RuneCountInString(n.Username) > 255 {
+		return errors.New("note_text and username must be at most 255 characters")
+	}
+	return nil
+}
+
+// keyCache guarda las claves de datos ya desenvueltas durante una operación
+type keyCache map[int]*services.Secret
+
+// destroy borra de memoria todas las claves de la caché
+func (c keyCache) destroy() {
+	for id, key := range c {
+		key.Destroy()
+		delete(c, id)
+	}
+}
+
+// ownerKey devuelve la clave de datos del dueño, creándola si aún no tiene. La
+// clave hay que destruirla al terminar.
+func (m *NotesModel) ownerKey(userID int) (*UserKey, *services.Secret, error) {
+	keyModel := UserKeyModel{DB: m.DB}
+	userKey, err := keyModel.GetOrCreate(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	dataKey, err := userKey.Unwrap()
+	if err != nil {
+		return nil, nil, err
+	}
+	return userKey, dataKey, nil
+}
+
+// noteKey desenvuelve la clave con la que se cifró la nota. cache evita repetirlo
+// para cada nota de un mismo listado y se encarga de destruir las claves; con
+// cache nil la clave la destruye el llamador.
+func (m *NotesModel) noteKey(note *Notes, cache keyCache) (*services.Secret, error) {
+	if dataKey, ok := cache[note.UserKeyId]; ok {
+		return dataKey, nil
+	}
+	keyModel := UserKeyModel{DB: m.DB}
+	userKey, err := keyModel.GetByID(note.UserKeyId)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	if userKey.UserId != note.UserId {
+		return nil, errors.New("note key does not belong to the note owner")
+	}
+	dataKey, err := userKey.Unwrap()
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		cache[note.UserKeyId] = dataKey
+	}
+	return dataKey, nil
+}
 
-	var notes []Notes
-	for rows.Next() {
-		var n Notes
-		var username sql.NullString
-		var password sql.NullString
-		var createdAt []byte
+// SetPassword cifra la contraseña en claro con la clave de datos del dueño de la nota.
+// En notas zero-knowledge la contraseña ya viene cifrada y se guarda tal cual.
+func (m *NotesModel) SetPassword(note *Notes, password *services.Secret) error {
+	if password.IsEmpty() {
+		note.PasswordCiphertext, note.PasswordNonce, note.KeyVersion = nil, nil, 0
+		note.HasPassword = false
+		return nil
+	}
 
-		if err := rows.Scan(&n.Id, &n.UserId, &n.NoteText, &username, &password, &createdAt); err != nil {
-			return nil, err
+	if note.ClientEncrypted {
+		note.PasswordCiphertext = append([]byte(nil), password.Bytes()...)
+		note.PasswordNonce, note.KeyVersion, note.UserKeyId = nil, 0, 0
+		note.HasPassword = true
+		note.Password = nil
+		return nil
+	}
+
+	userKey, dataKey, err := m.ownerKey(note.UserId)
+	if err != nil {
+		return err
+	}
+	defer dataKey.Destroy()
+
+	ciphertext, nonce, err := services.SealAESGCM(dataKey.Bytes(), password.Bytes(), PasswordAAD(note.UserId))
+	if err != nil {
+		return err
+	}
+	note.PasswordCiphertext = ciphertext
+	note.PasswordNonce = nonce
+	// La versión de clave maestra ya va en user_keys, la nota solo apunta a la clave
+	note.KeyVersion = 0
+	note.UserKeyId = userKey.Id
+	note.HasPassword = true
+	note.Password = nil
+	return nil
+}
+
+// EncryptFields cifra username y url con la clave de datos del dueño y calcula sus
+// índices ciegos para SearchByText. En zero-knowledge ya vienen cifrados y no se indexan.
+func (m *NotesModel) EncryptFields(note *Notes) error {
+	note.searchTerms = nil
+	if note.ClientEncrypted {
+		note.UsernameCiphertext, note.UsernameNonce, note.UrlCiphertext, note.UrlNonce = nil, nil, nil, nil
+		if note.Url != "" {
+			note.UrlCiphertext = []byte(note.Url)
 		}
+		return nil
+	}
 
-		if username.Valid {
-			n.Username = username.String
+	// Toda la nota usa una sola clave: una contraseña antigua cifrada con la clave
+	// maestra se pasa antes a la clave del dueño
+	if note.HasPassword && note.UserKeyId == 0 {
+		password, err := m.RevealPassword(note)
+		if err != nil {
+			return err
 		}
-		if password.Valid {
-			n.Password = password.String
+		err = m.SetPassword(note, password)
+		password.Destroy()
+		if err != nil {
+			return err
 		}
+	}
+
+	userKey, dataKey, err := m.ownerKey(note.UserId)
+	if err != nil {
+		return err
+	}
+	defer dataKey.Destroy()
+	note.UsernameCiphertext, note.UsernameNonce, err = sealField(dataKey, note.Username, fieldAAD("username", note.UserId))
+	if err != nil {
+		return err
+	}
+	note.UrlCiphertext, note.UrlNonce, err = sealField(dataKey, note.Url, fieldAAD("url", note.UserId))
+	if err != nil {
+		return err
+	}
+	note.UserKeyId = userKey.Id
+	indexKey := services.BlindIndexKey(dataKey)
+	defer indexKey.Destroy()
+	note.searchTerms = services.BlindIndexTerms(indexKey, note.Username, note.Url)
+	return nil
+}
+
+func sealField(dataKey *services.Secret, value string, aad []byte) ([]byte, []byte, error) {
+	if value == "" {
+		return nil, nil, nil
+	}
+	return services.SealAESGCM(dataKey.Bytes(), []byte(value), aad)
+}
 
-		if t, err := parseTime(createdAt); err == nil {
-			n.CreatedAt = t
+// openFields descifra username y url. Las filas anteriores al cifrado de campos
+// no tienen ciphertext y conservan el username en claro hasta que se migren.
+func (m *NotesModel) openFields(note *Notes, cache keyCache) error {
+	if note.ClientEncrypted || (note.UsernameCiphertext == nil && note.UrlCiphertext == nil) {
+		return nil
+	}
+	dataKey, err := m.noteKey(note, cache)
+	if err != nil {
+		return err
+	}
+	if cache == nil {
+		defer dataKey.Destroy()
+	}
+	if note.UsernameCiphertext != nil {
+		username, err := services.OpenAESGCM(dataKey.Bytes(), note.UsernameCiphertext, note.UsernameNonce, fieldAAD("username", note.UserId))
+		if err != nil {
+			return err
 		}
+		note.Username = string(username)
+	}
+	if note.UrlCiphertext != nil {
+		url, err := services.OpenAESGCM(dataKey.Bytes(), note.UrlCiphertext, note.UrlNonce, fieldAAD("url", note.UserId))
+		if err != nil {
+			return err
+		}
+		note.Url = string(url)
+	}
+	return nil
+}
 
-		notes = append(notes, n)
+// RevealPassword descifra la contraseña guardada de la nota. Sin contraseña
+// devuelve nil; si no, el llamador tiene que destruirla al terminar.
+func (m *NotesModel) RevealPassword(note *Notes) (*services.Secret, error) {
+	if !note.HasPassword {
+		return nil, nil
 	}
 
-	return notes, nil
+	// El servidor no puede descifrarla, se devuelve el blob para que lo haga el cliente
+	if note.ClientEncrypted {
+		return services.NewSecret(append([]byte(nil), note.PasswordCiphertext...)), nil
+	}
+
+	// Notas antiguas, cifradas directamente con la clave maestra
+	if note.UserKeyId == 0 {
+		return services.DecryptSecret(&services.EncryptedSecret{
+			Ciphertext: note.PasswordCiphertext,
+			Nonce:      note.PasswordNonce,
+			KeyVersion: note.KeyVersion,
+		}, PasswordAAD(note.UserId))
+	}
+
+	dataKey, err := m.noteKey(note, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer dataKey.Destroy()
+	plaintext, err := services.OpenAESGCM(dataKey.Bytes(), note.PasswordCiphertext, note.PasswordNonce, PasswordAAD(note.UserId))
+	if err != nil {
+		return nil, err
+	}
+	return services.NewSecret(plaintext), nil
 }
 
-// GetByUserID obtiene todas las notas de un usuario específico
-func (m *NotesModel) GetByUserID(userID int) ([]Notes, error) {
-	rows, err := m.DB.Query("SELECT id, user_id, note_text, username, password, created_at FROM notes WHERE user_id = ?", userID)
+// parseTime convierte []byte a time.Time
+func parseTime(b []byte) (time.Time, error) {
+	if b == nil {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02 15:04:05", string(b))
+}
+
+// queryNotes lee las notas de una consulta y descifra sus campos
+func (m *NotesModel) queryNotes(query string, args ...any) ([]Notes, error) {
+	rows, err := m.DB.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -74,27 +335,36 @@ func (m *NotesModel) GetByUserID(userID int) ([]Notes, error) {
 
 	var notes []Notes
 	for rows.Next() {
-		var n Notes
-		var username sql.NullString
-		var password sql.NullString
-		var createdAt []byte
-
-		if err := rows.Scan(&n.Id, &n.UserId, &n.NoteText, &username, &password, &createdAt); err != nil {
+		n, err := scanNote(rows)
+		if err != nil {
 			return nil, err
 		}
+		notes = append(notes, *n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-		if username.Valid {
-			n.Username = username.String
-		}
-		if password.Valid {
-			n.Password = password.String
+	cache := make(keyCache)
+	defer cache.destroy()
+	for i := range notes {
+		if err := m.openFields(&notes[i], cache); err != nil {
+			return nil, fmt.Errorf("error decrypting note %d: %w", notes[i].Id, err)
 		}
+	}
+	return notes, nil
+}
 
-		if t, err := parseTime(createdAt); err == nil {
-			n.CreatedAt = t
-		}
+// GetAll obtiene todas las notas
+func (m *NotesModel) GetAll() ([]Notes, error) {
+	return m.queryNotes("SELECT " + noteColumns + " FROM notes")
+}
 
-		notes = append(notes, n)
+// GetByUserID obtiene todas las notas de un usuario específico
+func (m *NotesModel) GetByUserID(userID int) ([]Notes, error) {
+	notes, err := m.queryNotes("SELECT "+noteColumns+" FROM notes WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
 	}
 
 	if len(notes) == 0 {
@@ -104,14 +374,28 @@ func (m *NotesModel) GetByUserID(userID int) ([]Notes, error) {
 	return notes, nil
 }
 
-// Insert crea una nueva nota
+// Insert crea una nueva nota junto a sus índices de búsqueda
 func (m *NotesModel) Insert(note *Notes) error {
-	result, err := m.DB.Exec(
-		"INSERT INTO notes (user_id, note_text, username, password) VALUES (?, ?, ?, ?)",
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT INTO notes (user_id, note_text, username, username_ciphertext, username_nonce, url_ciphertext, url_nonce, password_ciphertext, password_nonce, key_version, user_key_id, client_encrypted) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
 		note.UserId,
 		note.NoteText,
-		note.Username,
-		note.Password,
+		nullableUsername(note),
+		note.UsernameCiphertext,
+		note.UsernameNonce,
+		note.UrlCiphertext,
+		note.UrlNonce,
+		note.PasswordCiphertext,
+		note.PasswordNonce,
+		nullableKeyVersion(note),
+		nullableUserKeyID(note),
+		note.ClientEncrypted,
 	)
 	if err != nil {
 		return err
@@ -122,14 +406,32 @@ func (m *NotesModel) Insert(note *Notes) error {
 		return err
 	}
 	note.Id = int(id)
-	return nil
+
+	if err := replaceSearchIndex(tx, note); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-// UpdateByID actualiza el texto, username y password de una nota por ID
-func (m *NotesModel) UpdateByID(id int, noteText, username, password string) error {
-	result, err := m.DB.Exec(
-		"UPDATE notes SET note_text = ?, username = ?, password = ? WHERE id = ?",
-		noteText, username, password, id,
+// UpdateByID actualiza el texto, username, url y contraseña cifrada de una nota por ID
+func (m *NotesModel) UpdateByID(id int, note *Notes) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := updateNote(tx, id, note); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func updateNote(tx *sql.Tx, id int, note *Notes) error {
+	result, err := tx.Exec(
+		"UPDATE notes SET note_text = ?, username = ?, username_ciphertext = ?, username_nonce = ?, url_ciphertext = ?, url_nonce = ?, password_ciphertext = ?, password_nonce = ?, key_version = ?, user_key_id = ? WHERE id = ?",
+		note.NoteText, nullableUsername(note), note.UsernameCiphertext, note.UsernameNonce, note.UrlCiphertext, note.UrlNonce,
+		note.PasswordCiphertext, note.PasswordNonce, nullableKeyVersion(note), nullableUserKeyID(note), id,
 	)
 	if err != nil {
 		return err
@@ -143,7 +445,51 @@ func (m *NotesModel) UpdateByID(id int, noteText, username, password string) err
 		return sql.ErrNoRows
 	}
 
-	return nil
+	note.Id = id
+	return replaceSearchIndex(tx, note)
+}
+
+// replaceSearchIndex sustituye los índices ciegos de la nota por los calculados en EncryptFields
+func replaceSearchIndex(tx *sql.Tx, note *Notes) error {
+	if _, err := tx.Exec("DELETE FROM note_search_index WHERE note_id = ?", note.Id); err != nil {
+		return err
+	}
+	if len(note.searchTerms) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, len(note.searchTerms))
+	args := make([]any, 0, len(note.searchTerms)*4)
+	for i, t := range note.searchTerms {
+		placeholders[i] = "(?, ?, ?, ?)"
+		args = append(args, note.Id, note.UserId, t.Field, t.Term)
+	}
+	_, err := tx.Exec("INSERT INTO note_search_index (note_id, user_id, field, term) VALUES "+strings.Join(placeholders, ", "), args...)
+	return err
+}
+
+// nullableUsername solo guarda el username en su columna cuando es un blob del cliente
+func nullableUsername(note *Notes) sql.NullString {
+	if !note.ClientEncrypted {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: note.Username, Valid: true}
+}
+
+// nullableKeyVersion guarda NULL cuando la nota no tiene contraseña o usa clave de usuario
+func nullableKeyVersion(note *Notes) sql.NullInt64 {
+	if !note.HasPassword || note.UserKeyId != 0 || note.ClientEncrypted {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(note.KeyVersion), Valid: true}
+}
+
+// nullableUserKeyID guarda NULL cuando la nota no usa clave de usuario
+func nullableUserKeyID(note *Notes) sql.NullInt64 {
+	if note.UserKeyId == 0 {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(note.UserKeyId), Valid: true}
 }
 
 // DeleteByID elimina una nota por ID
@@ -166,69 +512,114 @@ func (m *NotesModel) DeleteByID(id int) error {
 
 // GetByID obtiene una nota específica por ID
 func (m *NotesModel) GetByID(id int) (*Notes, error) {
-	var n Notes
-	var username sql.NullString
-	var password sql.NullString
-	var createdAt []byte
-
-	err := m.DB.QueryRow("SELECT id, user_id, note_text, username, password, created_at FROM notes WHERE id = ?", id).
-		Scan(&n.Id, &n.UserId, &n.NoteText, &username, &password, &createdAt)
+	n, err := scanNote(m.DB.QueryRow("SELECT "+noteColumns+" FROM notes WHERE id = ?", id))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, sql.ErrNoRows
 		}
 		return nil, err
 	}
+	if err := m.openFields(n, nil); err != nil {
+		return nil, fmt.Errorf("error decrypting note %d: %w", n.Id, err)
+	}
 
-	if username.Valid {
-		n.Username = username.String
+	return n, nil
+}
+
+// SearchByText busca notas de un usuario por el texto de la nota (LIKE) y por
+// username, email o dominio a través de los índices ciegos. Con field solo se
+// busca en ese campo de los índices.
+func (nm *NotesModel) SearchByText(userID int, text string, field string) ([]Notes, error) {
+	var terms []services.BlindTerm
+	keyModel := UserKeyModel{DB: nm.DB}
+	userKey, err := keyModel.GetByUserID(userID)
+	switch {
+	case err == nil:
+		dataKey, err := userKey.Unwrap()
+		if err != nil {
+			return nil, err
+		}
+		indexKey := services.BlindIndexKey(dataKey)
+		dataKey.Destroy()
+		terms = services.BlindQueryTerms(indexKey, text, field)
+		indexKey.Destroy()
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, err
 	}
-	if password.Valid {
-		n.Password = password.String
+
+	var conditions []string
+	args := []any{userID}
+	if field == "" {
+		conditions = append(conditions, "note_text LIKE ?")
+		args = append(args, "%"+text+"%")
 	}
-	if t, err := parseTime(createdAt); err == nil {
-		n.CreatedAt = t
+	if len(terms) > 0 {
+		placeholders := make([]string, len(terms))
+		args = append(args, userID)
+		for i, t := range terms {
+			placeholders[i] = "?"
+			args = append(args, t.Term)
+		}
+		conditions = append(conditions, "id IN (SELECT note_id FROM note_search_index WHERE user_id = ? AND term IN ("+strings.Join(placeholders, ", ")+"))")
+	}
+	if len(conditions) == 0 {
+		return nil, nil
 	}
 
-	return &n, nil
+	query := `SELECT ` + noteColumns + `
+	          FROM notes
+	          WHERE user_id = ? AND (` + strings.Join(conditions, " OR ") + `)
+	          ORDER BY created_at DESC`
+	return nm.queryNotes(query, args...)
 }
 
-// SearchByText busca notas por texto para un usuario específico
-func (nm *NotesModel) SearchByText(userID int, text string) ([]Notes, error) {
-	query := `SELECT id, note_text, username, password, user_id, created_at 
-	          FROM notes 
-	          WHERE user_id = ? AND note_text LIKE ? 
-	          ORDER BY created_at DESC`
-	rows, err := nm.DB.Query(query, userID, "%"+text+"%")
+// EncryptLegacyFields cifra username y url de un lote de notas anteriores al cifrado
+// de campos y crea sus índices. Devuelve el último ID visto y cuántas ha migrado.
+func (m *NotesModel) EncryptLegacyFields(afterID, batchSize int) (int, int, error) {
+	tx, err := m.DB.Begin()
 	if err != nil {
-		return nil, err
+		return afterID, 0, err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
+	// FOR UPDATE: si el dueño edita la nota a la vez, espera a que terminemos
+	rows, err := tx.Query(
+		"SELECT "+noteColumns+" FROM notes WHERE id > ? AND client_encrypted = FALSE AND username_ciphertext IS NULL AND username IS NOT NULL ORDER BY id LIMIT ? FOR UPDATE",
+		afterID, batchSize,
+	)
+	if err != nil {
+		return afterID, 0, err
+	}
 	var notes []Notes
 	for rows.Next() {
-		var note Notes
-		var username sql.NullString
-		var password sql.NullString
-		var createdAt []byte
-
-		if err := rows.Scan(&note.Id, &note.NoteText, &username, &password, &note.UserId, &createdAt); err != nil {
-			return nil, err
+		n, err := scanNote(rows)
+		if err != nil {
+			rows.Close()
+			return afterID, 0, err
 		}
+		notes = append(notes, *n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return afterID, 0, err
+	}
 
-		if username.Valid {
-			note.Username = username.String
+	lastID := afterID
+	for i := range notes {
+		note := &notes[i]
+		if err := m.EncryptFields(note); err != nil {
+			return afterID, 0, fmt.Errorf("error encrypting note %d: %w", note.Id, err)
 		}
-		if password.Valid {
-			note.Password = password.String
-		}
-		if t, err := parseTime(createdAt); err == nil {
-			note.CreatedAt = t
+		if err := updateNote(tx, note.Id, note); err != nil {
+			return afterID, 0, fmt.Errorf("error updating note %d: %w", note.Id, err)
 		}
+		lastID = note.Id
+	}
 
-		notes = append(notes, note)
+	if err := tx.Commit(); err != nil {
+		return afterID, 0, err
 	}
-	return notes, nil
+	return lastID, len(notes), nil
 }
 
 // GetByUserIDSortedFixed obtiene notas de un usuario:
@@ -242,47 +633,22 @@ func (m *NotesModel) GetByUserIDSortedFixed(userID int, order string) ([]Notes,
 	// Si DESC -> contraseñas al final
 	var caseOrder string
 	if order == "ASC" {
-		caseOrder = "CASE WHEN password IS NULL OR password = '' THEN 1 ELSE 0 END ASC"
+		caseOrder = "CASE WHEN password_ciphertext IS NULL THEN 1 ELSE 0 END ASC"
 	} else {
-		caseOrder = "CASE WHEN password IS NULL OR password = '' THEN 1 ELSE 0 END DESC"
+		caseOrder = "CASE WHEN password_ciphertext IS NULL THEN 1 ELSE 0 END DESC"
 	}
 
 	query := fmt.Sprintf(`
-        SELECT id, user_id, note_text, username, password, created_at
+        SELECT %s
         FROM notes
         WHERE user_id = ?
         ORDER BY %s, note_text ASC
-    `, caseOrder)
+    `, noteColumns, caseOrder)
 
-	rows, err := m.DB.Query(query, userID)
+	notes, err := m.queryNotes(query, userID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var notes []Notes
-	for rows.Next() {
-		var n Notes
-		var username sql.NullString
-		var password sql.NullString
-		var createdAt []byte
-
-		if err := rows.Scan(&n.Id, &n.UserId, &n.NoteText, &username, &password, &createdAt); err != nil {
-			return nil, err
-		}
-
-		if username.Valid {
-			n.Username = username.String
-		}
-		if password.Valid {
-			n.Password = password.String
-		}
-		if t, err := parseTime(createdAt); err == nil {
-			n.CreatedAt = t
-		}
-
-		notes = append(notes, n)
-	}
 
 	if len(notes) == 0 {
 		return nil, sql.ErrNoRows
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"password-manager-backend/cmd/api/services"
+	"time"
+)
+
+type OIDCModel struct {
+	DB *sql.DB
+}
+
+// ErrOIDCLoginInvalid cubre states desconocidos, caducados o ya usados
+var ErrOIDCLoginInvalid = errors.New("invalid or expired login state")
+
+// OIDCCallbackRequest es lo que el frontend recibe del proveedor en la redirect URI
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+	// cookie o token, ver SessionModeCookie
+	SessionMode string `json:"session_mode" binding:"omitempty,oneof=token cookie"`
+}
+
+// OIDCLogin es un login a medias. Con LinkUserId la identidad que vuelva se enlaza
+// a ese usuario en vez de abrir sesión.
+type OIDCLogin struct {
+	Nonce        string
+	CodeVerifier string
+	LinkUserId   int
+}
+
+// SaveLogin guarda el state, el nonce y el code_verifier de un login que empieza.
+// linkUserID es el usuario que pide enlazar su cuenta, o 0 en un login normal.
+func (m *OIDCModel) SaveLogin(state, nonce, codeVerifier string, linkUserID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	linkUser := sql.NullInt64{Int64: int64(linkUserID), Valid: linkUserID != 0}
+	_, err := m.DB.ExecContext(ctx,
+		`INSERT INTO oidc_logins (state, nonce, code_verifier, link_user_id, expires_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP + INTERVAL ? SECOND)`,
+		state, nonce, codeVerifier, linkUser, int(services.OIDCLoginTTL().Seconds()),
+	)
+	return err
+}
+
+// ConsumeLogin recupera y borra el login del state: cada state sirve una sola vez
+func (m *OIDCModel) ConsumeLogin(state string) (*OIDCLogin, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var login OIDCLogin
+	var linkUser sql.NullInt64
+	var expired bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT nonce, code_verifier, link_user_id, expires_at <= CURRENT_TIMESTAMP FROM oidc_logins WHERE state = ? FOR UPDATE",
+		state,
+	).Scan(&login.Nonce, &login.CodeVerifier, &linkUser, &expired)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOIDCLoginInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM oidc_logins WHERE state = ?", state); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if expired {
+		return nil, ErrOIDCLoginInvalid
+	}
+	login.LinkUserId = int(linkUser.Int64)
+	return &login, nil
+}
+
+// DeleteExpiredLogins borra los logins que nunca volvieron del proveedor
+func (m *OIDCModel) DeleteExpiredLogins() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM oidc_logins WHERE expires_at < CURRENT_TIMESTAMP")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"password-manager-backend/cmd/api/services"
+	"time"
+)
+
+type PasswordResetModel struct {
+	DB *sql.DB
+}
+
+var (
+	// ErrPasswordResetInvalid cubre tokens desconocidos, caducados o ya usados
+	ErrPasswordResetInvalid = errors.New("invalid or expired reset token")
+	// ErrPasswordResetTooSoon indica que la cuenta acaba de recibir otro correo
+	ErrPasswordResetTooSoon = errors.New("password reset requested too recently")
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       *services.Secret `json:"token" binding:"required"`
+	NewPassword *services.Secret `json:"new_password" binding:"required"`
+}
+
+func (r *ResetPasswordRequest) Validate() error {
+	return ValidatePassword("new_password", r.NewPassword)
+}
+
+// Destroy borra de memoria el token y la contraseña
+func (r *ResetPasswordRequest) Destroy() {
+	r.Token.Destroy()
+	r.NewPassword.Destroy()
+}
+
+// ChangeRequiredPasswordRequest elige la contraseña nueva de una cuenta obligada a
+// cambiarla, con el password_change_token del login
+type ChangeRequiredPasswordRequest struct {
+	PasswordChangeToken string           `json:"password_change_token" binding:"required"`
+	NewPassword         *services.Secret `json:"new_password" binding:"required"`
+}
+
+func (r *ChangeRequiredPasswordRequest) Validate() error {
+	return ValidatePassword("new_password", r.NewPassword)
+}
+
+// Destroy borra de memoria la contraseña
+func (r *ChangeRequiredPasswordRequest) Destroy() {
+	r.NewPassword.Destroy()
+}
+
+// Create genera el token de restablecer contraseña del usuario. Los anteriores
+// dejan de valer; si el último se pidió hace menos de PasswordResetCooldown
+// devuelve ErrPasswordResetTooSoon.
+func (m *PasswordResetModel) Create(userID int) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	// Bloquear al usuario serializa dos peticiones simultáneas a la misma cuenta
+	var id int
+	if err := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE id = ? FOR UPDATE", userID).Scan(&id); err != nil {
+		return "", err
+	}
+	var recent bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM password_reset_tokens
+		WHERE user_id = ? AND created_at > CURRENT_TIMESTAMP - INTERVAL ? SECOND)`,
+		userID, int(services.PasswordResetCooldown().Seconds()),
+	).Scan(&recent)
+	if err != nil {
+		return "", err
+	}
+	if recent {
+		return "", ErrPasswordResetTooSoon
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND used_at IS NULL",
+		userID,
+	); err != nil {
+		return "", err
+	}
+	token, hash, err := services.NewPasswordResetToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+		VALUES (?, ?, CURRENT_TIMESTAMP + INTERVAL ? SECOND)`,
+		userID, hash, int(services.PasswordResetTTL().Seconds()),
+	)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Reset gasta el token y cambia la contraseña en una sola transacción. Sube
+// token_version y revoca todos los refresh tokens: ninguna sesión abierta sobrevive.
+// Devuelve el usuario al que pertenecía el token.
+func (m *PasswordResetModel) Reset(token *services.Secret, hashedPassword string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var tokenID int
+	user := &User{}
+	err = tx.QueryRowContext(ctx,
+		`SELECT t.id, u.id, u.email, u.vault_mode
+		FROM password_reset_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ? AND t.used_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP
+		FOR UPDATE`,
+		services.HashPasswordResetToken(token),
+	).Scan(&tokenID, &user.Id, &user.Email, &user.VaultMode)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrPasswordResetInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Una bóveda zero-knowledge no se puede abrir con una contraseña nueva
+	if user.VaultMode == VaultModeZeroKnowledge {
+		return nil, ErrPasswordResetInvalid
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND used_at IS NULL",
+		user.Id,
+	); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET password = ?, token_version = token_version + 1 WHERE id = ?",
+		hashedPassword, user.Id,
+	); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL",
+		user.Id,
+	); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// DeleteExpired borra los tokens caducados, usados o no
+func (m *PasswordResetModel) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM password_reset_tokens WHERE expires_at < CURRENT_TIMESTAMP")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
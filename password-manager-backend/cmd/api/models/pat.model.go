@@ -0,0 +1,181 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"password-manager-backend/cmd/api/services"
+	"strings"
+	"time"
+)
+
+type PersonalAccessTokenModel struct {
+	DB *sql.DB
+}
+
+// ErrPersonalAccessTokenInvalid cubre tokens desconocidos, caducados, revocados o
+// de antes de un cambio de contraseña
+var ErrPersonalAccessTokenInvalid = errors.New("invalid personal access token")
+
+// PersonalAccessToken es un PAT tal como se enseña al usuario; el token en claro
+// solo aparece en la respuesta de creación
+type PersonalAccessToken struct {
+	Id     int      `json:"id"`
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// La sesión que lo creó tenía segundo factor
+	MFA        bool       `json:"mfa"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// CreatePersonalAccessTokenRequest es el body para crear un PAT. ExpiresInDays
+// vacío son 90 días.
+type CreatePersonalAccessTokenRequest struct {
+	Name          string   `json:"name" binding:"required,max=64"`
+	Scopes        []string `json:"scopes" binding:"required,min=1" example:"notes:read"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"omitempty,min=1,max=365"`
+}
+
+// PersonalAccessTokenResponse devuelve el PAT recién creado con el token en claro
+type PersonalAccessTokenResponse struct {
+	PersonalAccessToken
+	Token string `json:"token"`
+}
+
+// Create guarda un PAT del usuario y devuelve el token en claro. mfa indica si la
+// sesión que lo crea se abrió con segundo factor; el PAT lo hereda.
+func (m *PersonalAccessTokenModel) Create(user *User, name string, scopes []string, mfa bool, ttl time.Duration) (*PersonalAccessTokenResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	token, hash, err := services.NewPersonalAccessToken()
+	if err != nil {
+		return nil, err
+	}
+	result, err := m.DB.ExecContext(ctx,
+		`INSERT INTO personal_access_tokens (user_id, name, token_hash, scopes, token_version, mfa, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP + INTERVAL ? SECOND)`,
+		user.Id, name, hash, strings.Join(scopes, " "), user.TokenVersion, mfa, int(ttl.Seconds()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	pat, err := scanPersonalAccessToken(m.DB.QueryRowContext(ctx, selectPersonalAccessToken+" WHERE id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	return &PersonalAccessTokenResponse{PersonalAccessToken: *pat, Token: token}, nil
+}
+
+const selectPersonalAccessToken = `SELECT id, name, scopes, mfa, created_at, expires_at, last_used_at FROM personal_access_tokens`
+
+func scanPersonalAccessToken(row rowScanner) (*PersonalAccessToken, error) {
+	var pat PersonalAccessToken
+	var scopes string
+	var createdAt, expiresAt, lastUsedAt []byte
+	if err := row.Scan(&pat.Id, &pat.Name, &scopes, &pat.MFA, &createdAt, &expiresAt, &lastUsedAt); err != nil {
+		return nil, err
+	}
+	pat.Scopes = strings.Fields(scopes)
+	pat.CreatedAt, _ = parseTime(createdAt)
+	pat.ExpiresAt, _ = parseTime(expiresAt)
+	if lastUsedAt != nil {
+		t, _ := parseTime(lastUsedAt)
+		pat.LastUsedAt = &t
+	}
+	return &pat, nil
+}
+
+// GetByUserID lista los PAT vigentes del usuario
+func (m *PersonalAccessTokenModel) GetByUserID(userID int) ([]PersonalAccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx,
+		selectPersonalAccessToken+` WHERE user_id = ? AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pats := []PersonalAccessToken{}
+	for rows.Next() {
+		pat, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		pats = append(pats, *pat)
+	}
+	return pats, rows.Err()
+}
+
+// Revoke invalida un PAT del usuario
+func (m *PersonalAccessTokenModel) Revoke(userID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		"UPDATE personal_access_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Authenticate busca el PAT presentado y devuelve su dueño y el PAT, con sus
+// permisos y si se creó con segundo factor. Apunta además la fecha de último uso.
+func (m *PersonalAccessTokenModel) Authenticate(token string) (*User, *PersonalAccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var pat PersonalAccessToken
+	var user User
+	var scopes string
+	query := `SELECT pat.id, pat.scopes, pat.mfa, u.id, u.email, u.username, u.vault_mode, u.token_version, u.totp_enabled
+		FROM personal_access_tokens pat JOIN users u ON u.id = pat.user_id
+		WHERE pat.token_hash = ? AND pat.revoked_at IS NULL AND pat.expires_at > CURRENT_TIMESTAMP
+		AND pat.token_version = u.token_version AND u.disabled_at IS NULL`
+	err := m.DB.QueryRowContext(ctx, query, services.HashPersonalAccessToken(token)).Scan(
+		&pat.Id, &scopes, &pat.MFA, &user.Id, &user.Email, &user.Username, &user.VaultMode, &user.TokenVersion, &user.TOTPEnabled,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrPersonalAccessTokenInvalid
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := m.DB.ExecContext(ctx, "UPDATE personal_access_tokens SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", pat.Id); err != nil {
+		return nil, nil, err
+	}
+	pat.Scopes = strings.Fields(scopes)
+	return &user, &pat, nil
+}
+
+// DeleteExpired borra los PAT caducados o revocados
+func (m *PersonalAccessTokenModel) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		"DELETE FROM personal_access_tokens WHERE expires_at < CURRENT_TIMESTAMP OR revoked_at IS NOT NULL",
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,106 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"password-manager-backend/cmd/api/services"
+	"time"
+)
+
+type RecoveryKitModel struct {
+	DB *sql.DB
+}
+
+// RecoveryKit es lo que guardamos de un kit: el hash del secreto y cómo se repartió
+type RecoveryKit struct {
+	UserId      int       `json:"-"`
+	SecretHash  string    `json:"-"`
+	Threshold   int       `json:"threshold"`
+	TotalShares int       `json:"total_shares"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type CreateRecoveryKitRequest struct {
+	Shares    int `json:"shares" binding:"required,min=2,max=255"`
+	Threshold int `json:"threshold" binding:"required,min=2,max=255,ltefield=Shares"`
+}
+
+// RecoveryKitResponse lleva los trozos: es la única vez que salen del servidor
+type RecoveryKitResponse struct {
+	Threshold int      `json:"threshold"`
+	Shares    []string `json:"shares"`
+}
+
+type RecoverAccountRequest struct {
+	Email       string           `json:"email" binding:"required,email"`
+	Shares      []string         `json:"shares" binding:"required,min=2,max=255,dive,required,max=600"`
+	NewPassword *services.Secret `json:"new_password" binding:"required"`
+}
+
+func (r *RecoverAccountRequest) Validate() error {
+	return ValidatePassword("new_password", r.NewPassword)
+}
+
+// Replace guarda el kit del usuario sustituyendo el anterior, cuyos trozos dejan de valer
+func (m *RecoveryKitModel) Replace(kit *RecoveryKit) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `INSERT INTO recovery_kits (user_id, secret_hash, threshold, total_shares) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE secret_hash = VALUES(secret_hash), threshold = VALUES(threshold),
+		total_shares = VALUES(total_shares), created_at = CURRENT_TIMESTAMP`
+	_, err := m.DB.ExecContext(ctx, query, kit.UserId, kit.SecretHash, kit.Threshold, kit.TotalShares)
+	return err
+}
+
+// GetByUserID obtiene el kit del usuario
+func (m *RecoveryKitModel) GetByUserID(userID int) (*RecoveryKit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var kit RecoveryKit
+	var createdAt []byte
+	query := "SELECT user_id, secret_hash, threshold, total_shares, created_at FROM recovery_kits WHERE user_id = ?"
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&kit.UserId, &kit.SecretHash, &kit.Threshold, &kit.TotalShares, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	kit.CreatedAt, _ = parseTime(createdAt)
+	return &kit, nil
+}
+
+// Recover cambia la contraseña, revoca los tokens, los refresh tokens y las sesiones y
+// gasta el kit en una sola transacción.
+// Si otro uso del mismo kit llegó antes no cambia nada y devuelve sql.ErrNoRows.
+func (m *RecoveryKitModel) Recover(kit *RecoveryKit, hashedPassword string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM recovery_kits WHERE user_id = ? AND secret_hash = ?", kit.UserId, kit.SecretHash)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE users SET password = ?, token_version = token_version + 1 WHERE id = ?",
+		hashedPassword, kit.UserId,
+	)
+	if err != nil {
+		return err
+	}
+	if err := revokeUserSessions(ctx, tx, kit.UserId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRecoverRevokesSessions(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := RecoveryKitModel{DB: db}
+	kit := &RecoveryKit{UserId: 3, SecretHash: "hash"}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(q("DELETE FROM recovery_kits WHERE user_id = ? AND secret_hash = ?")).WithArgs(3, "hash").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("UPDATE users SET password = ?, token_version = token_version + 1 WHERE id = ?")).WithArgs("new-hash", 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ?")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(q("DELETE FROM sessions WHERE user_id = ?")).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	if err := m.Recover(kit, "new-hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Si el kit ya se gastó no se toca nada más
+	mock.ExpectBegin()
+	mock.ExpectExec(q("DELETE FROM recovery_kits")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if err := m.Recover(kit, "new-hash"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a spent kit, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,206 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"password-manager-backend/cmd/api/services"
+	"time"
+)
+
+type RefreshTokenModel struct {
+	DB *sql.DB
+}
+
+// ErrRefreshTokenInvalid cubre tokens desconocidos, caducados o revocados: el cliente
+// recibe siempre la misma respuesta
+var ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+
+// ErrRefreshTokenReused indica que se presentó un token ya gastado. Alguien más lo
+// tiene, así que la familia entera queda revocada.
+var ErrRefreshTokenReused = errors.New("refresh token reused")
+
+// ErrRefreshAccountDisabled indica que la cuenta se desactivó: la familia queda revocada
+var ErrRefreshAccountDisabled = errors.New("account disabled")
+
+// ErrRefreshEmailNotVerified indica que hay que verificar el email antes de renovar.
+// El token no se gasta y vale otra vez tras la verificación.
+var ErrRefreshEmailNotVerified = errors.New("email not verified")
+
+// RefreshToken es una fila de refresh_tokens. El token en claro nunca se guarda.
+type RefreshToken struct {
+	Id           int
+	UserId       int
+	FamilyId     string
+	TokenVersion int
+	// La sesión se abrió con segundo factor
+	MFA       bool
+	CreatedAt time.Time
+}
+
+// RefreshRequest es el body de /auth/refresh en modo token; en modo cookie el
+// refresh token llega en su cookie y el body va vacío
+type RefreshRequest struct {
+	RefreshToken *services.Secret `json:"refresh_token" binding:"required"`
+}
+
+// Issue crea un refresh token de la familia y devuelve el token en claro
+func (m *RefreshTokenModel) Issue(userID, tokenVersion int, familyID string, mfa bool) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	token, hash, err := services.NewRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = m.DB.ExecContext(ctx, insertRefreshToken, userID, familyID, hash, tokenVersion, mfa, int(services.RefreshTokenTTL().Seconds()))
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+const insertRefreshToken = `INSERT INTO refresh_tokens (user_id, family_id, token_hash, token_version, mfa, expires_at)
+	VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP + INTERVAL ? SECOND)`
+
+// Rotate gasta el refresh token y emite el siguiente de la misma familia. Un token ya
+// usado revoca la familia y devuelve ErrRefreshTokenReused; uno caducado, revocado o
+// de antes de un cambio de contraseña (token_version distinta) devuelve ErrRefreshTokenInvalid.
+// Con la cuenta desactivada revoca la familia y devuelve ErrRefreshAccountDisabled, y
+// con el email sin verificar (si se exige) devuelve ErrRefreshEmailNotVerified.
+func (m *RefreshTokenModel) Rotate(token *services.Secret) (*RefreshToken, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	var rt RefreshToken
+	var used, revoked, expired, disabled, emailVerified bool
+	var currentVersion int
+	var createdAt []byte
+	query := `SELECT rt.id, rt.user_id, rt.family_id, rt.token_version, rt.mfa, rt.created_at,
+		rt.used_at IS NOT NULL, rt.revoked_at IS NOT NULL, rt.expires_at <= CURRENT_TIMESTAMP,
+		u.token_version, u.disabled_at IS NOT NULL, u.email_verified
+		FROM refresh_tokens rt JOIN users u ON u.id = rt.user_id
+		WHERE rt.token_hash = ? FOR UPDATE`
+	err = tx.QueryRowContext(ctx, query, services.HashRefreshToken(token)).Scan(
+		&rt.Id, &rt.UserId, &rt.FamilyId, &rt.TokenVersion, &rt.MFA, &createdAt, &used, &revoked, &expired,
+		&currentVersion, &disabled, &emailVerified,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	rt.CreatedAt, _ = parseTime(createdAt)
+
+	switch {
+	case revoked || expired:
+		return nil, "", ErrRefreshTokenInvalid
+	case used:
+		if err := revokeFamily(ctx, tx, rt.FamilyId); err != nil {
+			return nil, "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, "", err
+		}
+		return &rt, "", ErrRefreshTokenReused
+	case rt.TokenVersion != currentVersion:
+		// La contraseña cambió después del login: la sesión ya no vale
+		if err := revokeFamily(ctx, tx, rt.FamilyId); err != nil {
+			return nil, "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrRefreshTokenInvalid
+	case disabled:
+		if err := revokeFamily(ctx, tx, rt.FamilyId); err != nil {
+			return nil, "", err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, "", err
+		}
+		return &rt, "", ErrRefreshAccountDisabled
+	case !emailVerified && services.RequireVerifiedEmail():
+		return &rt, "", ErrRefreshEmailNotVerified
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = ?", rt.Id); err != nil {
+		return nil, "", err
+	}
+	next, hash, err := services.NewRefreshToken()
+	if err != nil {
+		return nil, "", err
+	}
+	_, err = tx.ExecContext(ctx, insertRefreshToken, rt.UserId, rt.FamilyId, hash, rt.TokenVersion, rt.MFA, int(services.RefreshTokenTTL().Seconds()))
+	if err != nil {
+		return nil, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+	return &rt, next, nil
+}
+
+// execer lo cumplen tanto *sql.DB como *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func revokeFamily(ctx context.Context, db execer, familyID string) error {
+	_, err := db.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = ? AND revoked_at IS NULL",
+		familyID,
+	)
+	return err
+}
+
+// RevokeFamily cierra una sesión: ni sus refresh tokens ni sus JWT vuelven a valer
+func (m *RefreshTokenModel) RevokeFamily(familyID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return revokeFamily(ctx, m.DB, familyID)
+}
+
+// RevokeUser cierra todas las sesiones abiertas del usuario
+func (m *RefreshTokenModel) RevokeUser(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL",
+		userID,
+	)
+	return err
+}
+
+// IsFamilyActive indica si la sesión del usuario sigue abierta: tiene algún token
+// sin revocar ni caducar
+func (m *RefreshTokenModel) IsFamilyActive(userID int, familyID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var active bool
+	query := `SELECT EXISTS(SELECT 1 FROM refresh_tokens
+		WHERE family_id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP)`
+	err := m.DB.QueryRowContext(ctx, query, familyID, userID).Scan(&active)
+	return active, err
+}
+
+// DeleteExpired borra los tokens caducados, que ya no sirven ni para detectar reutilizaciones
+func (m *RefreshTokenModel) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at < CURRENT_TIMESTAMP")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"errors"
+	"password-manager-backend/cmd/api/services"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const selectRefreshToken = "FROM refresh_tokens rt JOIN users u ON u.id = rt.user_id"
+
+var refreshColumns = []string{"id", "user_id", "family_id", "token_version", "mfa", "created_at",
+	"used", "revoked", "expired", "current_version", "disabled", "email_verified"}
+
+// refreshRow es un token de la familia "family" del usuario 1, con token_version 2
+func refreshRow(used, revoked, expired bool, currentVersion int, disabled, emailVerified bool) *sqlmock.Rows {
+	return sqlmock.NewRows(refreshColumns).
+		AddRow(9, 1, "family", 2, true, mockTime, used, revoked, expired, currentVersion, disabled, emailVerified)
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := RefreshTokenModel{DB: db}
+	token := services.SecretFromString("refresh")
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectRefreshToken)).WithArgs(services.HashRefreshToken(token)).
+		WillReturnRows(refreshRow(false, false, false, 2, false, true))
+	mock.ExpectExec(q("UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = ?")).WithArgs(9).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("INSERT INTO refresh_tokens")).WithArgs(1, "family", sqlmock.AnyArg(), 2, true, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(10, 1))
+	mock.ExpectCommit()
+	rotated, next, err := m.Rotate(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next == "" || rotated.FamilyId != "family" || !rotated.MFA {
+		t.Errorf("unexpected rotation %+v %q", rotated, next)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRotateRevokesFamily(t *testing.T) {
+	cases := []struct {
+		name string
+		rows *sqlmock.Rows
+		want error
+	}{
+		{"reused", refreshRow(true, false, false, 2, false, true), ErrRefreshTokenReused},
+		{"password changed", refreshRow(false, false, false, 3, false, true), ErrRefreshTokenInvalid},
+		{"disabled", refreshRow(false, false, false, 2, true, true), ErrRefreshAccountDisabled},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			m := RefreshTokenModel{DB: db}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(q(selectRefreshToken)).WillReturnRows(c.rows)
+			mock.ExpectExec(q("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = ?")).
+				WithArgs("family").WillReturnResult(sqlmock.NewResult(0, 2))
+			mock.ExpectCommit()
+			if _, next, err := m.Rotate(services.SecretFromString("refresh")); !errors.Is(err, c.want) || next != "" {
+				t.Errorf("expected %v and no new token, got %v %q", c.want, err, next)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRotateRejectsWithoutRevoking(t *testing.T) {
+	t.Setenv("REQUIRE_VERIFIED_EMAIL", "true")
+	cases := []struct {
+		name string
+		rows *sqlmock.Rows
+		want error
+	}{
+		{"revoked", refreshRow(false, true, false, 2, false, true), ErrRefreshTokenInvalid},
+		{"expired", refreshRow(false, false, true, 2, false, true), ErrRefreshTokenInvalid},
+		{"email not verified", refreshRow(false, false, false, 2, false, false), ErrRefreshEmailNotVerified},
+		{"unknown", sqlmock.NewRows(refreshColumns), ErrRefreshTokenInvalid},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			m := RefreshTokenModel{DB: db}
+
+			// Ni se gasta el token ni se emite otro
+			mock.ExpectBegin()
+			mock.ExpectQuery(q(selectRefreshToken)).WillReturnRows(c.rows)
+			mock.ExpectRollback()
+			if _, next, err := m.Rotate(services.SecretFromString("refresh")); !errors.Is(err, c.want) || next != "" {
+				t.Errorf("expected %v and no new token, got %v %q", c.want, err, next)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"password-manager-backend/cmd/api/services"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RoleModel gestiona los roles y sus permisos, ver la migración 000022
+type RoleModel struct {
+	DB *sql.DB
+}
+
+var (
+	ErrUnknownRole = errors.New("unknown role")
+	// ErrLastOwner: la instalación no se puede quedar sin nadie que la administre
+	ErrLastOwner = errors.New("there must be at least one owner")
+)
+
+type Role struct {
+	Id          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	BuiltIn     bool     `json:"built_in"`
+	Permissions []string `json:"permissions"`
+}
+
+// SetUserRolesRequest sustituye todos los roles de un usuario
+type SetUserRolesRequest struct {
+	Roles []string `json:"roles" binding:"required,dive,min=1,max=32"`
+}
+
+// userRolesSQL es la subconsulta con los roles del usuario de la tabla alias,
+// separados por comas (NULL si no tiene ninguno)
+func userRolesSQL(alias string) string {
+	return "(SELECT GROUP_CONCAT(r.name ORDER BY r.name) FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = " + alias + ".id)"
+}
+
+// queryExecer es un execer que además consulta, lo cumplen *sql.DB y *sql.Tx
+type queryExecer interface {
+	execer
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// splitNames convierte el resultado de GROUP_CONCAT en una lista
+func splitNames(value sql.NullString) []string {
+	if !value.Valid || value.String == "" {
+		return []string{}
+	}
+	return strings.Split(value.String, ",")
+}
+
+// GetAll devuelve todos los roles con sus permisos
+func (m *RoleModel) GetAll() ([]Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT r.id, r.name, r.description, r.built_in, GROUP_CONCAT(rp.permission ORDER BY rp.permission)
+		FROM roles r LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		GROUP BY r.id, r.name, r.description, r.built_in ORDER BY r.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []Role{}
+	for rows.Next() {
+		var role Role
+		var permissions sql.NullString
+		if err := rows.Scan(&role.Id, &role.Name, &role.Description, &role.BuiltIn, &permissions); err != nil {
+			return nil, err
+		}
+		role.Permissions = splitNames(permissions)
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// GetUserPermissions devuelve la suma de los permisos de los roles del usuario
+func (m *RoleModel) GetUserPermissions(userID int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT DISTINCT rp.permission FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		WHERE ur.user_id = ? ORDER BY rp.permission`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []string{}
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+// PermissionsOfRoles devuelve la suma de los permisos de los roles con esos nombres.
+// Si alguno no existe devuelve ErrUnknownRole.
+func (m *RoleModel) PermissionsOfRoles(names []string) ([]string, error) {
+	roles, err := m.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	permissions := []string{}
+	for _, name := range names {
+		i := slices.IndexFunc(roles, func(r Role) bool { return r.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownRole, name)
+		}
+		for _, permission := range roles[i].Permissions {
+			if !slices.Contains(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	return permissions, nil
+}
+
+// SetUserRoles sustituye los roles del usuario. Falla con ErrLastOwner si así no
+// queda ningún owner.
+func (m *RoleModel) SetUserRoles(userID int, names []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_roles WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := grantRole(ctx, tx, userID, name); err != nil {
+			return err
+		}
+	}
+	if err := requireOwner(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GrantRole da el rol al usuario; si ya lo tiene no hace nada
+func (m *RoleModel) GrantRole(userID int, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return grantRole(ctx, m.DB, userID, name)
+}
+
+// RevokeRole quita el rol al usuario. Falla con ErrLastOwner si así no queda ningún owner.
+func (m *RoleModel) RevokeRole(userID int, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := revokeRole(ctx, tx, userID, name); err != nil {
+		return err
+	}
+	if err := requireOwner(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsLastOwner indica si el usuario es el único owner que queda
+func (m *RoleModel) IsLastOwner(userID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var owners, isOwner int
+	err := m.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*), COALESCE(SUM(ur.user_id = ?), 0) FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id WHERE r.name = ?`,
+		userID, services.RoleOwner,
+	).Scan(&owners, &isOwner)
+	if err != nil {
+		return false, err
+	}
+	return isOwner > 0 && owners == 1, nil
+}
+
+// grantRole da el rol al usuario; si ya lo tiene no hace nada
+func grantRole(ctx context.Context, db queryExecer, userID int, name string) error {
+	result, err := db.ExecContext(ctx,
+		"INSERT IGNORE INTO user_roles (user_id, role_id) SELECT ?, id FROM roles WHERE name = ?",
+		userID, name,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// O el rol no existe o el usuario ya lo tenía
+		var exists bool
+		if err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM roles WHERE name = ?)", name).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: %s", ErrUnknownRole, name)
+		}
+	}
+	return nil
+}
+
+// grantDefaultRoles da a un usuario nuevo el rol user. Si todavía no hay ningún
+// owner (instalación recién hecha) también le da owner: alguien tiene que poder
+// repartir los roles. Devuelve los roles que le ha dado.
+func grantDefaultRoles(ctx context.Context, tx *sql.Tx, userID int) ([]string, error) {
+	if err := grantRole(ctx, tx, userID, services.RoleUser); err != nil {
+		return nil, err
+	}
+	var owners int
+	err := tx.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE r.name = ? FOR UPDATE",
+		services.RoleOwner,
+	).Scan(&owners)
+	if err != nil {
+		return nil, err
+	}
+	if owners > 0 {
+		return []string{services.RoleUser}, nil
+	}
+	if err := grantRole(ctx, tx, userID, services.RoleOwner); err != nil {
+		return nil, err
+	}
+	return []string{services.RoleOwner, services.RoleUser}, nil
+}
+
+// revokeRole quita el rol al usuario
+func revokeRole(ctx context.Context, db execer, userID int, name string) error {
+	_, err := db.ExecContext(ctx,
+		"DELETE ur FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = ? AND r.name = ?",
+		userID, name,
+	)
+	return err
+}
+
+// requireOwner falla con ErrLastOwner si no queda ningún owner
+func requireOwner(ctx context.Context, db queryExecer) error {
+	var owners int
+	err := db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE r.name = ?",
+		services.RoleOwner,
+	).Scan(&owners)
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"password-manager-backend/cmd/api/services"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SCIMModel es el aprovisionamiento del IdP (SCIM 2.0): los usuarios son los de
+// users y los grupos son los roles, ver la migración 000024
+type SCIMModel struct {
+	DB *sql.DB
+}
+
+var (
+	// ErrSCIMConflict: el userName, el email o el externalId ya son de otro usuario,
+	// o ya hay un rol con ese nombre
+	ErrSCIMConflict      = errors.New("a resource with that userName, email, externalId or displayName already exists")
+	ErrSCIMUnknownMember = errors.New("unknown group member")
+)
+
+// Atributos de SCIM por los que se puede filtrar y su columna
+var (
+	scimUserColumns = map[string]string{
+		"id":           "id",
+		"username":     "username",
+		"emails":       "email",
+		"emails.value": "email",
+		"externalid":   "scim_external_id",
+		"active":       "(disabled_at IS NULL)",
+	}
+	scimGroupColumns = map[string]string{
+		"id":          "id",
+		"displayname": "name",
+	}
+)
+
+// SCIMAccount es lo que SCIM ve de un usuario
+type SCIMAccount struct {
+	Id         int
+	UserName   string
+	Email      string
+	ExternalID string
+	Active     bool
+	Groups     []SCIMGroupRef
+}
+
+// SCIMGroupRef es un rol del usuario
+type SCIMGroupRef struct {
+	Id   int
+	Name string
+}
+
+// SCIMGroupRecord es un rol visto como grupo
+type SCIMGroupRecord struct {
+	Id      int
+	Name    string
+	BuiltIn bool
+	// nil si no se han pedido
+	Members []SCIMMember
+}
+
+// SCIMMember es un usuario de un grupo
+type SCIMMember struct {
+	Id       int
+	UserName string
+}
+
+// scimAccountColumns son las columnas que lee scanSCIMAccount. Los roles van como
+// "id:nombre" separados por comas: los nombres no llevan comas, ver ValidSCIMGroupName.
+const scimAccountColumns = `id, username, email, COALESCE(scim_external_id, ''), disabled_at IS NULL,
+	(SELECT GROUP_CONCAT(CONCAT(r.id, ':', r.name) ORDER BY r.id) FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = users.id)`
+
+func scanSCIMAccount(row rowScanner) (*SCIMAccount, error) {
+	var a SCIMAccount
+	var groups sql.NullString
+	if err := row.Scan(&a.Id, &a.UserName, &a.Email, &a.ExternalID, &a.Active, &groups); err != nil {
+		return nil, err
+	}
+	a.Groups = []SCIMGroupRef{}
+	for _, group := range splitNames(groups) {
+		id, name, _ := strings.Cut(group, ":")
+		roleID, _ := strconv.Atoi(id)
+		a.Groups = append(a.Groups, SCIMGroupRef{Id: roleID, Name: name})
+	}
+	return &a, nil
+}
+
+// scimWhere es la condición WHERE del filtro, o nada si no filtra
+func scimWhere(filter services.SCIMFilter, columns map[string]string) (string, []any, error) {
+	if len(filter) == 0 {
+		return "", nil, nil
+	}
+	condition, args, err := filter.SQL(columns)
+	if err != nil {
+		return "", nil, err
+	}
+	return " WHERE " + condition, args, nil
+}
+
+// ListUsers devuelve una página de los usuarios que cumplen el filtro, por id, y el total
+func (m *SCIMModel) ListUsers(filter services.SCIMFilter, page services.SCIMPage) ([]SCIMAccount, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	where, args, err := scimWhere(filter, scimUserColumns)
+	if err != nil {
+		return nil, 0, err
+	}
+	var total int
+	if err := m.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM users"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := m.DB.QueryContext(ctx,
+		"SELECT "+scimAccountColumns+" FROM users"+where+" ORDER BY id LIMIT ? OFFSET ?",
+		append(args, page.Count, page.Offset())...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	accounts := []SCIMAccount{}
+	for rows.Next() {
+		a, err := scanSCIMAccount(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		accounts = append(accounts, *a)
+	}
+	return accounts, total, rows.Err()
+}
+
+// GetUser devuelve el usuario o sql.ErrNoRows
+func (m *SCIMModel) GetUser(id int) (*SCIMAccount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return scanSCIMAccount(m.DB.QueryRowContext(ctx, "SELECT "+scimAccountColumns+" FROM users WHERE id = ?", id))
+}
+
+// CreateUser crea el usuario que manda el IdP, con el email ya verificado, los roles
+// de serie y una contraseña aleatoria que nadie conoce: entra por SSO o LDAP, o
+// con restablecer contraseña. Con Active a false se crea ya desactivado.
+func (m *SCIMModel) CreateUser(account *SCIMAccount) error {
+	password, err := services.RandomSecret(32)
+	if err != nil {
+		return err
+	}
+	defer password.Destroy()
+	hashedPassword, err := services.HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO users (email, password, username, icon, vault_mode, email_verified, scim_external_id, disabled_at)
+		VALUES (?, ?, ?, ?, ?, TRUE, ?, IF(?, NULL, CURRENT_TIMESTAMP))`,
+		account.Email, hashedPassword, account.UserName,
+		"https://avatar.iran.liara.run/username?username="+account.UserName, VaultModeServer,
+		nullIfEmpty(account.ExternalID), account.Active,
+	)
+	if isDuplicateKey(err) {
+		return ErrSCIMConflict
+	}
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if _, err := grantDefaultRoles(ctx, tx, int(id)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Igual que en el registro: sin clave de datos no puede guardar secretos
+	userKeyModel := UserKeyModel{DB: m.DB}
+	if _, err := userKeyModel.Create(int(id)); err != nil {
+		userModel := UserModel{DB: m.DB}
+		if delErr := userModel.DeleteUserByID(int(id)); delErr != nil {
+			log.Printf("Error rolling back user %d: %v", id, delErr)
+		}
+		return fmt.Errorf("error creating user key: %w", err)
+	}
+	account.Id = int(id)
+	return nil
+}
+
+// UpdateUser guarda el userName, el email y el externalId. El email lo verifica el
+// IdP, así que no pasa por EmailVerificationModel. active se cambia con
+// UserModel.SetDisabled, que además cierra las sesiones.
+func (m *SCIMModel) UpdateUser(account *SCIMAccount) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// Si el email cambia se sube token_version y se cierran las sesiones. MySQL
+	// asigna en orden, así que token_version se compara con el email anterior.
+	_, err := m.DB.ExecContext(ctx,
+		`UPDATE users SET token_version = token_version + (email <> ?),
+		username = ?, email = ?, email_verified = TRUE, scim_external_id = ? WHERE id = ?`,
+		account.Email, account.UserName, account.Email, nullIfEmpty(account.ExternalID), account.Id,
+	)
+	if isDuplicateKey(err) {
+		return ErrSCIMConflict
+	}
+	return err
+}
+
+// ListGroups devuelve una página de los roles que cumplen el filtro y el total.
+// Con withMembers trae también sus usuarios.
+func (m *SCIMModel) ListGroups(filter services.SCIMFilter, page services.SCIMPage, withMembers bool) ([]SCIMGroupRecord, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	where, args, err := scimWhere(filter, scimGroupColumns)
+	if err != nil {
+		return nil, 0, err
+	}
+	var total int
+	if err := m.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM roles"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := m.DB.QueryContext(ctx,
+		"SELECT id, name, built_in FROM roles"+where+" ORDER BY id LIMIT ? OFFSET ?",
+		append(args, page.Count, page.Offset())...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	groups := []SCIMGroupRecord{}
+	for rows.Next() {
+		var g SCIMGroupRecord
+		if err := rows.Scan(&g.Id, &g.Name, &g.BuiltIn); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		groups = append(groups, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if withMembers {
+		for i := range groups {
+			if groups[i].Members, err = groupMembers(ctx, m.DB, groups[i].Id); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return groups, total, nil
+}
+
+// GetGroup devuelve el rol o sql.ErrNoRows
+func (m *SCIMModel) GetGroup(id int, withMembers bool) (*SCIMGroupRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var g SCIMGroupRecord
+	err := m.DB.QueryRowContext(ctx, "SELECT id, name, built_in FROM roles WHERE id = ?", id).Scan(&g.Id, &g.Name, &g.BuiltIn)
+	if err != nil {
+		return nil, err
+	}
+	if withMembers {
+		if g.Members, err = groupMembers(ctx, m.DB, id); err != nil {
+			return nil, err
+		}
+	}
+	return &g, nil
+}
+
+// CreateGroup crea un rol sin permisos para el grupo del IdP; los permisos se los
+// da un administrador
+func (m *SCIMModel) CreateGroup(name string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		"INSERT INTO roles (name, description, built_in) VALUES (?, ?, FALSE)",
+		name, "Grupo aprovisionado por SCIM",
+	)
+	if isDuplicateKey(err) {
+		return 0, ErrSCIMConflict
+	}
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// RenameGroup cambia el nombre de un rol que no es de serie
+func (m *SCIMModel) RenameGroup(id int, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "UPDATE roles SET name = ? WHERE id = ? AND built_in = FALSE", name, id)
+	if isDuplicateKey(err) {
+		return ErrSCIMConflict
+	}
+	return err
+}
+
+// UpdateGroupMembers da el rol a los usuarios de add y se lo quita a los de remove.
+// Falla con ErrSCIMUnknownMember si alguno de add no existe y con ErrLastOwner si
+// así no queda ningún owner.
+func (m *SCIMModel) UpdateGroupMembers(id int, add, remove []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, userID := range add {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)", userID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: %d", ErrSCIMUnknownMember, userID)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT IGNORE INTO user_roles (user_id, role_id) VALUES (?, ?)", userID, id); err != nil {
+			return err
+		}
+	}
+	for _, userID := range remove {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM user_roles WHERE user_id = ? AND role_id = ?", userID, id); err != nil {
+			return err
+		}
+	}
+	if err := requireOwner(ctx, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteGroup borra un rol que no es de serie; sus usuarios lo pierden
+func (m *SCIMModel) DeleteGroup(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM roles WHERE id = ? AND built_in = FALSE", id)
+	return err
+}
+
+// groupMembers devuelve los usuarios que tienen el rol
+func groupMembers(ctx context.Context, db *sql.DB, roleID int) ([]SCIMMember, error) {
+	rows, err := db.QueryContext(ctx,
+		"SELECT u.id, u.username FROM user_roles ur JOIN users u ON u.id = ur.user_id WHERE ur.role_id = ? ORDER BY u.id",
+		roleID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []SCIMMember{}
+	for rows.Next() {
+		var member SCIMMember
+		if err := rows.Scan(&member.Id, &member.UserName); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// nullIfEmpty guarda NULL en vez de "": varios NULL no chocan en un índice UNIQUE
+func nullIfEmpty(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: value != ""}
+}
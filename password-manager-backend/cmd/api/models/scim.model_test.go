@@ -0,0 +1,52 @@
+package models
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestSCIMUpdateUserConflict(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := SCIMModel{DB: db}
+
+	// Otro usuario ya tiene ese email o ese nombre
+	mock.ExpectExec(q("UPDATE users SET token_version = token_version + (email <> ?)")).
+		WithArgs("ana@example.com", "luis", "ana@example.com", "ext-7", 7).
+		WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+	err := m.UpdateUser(&SCIMAccount{Id: 7, UserName: "luis", Email: "ana@example.com", ExternalID: "ext-7"})
+	if !errors.Is(err, ErrSCIMConflict) {
+		t.Errorf("expected ErrSCIMConflict, got %v", err)
+	}
+
+	// Sin externalId se guarda NULL, no una cadena vacía
+	mock.ExpectExec(q("UPDATE users SET token_version = token_version + (email <> ?)")).
+		WithArgs("luis@example.com", "luis", "luis@example.com", nil, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := m.UpdateUser(&SCIMAccount{Id: 7, UserName: "luis", Email: "luis@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSCIMGetUserGroups(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := SCIMModel{DB: db}
+
+	mock.ExpectQuery(q("FROM users WHERE id = ?")).WithArgs(7).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "username", "email", "external_id", "active", "groups"}).
+			AddRow(7, "luis", "luis@example.com", "", false, "2:user,5:support"))
+	account, err := m.GetUser(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Active || len(account.Groups) != 2 || account.Groups[1] != (SCIMGroupRef{Id: 5, Name: "support"}) {
+		t.Errorf("unexpected account %+v", account)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
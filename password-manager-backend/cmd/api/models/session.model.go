@@ -0,0 +1,197 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"password-manager-backend/cmd/api/services"
+	"time"
+)
+
+type SessionModel struct {
+	DB *sql.DB
+}
+
+// Session es una sesión abierta del usuario y el dispositivo desde el que se abrió
+type Session struct {
+	// Id es el family_id de sus refresh tokens y el sid de sus JWT
+	Id         string    `json:"id"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// Current marca la sesión desde la que se hace la petición
+	Current bool `json:"current"`
+}
+
+// activeFamily es la condición de una sesión abierta: algún refresh token de su
+// familia sigue sin revocar ni caducar
+const activeFamily = `EXISTS(SELECT 1 FROM refresh_tokens rt
+	WHERE rt.family_id = s.id AND rt.revoked_at IS NULL AND rt.expires_at > CURRENT_TIMESTAMP)`
+
+// Create guarda la sesión recién abierta con el dispositivo de la petición
+func (m *SessionModel) Create(sessionID string, userID int, userAgent, ip string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx,
+		"INSERT INTO sessions (id, user_id, device_name, user_agent, ip) VALUES (?, ?, ?, ?, ?)",
+		sessionID, userID, services.DeviceName(userAgent), services.TruncateUserAgent(userAgent), ip,
+	)
+	return err
+}
+
+// Touch apunta la última actividad y la IP de la sesión. Solo escribe si la
+// anterior tiene más de SessionTouchInterval.
+func (m *SessionModel) Touch(sessionID, ip string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx,
+		`UPDATE sessions SET last_seen_at = CURRENT_TIMESTAMP, ip = ?
+		WHERE id = ? AND last_seen_at < CURRENT_TIMESTAMP - INTERVAL ? SECOND`,
+		ip, sessionID, int(services.SessionTouchInterval.Seconds()),
+	)
+	return err
+}
+
+// GetByUserID devuelve las sesiones abiertas del usuario, la más reciente primero.
+// currentID marca la sesión de la petición.
+func (m *SessionModel) GetByUserID(userID int, currentID string) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT s.id, s.device_name, s.user_agent, s.ip, s.created_at, s.last_seen_at
+		FROM sessions s WHERE s.user_id = ? AND `+activeFamily+`
+		ORDER BY s.last_seen_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var s Session
+		var createdAt, lastSeenAt []byte
+		if err := rows.Scan(&s.Id, &s.DeviceName, &s.UserAgent, &s.IP, &createdAt, &lastSeenAt); err != nil {
+			return nil, err
+		}
+		s.CreatedAt, _ = parseTime(createdAt)
+		s.LastSeenAt, _ = parseTime(lastSeenAt)
+		s.Current = s.Id == currentID
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// Revoke cierra una sesión del usuario. Si no es suya devuelve sql.ErrNoRows.
+func (m *SessionModel) Revoke(userID int, sessionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM sessions WHERE id = ? AND user_id = ?", sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	if err := revokeFamily(ctx, tx, sessionID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokeOthers cierra todas las sesiones del usuario menos keepID, también las
+// abiertas antes de que existiera la tabla de sesiones. Devuelve cuántas cerró.
+func (m *SessionModel) RevokeOthers(userID int, keepID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var closed int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT COUNT(DISTINCT family_id) FROM refresh_tokens
+		WHERE user_id = ? AND family_id <> ? AND revoked_at IS NULL AND expires_at > CURRENT_TIMESTAMP`,
+		userID, keepID,
+	).Scan(&closed)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND family_id <> ? AND revoked_at IS NULL",
+		userID, keepID,
+	); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ? AND id <> ?", userID, keepID); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return closed, nil
+}
+
+// DeleteExpired borra las sesiones sin actividad desde hace más que la vida de un
+// refresh token: su último token ya caducó
+func (m *SessionModel) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		"DELETE FROM sessions WHERE last_seen_at < CURRENT_TIMESTAMP - INTERVAL ? SECOND",
+		int(services.RefreshTokenTTL().Seconds()),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RevokeAll cierra todas las sesiones del usuario, como una desconexión forzada desde
+// la consola de administración
+func (m *SessionModel) RevokeAll(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := revokeUserSessions(ctx, tx, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// revokeUserSessions revoca todos los refresh tokens del usuario y borra sus sesiones:
+// los JWT emitidos dejan de valer en la siguiente petición
+func revokeUserSessions(ctx context.Context, db execer, userID int) error {
+	if _, err := db.ExecContext(ctx,
+		"UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL",
+		userID,
+	); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ?", userID)
+	return err
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRevokeSession(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := SessionModel{DB: db}
+
+	// Cerrar la sesión revoca su familia de refresh tokens en la misma transacción
+	mock.ExpectBegin()
+	mock.ExpectExec(q("DELETE FROM sessions WHERE id = ? AND user_id = ?")).WithArgs("family", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(q("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE family_id = ?")).WithArgs("family").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	if err := m.Revoke(1, "family"); err != nil {
+		t.Fatal(err)
+	}
+
+	// La sesión de otro usuario no se toca
+	mock.ExpectBegin()
+	mock.ExpectExec(q("DELETE FROM sessions WHERE id = ? AND user_id = ?")).WithArgs("family", 2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if err := m.Revoke(2, "family"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := SessionModel{DB: db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(q("SELECT COUNT(DISTINCT family_id) FROM refresh_tokens")).WithArgs(1, "current").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectExec(q("UPDATE refresh_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND family_id <> ?")).
+		WithArgs(1, "current").WillReturnResult(sqlmock.NewResult(0, 5))
+	mock.ExpectExec(q("DELETE FROM sessions WHERE user_id = ? AND id <> ?")).WithArgs(1, "current").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	closed, err := m.RevokeOthers(1, "current")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closed != 3 {
+		t.Errorf("expected 3 closed sessions, got %d", closed)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetSessionsMarksCurrent(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := SessionModel{DB: db}
+
+	mock.ExpectQuery(q("FROM sessions s WHERE s.user_id = ? AND EXISTS")).WithArgs(1).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "device_name", "user_agent", "ip", "created_at", "last_seen_at"}).
+			AddRow("current", "Firefox on Linux", "Mozilla/5.0", "10.0.0.1", mockTime, mockTime).
+			AddRow("other", "Safari on iOS", "Mozilla/5.0", "10.0.0.2", mockTime, mockTime))
+	sessions, err := m.GetByUserID(1, "current")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 || !sessions[0].Current || sessions[1].Current {
+		t.Errorf("unexpected sessions %+v", sessions)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,5 +1,7 @@
 package models
 
+import "password-manager-backend/cmd/api/services"
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
@@ -8,3 +10,8 @@ type VerifyNotePasswordRequest struct {
 	NoteID   int    `json:"note_id"`
 	Password string `json:"password"`
 }
+
+type NoteSecretResponse struct {
+	NoteID   int                    `json:"note_id"`
+	Password services.ExposedSecret `json:"password" swaggertype:"string"`
+}
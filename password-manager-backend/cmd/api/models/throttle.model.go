@@ -0,0 +1,177 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"password-manager-backend/cmd/api/services"
+	"time"
+)
+
+type ThrottleModel struct {
+	DB *sql.DB
+}
+
+// ThrottleKey identifica lo que se limita: una cuenta, un usuario o una IP
+type ThrottleKey struct {
+	Scope   string
+	Subject string
+}
+
+// LoginThrottleKeys son las claves de un intento de login: el email y la IP
+func LoginThrottleKeys(email, ip string) []ThrottleKey {
+	return []ThrottleKey{
+		{Scope: services.ThrottleScopeLogin, Subject: services.NormalizeThrottleSubject(email)},
+		{Scope: services.ThrottleScopeIP, Subject: ip},
+	}
+}
+
+// LockedAccount es una cuenta bloqueada por fallos de login, tal como la ve un admin.
+// UserId es nil si el email no es de nadie: alguien está probando cuentas.
+type LockedAccount struct {
+	Email         string    `json:"email"`
+	UserId        *int      `json:"user_id"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+// Las diferencias de tiempo se calculan en MySQL para no mezclar su reloj con el nuestro
+const selectThrottleState = `SELECT failures, TIMESTAMPDIFF(SECOND, last_failure_at, CURRENT_TIMESTAMP),
+	GREATEST(COALESCE(TIMESTAMPDIFF(SECOND, CURRENT_TIMESTAMP, locked_until), 0), 0)
+	FROM auth_throttle WHERE scope = ? AND subject = ?`
+
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func loadThrottleState(ctx context.Context, db rowQueryer, key ThrottleKey, forUpdate bool) (services.ThrottleState, error) {
+	query := selectThrottleState
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	var state services.ThrottleState
+	var since, locked int64
+	err := db.QueryRowContext(ctx, query, key.Scope, key.Subject).Scan(&state.Failures, &since, &locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return services.ThrottleState{SinceLastFailure: services.ThrottleWindow()}, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	state.SinceLastFailure = time.Duration(since) * time.Second
+	state.LockedFor = time.Duration(locked) * time.Second
+	return state, nil
+}
+
+// RetryAfter devuelve cuánto hay que esperar antes de volver a intentarlo con
+// estas claves; 0 si se puede ya
+func (m *ThrottleModel) RetryAfter(keys ...ThrottleKey) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var wait time.Duration
+	for _, key := range keys {
+		state, err := loadThrottleState(ctx, m.DB, key, false)
+		if err != nil {
+			return 0, err
+		}
+		wait = max(wait, services.ThrottleRetryAfter(services.ThrottlePolicyFor(key.Scope), state))
+	}
+	return wait, nil
+}
+
+// RecordFailure cuenta un fallo en cada clave y las bloquea si llegan al límite
+func (m *ThrottleModel) RecordFailure(keys ...ThrottleKey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, key := range keys {
+		state, err := loadThrottleState(ctx, tx, key, true)
+		if err != nil {
+			return err
+		}
+		failures, lock := services.ThrottleNextFailure(services.ThrottlePolicyFor(key.Scope), state)
+		lockSeconds := int(lock.Seconds())
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO auth_throttle (scope, subject, failures, last_failure_at, locked_until)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP, IF(? > 0, CURRENT_TIMESTAMP + INTERVAL ? SECOND, NULL))
+			ON DUPLICATE KEY UPDATE failures = ?, last_failure_at = CURRENT_TIMESTAMP,
+				locked_until = IF(? > 0, CURRENT_TIMESTAMP + INTERVAL ? SECOND, locked_until)`,
+			key.Scope, key.Subject, failures, lockSeconds, lockSeconds,
+			failures, lockSeconds, lockSeconds,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Reset olvida los fallos de una clave tras un intento correcto
+func (m *ThrottleModel) Reset(key ThrottleKey) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "DELETE FROM auth_throttle WHERE scope = ? AND subject = ?", key.Scope, key.Subject)
+	return err
+}
+
+// GetLockedAccounts lista las cuentas de login bloqueadas ahora mismo
+func (m *ThrottleModel) GetLockedAccounts() ([]LockedAccount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT t.subject, u.id, t.failures, t.last_failure_at, t.locked_until
+		FROM auth_throttle t LEFT JOIN users u ON u.email = t.subject
+		WHERE t.scope = ? AND t.locked_until > CURRENT_TIMESTAMP
+		ORDER BY t.locked_until DESC`,
+		services.ThrottleScopeLogin,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []LockedAccount{}
+	for rows.Next() {
+		var a LockedAccount
+		var userID sql.NullInt64
+		var lastFailureAt, lockedUntil []byte
+		if err := rows.Scan(&a.Email, &userID, &a.Failures, &lastFailureAt, &lockedUntil); err != nil {
+			return nil, err
+		}
+		if userID.Valid {
+			id := int(userID.Int64)
+			a.UserId = &id
+		}
+		a.LastFailureAt, _ = parseTime(lastFailureAt)
+		a.LockedUntil, _ = parseTime(lockedUntil)
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// DeleteExpired olvida las claves sin fallos recientes ni bloqueo vigente
+func (m *ThrottleModel) DeleteExpired() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx,
+		`DELETE FROM auth_throttle
+		WHERE last_failure_at < CURRENT_TIMESTAMP - INTERVAL ? SECOND
+		AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)`,
+		int(services.ThrottleWindow().Seconds()),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
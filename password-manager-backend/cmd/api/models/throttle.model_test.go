@@ -0,0 +1,69 @@
+package models
+
+import (
+	"password-manager-backend/cmd/api/services"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const selectThrottle = "FROM auth_throttle WHERE scope = ? AND subject = ?"
+
+var throttleColumns = []string{"failures", "since_last_failure", "locked_for"}
+
+func TestRecordFailureLocksAtMaxAttempts(t *testing.T) {
+	t.Setenv("LOGIN_MAX_ATTEMPTS", "10")
+	t.Setenv("LOGIN_LOCKOUT_DURATION", "15m")
+	db, mock := newMockDB(t)
+	m := ThrottleModel{DB: db}
+	keys := LoginThrottleKeys(" Ana@Example.com", "10.0.0.1")
+
+	// El décimo fallo de la cuenta la bloquea; la IP aún no llega a su límite
+	mock.ExpectBegin()
+	mock.ExpectQuery(q(selectThrottle+" FOR UPDATE")).WithArgs(services.ThrottleScopeLogin, "ana@example.com").
+		WillReturnRows(sqlmock.NewRows(throttleColumns).AddRow(9, 30, 0))
+	mock.ExpectExec(q("INSERT INTO auth_throttle")).
+		WithArgs(services.ThrottleScopeLogin, "ana@example.com", 10, 900, 900, 10, 900, 900).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(q(selectThrottle+" FOR UPDATE")).WithArgs(services.ThrottleScopeIP, "10.0.0.1").
+		WillReturnRows(sqlmock.NewRows(throttleColumns))
+	mock.ExpectExec(q("INSERT INTO auth_throttle")).
+		WithArgs(services.ThrottleScopeIP, "10.0.0.1", 1, 0, 0, 1, 0, 0).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := m.RecordFailure(keys...); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mientras dura el bloqueo hay que esperar lo que le queda
+	mock.ExpectQuery(q(selectThrottle)).WithArgs(services.ThrottleScopeLogin, "ana@example.com").
+		WillReturnRows(sqlmock.NewRows(throttleColumns).AddRow(10, 0, 900))
+	mock.ExpectQuery(q(selectThrottle)).WithArgs(services.ThrottleScopeIP, "10.0.0.1").
+		WillReturnRows(sqlmock.NewRows(throttleColumns).AddRow(1, 0, 0))
+	wait, err := m.RetryAfter(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 15*time.Minute {
+		t.Errorf("expected to wait 15m, got %v", wait)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRetryAfterWithoutFailures(t *testing.T) {
+	db, mock := newMockDB(t)
+	m := ThrottleModel{DB: db}
+
+	mock.ExpectQuery(q(selectThrottle)).WillReturnRows(sqlmock.NewRows(throttleColumns))
+	mock.ExpectQuery(q(selectThrottle)).WillReturnRows(sqlmock.NewRows(throttleColumns))
+	wait, err := m.RetryAfter(LoginThrottleKeys("ana@example.com", "10.0.0.1")...)
+	if err != nil || wait != 0 {
+		t.Errorf("expected no wait, got %v %v", wait, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
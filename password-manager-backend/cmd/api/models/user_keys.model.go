@@ -0,0 +1,124 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"password-manager-backend/cmd/api/services"
+	"time"
+)
+
+type UserKeyModel struct {
+	DB *sql.DB
+}
+
+// UserKey es la clave de datos de un usuario, guardada envuelta por la clave maestra
+type UserKey struct {
+	Id               int
+	UserId           int
+	WrappedKey       []byte
+	Nonce            []byte
+	MasterKeyVersion int
+	CreatedAt        time.Time
+}
+
+// userKeyAAD liga la clave envuelta a su usuario
+func userKeyAAD(userID int) []byte {
+	return []byte(fmt.Sprintf("user_keys:user=%d", userID))
+}
+
+// Unwrap descifra la clave de datos con la clave maestra. El llamador la destruye al terminar.
+func (k *UserKey) Unwrap() (*services.Secret, error) {
+	return services.UnwrapDataKey(&services.EncryptedSecret{
+		Ciphertext: k.WrappedKey,
+		Nonce:      k.Nonce,
+		KeyVersion: k.MasterKeyVersion,
+	}, userKeyAAD(k.UserId))
+}
+
+// Create genera una clave de datos nueva para el usuario y la guarda envuelta
+func (m *UserKeyModel) Create(userID int) (*UserKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	dataKey, err := services.GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+	defer dataKey.Destroy()
+	wrapped, err := services.WrapDataKey(dataKey, userKeyAAD(userID))
+	if err != nil {
+		return nil, err
+	}
+
+	query := "INSERT INTO user_keys (user_id, wrapped_key, nonce, master_key_version) VALUES (?, ?, ?, ?)"
+	result, err := m.DB.ExecContext(ctx, query, userID, wrapped.Ciphertext, wrapped.Nonce, wrapped.KeyVersion)
+	if err != nil {
+		return nil, fmt.Errorf("error inserting user key: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("error getting last insert id: %w", err)
+	}
+
+	return &UserKey{
+		Id:               int(id),
+		UserId:           userID,
+		WrappedKey:       wrapped.Ciphertext,
+		Nonce:            wrapped.Nonce,
+		MasterKeyVersion: wrapped.KeyVersion,
+		CreatedAt:        time.Now(),
+	}, nil
+}
+
+// GetByID obtiene una clave por su ID
+func (m *UserKeyModel) GetByID(id int) (*UserKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT id, user_id, wrapped_key, nonce, master_key_version, created_at FROM user_keys WHERE id = ?"
+	return scanUserKey(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// GetByUserID obtiene la clave de datos de un usuario
+func (m *UserKeyModel) GetByUserID(userID int) (*UserKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT id, user_id, wrapped_key, nonce, master_key_version, created_at FROM user_keys WHERE user_id = ?"
+	return scanUserKey(m.DB.QueryRowContext(ctx, query, userID))
+}
+
+// GetOrCreate devuelve la clave del usuario y la crea si todavía no tiene
+// (usuarios registrados antes de que existieran las claves por usuario)
+func (m *UserKeyModel) GetOrCreate(userID int) (*UserKey, error) {
+	key, err := m.GetByUserID(userID)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	key, err = m.Create(userID)
+	if err != nil {
+		// Otra petición pudo crearla a la vez (user_id es UNIQUE)
+		if existing, getErr := m.GetByUserID(userID); getErr == nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+func scanUserKey(row rowScanner) (*UserKey, error) {
+	var k UserKey
+	var createdAt []byte
+	if err := row.Scan(&k.Id, &k.UserId, &k.WrappedKey, &k.Nonce, &k.MasterKeyVersion, &createdAt); err != nil {
+		return nil, err
+	}
+	if t, err := parseTime(createdAt); err == nil {
+		k.CreatedAt = t
+	}
+	return &k, nil
+}
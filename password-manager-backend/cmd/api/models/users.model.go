@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"password-manager-backend/cmd/api/services"
 	"time"
 )
 
@@ -14,7 +15,8 @@ type UserModel struct {
 
 type UpdateUserRequest struct {
 	Userame string `json:"username"`
-	Email   string `json:"email"`
+	// Un email distinto no se guarda aquí: queda pendiente hasta confirmarlo, ver EmailVerificationModel
+	Email string `json:"email" binding:"omitempty,email"`
 }
 
 type User struct {
@@ -23,15 +25,59 @@ type User struct {
 	Password string `json:"password,omitempty" binding:"required,min=8,max=64"`
 	Username string `json:"username" binding:"required,min=3,max=32"`
 	Icon     string `json:"icon" binding:"omitempty,max=256"`
-	Admin    bool   `json:"admin" binding:"omitempty"`
+	// Nombres de sus roles, ver RoleModel
+	Roles []string `json:"roles"`
+	// Permisos de sus roles; solo los devuelve /users/me
+	Permissions []string `json:"permissions,omitempty"`
+	// server o zero_knowledge, ver VaultModeServer
+	VaultMode    string              `json:"vault_mode"`
+	Kdf          *services.KdfParams `json:"-"`
+	TokenVersion int                 `json:"-"`
+	// Segundo factor TOTP confirmado, ver MFAModel
+	TOTPEnabled bool `json:"totp_enabled"`
+	// El usuario ha demostrado que el email es suyo, ver EmailVerificationModel
+	EmailVerified bool `json:"email_verified"`
+	// Cuenta desactivada desde la consola de administración: no entra de ninguna forma
+	Disabled bool `json:"disabled"`
+	// El próximo login con contraseña obliga a cambiarla, ver ChangeRequiredPassword
+	MustChangePassword bool `json:"must_change_password"`
+}
+
+// UserList es una página del listado de usuarios de la consola de administración
+type UserList struct {
+	Users []User `json:"users"`
+	Total int    `json:"total"`
+	services.Page
 }
 
 func (m *UserModel) Insert(user *User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "INSERT INTO users (email, password, username, icon) VALUES (?, ?, ?, ?)"
-	result, err := m.DB.ExecContext(ctx, query, user.Email, user.Password, user.Username, user.Icon)
+	if user.VaultMode == "" {
+		user.VaultMode = VaultModeServer
+	}
+	// Los parámetros del KDF solo existen en las bóvedas zero-knowledge
+	var kdfAlgorithm, kdfSalt sql.NullString
+	var kdfIterations, kdfMemory, kdfParallelism sql.NullInt64
+	if user.Kdf != nil {
+		kdfAlgorithm = sql.NullString{String: user.Kdf.Algorithm, Valid: true}
+		kdfSalt = sql.NullString{String: user.Kdf.Salt, Valid: true}
+		kdfIterations = sql.NullInt64{Int64: int64(user.Kdf.Iterations), Valid: true}
+		kdfMemory = sql.NullInt64{Int64: int64(user.Kdf.Memory), Valid: true}
+		kdfParallelism = sql.NullInt64{Int64: int64(user.Kdf.Parallelism), Valid: true}
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO users (email, password, username, icon, vault_mode, kdf_algorithm, kdf_salt, kdf_iterations, kdf_memory, kdf_parallelism)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	result, err := tx.ExecContext(ctx, query, user.Email, user.Password, user.Username, user.Icon,
+		user.VaultMode, kdfAlgorithm, kdfSalt, kdfIterations, kdfMemory, kdfParallelism)
 
 	if err != nil {
 		// Usamos fmt para imprimir por consola
@@ -41,8 +87,16 @@ func (m *UserModel) Insert(user *User) error {
 	if err != nil {
 		return fmt.Errorf("error getting last insert id: %w", err)
 	}
+	roles, err := grantDefaultRoles(ctx, tx, int(id))
+	if err != nil {
+		return fmt.Errorf("error granting default roles: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
 
 	user.Id = int(id)
+	user.Roles = roles
 	return nil
 }
 
@@ -51,15 +105,17 @@ func (m *UserModel) GetUserFromEmail(email string) (*User, error) { // Devolver
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel() // Es buena practica usar el cancel cuando se usa WithTimeout
 
-	query := "SELECT id, username, email, icon, password, admin FROM users WHERE email = ?"
+	query := "SELECT id, username, email, icon, password, " + userRolesSQL("users") + ", vault_mode, token_version, totp_enabled, email_verified, disabled_at IS NOT NULL, must_change_password FROM users WHERE email = ?"
 	user := &User{} // Puntero a un usuario
-	err := m.DB.QueryRowContext(ctx, query, email).Scan(&user.Id, &user.Username, &user.Email, &user.Icon, &user.Password, &user.Admin)
+	var roles sql.NullString
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(&user.Id, &user.Username, &user.Email, &user.Icon, &user.Password, &roles, &user.VaultMode, &user.TokenVersion, &user.TOTPEnabled, &user.EmailVerified, &user.Disabled, &user.MustChangePassword)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, sql.ErrNoRows
 		}
 		return nil, err
 	}
+	user.Roles = splitNames(roles)
 	return user, nil
 }
 
@@ -67,14 +123,16 @@ func (m *UserModel) GetByID(id int) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "SELECT id, email, username, icon, admin, password FROM users WHERE id = ?"
+	query := "SELECT id, email, username, icon, " + userRolesSQL("users") + ", password, vault_mode, token_version, totp_enabled, email_verified, disabled_at IS NOT NULL, must_change_password FROM users WHERE id = ?"
 	row := m.DB.QueryRowContext(ctx, query, id)
 
 	var u User
-	err := row.Scan(&u.Id, &u.Email, &u.Username, &u.Icon, &u.Admin, &u.Password)
+	var roles sql.NullString
+	err := row.Scan(&u.Id, &u.Email, &u.Username, &u.Icon, &roles, &u.Password, &u.VaultMode, &u.TokenVersion, &u.TOTPEnabled, &u.EmailVerified, &u.Disabled, &u.MustChangePassword)
 	if err != nil {
 		return nil, err
 	}
+	u.Roles = splitNames(roles)
 
 	return &u, nil
 }
@@ -83,7 +141,7 @@ func (m *UserModel) GetAll() ([]User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "SELECT id, email, username, icon, admin, password FROM users"
+	query := "SELECT id, email, username, icon, " + userRolesSQL("users") + ", password, vault_mode, totp_enabled, email_verified, disabled_at IS NOT NULL, must_change_password FROM users"
 	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
@@ -93,27 +151,193 @@ func (m *UserModel) GetAll() ([]User, error) {
 	var users []User
 	for rows.Next() {
 		var u User
-		err := rows.Scan(&u.Id, &u.Email, &u.Username, &u.Icon, &u.Admin, &u.Password)
+		var roles sql.NullString
+		err := rows.Scan(&u.Id, &u.Email, &u.Username, &u.Icon, &roles, &u.Password, &u.VaultMode, &u.TOTPEnabled, &u.EmailVerified, &u.Disabled, &u.MustChangePassword)
 		if err != nil {
 			return nil, err
 		}
+		u.Roles = splitNames(roles)
 		users = append(users, u)
 	}
 
 	return users, nil
 }
 
+// Search busca usuarios por email o nombre para la consola de administración (text
+// vacío: todos) y devuelve una página, por id, y el total. Nunca trae la contraseña.
+func (m *UserModel) Search(text string, page services.Page) ([]User, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	where := ""
+	var args []any
+	if text != "" {
+		where = " WHERE email LIKE ? OR username LIKE ?"
+		pattern := services.LikeContains(text)
+		args = append(args, pattern, pattern)
+	}
+
+	var total int
+	if err := m.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM users"+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	query := "SELECT id, email, username, icon, " + userRolesSQL("users") + `, vault_mode, totp_enabled, email_verified,
+		disabled_at IS NOT NULL, must_change_password FROM users` + where + " ORDER BY id LIMIT ? OFFSET ?"
+	rows, err := m.DB.QueryContext(ctx, query, append(args, page.Size, page.Offset())...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var u User
+		var roles sql.NullString
+		err := rows.Scan(&u.Id, &u.Email, &u.Username, &u.Icon, &roles, &u.VaultMode, &u.TOTPEnabled, &u.EmailVerified, &u.Disabled, &u.MustChangePassword)
+		if err != nil {
+			return nil, 0, err
+		}
+		u.Roles = splitNames(roles)
+		users = append(users, u)
+	}
+	return users, total, rows.Err()
+}
+
+// GetKdfParams devuelve los parámetros del KDF de una bóveda zero-knowledge.
+// Si el usuario no existe o no usa ese modo devuelve sql.ErrNoRows.
+func (m *UserModel) GetKdfParams(email string) (*services.KdfParams, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT kdf_algorithm, kdf_salt, kdf_iterations, kdf_memory, kdf_parallelism
+		FROM users WHERE email = ? AND vault_mode = ?`
+	var p services.KdfParams
+	var memory, parallelism sql.NullInt64
+	err := m.DB.QueryRowContext(ctx, query, email, VaultModeZeroKnowledge).
+		Scan(&p.Algorithm, &p.Salt, &p.Iterations, &memory, &parallelism)
+	if err != nil {
+		return nil, err
+	}
+	p.Memory = int(memory.Int64)
+	p.Parallelism = int(parallelism.Int64)
+	return &p, nil
+}
+
+// UpdateUserByID cambia el nombre de usuario; si viene vacío se deja como está.
+// El email solo cambia con EmailVerificationModel.Confirm, cuando el nuevo está
+// verificado.
 func (um *UserModel) UpdateUserByID(id int, req UpdateUserRequest) error {
-	_, err := um.DB.Exec(`
-		UPDATE users 
-		SET username = ?, email = ? 
-		WHERE id = ?`,
-		req.Userame, req.Email, id,
-	)
+	if req.Userame == "" {
+		return nil
+	}
+	_, err := um.DB.Exec(`UPDATE users SET username = ? WHERE id = ?`, req.Userame, id)
 	return err
 }
 
-func (um *UserModel) DeleteUserByID(id int) error {
-	_, err := um.DB.Exec(`DELETE FROM users WHERE id = ?`, id)
+// UpdatePassword guarda un nuevo hash de contraseña
+func (um *UserModel) UpdatePassword(id int, hashedPassword string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := um.DB.ExecContext(ctx, `UPDATE users SET password = ? WHERE id = ?`, hashedPassword, id)
 	return err
 }
+
+// DeleteUserByID borra el usuario. Antes destruye su clave de datos: sin ella los
+// secretos cifrados que queden en backups ya no se pueden descifrar (crypto-shredding)
+func (um *UserModel) DeleteUserByID(id int) error {
+	tx, err := um.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM user_keys WHERE user_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetDisabled desactiva o reactiva la cuenta. Al desactivarla cierra todas sus
+// sesiones; sus PAT dejan de valer mientras siga desactivada.
+func (um *UserModel) SetDisabled(id int, disabled bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := um.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if !disabled {
+		if _, err := tx.ExecContext(ctx, "UPDATE users SET disabled_at = NULL WHERE id = ?", id); err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET disabled_at = COALESCE(disabled_at, CURRENT_TIMESTAMP) WHERE id = ?", id,
+	); err != nil {
+		return err
+	}
+	if err := revokeUserSessions(ctx, tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RequirePasswordChange obliga a cambiar la contraseña en el próximo login y cierra
+// las sesiones abiertas para que ese login sea ya
+func (um *UserModel) RequirePasswordChange(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := um.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET must_change_password = TRUE WHERE id = ?", id); err != nil {
+		return err
+	}
+	if err := revokeUserSessions(ctx, tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ChangeRequiredPassword guarda la contraseña nueva de un usuario obligado a
+// cambiarla. tokenVersion es la del token del login: si ha cambiado desde entonces,
+// o ya no hay que cambiarla, devuelve ErrPasswordResetInvalid. Sube token_version y
+// cierra las sesiones como un restablecimiento por correo.
+func (um *UserModel) ChangeRequiredPassword(id, tokenVersion int, hashedPassword string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := um.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE users SET password = ?, must_change_password = FALSE, token_version = token_version + 1
+		WHERE id = ? AND token_version = ? AND must_change_password = TRUE`,
+		hashedPassword, id, tokenVersion,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrPasswordResetInvalid
+	}
+	if err := revokeUserSessions(ctx, tx, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestUpdateUserByIDOnlyEmail(t *testing.T) {
+	db, mock := newMockDB(t)
+	um := UserModel{DB: db}
+
+	// El email queda pendiente de verificar: no hay nada que escribir y el
+	// nombre de usuario no se toca
+	if err := um.UpdateUserByID(1, UpdateUserRequest{Email: "new@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateUserByIDUsername(t *testing.T) {
+	db, mock := newMockDB(t)
+	um := UserModel{DB: db}
+
+	mock.ExpectExec(`^`+q("UPDATE users SET username = ? WHERE id = ?")+`$`).WithArgs("ana", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := um.UpdateUserByID(1, UpdateUserRequest{Userame: "ana", Email: "new@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
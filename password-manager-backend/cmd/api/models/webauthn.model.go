@@ -0,0 +1,317 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"password-manager-backend/cmd/api/services"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+type WebAuthnModel struct {
+	DB *sql.DB
+}
+
+// Ceremonias de WebAuthn, se guardan con su reto para no mezclar uno de registro con uno de login
+const (
+	CeremonyRegistration = "registration"
+	CeremonyLogin        = "login"
+)
+
+var (
+	// ErrWebAuthnSessionInvalid cubre retos desconocidos, caducados, ya usados o de otra ceremonia
+	ErrWebAuthnSessionInvalid = errors.New("invalid or expired passkey session")
+	// ErrPasskeyExists indica que el autenticador ya estaba registrado
+	ErrPasskeyExists = errors.New("passkey already registered")
+)
+
+// Passkey es una credencial registrada, tal como se enseña al usuario
+type Passkey struct {
+	Id           int        `json:"id"`
+	Name         string     `json:"name"`
+	BackedUp     bool       `json:"backed_up"`
+	CloneWarning bool       `json:"clone_warning"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+}
+
+// PasskeyRegisterFinishRequest lleva la respuesta de navigator.credentials.create
+// y el nombre con el que el usuario reconocerá la passkey
+type PasskeyRegisterFinishRequest struct {
+	SessionID  string          `json:"session_id" binding:"required,len=32"`
+	Name       string          `json:"name" binding:"required,max=64"`
+	Credential json.RawMessage `json:"credential" binding:"required" swaggertype:"object"`
+}
+
+// PasskeyFinishRequest lleva la respuesta de navigator.credentials.get tal cual la
+// devuelve el navegador
+type PasskeyFinishRequest struct {
+	SessionID   string          `json:"session_id" binding:"required,len=32"`
+	Credential  json.RawMessage `json:"credential" binding:"required" swaggertype:"object"`
+	SessionMode string          `json:"session_mode" binding:"omitempty,oneof=token cookie"`
+}
+
+// PasskeyOptionsResponse son las opciones para el navegador junto al id del reto
+type PasskeyOptionsResponse struct {
+	SessionID string `json:"session_id"`
+	Options   any    `json:"options"`
+}
+
+// PasskeyUser carga al usuario con sus passkeys. Si todavía no tiene user handle se
+// le asigna uno.
+func (m *WebAuthnModel) PasskeyUser(user *User) (*services.PasskeyUser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var handle []byte
+	err := m.DB.QueryRowContext(ctx, "SELECT webauthn_handle FROM users WHERE id = ?", user.Id).Scan(&handle)
+	if err != nil {
+		return nil, err
+	}
+	if handle == nil {
+		if handle, err = services.NewPasskeyUserHandle(); err != nil {
+			return nil, err
+		}
+		// Si otra petición lo asignó antes, nos quedamos con el suyo
+		_, err = m.DB.ExecContext(ctx, "UPDATE users SET webauthn_handle = ? WHERE id = ? AND webauthn_handle IS NULL", handle, user.Id)
+		if err != nil {
+			return nil, err
+		}
+		if err := m.DB.QueryRowContext(ctx, "SELECT webauthn_handle FROM users WHERE id = ?", user.Id).Scan(&handle); err != nil {
+			return nil, err
+		}
+	}
+	return m.loadPasskeyUser(ctx, user, handle)
+}
+
+// GetByHandle busca al dueño de una passkey por el user handle que devuelve el autenticador
+func (m *WebAuthnModel) GetByHandle(handle []byte) (*User, *services.PasskeyUser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	err := m.DB.QueryRowContext(ctx, "SELECT id FROM users WHERE webauthn_handle = ?", handle).Scan(&id)
+	if err != nil {
+		return nil, nil, err
+	}
+	userModel := UserModel{DB: m.DB}
+	user, err := userModel.GetByID(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	passkeyUser, err := m.loadPasskeyUser(ctx, user, handle)
+	if err != nil {
+		return nil, nil, err
+	}
+	return user, passkeyUser, nil
+}
+
+func (m *WebAuthnModel) loadPasskeyUser(ctx context.Context, user *User, handle []byte) (*services.PasskeyUser, error) {
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT credential_id, public_key, attestation_type, aaguid, transports, flags, sign_count, clone_warning
+		FROM webauthn_credentials WHERE user_id = ?`,
+		user.Id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeyUser := &services.PasskeyUser{
+		Id:          user.Id,
+		Handle:      handle,
+		Name:        user.Email,
+		DisplayName: user.Username,
+	}
+	for rows.Next() {
+		var cred webauthn.Credential
+		var transports string
+		var flags uint8
+		err := rows.Scan(&cred.ID, &cred.PublicKey, &cred.AttestationType, &cred.Authenticator.AAGUID,
+			&transports, &flags, &cred.Authenticator.SignCount, &cred.Authenticator.CloneWarning)
+		if err != nil {
+			return nil, err
+		}
+		cred.Flags = webauthn.NewCredentialFlags(protocol.AuthenticatorFlags(flags))
+		for _, transport := range strings.Split(transports, ",") {
+			if transport != "" {
+				cred.Transport = append(cred.Transport, protocol.AuthenticatorTransport(transport))
+			}
+		}
+		passkeyUser.Credentials = append(passkeyUser.Credentials, cred)
+	}
+	return passkeyUser, rows.Err()
+}
+
+// SaveCredential guarda una passkey recién registrada
+func (m *WebAuthnModel) SaveCredential(userID int, name string, cred *webauthn.Credential) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	transports := make([]string, len(cred.Transport))
+	for i, transport := range cred.Transport {
+		transports[i] = string(transport)
+	}
+	_, err := m.DB.ExecContext(ctx,
+		`INSERT INTO webauthn_credentials
+		(user_id, name, credential_id, public_key, attestation_type, aaguid, transports, flags, sign_count)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, name, cred.ID, cred.PublicKey, cred.AttestationType, cred.Authenticator.AAGUID,
+		strings.Join(transports, ","), uint8(cred.Flags.ProtocolValue()), cred.Authenticator.SignCount,
+	)
+	if isDuplicateKey(err) {
+		return ErrPasskeyExists
+	}
+	return err
+}
+
+// UpdateAfterLogin guarda el contador y el estado de copia de seguridad tras un login.
+// Un contador que no avanzó deja la passkey marcada y no vuelve a servir.
+func (m *WebAuthnModel) UpdateAfterLogin(cred *webauthn.Credential) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE webauthn_credentials SET sign_count = ?, flags = ?, clone_warning = ?, last_used_at = CURRENT_TIMESTAMP
+		WHERE credential_id = ?`
+	args := []any{cred.Authenticator.SignCount, uint8(cred.Flags.ProtocolValue()), cred.Authenticator.CloneWarning, cred.ID}
+	if cred.Authenticator.CloneWarning {
+		// El contador no se toca: el que vale es el último legítimo
+		query = "UPDATE webauthn_credentials SET clone_warning = TRUE WHERE credential_id = ?"
+		args = []any{cred.ID}
+	}
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// GetByUserID lista las passkeys de un usuario
+func (m *WebAuthnModel) GetByUserID(userID int) ([]Passkey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx,
+		`SELECT id, name, flags, clone_warning, created_at, last_used_at
+		FROM webauthn_credentials WHERE user_id = ? ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []Passkey{}
+	for rows.Next() {
+		var p Passkey
+		var flags uint8
+		var createdAt, lastUsedAt []byte
+		if err := rows.Scan(&p.Id, &p.Name, &flags, &p.CloneWarning, &createdAt, &lastUsedAt); err != nil {
+			return nil, err
+		}
+		p.BackedUp = protocol.AuthenticatorFlags(flags).HasBackupState()
+		p.CreatedAt, _ = parseTime(createdAt)
+		if lastUsedAt != nil {
+			t, _ := parseTime(lastUsedAt)
+			p.LastUsedAt = &t
+		}
+		passkeys = append(passkeys, p)
+	}
+	return passkeys, rows.Err()
+}
+
+// DeleteCredential borra una passkey del usuario
+func (m *WebAuthnModel) DeleteCredential(userID, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SaveSession guarda el reto de una ceremonia y devuelve su id. userID es 0 en el
+// login, que empieza sin saber quién es el usuario.
+func (m *WebAuthnModel) SaveSession(userID int, ceremony string, session *webauthn.SessionData) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	id, err := services.NewTokenFamily()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	owner := sql.NullInt64{Int64: int64(userID), Valid: userID != 0}
+	_, err = m.DB.ExecContext(ctx,
+		`INSERT INTO webauthn_sessions (id, user_id, ceremony, data, expires_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP + INTERVAL ? SECOND)`,
+		id, owner, ceremony, data, int(services.PasskeyCeremonyTTL().Seconds()),
+	)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// ConsumeSession recupera y borra el reto de una ceremonia: cada reto sirve una sola vez
+func (m *WebAuthnModel) ConsumeSession(id string, userID int, ceremony string) (*webauthn.SessionData, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var data []byte
+	var owner sql.NullInt64
+	var expired bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT data, user_id, expires_at <= CURRENT_TIMESTAMP FROM webauthn_sessions WHERE id = ? AND ceremony = ? FOR UPDATE",
+		id, ceremony,
+	).Scan(&data, &owner, &expired)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrWebAuthnSessionInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM webauthn_sessions WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if expired || int(owner.Int64) != userID {
+		return nil, ErrWebAuthnSessionInvalid
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// DeleteExpiredSessions borra los retos que nadie llegó a usar
+func (m *WebAuthnModel) DeleteExpiredSessions() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM webauthn_sessions WHERE expires_at < CURRENT_TIMESTAMP")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
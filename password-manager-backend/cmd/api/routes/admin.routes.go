@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"database/sql"
+	"password-manager-backend/cmd/api/controllers"
+	"password-manager-backend/cmd/api/middlewares"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AdminRoutes(rg *gin.RouterGroup, db *sql.DB) {
+	adminController := controllers.AdminController{DB: db}
+	userModel := models.UserModel{DB: db}
+	// Solo con sesión y segundo factor: la consola no admite PAT
+	admin := rg.Group("/admin", middlewares.IsLogged(&userModel), middlewares.RequireMFA())
+	{
+		admin.GET("/users", middlewares.RequirePermission(services.PermUsersView), adminController.ListUsers)
+		admin.POST("/users/:id/disable", middlewares.RequirePermission(services.PermUsersDisable), adminController.DisableUser)
+		admin.POST("/users/:id/enable", middlewares.RequirePermission(services.PermUsersDisable), adminController.EnableUser)
+		admin.POST("/users/:id/logout", middlewares.RequirePermission(services.PermSessionsRevoke), adminController.ForceLogout)
+		admin.POST("/users/:id/require-password-change", middlewares.RequirePermission(services.PermUsersResetCredentials), adminController.RequirePasswordChange)
+		admin.POST("/users/:id/reset-2fa", middlewares.RequirePermission(services.PermUsersResetCredentials), adminController.ResetMFA)
+		admin.PUT("/users/:id/admin", middlewares.RequirePermission(services.PermRolesAssign), adminController.PromoteAdmin)
+		admin.DELETE("/users/:id/admin", middlewares.RequirePermission(services.PermRolesAssign), adminController.DemoteAdmin)
+		admin.GET("/audit", middlewares.RequirePermission(services.PermAuditView), adminController.GetAuditLog)
+	}
+}
@@ -0,0 +1,29 @@
+package routes
+
+import (
+	"database/sql"
+	"password-manager-backend/cmd/api/controllers"
+	"password-manager-backend/cmd/api/middlewares"
+	"password-manager-backend/cmd/api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func EmergencyRoutes(rg *gin.RouterGroup, db *sql.DB) {
+	emergency := rg.Group("/emergency")
+	emergencyController := controllers.EmergencyController{DB: db}
+	userModel := models.UserModel{DB: db}
+
+	emergency.Use(middlewares.IsLogged(&userModel), middlewares.RequireMFA())
+	emergency.POST("/", emergencyController.InviteEmergencyContact)
+	emergency.GET("/contacts", emergencyController.GetEmergencyContacts)
+	emergency.GET("/grantors", emergencyController.GetEmergencyGrantors)
+	emergency.POST("/:id/accept", emergencyController.AcceptEmergencyInvite)
+	emergency.POST("/:id/request", emergencyController.RequestEmergencyAccess)
+	emergency.POST("/:id/approve", emergencyController.ApproveEmergencyAccess)
+	emergency.POST("/:id/reject", emergencyController.RejectEmergencyAccess)
+	emergency.DELETE("/:id", emergencyController.DeleteEmergencyAccess)
+	emergency.GET("/:id/notes", emergencyController.GetEmergencyNotes)
+	emergency.GET("/:id/notes/:noteId/secret", emergencyController.GetEmergencyNoteSecret)
+	emergency.POST("/:id/takeover", emergencyController.EmergencyTakeover)
+}
@@ -5,6 +5,7 @@ import (
 	"password-manager-backend/cmd/api/controllers"
 	"password-manager-backend/cmd/api/middlewares"
 	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -13,13 +14,20 @@ func NotesRoutes(rg *gin.RouterGroup, db *sql.DB) {
 	notes := rg.Group("/notes")
 	notesController := controllers.NotesController{DB: db}
 	userModel := models.UserModel{DB: db}
+	// Con un token de acceso personal cada ruta pide su scope; además los roles del
+	// usuario tienen que dar el permiso
+	canRead := middlewares.IsLogged(&userModel, services.ScopeNotesRead)
+	canWrite := middlewares.IsLogged(&userModel, services.ScopeNotesWrite)
+	readNotes := middlewares.RequirePermission(services.PermNotesRead)
+	writeNotes := middlewares.RequirePermission(services.PermNotesWrite)
 
-	notes.GET("/my", middlewares.IsLogged(&userModel), notesController.GetMyNotes)
-	notes.GET("/:id", middlewares.IsLogged(&userModel), notesController.GetNoteByID)
-	notes.GET("/sorted-password", middlewares.IsLogged(&userModel), notesController.GetSortedNotesFixed)
-	notes.GET("/search", middlewares.IsLogged(&userModel), notesController.SearchNotes)
-	notes.POST("/", middlewares.IsLogged(&userModel), notesController.CreateNote)
-	notes.POST("/verify-password", middlewares.IsLogged(&userModel), notesController.VerifyNotePassword)
-	notes.PUT("/:id", middlewares.IsLogged(&userModel), notesController.UpdateNote)
-	notes.DELETE("/:id", middlewares.IsLogged(&userModel), notesController.DeleteNote)
+	notes.GET("/my", canRead, middlewares.RequireMFA(), readNotes, notesController.GetMyNotes)
+	notes.GET("/:id", canRead, middlewares.RequireMFA(), readNotes, notesController.GetNoteByID)
+	notes.GET("/:id/secret", canRead, middlewares.RequireMFA(), readNotes, notesController.GetNoteSecret)
+	notes.GET("/sorted-password", canRead, middlewares.RequireMFA(), readNotes, notesController.GetSortedNotesFixed)
+	notes.GET("/search", canRead, middlewares.RequireMFA(), readNotes, notesController.SearchNotes)
+	notes.POST("/", canWrite, middlewares.RequireMFA(), writeNotes, notesController.CreateNote)
+	notes.POST("/verify-password", canRead, middlewares.RequireMFA(), readNotes, notesController.VerifyNotePassword)
+	notes.PUT("/:id", canWrite, middlewares.RequireMFA(), writeNotes, notesController.UpdateNote)
+	notes.DELETE("/:id", canWrite, middlewares.RequireMFA(), writeNotes, notesController.DeleteNote)
 }
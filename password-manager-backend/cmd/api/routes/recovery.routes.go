@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"database/sql"
+	"password-manager-backend/cmd/api/controllers"
+	"password-manager-backend/cmd/api/middlewares"
+	"password-manager-backend/cmd/api/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RecoveryRoutes(rg *gin.RouterGroup, db *sql.DB) {
+	recovery := rg.Group("/recovery")
+	recoveryController := controllers.RecoveryController{DB: db}
+	userModel := models.UserModel{DB: db}
+
+	recovery.POST("/kit", middlewares.IsLogged(&userModel), middlewares.RequireMFA(), recoveryController.CreateRecoveryKit)
+	recovery.GET("/kit", middlewares.IsLogged(&userModel), middlewares.RequireMFA(), recoveryController.GetRecoveryKit)
+	recovery.POST("/recover", recoveryController.RecoverAccount)
+}
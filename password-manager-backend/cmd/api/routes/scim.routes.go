@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"database/sql"
+	"password-manager-backend/cmd/api/controllers"
+	"password-manager-backend/cmd/api/middlewares"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SCIMRoutes va fuera de /api/v1: los IdP esperan /scim/v2 en la raíz
+func SCIMRoutes(rg *gin.RouterGroup, db *sql.DB) {
+	scimController := controllers.SCIMController{DB: db}
+	// Solo el IdP, con el token de SCIM_TOKEN
+	scim := rg.Group("/scim/v2", middlewares.RequireSCIMToken())
+	{
+		scim.GET("/ServiceProviderConfig", scimController.ServiceProviderConfig)
+		scim.GET("/Users", scimController.ListUsers)
+		scim.POST("/Users", scimController.CreateUser)
+		scim.GET("/Users/:id", scimController.GetUser)
+		scim.PUT("/Users/:id", scimController.ReplaceUser)
+		scim.PATCH("/Users/:id", scimController.PatchUser)
+		scim.DELETE("/Users/:id", scimController.DeleteUser)
+		scim.GET("/Groups", scimController.ListGroups)
+		scim.POST("/Groups", scimController.CreateGroup)
+		scim.GET("/Groups/:id", scimController.GetGroup)
+		scim.PUT("/Groups/:id", scimController.ReplaceGroup)
+		scim.PATCH("/Groups/:id", scimController.PatchGroup)
+		scim.DELETE("/Groups/:id", scimController.DeleteGroup)
+	}
+}
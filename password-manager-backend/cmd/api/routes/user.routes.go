@@ -5,6 +5,7 @@ import (
 	"password-manager-backend/cmd/api/controllers"
 	"password-manager-backend/cmd/api/middlewares"
 	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,14 +13,78 @@ import (
 func UserRoutes(rg *gin.RouterGroup, db *sql.DB) {
 	users := rg.Group("/users")
 	userController := controllers.UserController{DB: db}
+	mfaController := controllers.MFAController{DB: db}
+	webauthnController := controllers.WebAuthnController{DB: db}
+	patController := controllers.PersonalAccessTokenController{DB: db}
+	resetController := controllers.PasswordResetController{DB: db}
+	verificationController := controllers.EmailVerificationController{DB: db}
+	sessionController := controllers.SessionController{DB: db}
+	oidcController := controllers.OIDCController{DB: db}
+	roleController := controllers.RoleController{DB: db}
 	userModel := models.UserModel{DB: db}
+	// Con un token de acceso personal cada ruta pide su permiso
+	canRead := middlewares.IsLogged(&userModel, services.ScopeUsersRead)
+	canWrite := middlewares.IsLogged(&userModel, services.ScopeUsersWrite)
 	{
-		users.GET("/", middlewares.IsLogged(&userModel), userController.GetAllUsers)
+		users.GET("/", canRead, middlewares.RequireMFA(), middlewares.RequirePermission(services.PermUsersView), userController.GetAllUsers)
 		users.POST("/auth/register", middlewares.ValidateRegisterRequest(), userController.RegisterUser)
+		users.POST("/auth/prelogin", userController.Prelogin)
 		users.POST("/auth/login", userController.LoginUser)
-		users.GET("/:id", middlewares.IsLogged(&userModel), middlewares.CanSeePassword(&userModel), userController.GetUserByID)
-		users.GET("/me", middlewares.IsLogged(&userModel), userController.GetMe)
-		users.PUT("/:id", middlewares.IsLogged(&userModel), middlewares.CanSeePassword(&userModel), userController.UpdateUser)
-		users.DELETE("/:id", middlewares.IsLogged(&userModel), middlewares.CanSeePassword(&userModel), userController.DeleteUser)
+		users.POST("/auth/mfa", mfaController.VerifyMFA)
+		users.POST("/auth/passkey/begin", webauthnController.BeginPasskeyLogin)
+		users.POST("/auth/passkey/finish", webauthnController.FinishPasskeyLogin)
+		users.GET("/auth/methods", oidcController.GetAuthMethods)
+		users.POST("/auth/oidc/begin", oidcController.BeginOIDCLogin)
+		users.POST("/auth/oidc/callback", oidcController.FinishOIDCLogin)
+		// Enlazar el SSO es añadir una forma de entrar: sesión con segundo factor
+		users.POST("/auth/oidc/link", middlewares.IsLogged(&userModel), middlewares.RequireMFA(), oidcController.BeginOIDCLink)
+		users.POST("/auth/forgot-password", resetController.ForgotPassword)
+		users.POST("/auth/reset-password", resetController.ResetPassword)
+		users.POST("/auth/change-password", resetController.ChangeRequiredPassword)
+		users.POST("/auth/verify-email", verificationController.VerifyEmail)
+		users.POST("/auth/resend-verification", verificationController.ResendVerification)
+		users.POST("/auth/refresh", userController.RefreshToken)
+		users.POST("/auth/logout", middlewares.IsLogged(&userModel), userController.Logout)
+		users.GET("/:id", canRead, middlewares.RequireMFA(), middlewares.RequireSelfOrPermission(services.PermUsersView), userController.GetUserByID)
+		users.GET("/me", canRead, userController.GetMe)
+		users.GET("/locked", middlewares.IsLogged(&userModel), middlewares.RequireMFA(), middlewares.RequirePermission(services.PermLockoutsView), userController.GetLockedAccounts)
+		users.GET("/roles", middlewares.IsLogged(&userModel), middlewares.RequireMFA(), middlewares.RequirePermission(services.PermRolesView), roleController.GetRoles)
+		users.PUT("/:id", canWrite, middlewares.RequireMFA(), middlewares.RequireSelfOrPermission(services.PermUsersUpdate), userController.UpdateUser)
+		users.PUT("/:id/roles", middlewares.IsLogged(&userModel), middlewares.RequireMFA(), middlewares.RequirePermission(services.PermRolesAssign), roleController.SetUserRoles)
+		users.DELETE("/:id", canWrite, middlewares.RequireMFA(), middlewares.RequireSelfOrPermission(services.PermUsersDelete), userController.DeleteUser)
+	}
+
+	// Alta y gestión del 2FA: accesibles sin segundo factor para poder darlo de alta
+	twoFactor := users.Group("/2fa", middlewares.IsLogged(&userModel))
+	{
+		twoFactor.POST("/enroll", mfaController.EnrollTOTP)
+		twoFactor.POST("/confirm", mfaController.ConfirmTOTP)
+		twoFactor.POST("/recovery-codes", mfaController.RegenerateRecoveryCodes)
+		twoFactor.DELETE("", mfaController.DisableTOTP)
+	}
+
+	// Añadir una passkey es añadir una forma de entrar: hace falta sesión con segundo factor
+	passkeys := users.Group("/passkeys", middlewares.IsLogged(&userModel), middlewares.RequireMFA())
+	{
+		passkeys.GET("", webauthnController.GetPasskeys)
+		passkeys.POST("/register/begin", webauthnController.BeginPasskeyRegistration)
+		passkeys.POST("/register/finish", webauthnController.FinishPasskeyRegistration)
+		passkeys.DELETE("/:id", webauthnController.DeletePasskey)
+	}
+
+	// Sesiones abiertas por dispositivo. Solo con sesión: un PAT no es una sesión
+	sessions := users.Group("/me/sessions", middlewares.IsLogged(&userModel), middlewares.RequireMFA())
+	{
+		sessions.GET("", sessionController.GetSessions)
+		sessions.DELETE("", sessionController.RevokeOtherSessions)
+		sessions.DELETE("/:id", sessionController.RevokeSession)
+	}
+
+	// Los tokens de acceso personal se gestionan solo desde una sesión, nunca con otro PAT
+	tokens := users.Group("/tokens", middlewares.IsLogged(&userModel), middlewares.RequireMFA())
+	{
+		tokens.GET("", patController.GetPersonalAccessTokens)
+		tokens.POST("", patController.CreatePersonalAccessToken)
+		tokens.DELETE("/:id", patController.RevokePersonalAccessToken)
 	}
 }
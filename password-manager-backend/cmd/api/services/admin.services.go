@@ -0,0 +1,56 @@
+package services
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Tamaño de página de los listados de la consola de administración
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+var ErrInvalidPage = errors.New("page and page_size must be positive integers")
+
+// Page es una página de un listado: Number empieza en 1
+type Page struct {
+	Number int `json:"page"`
+	Size   int `json:"page_size"`
+}
+
+// Offset es el OFFSET de SQL de la página
+func (p Page) Offset() int {
+	return (p.Number - 1) * p.Size
+}
+
+// ParsePage lee los parámetros page y page_size de la query. Vacíos valen la
+// primera página de DefaultPageSize; un tamaño mayor que MaxPageSize se recorta.
+func ParsePage(page, pageSize string) (Page, error) {
+	p := Page{Number: 1, Size: DefaultPageSize}
+	if page != "" {
+		n, err := strconv.Atoi(page)
+		if err != nil || n < 1 {
+			return Page{}, ErrInvalidPage
+		}
+		p.Number = n
+	}
+	if pageSize != "" {
+		n, err := strconv.Atoi(pageSize)
+		if err != nil || n < 1 {
+			return Page{}, ErrInvalidPage
+		}
+		p.Size = min(n, MaxPageSize)
+	}
+	return p, nil
+}
+
+// LikeContains devuelve el patrón LIKE que busca text en cualquier parte, con los
+// comodines de text escapados: buscar "a_b" no encuentra "axb"
+func LikeContains(text string) string {
+	return "%" + likeEscaper.Replace(text) + "%"
+}
+
+// likeEscaper escapa los comodines de LIKE
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
@@ -0,0 +1,48 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParsePage(t *testing.T) {
+	cases := []struct {
+		page, size string
+		want       Page
+	}{
+		{"", "", Page{Number: 1, Size: DefaultPageSize}},
+		{"3", "10", Page{Number: 3, Size: 10}},
+		{"1", "5000", Page{Number: 1, Size: MaxPageSize}},
+	}
+	for _, c := range cases {
+		got, err := ParsePage(c.page, c.size)
+		if err != nil {
+			t.Fatalf("ParsePage(%q, %q): %v", c.page, c.size, err)
+		}
+		if got != c.want {
+			t.Errorf("ParsePage(%q, %q) = %+v, want %+v", c.page, c.size, got, c.want)
+		}
+	}
+	if got := (Page{Number: 3, Size: 10}).Offset(); got != 20 {
+		t.Errorf("expected offset 20, got %d", got)
+	}
+	for _, bad := range [][2]string{{"0", ""}, {"-1", ""}, {"x", ""}, {"", "0"}, {"", "ten"}} {
+		if _, err := ParsePage(bad[0], bad[1]); !errors.Is(err, ErrInvalidPage) {
+			t.Errorf("ParsePage(%q, %q): expected ErrInvalidPage, got %v", bad[0], bad[1], err)
+		}
+	}
+}
+
+func TestLikeContains(t *testing.T) {
+	cases := map[string]string{
+		"alice":      "%alice%",
+		"a_b":        `%a\_b%`,
+		"100%":       `%100\%%`,
+		`back\slash`: `%back\\slash%`,
+	}
+	for text, want := range cases {
+		if got := LikeContains(text); got != want {
+			t.Errorf("LikeContains(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"net/url"
+	"strings"
+)
+
+// Índices ciegos: en lugar del username o la url en claro guardamos HMACs de sus
+// valores normalizados y de sus prefijos. Buscar es calcular los mismos HMACs de
+// la consulta y compararlos, así la base de datos nunca ve el texto.
+//
+// La clave sale de la clave de datos de cada usuario: el mismo email en dos
+// cuentas da términos distintos y no se pueden cruzar entre usuarios.
+
+// Campos por los que se puede buscar
+const (
+	BlindFieldUsername = "username"
+	BlindFieldEmail    = "email"
+	BlindFieldDomain   = "domain"
+)
+
+const (
+	blindTermSize  = 16 // bytes del HMAC que guardamos, suficiente para no tener colisiones
+	minBlindPrefix = 3  // prefijos más cortos apenas acotan la búsqueda y revelan más patrones
+	maxBlindPrefix = 32
+)
+
+// BlindTerm es una entrada del índice: el campo y el HMAC truncado
+type BlindTerm struct {
+	Field string
+	Term  []byte
+}
+
+// BlindIndexKey deriva la clave de los índices a partir de la clave de datos del usuario
+func BlindIndexKey(dataKey *Secret) *Secret {
+	mac := hmac.New(sha256.New, dataKey.Bytes())
+	mac.Write([]byte("notes.blind-index.v1"))
+	return NewSecret(mac.Sum(nil))
+}
+
+// ValidBlindField indica si el campo es uno de los indexados
+func ValidBlindField(field string) bool {
+	return field == BlindFieldUsername || field == BlindFieldEmail || field == BlindFieldDomain
+}
+
+type blindTermSet struct {
+	key   *Secret
+	terms []BlindTerm
+	seen  map[string]bool
+}
+
+func (s *blindTermSet) add(field, kind, value string) {
+	mac := hmac.New(sha256.New, s.key.Bytes())
+	mac.Write([]byte(field + "|" + kind + "|" + value))
+	term := mac.Sum(nil)[:blindTermSize]
+	id := field + string(term)
+	if s.seen[id] {
+		return
+	}
+	s.seen[id] = true
+	s.terms = append(s.terms, BlindTerm{Field: field, Term: term})
+}
+
+// addValue añade el valor exacto y sus prefijos
+func (s *blindTermSet) addValue(field, value string) {
+	s.add(field, "exact", value)
+	runes := []rune(value)
+	for n := minBlindPrefix; n <= len(runes) && n <= maxBlindPrefix; n++ {
+		s.add(field, "prefix", string(runes[:n]))
+	}
+}
+
+// addDomain indexa el dominio y sus dominios padre (mail.google.com -> google.com)
+func (s *blindTermSet) addDomain(domain string) {
+	for strings.Contains(domain, ".") {
+		s.addValue(BlindFieldDomain, domain)
+		_, domain, _ = strings.Cut(domain, ".")
+	}
+}
+
+func normalizeBlindValue(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
+
+// hostFromURL saca el host de una url, con o sin esquema, sin el "www."
+func hostFromURL(rawURL string) string {
+	rawURL = normalizeBlindValue(rawURL)
+	if rawURL == "" {
+		return ""
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Hostname(), "www.")
+}
+
+// BlindIndexTerms calcula los términos que se guardan para el username y la url de una
+// nota. Un username con forma de email se indexa como email y además por su dominio.
+func BlindIndexTerms(key *Secret, username, rawURL string) []BlindTerm {
+	set := &blindTermSet{key: key, seen: make(map[string]bool)}
+
+	if u := normalizeBlindValue(username); u != "" {
+		local, domain, isEmail := strings.Cut(u, "@")
+		if isEmail && local != "" && domain != "" {
+			set.addValue(BlindFieldEmail, u)
+			set.addDomain(domain)
+		} else {
+			set.addValue(BlindFieldUsername, u)
+		}
+	}
+	if host := hostFromURL(rawURL); host != "" {
+		set.addDomain(host)
+	}
+	return set.terms
+}
+
+// BlindQueryTerms calcula los términos a buscar para una consulta: el valor exacto y,
+// si tiene la longitud adecuada, como prefijo. Sin field se busca en todos los campos.
+func BlindQueryTerms(key *Secret, query, field string) []BlindTerm {
+	fields := []string{BlindFieldUsername, BlindFieldEmail, BlindFieldDomain}
+	if field != "" {
+		fields = []string{field}
+	}
+
+	q := normalizeBlindValue(query)
+	if q == "" {
+		return nil
+	}
+	set := &blindTermSet{key: key, seen: make(map[string]bool)}
+	length := len([]rune(q))
+	for _, f := range fields {
+		set.add(f, "exact", q)
+		if length >= minBlindPrefix && length <= maxBlindPrefix {
+			set.add(f, "prefix", q)
+		}
+	}
+	return set.terms
+}
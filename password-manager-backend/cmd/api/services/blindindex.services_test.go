@@ -0,0 +1,56 @@
+package services
+
+import (
+	"bytes"
+	"testing"
+)
+
+// matches indica si algún término de la consulta está en el índice de la nota
+func matches(index, query []BlindTerm) bool {
+	for _, q := range query {
+		for _, t := range index {
+			if q.Field == t.Field && bytes.Equal(q.Term, t.Term) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestBlindIndexMatching(t *testing.T) {
+	key := BlindIndexKey(SecretFromString("0123456789abcdef0123456789abcdef"))
+	index := BlindIndexTerms(key, "Alice@Gmail.com", "https://www.mail.google.com/inbox")
+
+	tests := []struct {
+		query, field string
+		want         bool
+	}{
+		{"alice@gmail.com", "", true},
+		{"ali", "", true},                     // prefijo del email
+		{"gmail.com", BlindFieldDomain, true}, // dominio del email
+		{"google.com", BlindFieldDomain, true},
+		{"mail.goo", BlindFieldDomain, true},
+		{"al", "", false}, // demasiado corto para prefijo
+		{"lice", "", false},
+		{"alice@gmail.com", BlindFieldUsername, false},
+		{"outlook.com", "", false},
+	}
+	for _, tt := range tests {
+		if got := matches(index, BlindQueryTerms(key, tt.query, tt.field)); got != tt.want {
+			t.Errorf("search %q in %q = %v, want %v", tt.query, tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestBlindIndexIsPerKey(t *testing.T) {
+	aliceKey := BlindIndexKey(SecretFromString("0123456789abcdef0123456789abcdef"))
+	bobKey := BlindIndexKey(SecretFromString("fedcba9876543210fedcba9876543210"))
+
+	index := BlindIndexTerms(aliceKey, "shared_user", "")
+	if matches(index, BlindQueryTerms(bobKey, "shared_user", "")) {
+		t.Error("terms computed with another user's key should not match")
+	}
+	if !matches(index, BlindQueryTerms(aliceKey, "SHARED_USER ", BlindFieldUsername)) {
+		t.Error("expected a normalized exact match on username")
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Tipos de enlace de verificación: el email con el que se registró la cuenta o
+// un email nuevo que aún no se ha guardado
+const (
+	EmailVerificationPurposeVerify = "verify"
+	EmailVerificationPurposeChange = "change"
+)
+
+const (
+	emailVerificationTokenBytes  = 32
+	emailVerificationTokenPrefix = "evt_"
+	// Tiempo mínimo entre dos correos de verificación del mismo tipo a la misma cuenta
+	emailVerificationCooldown = time.Minute
+)
+
+// EmailVerificationTTL es la vida del enlace de verificación
+// (EMAIL_VERIFICATION_TTL, por defecto 24h)
+func EmailVerificationTTL() time.Duration {
+	return envDuration("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+}
+
+// EmailVerificationCooldown evita que se use el registro para llenar un buzón ajeno
+func EmailVerificationCooldown() time.Duration {
+	return emailVerificationCooldown
+}
+
+// RequireVerifiedEmail indica si hay que verificar el email antes de iniciar sesión.
+// Activo salvo REQUIRE_VERIFIED_EMAIL=false.
+func RequireVerifiedEmail() bool {
+	return os.Getenv("REQUIRE_VERIFIED_EMAIL") != "false"
+}
+
+// NewEmailVerificationToken genera un token de un solo uso y el hash que se guarda
+func NewEmailVerificationToken() (string, []byte, error) {
+	raw, err := RandomSecret(emailVerificationTokenBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	defer raw.Destroy()
+	token := emailVerificationTokenPrefix + base64.RawURLEncoding.EncodeToString(raw.Bytes())
+	hash := sha256.Sum256([]byte(token))
+	return token, hash[:], nil
+}
+
+// HashEmailVerificationToken calcula el hash con el que se busca un token
+func HashEmailVerificationToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// EmailVerificationLink es el enlace del correo: EMAIL_VERIFICATION_URL (la página
+// del frontend) con el token en ?token=
+func EmailVerificationLink(token string) string {
+	base := os.Getenv("EMAIL_VERIFICATION_URL")
+	if base == "" {
+		base = "http://localhost:3000/verify-email"
+	}
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+// EmailVerificationMail es el correo que confirma el email de una cuenta nueva
+func EmailVerificationMail(to, token string) Mail {
+	return Mail{
+		To:      to,
+		Subject: "Confirma tu email",
+		Body: fmt.Sprintf(`Se ha creado una cuenta con este email.
+
+Para confirmarlo entra en este enlace antes de %d horas:
+%s
+
+Si no has sido tú, ignora este correo.
+`, int(EmailVerificationTTL().Hours()), EmailVerificationLink(token)),
+	}
+}
+
+// EmailChangeMail va al email nuevo: hasta que se abra el enlace la cuenta sigue
+// con el anterior
+func EmailChangeMail(to, token string) Mail {
+	return Mail{
+		To:      to,
+		Subject: "Confirma tu nuevo email",
+		Body: fmt.Sprintf(`Alguien ha pedido usar este email en su cuenta.
+
+Para confirmar el cambio entra en este enlace antes de %d horas:
+%s
+
+Si no has sido tú, ignora este correo: no se cambia nada.
+`, int(EmailVerificationTTL().Hours()), EmailVerificationLink(token)),
+	}
+}
+
+// EmailChangeNoticeMail avisa al email actual de que se ha pedido cambiarlo
+func EmailChangeNoticeMail(to, newEmail string) Mail {
+	return Mail{
+		To:      to,
+		Subject: "Cambio de email pedido",
+		Body: fmt.Sprintf(`Se ha pedido cambiar el email de tu cuenta a %s.
+
+El cambio solo se hace cuando se confirme desde ese email. Si no has sido tú,
+cambia tu contraseña y revisa tus sesiones abiertas.
+`, newEmail),
+	}
+}
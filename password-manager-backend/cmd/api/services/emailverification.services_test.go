@@ -0,0 +1,60 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEmailVerificationToken(t *testing.T) {
+	token, hash, err := NewEmailVerificationToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, "evt_") {
+		t.Errorf("token %q misses the evt_ prefix", token)
+	}
+	if !bytes.Equal(hash, HashEmailVerificationToken(token)) {
+		t.Error("stored hash and lookup hash differ")
+	}
+	other, _, err := NewEmailVerificationToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == token {
+		t.Error("two tokens are equal")
+	}
+}
+
+func TestEmailVerificationMails(t *testing.T) {
+	t.Setenv("EMAIL_VERIFICATION_URL", "https://vault.example.com/verify-email")
+	t.Setenv("EMAIL_VERIFICATION_TTL", "48h")
+
+	mail := EmailVerificationMail("ana@example.com", "evt_a+b")
+	if mail.To != "ana@example.com" || !strings.Contains(mail.Body, "https://vault.example.com/verify-email?token=evt_a%2Bb") {
+		t.Errorf("unexpected mail %+v", mail)
+	}
+	if !strings.Contains(mail.Body, "48 horas") {
+		t.Errorf("mail does not show the TTL: %s", mail.Body)
+	}
+
+	change := EmailChangeMail("nuevo@example.com", "evt_x")
+	if change.To != "nuevo@example.com" || !strings.Contains(change.Body, "?token=evt_x") {
+		t.Errorf("unexpected mail %+v", change)
+	}
+	notice := EmailChangeNoticeMail("ana@example.com", "nuevo@example.com")
+	if notice.To != "ana@example.com" || !strings.Contains(notice.Body, "nuevo@example.com") || strings.Contains(notice.Body, "token") {
+		t.Errorf("unexpected notice %+v", notice)
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	t.Setenv("REQUIRE_VERIFIED_EMAIL", "")
+	if !RequireVerifiedEmail() {
+		t.Error("verification must be required by default")
+	}
+	t.Setenv("REQUIRE_VERIFIED_EMAIL", "false")
+	if RequireVerifiedEmail() {
+		t.Error("REQUIRE_VERIFIED_EMAIL=false must disable it")
+	}
+}
@@ -0,0 +1,48 @@
+package services
+
+// Estados del acceso de emergencia:
+//
+//	invited -> accepted -> requested -> granted -> taken_over
+//	                           |           |
+//	                           +-> rejected <-+
+//
+// Un rechazo no borra el contacto: puede volver a pedir acceso más adelante.
+// taken_over es final y solo lo pone EmergencyAccessModel.Takeover: el acceso ya
+// se usó para cambiar la contraseña del dueño y no vale para otra toma de control.
+const (
+	EmergencyInvited   = "invited"
+	EmergencyAccepted  = "accepted"
+	EmergencyRequested = "requested"
+	EmergencyGranted   = "granted"
+	EmergencyRejected  = "rejected"
+	EmergencyTakenOver = "taken_over"
+)
+
+// Tipos de acceso: view solo deja leer las notas, takeover además cambiar la contraseña
+const (
+	EmergencyAccessView     = "view"
+	EmergencyAccessTakeover = "takeover"
+)
+
+var emergencyTransitions = map[string][]string{
+	EmergencyInvited:   {EmergencyAccepted},
+	EmergencyAccepted:  {EmergencyRequested},
+	EmergencyRequested: {EmergencyGranted, EmergencyRejected},
+	EmergencyGranted:   {EmergencyRejected},
+	EmergencyRejected:  {EmergencyRequested},
+}
+
+// CanTransitionEmergency indica si se puede pasar del estado from al estado to
+func CanTransitionEmergency(from, to string) bool {
+	for _, next := range emergencyTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultEmergencyWaitDays es la espera por defecto (EMERGENCY_WAIT_DAYS, 7 si no está)
+func DefaultEmergencyWaitDays() int {
+	return envInt("EMERGENCY_WAIT_DAYS", 7)
+}
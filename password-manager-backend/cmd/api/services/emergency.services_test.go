@@ -0,0 +1,27 @@
+package services
+
+import "testing"
+
+func TestCanTransitionEmergency(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{EmergencyInvited, EmergencyAccepted, true},
+		{EmergencyAccepted, EmergencyRequested, true},
+		{EmergencyRequested, EmergencyGranted, true},
+		{EmergencyRequested, EmergencyRejected, true},
+		{EmergencyGranted, EmergencyRejected, true},
+		{EmergencyRejected, EmergencyRequested, true},
+		// No se puede pedir acceso sin aceptar la invitación ni saltarse la espera
+		{EmergencyInvited, EmergencyRequested, false},
+		{EmergencyAccepted, EmergencyGranted, false},
+		{EmergencyGranted, EmergencyRequested, false},
+		{"unknown", EmergencyAccepted, false},
+	}
+	for _, tt := range tests {
+		if got := CanTransitionEmergency(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransitionEmergency(%q, %q) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// DataKeySize es el tamaño de las claves de datos de cada usuario (AES-256)
+const DataKeySize = 32
+
+var ErrInvalidKey = errors.New("ENCRYPTION_KEY must be a base64 encoded 32 byte key")
+
+// EncryptedSecret agrupa todo lo necesario para descifrar un secreto guardado
+type EncryptedSecret struct {
+	Ciphertext []byte
+	Nonce      []byte
+	KeyVersion int
+}
+
+// CurrentKeyVersion es la versión de la clave maestra con la que se cifra lo nuevo.
+// Se configura con ENCRYPTION_KEY_VERSION y por defecto es la 1.
+func CurrentKeyVersion() int {
+	version, err := strconv.Atoi(os.Getenv("ENCRYPTION_KEY_VERSION"))
+	if err != nil || version < 1 {
+		return 1
+	}
+	return version
+}
+
+// masterKey devuelve la clave maestra AES-256 asociada a una versión, resuelta por
+// el KeyProvider (ENCRYPTION_KEY_V1, ENCRYPTION_KEY_V2...). Durante una rotación
+// conviven varias, y cada fila dice con cuál se cifró. El llamador tiene que
+// destruirla al terminar.
+func masterKey(version int) (*Secret, error) {
+	if version < 1 {
+		return nil, fmt.Errorf("unknown encryption key version %d", version)
+	}
+	raw, err := GetKey(MasterKeyName(version))
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	key, err := secretFromBase64(raw)
+	if err != nil || key.Len() != 32 {
+		key.Destroy()
+		return nil, ErrInvalidKey
+	}
+	return key, nil
+}
+
+// HasMasterKey indica si una versión de clave maestra está configurada y es válida
+func HasMasterKey(version int) bool {
+	key, err := masterKey(version)
+	key.Destroy()
+	return err == nil
+}
+
+// EncryptSecret cifra un texto con AES-256-GCM y la clave maestra. El aad no se
+// cifra pero queda autenticado, así un secreto no se puede mover a otra fila sin que falle.
+func EncryptSecret(plaintext *Secret, aad []byte) (*EncryptedSecret, error) {
+	version := CurrentKeyVersion()
+	key, err := masterKey(version)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+	ciphertext, nonce, err := SealAESGCM(key.Bytes(), plaintext.Bytes(), aad)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedSecret{Ciphertext: ciphertext, Nonce: nonce, KeyVersion: version}, nil
+}
+
+// DecryptSecret descifra un secreto usando la versión de clave con la que se guardó
+func DecryptSecret(secret *EncryptedSecret, aad []byte) (*Secret, error) {
+	key, err := masterKey(secret.KeyVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+	plaintext, err := OpenAESGCM(key.Bytes(), secret.Ciphertext, secret.Nonce, aad)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecret(plaintext), nil
+}
+
+// GenerateDataKey crea una clave de datos aleatoria para un usuario
+func GenerateDataKey() (*Secret, error) {
+	return RandomSecret(DataKeySize)
+}
+
+// WrapDataKey cifra la clave de datos de un usuario con la clave maestra actual
+func WrapDataKey(dataKey *Secret, aad []byte) (*EncryptedSecret, error) {
+	version := CurrentKeyVersion()
+	key, err := masterKey(version)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+	ciphertext, nonce, err := SealAESGCM(key.Bytes(), dataKey.Bytes(), aad)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedSecret{Ciphertext: ciphertext, Nonce: nonce, KeyVersion: version}, nil
+}
+
+// UnwrapDataKey descifra una clave de datos con la versión de clave maestra que la envolvió
+func UnwrapDataKey(wrapped *EncryptedSecret, aad []byte) (*Secret, error) {
+	key, err := masterKey(wrapped.KeyVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+	plaintext, err := OpenAESGCM(key.Bytes(), wrapped.Ciphertext, wrapped.Nonce, aad)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecret(plaintext), nil
+}
+
+// RewrapDataKey vuelve a envolver una clave de datos con la clave maestra actual
+func RewrapDataKey(wrapped *EncryptedSecret, aad []byte) (*EncryptedSecret, error) {
+	dataKey, err := UnwrapDataKey(wrapped, aad)
+	if err != nil {
+		return nil, err
+	}
+	defer dataKey.Destroy()
+	return WrapDataKey(dataKey, aad)
+}
+
+// GenerateMasterKey crea una clave maestra nueva codificada en base64
+func GenerateMasterKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// SealAESGCM cifra con una clave de 32 bytes y un nonce aleatorio
+func SealAESGCM(key, plaintext, aad []byte) (ciphertext []byte, nonce []byte, err error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return gcm.Seal(nil, nonce, plaintext, aad), nonce, nil
+}
+
+// OpenAESGCM descifra y comprueba la etiqueta de autenticación
+func OpenAESGCM(key, ciphertext, nonce, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce size")
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
+	if err != nil {
+		return nil, errors.New("could not decrypt secret")
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("encryption key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
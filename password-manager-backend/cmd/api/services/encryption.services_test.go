@@ -0,0 +1,122 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+func setTestKey(t *testing.T) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(key))
+}
+
+func TestEncryptDecryptSecret(t *testing.T) {
+	setTestKey(t)
+
+	secret, err := EncryptSecret(SecretFromString("s3cr3t-p4ss"), []byte("user=1"))
+	if err != nil {
+		t.Fatalf("EncryptSecret returned error: %v", err)
+	}
+	if secret.KeyVersion != 1 {
+		t.Errorf("expected key version 1, got %d", secret.KeyVersion)
+	}
+
+	plaintext, err := DecryptSecret(secret, []byte("user=1"))
+	if err != nil {
+		t.Fatalf("DecryptSecret returned error: %v", err)
+	}
+	if string(plaintext.Bytes()) != "s3cr3t-p4ss" {
+		t.Errorf("expected original plaintext, got %q", plaintext.Bytes())
+	}
+}
+
+func TestDecryptSecretRejectsOtherAAD(t *testing.T) {
+	setTestKey(t)
+
+	secret, err := EncryptSecret(SecretFromString("s3cr3t-p4ss"), []byte("user=1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecryptSecret(secret, []byte("user=2")); err == nil {
+		t.Fatal("expected error decrypting with a different aad")
+	}
+}
+
+func TestEncryptSecretWithoutKey(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY", "")
+
+	if _, err := EncryptSecret(SecretFromString("s3cr3t-p4ss"), nil); err != ErrInvalidKey {
+		t.Fatalf("expected ErrInvalidKey, got %v", err)
+	}
+}
+
+func TestWrapUnwrapDataKey(t *testing.T) {
+	setTestKey(t)
+
+	dataKey, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := WrapDataKey(dataKey, []byte("user=1"))
+	if err != nil {
+		t.Fatalf("WrapDataKey returned error: %v", err)
+	}
+
+	unwrapped, err := UnwrapDataKey(wrapped, []byte("user=1"))
+	if err != nil {
+		t.Fatalf("UnwrapDataKey returned error: %v", err)
+	}
+	if !unwrapped.Equal(dataKey) {
+		t.Error("unwrapped key does not match the generated key")
+	}
+
+	// Otra clave maestra no debe poder abrirla
+	setTestKey(t)
+	if _, err := UnwrapDataKey(wrapped, []byte("user=1")); err == nil {
+		t.Error("expected error unwrapping with another master key")
+	}
+}
+
+func TestRewrapDataKeyWithNewVersion(t *testing.T) {
+	setTestKey(t)
+	t.Setenv("ENCRYPTION_KEY_VERSION", "1")
+
+	dataKey, err := GenerateDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := WrapDataKey(dataKey, []byte("user=1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newKey, err := GenerateMasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ENCRYPTION_KEY_V2", newKey)
+	t.Setenv("ENCRYPTION_KEY_VERSION", "2")
+
+	rewrapped, err := RewrapDataKey(wrapped, []byte("user=1"))
+	if err != nil {
+		t.Fatalf("RewrapDataKey returned error: %v", err)
+	}
+	if rewrapped.KeyVersion != 2 {
+		t.Errorf("expected key version 2, got %d", rewrapped.KeyVersion)
+	}
+
+	// Durante la rotación las dos versiones siguen descifrando
+	for _, w := range []*EncryptedSecret{wrapped, rewrapped} {
+		unwrapped, err := UnwrapDataKey(w, []byte("user=1"))
+		if err != nil {
+			t.Fatalf("UnwrapDataKey v%d returned error: %v", w.KeyVersion, err)
+		}
+		if !unwrapped.Equal(dataKey) {
+			t.Errorf("unwrapped key v%d does not match", w.KeyVersion)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"slices"
+	"strings"
+)
+
+// ExternalIdentity es una cuenta que viene de fuera (un ID token OIDC verificado o
+// una entrada del directorio LDAP). Se enlaza a un usuario por Issuer + Subject.
+type ExternalIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// preferred_username, o name si no viene
+	Username string
+	Groups   []string
+	// El proveedor pidió un segundo factor (amr)
+	MFA bool
+}
+
+// ExternalUsername elige el nombre de usuario de una cuenta nueva: el del proveedor
+// o la parte local del email, solo con letras, dígitos, . _ - y entre 3 y 32 caracteres
+func ExternalUsername(identity *ExternalIdentity) string {
+	candidate := identity.Username
+	if candidate == "" {
+		candidate, _, _ = strings.Cut(identity.Email, "@")
+	}
+	var b strings.Builder
+	for _, r := range candidate {
+		if b.Len() == 32 {
+			break
+		}
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() < 3 {
+		return "user"
+	}
+	return b.String()
+}
+
+// AdminFromGroups dice si alguno de los grupos da permisos de admin. managed es
+// false si no hay grupos de admin configurados: entonces el admin se gestiona a mano.
+func AdminFromGroups(groups, adminGroups []string) (admin, managed bool) {
+	if len(adminGroups) == 0 {
+		return false, false
+	}
+	for _, group := range groups {
+		if slices.ContainsFunc(adminGroups, func(g string) bool { return strings.EqualFold(g, group) }) {
+			return true, true
+		}
+	}
+	return false, true
+}
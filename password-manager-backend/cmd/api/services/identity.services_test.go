@@ -0,0 +1,37 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExternalUsername(t *testing.T) {
+	cases := []struct {
+		identity ExternalIdentity
+		want     string
+	}{
+		{ExternalIdentity{Username: "ana.garcia", Email: "ana@example.com"}, "ana.garcia"},
+		{ExternalIdentity{Email: "luis+vault@example.com"}, "luisvault"},
+		{ExternalIdentity{Username: "José Pérez"}, "JosPrez"},
+		{ExternalIdentity{Username: strings.Repeat("x", 40)}, strings.Repeat("x", 32)},
+		{ExternalIdentity{Username: "ñ"}, "user"},
+	}
+	for _, tc := range cases {
+		if got := ExternalUsername(&tc.identity); got != tc.want {
+			t.Errorf("ExternalUsername(%+v) = %q, want %q", tc.identity, got, tc.want)
+		}
+	}
+}
+
+func TestAdminFromGroups(t *testing.T) {
+	if admin, managed := AdminFromGroups([]string{"admins"}, nil); admin || managed {
+		t.Error("without admin groups the admin flag must be left alone")
+	}
+	admins := []string{"cn=Vault Admins,ou=Groups,dc=example,dc=com"}
+	if admin, managed := AdminFromGroups([]string{"CN=Vault Admins,OU=Groups,DC=example,DC=com"}, admins); !admin || !managed {
+		t.Error("group names must match regardless of case")
+	}
+	if admin, managed := AdminFromGroups([]string{"staff"}, admins); admin || !managed {
+		t.Error("other groups must not grant admin")
+	}
+}
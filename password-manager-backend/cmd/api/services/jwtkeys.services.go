@@ -0,0 +1,347 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Claves de firma de los JWT. Se firma con una sola (JWT_KEY_ID) y se aceptan
+// también las de JWT_VERIFY_KEY_IDS, así una rotación no invalida los tokens ya
+// emitidos. Cada token lleva en la cabecera "kid" la clave con la que se firmó y
+// las públicas se publican en /.well-known/jwks.json para que otros servicios
+// puedan verificar sin compartir ningún secreto.
+//
+// El material se resuelve con el KeyProvider: JWT_PRIVATE_KEY_<kid> es la clave
+// privada (Ed25519 o RSA de al menos 2048 bits, en PEM PKCS#8 o en base64 DER) y
+// para las claves antiguas basta con JWT_PUBLIC_KEY_<kid> (PEM PKIX o base64 DER).
+const (
+	JWTAlgEdDSA = "EdDSA"
+	JWTAlgRS256 = "RS256"
+
+	minRSABits = 2048
+)
+
+var (
+	ErrUnknownJWTKey = errors.New("unknown JWT key id")
+	jwtKeyIDPattern  = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+)
+
+// JWTPrivateKeyName es el nombre en el KeyProvider de la clave privada de un kid
+func JWTPrivateKeyName(kid string) string {
+	return "JWT_PRIVATE_KEY_" + kid
+}
+
+// JWTPublicKeyName es el nombre en el KeyProvider de la clave pública de un kid
+func JWTPublicKeyName(kid string) string {
+	return "JWT_PUBLIC_KEY_" + kid
+}
+
+// JWTKey es una clave de verificación con su algoritmo
+type JWTKey struct {
+	ID        string
+	Algorithm string
+	Public    crypto.PublicKey
+}
+
+// JWTKeySet son la clave de firma y todas las que se aceptan al verificar
+type JWTKeySet struct {
+	signingID  string
+	signingKey crypto.Signer
+	keys       map[string]JWTKey
+	// Orden de publicación en el JWKS: primero la de firma
+	order []string
+}
+
+// LoadJWTKeySet lee JWT_KEY_ID y JWT_VERIFY_KEY_IDS y carga sus claves del KeyProvider
+func LoadJWTKeySet() (*JWTKeySet, error) {
+	signingID := strings.TrimSpace(os.Getenv("JWT_KEY_ID"))
+	if signingID == "" {
+		return nil, errors.New("JWT_KEY_ID is required")
+	}
+	var verifyIDs []string
+	for _, kid := range strings.Split(os.Getenv("JWT_VERIFY_KEY_IDS"), ",") {
+		if kid = strings.TrimSpace(kid); kid != "" && kid != signingID {
+			verifyIDs = append(verifyIDs, kid)
+		}
+	}
+	return NewJWTKeySet(signingID, verifyIDs, GetKey)
+}
+
+// NewJWTKeySet carga la clave de firma y las de verificación con getKey
+func NewJWTKeySet(signingID string, verifyIDs []string, getKey func(name string) ([]byte, error)) (*JWTKeySet, error) {
+	if !jwtKeyIDPattern.MatchString(signingID) {
+		return nil, fmt.Errorf("invalid JWT key id %q: use letters, digits and _", signingID)
+	}
+	raw, err := getKey(JWTPrivateKeyName(signingID))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", JWTPrivateKeyName(signingID), err)
+	}
+	signer, err := parseJWTPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", JWTPrivateKeyName(signingID), err)
+	}
+	signing, err := newJWTKey(signingID, signer.Public())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", JWTPrivateKeyName(signingID), err)
+	}
+
+	set := &JWTKeySet{
+		signingID:  signingID,
+		signingKey: signer,
+		keys:       map[string]JWTKey{signingID: signing},
+		order:      []string{signingID},
+	}
+	for _, kid := range verifyIDs {
+		if !jwtKeyIDPattern.MatchString(kid) {
+			return nil, fmt.Errorf("invalid JWT key id %q: use letters, digits and _", kid)
+		}
+		if _, ok := set.keys[kid]; ok {
+			continue
+		}
+		public, err := loadJWTPublicKey(kid, getKey)
+		if err != nil {
+			return nil, err
+		}
+		key, err := newJWTKey(kid, public)
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %s: %w", kid, err)
+		}
+		set.keys[kid] = key
+		set.order = append(set.order, kid)
+	}
+	return set, nil
+}
+
+// loadJWTPublicKey usa JWT_PUBLIC_KEY_<kid> y, si no está, la pública de la privada
+func loadJWTPublicKey(kid string, getKey func(name string) ([]byte, error)) (crypto.PublicKey, error) {
+	raw, err := getKey(JWTPublicKeyName(kid))
+	if err == nil {
+		public, err := parseJWTPublicKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", JWTPublicKeyName(kid), err)
+		}
+		return public, nil
+	}
+	if !errors.Is(err, ErrKeyNotFound) {
+		return nil, fmt.Errorf("%s: %w", JWTPublicKeyName(kid), err)
+	}
+	raw, err = getKey(JWTPrivateKeyName(kid))
+	if err != nil {
+		return nil, fmt.Errorf("JWT key %s: neither %s nor %s found: %w", kid, JWTPublicKeyName(kid), JWTPrivateKeyName(kid), err)
+	}
+	signer, err := parseJWTPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", JWTPrivateKeyName(kid), err)
+	}
+	return signer.Public(), nil
+}
+
+// newJWTKey elige el algoritmo según el tipo de clave
+func newJWTKey(kid string, public crypto.PublicKey) (JWTKey, error) {
+	switch k := public.(type) {
+	case ed25519.PublicKey:
+		return JWTKey{ID: kid, Algorithm: JWTAlgEdDSA, Public: k}, nil
+	case *rsa.PublicKey:
+		if k.N.BitLen() < minRSABits {
+			return JWTKey{}, fmt.Errorf("RSA keys must have at least %d bits", minRSABits)
+		}
+		return JWTKey{ID: kid, Algorithm: JWTAlgRS256, Public: k}, nil
+	default:
+		return JWTKey{}, fmt.Errorf("unsupported key type %T, use Ed25519 or RSA", public)
+	}
+}
+
+// decodeKeyMaterial acepta PEM o el DER en base64, que cabe en una sola línea del .env
+func decodeKeyMaterial(raw []byte) ([]byte, error) {
+	if block, _ := pem.Decode(raw); block != nil {
+		return block.Bytes, nil
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(raw)))
+	if err != nil {
+		return nil, errors.New("key must be PEM or base64 DER")
+	}
+	return der, nil
+}
+
+func parseJWTPrivateKey(raw []byte) (crypto.Signer, error) {
+	der, err := decodeKeyMaterial(raw)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		// Las claves RSA generadas con openssl antiguos vienen en PKCS#1
+		if rsaKey, rsaErr := x509.ParsePKCS1PrivateKey(der); rsaErr == nil {
+			return rsaKey, nil
+		}
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+func parseJWTPublicKey(raw []byte) (crypto.PublicKey, error) {
+	der, err := decodeKeyMaterial(raw)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	return key, nil
+}
+
+// SigningKey devuelve el kid, el algoritmo y la clave privada con la que se firma
+func (s *JWTKeySet) SigningKey() (string, string, crypto.Signer) {
+	return s.signingID, s.keys[s.signingID].Algorithm, s.signingKey
+}
+
+// VerificationKey busca la clave pública de un kid
+func (s *JWTKeySet) VerificationKey(kid string) (JWTKey, error) {
+	key, ok := s.keys[kid]
+	if !ok {
+		return JWTKey{}, ErrUnknownJWTKey
+	}
+	return key, nil
+}
+
+// JWK es una clave pública en formato JSON Web Key (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// Ed25519 (RFC 8037) y curvas elípticas
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+}
+
+// JWKS es el documento de /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS devuelve las claves públicas de verificación
+func (s *JWTKeySet) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0, len(s.order))}
+	for _, kid := range s.order {
+		key := s.keys[kid]
+		jwk := JWK{Kid: kid, Use: "sig", Alg: key.Algorithm}
+		switch public := key.Public.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+// PublicKey convierte la JWK en una clave pública: RSA, EC (P-256, P-384, P-521)
+// u OKP (Ed25519). Sirve para los JWKS de otros, como el de un proveedor OIDC.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	decode := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, errN := decode(k.N)
+		e, errE := decode(k.E)
+		if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RSA JWK")
+		}
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if public.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA keys must have at least %d bits", minRSABits)
+		}
+		return public, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported EC curve %q", k.Crv)
+		}
+		x, errX := decode(k.X)
+		y, errY := decode(k.Y)
+		size := (curve.Params().BitSize + 7) / 8
+		if errX != nil || errY != nil || len(x) != size || len(y) != size {
+			return nil, errors.New("invalid EC JWK")
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, append(append([]byte{4}, x...), y...))
+	case "OKP":
+		x, err := decode(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid OKP JWK")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported JWK type %q", k.Kty)
+	}
+}
+
+var (
+	jwtKeySetMu sync.RWMutex
+	jwtKeySet   *JWTKeySet
+)
+
+// ConfigureJWTKeys carga las claves del entorno y las deja como las globales. Se
+// llama al arrancar para no servir peticiones sin clave de firma.
+func ConfigureJWTKeys() error {
+	set, err := LoadJWTKeySet()
+	if err != nil {
+		return err
+	}
+	SetJWTKeySet(set)
+	return nil
+}
+
+// SetJWTKeySet cambia las claves que usan la firma y la verificación de los JWT
+func SetJWTKeySet(set *JWTKeySet) {
+	jwtKeySetMu.Lock()
+	defer jwtKeySetMu.Unlock()
+	jwtKeySet = set
+}
+
+// CurrentJWTKeySet devuelve las claves configuradas; si nadie las configuró aún
+// (herramientas de línea de comandos) las carga del entorno
+func CurrentJWTKeySet() (*JWTKeySet, error) {
+	jwtKeySetMu.RLock()
+	set := jwtKeySet
+	jwtKeySetMu.RUnlock()
+	if set != nil {
+		return set, nil
+	}
+	if err := ConfigureJWTKeys(); err != nil {
+		return nil, err
+	}
+	return CurrentJWTKeySet()
+}
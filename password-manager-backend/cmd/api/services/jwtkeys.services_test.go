@@ -0,0 +1,173 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"testing"
+)
+
+// keyring simula el KeyProvider con un mapa
+func keyring(keys map[string]string) func(string) ([]byte, error) {
+	return func(name string) ([]byte, error) {
+		value, ok := keys[name]
+		if !ok {
+			return nil, ErrKeyNotFound
+		}
+		return []byte(value), nil
+	}
+}
+
+func ed25519KeyBase64(t *testing.T) (string, ed25519.PublicKey) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(der), public
+}
+
+func rsaKeyPEM(t *testing.T, bits int) (string, *rsa.PublicKey) {
+	private, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), &private.PublicKey
+}
+
+func TestJWTKeySetRotation(t *testing.T) {
+	current, currentPublic := ed25519KeyBase64(t)
+	previous, previousPublic := rsaKeyPEM(t, 2048)
+	_, retiredPublic := ed25519KeyBase64(t)
+	retiredDER, _ := x509.MarshalPKIXPublicKey(retiredPublic)
+
+	set, err := NewJWTKeySet("v3", []string{"v2", "v1", "v3"}, keyring(map[string]string{
+		JWTPrivateKeyName("v3"): current,
+		JWTPrivateKeyName("v2"): previous,
+		// De las claves retiradas basta con la pública
+		JWTPublicKeyName("v1"): base64.StdEncoding.EncodeToString(retiredDER),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kid, alg, signer := set.SigningKey()
+	if kid != "v3" || alg != JWTAlgEdDSA || !currentPublic.Equal(signer.Public()) {
+		t.Errorf("unexpected signing key %s %s", kid, alg)
+	}
+	v2, err := set.VerificationKey("v2")
+	if err != nil || v2.Algorithm != JWTAlgRS256 || !previousPublic.Equal(v2.Public) {
+		t.Errorf("unexpected v2 key %+v: %v", v2, err)
+	}
+	v1, err := set.VerificationKey("v1")
+	if err != nil || !retiredPublic.Equal(v1.Public) {
+		t.Errorf("unexpected v1 key %+v: %v", v1, err)
+	}
+	if _, err := set.VerificationKey("v0"); !errors.Is(err, ErrUnknownJWTKey) {
+		t.Errorf("expected ErrUnknownJWTKey, got %v", err)
+	}
+
+	jwks := set.JWKS()
+	if len(jwks.Keys) != 3 || jwks.Keys[0].Kid != "v3" {
+		t.Fatalf("unexpected JWKS %+v", jwks)
+	}
+	if k := jwks.Keys[0]; k.Kty != "OKP" || k.Crv != "Ed25519" || k.X != base64.RawURLEncoding.EncodeToString(currentPublic) {
+		t.Errorf("unexpected Ed25519 JWK %+v", k)
+	}
+	if k := jwks.Keys[1]; k.Kty != "RSA" || k.Alg != JWTAlgRS256 || k.E != "AQAB" || k.N == "" {
+		t.Errorf("unexpected RSA JWK %+v", k)
+	}
+}
+
+func TestJWTKeySetErrors(t *testing.T) {
+	current, _ := ed25519KeyBase64(t)
+	weak, _ := rsaKeyPEM(t, 1024)
+
+	cases := map[string]struct {
+		signingID string
+		verifyIDs []string
+		keys      map[string]string
+	}{
+		"missing signing key":  {"v1", nil, map[string]string{}},
+		"invalid key id":       {"v-1", nil, map[string]string{JWTPrivateKeyName("v-1"): current}},
+		"garbage key":          {"v1", nil, map[string]string{JWTPrivateKeyName("v1"): "not a key"}},
+		"weak RSA key":         {"v1", nil, map[string]string{JWTPrivateKeyName("v1"): weak}},
+		"missing verification": {"v1", []string{"v0"}, map[string]string{JWTPrivateKeyName("v1"): current}},
+		// Una clave simétrica no es ni PEM ni DER
+		"HMAC secret": {"v1", nil, map[string]string{JWTPrivateKeyName("v1"): "2LFSTc7JXm5QF7253ugf"}},
+	}
+	for name, tc := range cases {
+		if _, err := NewJWTKeySet(tc.signingID, tc.verifyIDs, keyring(tc.keys)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadJWTKeySetRequiresKeyID(t *testing.T) {
+	t.Setenv("JWT_KEY_ID", "")
+	if _, err := LoadJWTKeySet(); err == nil {
+		t.Error("expected an error without JWT_KEY_ID")
+	}
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	edKey, edPublic := ed25519KeyBase64(t)
+	rsaKey, rsaPublic := rsaKeyPEM(t, 2048)
+	set, err := NewJWTKeySet("ed", []string{"rsa"}, keyring(map[string]string{
+		JWTPrivateKeyName("ed"):  edKey,
+		JWTPrivateKeyName("rsa"): rsaKey,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lo que publica el JWKS se tiene que poder leer de vuelta
+	jwks := set.JWKS()
+	for i, want := range []crypto.PublicKey{edPublic, rsaPublic} {
+		got, err := jwks.Keys[i].PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !want.(interface{ Equal(crypto.PublicKey) bool }).Equal(got) {
+			t.Errorf("key %s does not round-trip", jwks.Keys[i].Kid)
+		}
+	}
+
+	ecPrivate, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	point, err := ecPrivate.PublicKey.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ec := JWK{Kty: "EC", Crv: "P-256", X: base64.RawURLEncoding.EncodeToString(point[1:33]), Y: base64.RawURLEncoding.EncodeToString(point[33:])}
+	got, err := ec.PublicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecPrivate.PublicKey.Equal(got) {
+		t.Error("EC key does not round-trip")
+	}
+
+	ec.Y = ec.X
+	if _, err := ec.PublicKey(); err == nil {
+		t.Error("expected a point off the curve to be rejected")
+	}
+	if _, err := (JWK{Kty: "oct"}).PublicKey(); err == nil {
+		t.Error("expected symmetric keys to be rejected")
+	}
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Nombres de las claves. Son los mismos en todos los proveedores: variables de
+// entorno, entradas del fichero keyring o rutas del KMS. Las de los JWT llevan
+// el kid, ver JWTPrivateKeyName.
+const (
+	KeyNamePrelogin = "PRELOGIN_SECRET"
+)
+
+// MasterKeyName es el nombre de la clave maestra de cifrado de una versión
+func MasterKeyName(version int) string {
+	return fmt.Sprintf("ENCRYPTION_KEY_V%d", version)
+}
+
+var ErrKeyNotFound = errors.New("key not found")
+
+// KeyProvider resuelve el material de las claves de firma y cifrado. Devuelve el
+// valor tal y como se configuró (las claves maestras siguen en base64).
+type KeyProvider interface {
+	GetKey(name string) ([]byte, error)
+}
+
+var (
+	keyProviderMu sync.RWMutex
+	keyProvider   KeyProvider = EnvKeyProvider{}
+)
+
+// SetKeyProvider cambia el proveedor que usan GetKey y el cifrado
+func SetKeyProvider(p KeyProvider) {
+	keyProviderMu.Lock()
+	defer keyProviderMu.Unlock()
+	keyProvider = p
+}
+
+// GetKey busca una clave en el proveedor configurado
+func GetKey(name string) ([]byte, error) {
+	keyProviderMu.RLock()
+	p := keyProvider
+	keyProviderMu.RUnlock()
+	return p.GetKey(name)
+}
+
+// NewKeyProviderFromEnv crea el proveedor indicado en KEY_PROVIDER:
+//   - env (por defecto): variables de entorno
+//   - file: fichero JSON {"nombre": "valor"} en KEYRING_FILE, solo legible por su dueño
+//   - http: servicio tipo KMS en KMS_URL, autenticado con KMS_TOKEN
+func NewKeyProviderFromEnv() (KeyProvider, error) {
+	switch os.Getenv("KEY_PROVIDER") {
+	case "", "env":
+		return EnvKeyProvider{}, nil
+	case "file":
+		return NewFileKeyProvider(os.Getenv("KEYRING_FILE"))
+	case "http":
+		if os.Getenv("KMS_URL") == "" {
+			return nil, errors.New("KMS_URL is required when KEY_PROVIDER=http")
+		}
+		return NewHTTPKeyProvider(os.Getenv("KMS_URL"), os.Getenv("KMS_TOKEN")), nil
+	default:
+		return nil, fmt.Errorf("unknown KEY_PROVIDER %q", os.Getenv("KEY_PROVIDER"))
+	}
+}
+
+// ConfigureKeyProvider crea el proveedor de KEY_PROVIDER y lo deja como el global
+func ConfigureKeyProvider() error {
+	p, err := NewKeyProviderFromEnv()
+	if err != nil {
+		return err
+	}
+	SetKeyProvider(p)
+	return nil
+}
+
+// EnvKeyProvider lee las claves de variables de entorno con el mismo nombre
+type EnvKeyProvider struct{}
+
+func (EnvKeyProvider) GetKey(name string) ([]byte, error) {
+	value := os.Getenv(name)
+	// Instalaciones anteriores a la rotación solo tienen ENCRYPTION_KEY
+	if value == "" && name == MasterKeyName(1) {
+		value = os.Getenv("ENCRYPTION_KEY")
+	}
+	if value == "" {
+		return nil, ErrKeyNotFound
+	}
+	return []byte(value), nil
+}
+
+// FileKeyProvider lee las claves de un fichero keyring JSON cargado al arrancar
+type FileKeyProvider struct {
+	keys map[string]string
+}
+
+// NewFileKeyProvider carga el keyring y se niega a usarlo si otros usuarios pueden leerlo
+func NewFileKeyProvider(path string) (*FileKeyProvider, error) {
+	if path == "" {
+		return nil, errors.New("KEYRING_FILE is required when KEY_PROVIDER=file")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading keyring: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("keyring %s is not a regular file", path)
+	}
+	// En Windows los permisos Unix no existen y Go siempre devuelve 0666
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("keyring %s has permissions %04o, it must not be accessible by group or others (chmod 600)", path, info.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading keyring: %w", err)
+	}
+	var keys map[string]string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("keyring %s is not valid JSON: %w", path, err)
+	}
+	return &FileKeyProvider{keys: keys}, nil
+}
+
+func (p *FileKeyProvider) GetKey(name string) ([]byte, error) {
+	value, ok := p.keys[name]
+	if !ok || value == "" {
+		return nil, ErrKeyNotFound
+	}
+	return []byte(value), nil
+}
+
+// HTTPKeyProvider pide las claves a un servicio tipo KMS:
+//
+//	GET <KMS_URL>/v1/keys/<nombre>  Authorization: Bearer <KMS_TOKEN>
+//	200 {"name": "...", "value": "<valor en base64>"}
+//
+// Las claves no cambian para un mismo nombre (las maestras llevan la versión),
+// así que se guardan en memoria para no hacer una petición por cada cifrado.
+type HTTPKeyProvider struct {
+	baseURL string
+	token   string
+	client  *http.Client
+
+	mu    sync.RWMutex
+	cache map[string][]byte
+}
+
+func NewHTTPKeyProvider(baseURL, token string) *HTTPKeyProvider {
+	return &HTTPKeyProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		client:  &http.Client{Timeout: 5 * time.Second},
+		cache:   make(map[string][]byte),
+	}
+}
+
+func (p *HTTPKeyProvider) GetKey(name string) ([]byte, error) {
+	p.mu.RLock()
+	value, ok := p.cache[name]
+	p.mu.RUnlock()
+	if ok {
+		return value, nil
+	}
+
+	// La petición va sin el candado: un KMS lento no bloquea las claves ya
+	// cacheadas. Si dos peticiones piden la misma clave a la vez se guarda la
+	// misma dos veces, que no cambia nada.
+	value, err := p.fetch(name)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.cache[name] = value
+	p.mu.Unlock()
+	return value, nil
+}
+
+// fetch pide una clave al servicio de claves, sin pasar por la caché
+func (p *HTTPKeyProvider) fetch(name string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, p.baseURL+"/v1/keys/"+url.PathEscape(name), nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error contacting key service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrKeyNotFound
+	default:
+		return nil, fmt.Errorf("key service returned %s for %s", resp.Status, name)
+	}
+
+	var body struct {
+		Value string `json:"value"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid key service response: %w", err)
+	}
+	value, err := base64.StdEncoding.DecodeString(body.Value)
+	if err != nil || len(value) == 0 {
+		return nil, errors.New("invalid key service response: value must be base64")
+	}
+	return value, nil
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestEnvKeyProviderFallsBackToEncryptionKey(t *testing.T) {
+	t.Setenv("ENCRYPTION_KEY_V1", "")
+	t.Setenv("ENCRYPTION_KEY", "legacy")
+
+	value, err := EnvKeyProvider{}.GetKey(MasterKeyName(1))
+	if err != nil || string(value) != "legacy" {
+		t.Fatalf("expected legacy ENCRYPTION_KEY, got %q (%v)", value, err)
+	}
+	if _, err := (EnvKeyProvider{}).GetKey(MasterKeyName(2)); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound for a missing version, got %v", err)
+	}
+}
+
+func writeKeyring(t *testing.T, perm os.FileMode, keys map[string]string) string {
+	data, err := json.Marshal(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	if err := os.WriteFile(path, data, perm); err != nil {
+		t.Fatal(err)
+	}
+	// WriteFile respeta el umask, forzamos los permisos que queremos probar
+	if err := os.Chmod(path, perm); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	path := writeKeyring(t, 0o600, map[string]string{JWTPrivateKeyName("v1"): "file-secret"})
+
+	p, err := NewFileKeyProvider(path)
+	if err != nil {
+		t.Fatalf("NewFileKeyProvider returned error: %v", err)
+	}
+	value, err := p.GetKey(JWTPrivateKeyName("v1"))
+	if err != nil || string(value) != "file-secret" {
+		t.Errorf("expected file-secret, got %q (%v)", value, err)
+	}
+	if _, err := p.GetKey(KeyNamePrelogin); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestFileKeyProviderRejectsOpenPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix permissions only")
+	}
+	path := writeKeyring(t, 0o644, map[string]string{JWTPrivateKeyName("v1"): "file-secret"})
+
+	if _, err := NewFileKeyProvider(path); err == nil {
+		t.Error("expected error for a keyring readable by others")
+	}
+}
+
+func TestHTTPKeyProvider(t *testing.T) {
+	rawKey := make([]byte, 32)
+	for i := range rawKey {
+		rawKey[i] = byte(i)
+	}
+	encodedMaster := base64.StdEncoding.EncodeToString(rawKey)
+	keys := map[string]string{MasterKeyName(1): encodedMaster}
+
+	// Servicio de claves local que hace de KMS
+	requests := 0
+	kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		name := filepath.Base(r.URL.Path)
+		value, ok := keys[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"name":  name,
+			"value": base64.StdEncoding.EncodeToString([]byte(value)),
+		})
+	}))
+	defer kms.Close()
+
+	p := NewHTTPKeyProvider(kms.URL+"/", "test-token")
+	for i := 0; i < 2; i++ {
+		value, err := p.GetKey(MasterKeyName(1))
+		if err != nil || string(value) != encodedMaster {
+			t.Fatalf("expected master key from the key service, got %q (%v)", value, err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected the key to be cached after the first request, got %d requests", requests)
+	}
+	if _, err := p.GetKey(MasterKeyName(2)); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("expected ErrKeyNotFound, got %v", err)
+	}
+	if _, err := NewHTTPKeyProvider(kms.URL, "wrong").GetKey(MasterKeyName(1)); err == nil {
+		t.Error("expected error with a wrong token")
+	}
+
+	// El cifrado resuelve la clave maestra a través del proveedor configurado
+	SetKeyProvider(p)
+	defer SetKeyProvider(EnvKeyProvider{})
+	t.Setenv("ENCRYPTION_KEY", "")
+	t.Setenv("ENCRYPTION_KEY_VERSION", "1")
+
+	secret, err := EncryptSecret(SecretFromString("from-kms"), []byte("aad"))
+	if err != nil {
+		t.Fatalf("EncryptSecret returned error: %v", err)
+	}
+	key, err := masterKey(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := OpenAESGCM(key.Bytes(), secret.Ciphertext, secret.Nonce, []byte("aad"))
+	if err != nil || string(plaintext) != "from-kms" {
+		t.Errorf("expected to decrypt with the key from the key service, got %q (%v)", plaintext, err)
+	}
+}
+
+func TestHTTPKeyProviderCacheNotBlockedBySlowFetch(t *testing.T) {
+	release := make(chan struct{})
+	kms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.Base(r.URL.Path)
+		if name == MasterKeyName(2) {
+			<-release
+		}
+		json.NewEncoder(w).Encode(map[string]string{"name": name, "value": base64.StdEncoding.EncodeToString([]byte(name))})
+	}))
+	defer kms.Close()
+	defer close(release)
+
+	p := NewHTTPKeyProvider(kms.URL, "")
+	if _, err := p.GetKey(MasterKeyName(1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mientras el KMS tarda con la v2, la v1 sale de la caché sin esperar
+	go p.GetKey(MasterKeyName(2))
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.GetKey(MasterKeyName(1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("cached key blocked by a pending key service request")
+	}
+}
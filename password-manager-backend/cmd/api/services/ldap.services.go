@@ -0,0 +1,327 @@
+package services
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Login contra un directorio LDAP o Active Directory. Con una cuenta de servicio
+// (o anónimamente) se busca la entrada del email en LDAP_BASE_DN con LDAP_USER_FILTER
+// y se comprueba la contraseña haciendo bind como esa entrada.
+//
+// Se activa con LDAP_URL. Las contraseñas no viajan en claro: hace falta ldaps:// o
+// LDAP_START_TLS=true, salvo contra un servidor en localhost.
+
+var (
+	ErrLDAPDisabled = errors.New("LDAP login is not configured")
+	// ErrLDAPInvalidCredentials cubre el email que no está en el directorio y la
+	// contraseña incorrecta: el login no los distingue
+	ErrLDAPInvalidCredentials = errors.New("invalid directory credentials")
+)
+
+// ldapLoginPlaceholder es donde va el email (escapado) en LDAP_USER_FILTER
+const ldapLoginPlaceholder = "{login}"
+
+// LDAPConfig es la configuración del directorio
+type LDAPConfig struct {
+	URL string
+	// Pasar a TLS con StartTLS en una conexión ldap://
+	StartTLS bool
+	// Certificado PEM de la CA del directorio; vacío usa las del sistema
+	CACertFile string
+	// Solo para pruebas: no comprueba el certificado del servidor
+	InsecureSkipVerify bool
+	// Cuenta de servicio para buscar; vacía busca anónimamente
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// Filtro de búsqueda con {login} donde va el email
+	UserFilter        string
+	EmailAttribute    string
+	UsernameAttribute string
+	// Atributo que identifica la entrada aunque la muevan o la renombren
+	// (entryUUID en OpenLDAP, objectGUID en AD). Sin él se usa el DN.
+	IDAttribute    string
+	GroupAttribute string
+	// Dar por verificado el email del directorio. Solo si nadie puede cambiarse
+	// el mail de su propia entrada.
+	EmailVerified bool
+	// Quien esté en alguno de estos grupos (DN) tiene el rol admin. Vacío: el rol no se toca.
+	AdminGroups []string
+	Timeout     time.Duration
+}
+
+// LDAPConfigFromEnv lee la configuración; ok es false si LDAP no está activado
+func LDAPConfigFromEnv() (cfg LDAPConfig, ok bool, err error) {
+	cfg = LDAPConfig{
+		URL:                strings.TrimSpace(os.Getenv("LDAP_URL")),
+		StartTLS:           os.Getenv("LDAP_START_TLS") == "true",
+		CACertFile:         os.Getenv("LDAP_CA_CERT"),
+		InsecureSkipVerify: os.Getenv("LDAP_TLS_SKIP_VERIFY") == "true",
+		BindDN:             os.Getenv("LDAP_BIND_DN"),
+		BindPassword:       os.Getenv("LDAP_BIND_PASSWORD"),
+		BaseDN:             os.Getenv("LDAP_BASE_DN"),
+		UserFilter:         os.Getenv("LDAP_USER_FILTER"),
+		EmailAttribute:     os.Getenv("LDAP_EMAIL_ATTRIBUTE"),
+		UsernameAttribute:  os.Getenv("LDAP_USERNAME_ATTRIBUTE"),
+		IDAttribute:        os.Getenv("LDAP_ID_ATTRIBUTE"),
+		GroupAttribute:     os.Getenv("LDAP_GROUP_ATTRIBUTE"),
+		EmailVerified:      os.Getenv("LDAP_EMAIL_VERIFIED") == "true",
+		AdminGroups:        splitDNList(os.Getenv("LDAP_ADMIN_GROUPS")),
+		Timeout:            envDuration("LDAP_TIMEOUT", 5*time.Second),
+	}
+	if cfg.URL == "" {
+		return cfg, false, nil
+	}
+	if cfg.BaseDN == "" {
+		return cfg, false, errors.New("LDAP_BASE_DN is required with LDAP_URL")
+	}
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = "(&(objectClass=person)(mail={login}))"
+	}
+	if !strings.Contains(cfg.UserFilter, ldapLoginPlaceholder) {
+		return cfg, false, errors.New("LDAP_USER_FILTER must contain {login}")
+	}
+	if cfg.EmailAttribute == "" {
+		cfg.EmailAttribute = "mail"
+	}
+	if cfg.UsernameAttribute == "" {
+		cfg.UsernameAttribute = "uid"
+	}
+	if cfg.IDAttribute == "" {
+		cfg.IDAttribute = "entryUUID"
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = "memberOf"
+	}
+	if err := cfg.requireTLS(); err != nil {
+		return cfg, false, fmt.Errorf("LDAP_URL: %w", err)
+	}
+	return cfg, true, nil
+}
+
+// splitDNList parte una lista de DNs separada por ';' (los DNs ya llevan comas)
+func splitDNList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// requireTLS exige ldaps:// o StartTLS salvo en localhost
+func (cfg LDAPConfig) requireTLS() error {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || u.Host == "" {
+		return errors.New("invalid URL")
+	}
+	switch u.Scheme {
+	case "ldaps":
+		if cfg.StartTLS {
+			return errors.New("LDAP_START_TLS only applies to ldap:// URLs")
+		}
+		return nil
+	case "ldap":
+		if cfg.StartTLS {
+			return nil
+		}
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+		return errors.New("must use ldaps:// or LDAP_START_TLS=true")
+	}
+	return errors.New("scheme must be ldap or ldaps")
+}
+
+// LDAPDirectory comprueba credenciales contra el directorio. Abre una conexión
+// por login: los logins son pocos y así no hay conexiones caídas que vigilar.
+type LDAPDirectory struct {
+	cfg       LDAPConfig
+	tlsConfig *tls.Config
+}
+
+// NewLDAPDirectory prepara el cliente, cargando la CA si se ha configurado
+func NewLDAPDirectory(cfg LDAPConfig) (*LDAPDirectory, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CACertFile != "" {
+		pem, err := os.ReadFile(cfg.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("LDAP_CA_CERT: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("LDAP_CA_CERT: no PEM certificates found")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &LDAPDirectory{cfg: cfg, tlsConfig: tlsConfig}, nil
+}
+
+// Config devuelve la configuración del directorio
+func (d *LDAPDirectory) Config() LDAPConfig {
+	return d.cfg
+}
+
+// Issuer identifica el directorio en user_identities. Es la base de búsqueda y no
+// la URL para que cambiar de controlador de dominio no desenlace las cuentas.
+func (d *LDAPDirectory) Issuer() string {
+	return "ldap:" + strings.ToLower(d.cfg.BaseDN)
+}
+
+// Authenticate busca la entrada del email y hace bind con su contraseña. Devuelve
+// ErrLDAPInvalidCredentials si no hay una entrada única o la contraseña no vale;
+// cualquier otro error es del directorio.
+func (d *LDAPDirectory) Authenticate(login string, password *Secret) (*ExternalIdentity, error) {
+	// Un bind sin contraseña es anónimo y el servidor lo da por bueno
+	if login == "" || password.IsEmpty() {
+		return nil, ErrLDAPInvalidCredentials
+	}
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if d.cfg.BindDN != "" {
+		if err := conn.Bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("LDAP service bind: %w", err)
+		}
+	}
+	filter := strings.ReplaceAll(d.cfg.UserFilter, ldapLoginPlaceholder, ldap.EscapeFilter(login))
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(d.cfg.Timeout.Seconds()), false, filter,
+		[]string{d.cfg.EmailAttribute, d.cfg.UsernameAttribute, d.cfg.IDAttribute, d.cfg.GroupAttribute},
+		nil,
+	))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		// Dos entradas con el mismo email: no se sabe de quién es la contraseña
+		return nil, ErrLDAPInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("LDAP search: %w", err)
+	}
+	if len(result.Entries) != 1 {
+		return nil, ErrLDAPInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	// go-ldap solo acepta la contraseña como string
+	if err := conn.Bind(entry.DN, string(password.Bytes())); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrLDAPInvalidCredentials
+		}
+		return nil, fmt.Errorf("LDAP user bind: %w", err)
+	}
+
+	identity := &ExternalIdentity{
+		Issuer:   d.Issuer(),
+		Subject:  ldapEntryID(entry, d.cfg.IDAttribute),
+		Email:    entry.GetAttributeValue(d.cfg.EmailAttribute),
+		Username: entry.GetAttributeValue(d.cfg.UsernameAttribute),
+		Groups:   entry.GetAttributeValues(d.cfg.GroupAttribute),
+	}
+	// Muchos directorios dejan que cada uno edite su mail: solo vale como
+	// verificado si el despliegue lo dice
+	identity.EmailVerified = d.cfg.EmailVerified && identity.Email != ""
+	return identity, nil
+}
+
+// IsAdmin dice si los grupos dan permisos de admin, ver AdminFromGroups
+func (d *LDAPDirectory) IsAdmin(groups []string) (admin, managed bool) {
+	return AdminFromGroups(groups, d.cfg.AdminGroups)
+}
+
+func (d *LDAPDirectory) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(d.cfg.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: d.cfg.Timeout}),
+		ldap.DialWithTLSConfig(d.tlsConfig),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("LDAP dial: %w", err)
+	}
+	conn.SetTimeout(d.cfg.Timeout)
+	if d.cfg.StartTLS {
+		if err := conn.StartTLS(d.tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("LDAP StartTLS: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// ldapEntryID es el identificador estable de la entrada. objectGUID es binario y
+// se guarda en hexadecimal.
+func ldapEntryID(entry *ldap.Entry, attribute string) string {
+	raw := entry.GetRawAttributeValue(attribute)
+	if len(raw) == 0 {
+		return strings.ToLower(entry.DN)
+	}
+	if utf8.Valid(raw) {
+		return string(raw)
+	}
+	return hex.EncodeToString(raw)
+}
+
+var (
+	ldapMu        sync.RWMutex
+	ldapDirectory *LDAPDirectory
+)
+
+// ConfigureLDAP crea el directorio del entorno, o ninguno si LDAP no está activado
+func ConfigureLDAP() error {
+	cfg, ok, err := LDAPConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		SetLDAPDirectory(nil)
+		return nil
+	}
+	directory, err := NewLDAPDirectory(cfg)
+	if err != nil {
+		return err
+	}
+	SetLDAPDirectory(directory)
+	return nil
+}
+
+// SetLDAPDirectory cambia el directorio que usa la aplicación
+func SetLDAPDirectory(d *LDAPDirectory) {
+	ldapMu.Lock()
+	defer ldapMu.Unlock()
+	ldapDirectory = d
+}
+
+// GetLDAPDirectory devuelve el directorio configurado o ErrLDAPDisabled
+func GetLDAPDirectory() (*LDAPDirectory, error) {
+	ldapMu.RLock()
+	defer ldapMu.RUnlock()
+	if ldapDirectory == nil {
+		return nil, ErrLDAPDisabled
+	}
+	return ldapDirectory, nil
+}
@@ -0,0 +1,469 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// mockLDAP es un servidor LDAP mínimo en memoria: bind simple, búsqueda con
+// filtros and/or/not/igualdad/presencia y StartTLS
+type mockLDAP struct {
+	t        *testing.T
+	listener net.Listener
+	// Certificado del servidor para ldaps:// y StartTLS
+	tlsConfig *tls.Config
+	caFile    string
+
+	serviceDN       string
+	servicePassword string
+	entries         []mockLDAPEntry
+
+	mu    sync.Mutex
+	binds []string
+}
+
+type mockLDAPEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// newMockLDAP arranca el servidor en localhost; con ldaps la conexión ya es TLS
+func newMockLDAP(t *testing.T, ldaps bool) *mockLDAP {
+	t.Helper()
+	m := &mockLDAP{
+		t:               t,
+		serviceDN:       "cn=vault,ou=services,dc=example,dc=com",
+		servicePassword: "service-secret",
+		entries: []mockLDAPEntry{
+			{
+				dn:       "uid=ana,ou=people,dc=example,dc=com",
+				password: "ana-directory-pass",
+				attrs: map[string][]string{
+					"objectClass": {"person", "inetOrgPerson"},
+					"mail":        {"ana@example.com"},
+					"uid":         {"ana"},
+					"entryUUID":   {"5f1c1c7e-1b2a-4a57-9a0e-3f5e9d2c8b10"},
+					"memberOf":    {"cn=Vault Admins,ou=groups,dc=example,dc=com", "cn=staff,ou=groups,dc=example,dc=com"},
+				},
+			},
+			{
+				dn:       "uid=luis,ou=people,dc=example,dc=com",
+				password: "luis-directory-pass",
+				attrs: map[string][]string{
+					"objectClass": {"person"},
+					"mail":        {"luis@example.com"},
+					"uid":         {"luis"},
+					"objectGUID":  {string([]byte{0x01, 0xff, 0x80, 0x00})},
+				},
+			},
+			// Dos entradas con el mismo email
+			{dn: "uid=dup1,ou=people,dc=example,dc=com", password: "dup-pass", attrs: map[string][]string{"objectClass": {"person"}, "mail": {"dup@example.com"}}},
+			{dn: "uid=dup2,ou=people,dc=example,dc=com", password: "dup-pass", attrs: map[string][]string{"objectClass": {"person"}, "mail": {"dup@example.com"}}},
+			{dn: "uid=dup3,ou=people,dc=example,dc=com", password: "dup-pass", attrs: map[string][]string{"objectClass": {"person"}, "mail": {"dup@example.com"}}},
+		},
+	}
+	m.tlsConfig, m.caFile = mockLDAPCertificate(t)
+
+	var err error
+	if ldaps {
+		m.listener, err = tls.Listen("tcp", "127.0.0.1:0", m.tlsConfig)
+	} else {
+		m.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.listener.Close() })
+	go m.serve()
+	return m
+}
+
+// URL devuelve la URL del servidor con el esquema pedido
+func (m *mockLDAP) URL(scheme string) string {
+	return scheme + "://" + m.listener.Addr().String()
+}
+
+// config es una configuración que apunta a este servidor
+func (m *mockLDAP) config(scheme string) LDAPConfig {
+	return LDAPConfig{
+		URL:               m.URL(scheme),
+		CACertFile:        m.caFile,
+		BindDN:            m.serviceDN,
+		BindPassword:      m.servicePassword,
+		BaseDN:            "ou=people,dc=example,dc=com",
+		UserFilter:        "(&(objectClass=person)(mail={login}))",
+		EmailAttribute:    "mail",
+		UsernameAttribute: "uid",
+		IDAttribute:       "entryUUID",
+		GroupAttribute:    "memberOf",
+		AdminGroups:       []string{"cn=vault admins,ou=groups,dc=example,dc=com"},
+		Timeout:           2 * time.Second,
+	}
+}
+
+func (m *mockLDAP) directory(cfg LDAPConfig) *LDAPDirectory {
+	m.t.Helper()
+	d, err := NewLDAPDirectory(cfg)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return d
+}
+
+func (m *mockLDAP) bindCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.binds)
+}
+
+func (m *mockLDAP) serve() {
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			return
+		}
+		go m.handle(conn)
+	}
+}
+
+func (m *mockLDAP) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	bound := ""
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			name, password := op.Children[1].Data.String(), op.Children[2].Data.String()
+			code := m.bind(name, password)
+			if code == ldap.LDAPResultSuccess {
+				bound = name
+			}
+			m.send(conn, id, ldapResultPacket(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationUnbindRequest:
+			return
+		case ldap.ApplicationSearchRequest:
+			if bound == "" {
+				m.send(conn, id, ldapResultPacket(ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights))
+				continue
+			}
+			m.search(conn, id, op)
+		case ldap.ApplicationExtendedRequest:
+			if op.Children[0].Data.String() != "1.3.6.1.4.1.1466.20037" {
+				m.send(conn, id, ldapResultPacket(ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError))
+				continue
+			}
+			m.send(conn, id, ldapResultPacket(ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess))
+			conn = tls.Server(conn, m.tlsConfig)
+		default:
+			return
+		}
+	}
+}
+
+func (m *mockLDAP) bind(name, password string) int {
+	m.mu.Lock()
+	m.binds = append(m.binds, name)
+	m.mu.Unlock()
+	if name == m.serviceDN && password == m.servicePassword {
+		return ldap.LDAPResultSuccess
+	}
+	for _, entry := range m.entries {
+		if strings.EqualFold(entry.dn, name) && password != "" && password == entry.password {
+			return ldap.LDAPResultSuccess
+		}
+	}
+	return ldap.LDAPResultInvalidCredentials
+}
+
+func (m *mockLDAP) search(conn net.Conn, id int64, op *ber.Packet) {
+	base := strings.ToLower(op.Children[0].Data.String())
+	sizeLimit, _ := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+	var attributes []string
+	for _, attr := range op.Children[7].Children {
+		attributes = append(attributes, attr.Data.String())
+	}
+
+	sent := 0
+	for _, entry := range m.entries {
+		if !strings.HasSuffix(strings.ToLower(entry.dn), base) || !matchLDAPFilter(filter, entry) {
+			continue
+		}
+		if sizeLimit > 0 && int64(sent) == sizeLimit {
+			m.send(conn, id, ldapResultPacket(ldap.ApplicationSearchResultDone, ldap.LDAPResultSizeLimitExceeded))
+			return
+		}
+		result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+		result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, ""))
+		attrs := ber.NewSequence("")
+		for _, name := range attributes {
+			values, ok := entry.attrs[name]
+			if !ok {
+				continue
+			}
+			attr := ber.NewSequence("")
+			attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, value := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+			}
+			attr.AppendChild(set)
+			attrs.AppendChild(attr)
+		}
+		result.AppendChild(attrs)
+		m.send(conn, id, result)
+		sent++
+	}
+	m.send(conn, id, ldapResultPacket(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+}
+
+func (m *mockLDAP) send(conn net.Conn, id int64, op *ber.Packet) {
+	packet := ber.NewSequence("")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	packet.AppendChild(op)
+	if _, err := conn.Write(packet.Bytes()); err != nil {
+		m.t.Logf("mock LDAP write: %v", err)
+	}
+}
+
+func ldapResultPacket(tag ber.Tag, code int) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return result
+}
+
+// matchLDAPFilter evalúa and (0), or (1), not (2), igualdad (3) y presencia (7)
+func matchLDAPFilter(filter *ber.Packet, entry mockLDAPEntry) bool {
+	switch filter.Tag {
+	case 0:
+		for _, child := range filter.Children {
+			if !matchLDAPFilter(child, entry) {
+				return false
+			}
+		}
+		return true
+	case 1:
+		for _, child := range filter.Children {
+			if matchLDAPFilter(child, entry) {
+				return true
+			}
+		}
+		return false
+	case 2:
+		return !matchLDAPFilter(filter.Children[0], entry)
+	case 3:
+		name, value := filter.Children[0].Data.String(), filter.Children[1].Data.String()
+		for _, v := range entry.attrs[name] {
+			if strings.EqualFold(v, value) {
+				return true
+			}
+		}
+		return false
+	case 7:
+		_, ok := entry.attrs[filter.Data.String()]
+		return ok
+	}
+	return false
+}
+
+// mockLDAPCertificate crea un certificado autofirmado para 127.0.0.1 y lo deja en
+// un PEM para LDAP_CA_CERT
+func mockLDAPCertificate(t *testing.T) (*tls.Config, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mock ldap"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS12,
+	}, caFile
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	m := newMockLDAP(t, false)
+	d := m.directory(m.config("ldap"))
+
+	password := SecretFromString("ana-directory-pass")
+	identity, err := d.Authenticate("ana@example.com", password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Issuer != "ldap:ou=people,dc=example,dc=com" || identity.Subject != "5f1c1c7e-1b2a-4a57-9a0e-3f5e9d2c8b10" {
+		t.Errorf("unexpected identity key %q %q", identity.Issuer, identity.Subject)
+	}
+	if identity.Email != "ana@example.com" || identity.EmailVerified || identity.Username != "ana" || len(identity.Groups) != 2 {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if admin, managed := d.IsAdmin(identity.Groups); !admin || !managed {
+		t.Error("expected Vault Admins to grant admin")
+	}
+
+	// objectGUID es binario: se guarda en hexadecimal
+	cfg := m.config("ldap")
+	cfg.IDAttribute = "objectGUID"
+	identity, err = m.directory(cfg).Authenticate("luis@example.com", SecretFromString("luis-directory-pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Subject != "01ff8000" {
+		t.Errorf("Subject = %q, want hex objectGUID", identity.Subject)
+	}
+	if admin, _ := d.IsAdmin(identity.Groups); admin {
+		t.Error("users outside the admin groups must not be admin")
+	}
+
+	// El email del directorio solo cuenta como verificado si se pide
+	cfg = m.config("ldap")
+	cfg.EmailVerified = true
+	identity, err = m.directory(cfg).Authenticate("ana@example.com", SecretFromString("ana-directory-pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !identity.EmailVerified {
+		t.Error("expected LDAP_EMAIL_VERIFIED to mark the email as verified")
+	}
+}
+
+func TestLDAPAuthenticateRejects(t *testing.T) {
+	m := newMockLDAP(t, false)
+	d := m.directory(m.config("ldap"))
+
+	cases := []struct {
+		name     string
+		login    string
+		password string
+	}{
+		{"wrong password", "ana@example.com", "not-the-password"},
+		{"unknown email", "nadie@example.com", "ana-directory-pass"},
+		{"ambiguous email", "dup@example.com", "dup-pass"},
+		{"filter injection", "*", "ana-directory-pass"},
+		{"filter injection with or", "x)(mail=ana@example.com", "ana-directory-pass"},
+	}
+	for _, tc := range cases {
+		if _, err := d.Authenticate(tc.login, SecretFromString(tc.password)); !errors.Is(err, ErrLDAPInvalidCredentials) {
+			t.Errorf("%s: err = %v, want ErrLDAPInvalidCredentials", tc.name, err)
+		}
+	}
+
+	// Sin contraseña el bind sería anónimo: ni se llega a conectar
+	before := m.bindCount()
+	if _, err := d.Authenticate("ana@example.com", SecretFromString("")); !errors.Is(err, ErrLDAPInvalidCredentials) {
+		t.Errorf("empty password: err = %v", err)
+	}
+	if m.bindCount() != before {
+		t.Error("an empty password must not reach the directory")
+	}
+
+	// Un fallo de la cuenta de servicio es un error del directorio, no del usuario
+	cfg := m.config("ldap")
+	cfg.BindPassword = "wrong"
+	_, err := m.directory(cfg).Authenticate("ana@example.com", SecretFromString("ana-directory-pass"))
+	if err == nil || errors.Is(err, ErrLDAPInvalidCredentials) {
+		t.Errorf("service bind failure: err = %v", err)
+	}
+}
+
+func TestLDAPTLS(t *testing.T) {
+	password := SecretFromString("ana-directory-pass")
+
+	m := newMockLDAP(t, true)
+	if _, err := m.directory(m.config("ldaps")).Authenticate("ana@example.com", password); err != nil {
+		t.Errorf("ldaps: %v", err)
+	}
+
+	m = newMockLDAP(t, false)
+	cfg := m.config("ldap")
+	cfg.StartTLS = true
+	if _, err := m.directory(cfg).Authenticate("ana@example.com", password); err != nil {
+		t.Errorf("StartTLS: %v", err)
+	}
+
+	// Sin la CA el certificado autofirmado no vale
+	cfg.CACertFile = ""
+	if _, err := m.directory(cfg).Authenticate("ana@example.com", password); err == nil {
+		t.Error("expected an untrusted certificate to be rejected")
+	}
+}
+
+func TestLDAPConfigFromEnv(t *testing.T) {
+	t.Setenv("LDAP_URL", "")
+	if _, ok, err := LDAPConfigFromEnv(); ok || err != nil {
+		t.Fatalf("LDAP must be disabled without LDAP_URL: ok=%v err=%v", ok, err)
+	}
+
+	t.Setenv("LDAP_URL", "ldaps://dc.example.com")
+	t.Setenv("LDAP_BASE_DN", "dc=example,dc=com")
+	t.Setenv("LDAP_ADMIN_GROUPS", "cn=Vault Admins,ou=groups,dc=example,dc=com; cn=IT,ou=groups,dc=example,dc=com")
+	cfg, ok, err := LDAPConfigFromEnv()
+	if err != nil || !ok {
+		t.Fatalf("ok=%v err=%v", ok, err)
+	}
+	if cfg.UserFilter != "(&(objectClass=person)(mail={login}))" || cfg.EmailAttribute != "mail" || cfg.GroupAttribute != "memberOf" {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+	if len(cfg.AdminGroups) != 2 || cfg.AdminGroups[1] != "cn=IT,ou=groups,dc=example,dc=com" {
+		t.Errorf("AdminGroups = %q", cfg.AdminGroups)
+	}
+
+	t.Setenv("LDAP_USER_FILTER", "(mail=*)")
+	if _, _, err := LDAPConfigFromEnv(); err == nil {
+		t.Error("expected a filter without {login} to be rejected")
+	}
+	t.Setenv("LDAP_USER_FILTER", "")
+
+	// Contraseñas en claro por la red: solo contra localhost
+	t.Setenv("LDAP_URL", "ldap://dc.example.com")
+	if _, _, err := LDAPConfigFromEnv(); err == nil {
+		t.Error("expected plain ldap:// to be rejected")
+	}
+	t.Setenv("LDAP_START_TLS", "true")
+	if _, _, err := LDAPConfigFromEnv(); err != nil {
+		t.Errorf("ldap:// with StartTLS: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mail es un correo de texto plano
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer envía los correos de la aplicación (restablecer contraseña, avisos...)
+type Mailer interface {
+	Send(ctx context.Context, mail Mail) error
+}
+
+var ErrMailerNotConfigured = errors.New("mailer not configured: set SMTP_HOST")
+
+var (
+	mailerMu sync.RWMutex
+	mailer   Mailer = disabledMailer{}
+)
+
+// SetMailer cambia el Mailer que usa la aplicación
+func SetMailer(m Mailer) {
+	mailerMu.Lock()
+	defer mailerMu.Unlock()
+	mailer = m
+}
+
+// GetMailer devuelve el Mailer configurado
+func GetMailer() Mailer {
+	mailerMu.RLock()
+	defer mailerMu.RUnlock()
+	return mailer
+}
+
+// NewMailerFromEnv crea el Mailer según el entorno:
+//   - SMTP_HOST configurado: SMTP (SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD, SMTP_FROM)
+//   - APP_ENV=local sin SMTP: escribe los correos en el log, solo para desarrollo
+//   - si no, ninguno: cada envío devuelve ErrMailerNotConfigured
+func NewMailerFromEnv() Mailer {
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return &SMTPMailer{
+			Addr:     net.JoinHostPort(host, port),
+			Host:     host,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		}
+	}
+	if os.Getenv("APP_ENV") == "local" {
+		return LogMailer{}
+	}
+	return disabledMailer{}
+}
+
+// ConfigureMailer crea el Mailer del entorno y lo deja como el global
+func ConfigureMailer() {
+	SetMailer(NewMailerFromEnv())
+}
+
+// SMTPMailer envía por SMTP. net/smtp negocia STARTTLS si el servidor lo ofrece y
+// solo manda la contraseña por una conexión cifrada (o a localhost).
+type SMTPMailer struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, mail Mail) error {
+	msg, err := buildMessage(m.From, mail, time.Now())
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	// smtp.SendMail no acepta contexto: se respeta al menos la cancelación previa
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return smtp.SendMail(m.Addr, auth, m.From, []string{mail.To}, msg)
+}
+
+// buildMessage arma el correo con sus cabeceras. Un salto de línea en el
+// destinatario o el asunto permitiría inyectar cabeceras, así que se rechaza.
+func buildMessage(from string, mail Mail, date time.Time) ([]byte, error) {
+	for _, value := range []string{from, mail.To, mail.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, errors.New("mail headers must not contain line breaks")
+		}
+	}
+	if from == "" || mail.To == "" {
+		return nil, errors.New("mail needs a sender and a recipient")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", mail.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	buf.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+	return buf.Bytes(), nil
+}
+
+// LogMailer escribe los correos en el log en vez de enviarlos. Solo para desarrollo:
+// los enlaces de restablecer contraseña quedan en el log.
+type LogMailer struct{}
+
+func (LogMailer) Send(_ context.Context, mail Mail) error {
+	log.Printf("Mail to %s: %s\n%s", mail.To, mail.Subject, mail.Body)
+	return nil
+}
+
+type disabledMailer struct{}
+
+func (disabledMailer) Send(context.Context, Mail) error {
+	return ErrMailerNotConfigured
+}
+
+// FakeMailer guarda los correos en memoria para los tests
+type FakeMailer struct {
+	mu   sync.Mutex
+	sent []Mail
+	// Err, si no es nil, lo devuelve cada envío
+	Err error
+}
+
+func (m *FakeMailer) Send(_ context.Context, mail Mail) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Err != nil {
+		return m.Err
+	}
+	m.sent = append(m.sent, mail)
+	return nil
+}
+
+// Sent devuelve una copia de los correos enviados
+func (m *FakeMailer) Sent() []Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Mail(nil), m.sent...)
+}
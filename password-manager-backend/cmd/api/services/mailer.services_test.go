@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildMessage(t *testing.T) {
+	date := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	msg, err := buildMessage("vault@example.com", Mail{To: "ana@example.com", Subject: "Restablecer tu contraseña", Body: "hola\nadiós"}, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := string(msg)
+	for _, want := range []string{
+		"From: vault@example.com\r\n",
+		"To: ana@example.com\r\n",
+		"Subject: =?utf-8?q?Restablecer_tu_contrase=C3=B1a?=\r\n",
+		"Content-Type: text/plain; charset=UTF-8\r\n",
+		"\r\n\r\nhola\r\nadiós",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("message misses %q:\n%s", want, text)
+		}
+	}
+
+	if _, err := buildMessage("vault@example.com", Mail{To: "ana@example.com\r\nBcc: eve@example.com", Subject: "x"}, date); err == nil {
+		t.Error("expected header injection to be rejected")
+	}
+}
+
+func TestFakeMailer(t *testing.T) {
+	fake := &FakeMailer{}
+	SetMailer(fake)
+	defer SetMailer(disabledMailer{})
+
+	mail := PasswordResetMail("ana@example.com", "prt_token")
+	if err := GetMailer().Send(context.Background(), mail); err != nil {
+		t.Fatal(err)
+	}
+	sent := fake.Sent()
+	if len(sent) != 1 || sent[0].To != "ana@example.com" || !strings.Contains(sent[0].Body, "?token=prt_token") {
+		t.Errorf("unexpected mails %+v", sent)
+	}
+
+	fake.Err = errors.New("smtp down")
+	if err := fake.Send(context.Background(), mail); err == nil {
+		t.Error("expected the configured error")
+	}
+}
+
+func TestNewMailerFromEnv(t *testing.T) {
+	t.Setenv("SMTP_HOST", "")
+	t.Setenv("APP_ENV", "production")
+	if err := NewMailerFromEnv().Send(context.Background(), Mail{}); !errors.Is(err, ErrMailerNotConfigured) {
+		t.Errorf("expected ErrMailerNotConfigured, got %v", err)
+	}
+	t.Setenv("SMTP_HOST", "smtp.example.com")
+	if m, ok := NewMailerFromEnv().(*SMTPMailer); !ok || m.Addr != "smtp.example.com:587" {
+		t.Errorf("expected an SMTP mailer on port 587, got %#v", m)
+	}
+}
+
+func TestPasswordResetToken(t *testing.T) {
+	token, hash, err := NewPasswordResetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, "prt_") || string(hash) != string(HashPasswordResetToken(SecretFromString(token))) {
+		t.Errorf("unexpected token %q", token)
+	}
+}
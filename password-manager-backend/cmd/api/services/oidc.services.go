@@ -0,0 +1,499 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Login con un proveedor de identidad OpenID Connect (authorization code + PKCE).
+// El navegador va al proveedor con AuthorizationURL, vuelve al frontend con un code
+// y el backend lo canjea en el token endpoint por el ID token, que se verifica con
+// las claves del jwks_uri del proveedor.
+//
+// Se activa con OIDC_ISSUER y OIDC_CLIENT_ID. OIDC_CLIENT_SECRET es opcional (un
+// cliente público se protege solo con PKCE) y OIDC_REDIRECT_URL es la página del
+// frontend que recibe ?code=&state=.
+const (
+	// Vida del state, el nonce y el code_verifier de un login a medias
+	oidcLoginTTL = 10 * time.Minute
+	// Como mucho se vuelve a pedir el JWKS una vez por minuto al ver un kid desconocido
+	oidcJWKSRefreshInterval = time.Minute
+	oidcMaxResponseBytes    = 1 << 20
+	// Margen por relojes desincronizados con el proveedor
+	oidcClockSkew = time.Minute
+)
+
+// La cookie ata el state al navegador que empezó el login: sin ella alguien podría
+// colar a otro su propio code y dejarle dentro de una cuenta ajena (login CSRF)
+const (
+	OIDCCookieName = "pm_oidc"
+	OIDCCookiePath = RefreshCookiePath + "/oidc"
+)
+
+var (
+	ErrOIDCDisabled     = errors.New("OIDC login is not configured")
+	ErrOIDCInvalidToken = errors.New("invalid ID token")
+	// Algoritmos aceptados en el ID token. Nunca none ni HMAC: el client secret
+	// no puede servir para firmar identidades.
+	oidcSigningAlgs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+	// Valores de amr (RFC 8176) que cuentan como segundo factor en el proveedor
+	oidcMFAMethods = []string{"mfa", "otp", "hwk"}
+)
+
+// OIDCLoginTTL es lo que tiene el usuario para volver del proveedor
+func OIDCLoginTTL() time.Duration {
+	return oidcLoginTTL
+}
+
+// PasswordLoginEnabled indica si se puede entrar con email y contraseña. Un
+// despliegue con SSO puede cerrarlo con PASSWORD_LOGIN_ENABLED=false.
+func PasswordLoginEnabled() bool {
+	return os.Getenv("PASSWORD_LOGIN_ENABLED") != "false"
+}
+
+// OIDCConfig es la configuración del cliente OIDC
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// Claim del ID token con los grupos del usuario (OIDC_GROUPS_CLAIM, por defecto groups)
+	GroupsClaim string
+	// Quien esté en alguno de estos grupos tiene el rol admin. Vacío: el rol no se toca.
+	AdminGroups []string
+	// Nombre del proveedor en el botón del login
+	Name string
+}
+
+// OIDCConfigFromEnv lee la configuración; ok es false si OIDC no está activado
+func OIDCConfigFromEnv() (cfg OIDCConfig, ok bool, err error) {
+	cfg = OIDCConfig{
+		Issuer:       strings.TrimSuffix(strings.TrimSpace(os.Getenv("OIDC_ISSUER")), "/"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       strings.Fields(os.Getenv("OIDC_SCOPES")),
+		GroupsClaim:  os.Getenv("OIDC_GROUPS_CLAIM"),
+		AdminGroups:  splitList(os.Getenv("OIDC_ADMIN_GROUPS")),
+		Name:         os.Getenv("OIDC_PROVIDER_NAME"),
+	}
+	if cfg.Issuer == "" {
+		return cfg, false, nil
+	}
+	if cfg.ClientID == "" {
+		return cfg, false, errors.New("OIDC_CLIENT_ID is required with OIDC_ISSUER")
+	}
+	if cfg.RedirectURL == "" {
+		cfg.RedirectURL = "http://localhost:3000/oidc/callback"
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	if cfg.Name == "" {
+		cfg.Name = "SSO"
+	}
+	if err := requireSecureURL(cfg.Issuer); err != nil {
+		return cfg, false, fmt.Errorf("OIDC_ISSUER: %w", err)
+	}
+	return cfg, true, nil
+}
+
+// splitList parte una lista separada por comas
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// requireSecureURL exige https salvo en localhost, donde corre un proveedor de pruebas
+func requireSecureURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("invalid URL")
+	}
+	if u.Scheme == "https" {
+		return nil
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); u.Scheme == "http" && (host == "localhost" || (ip != nil && ip.IsLoopback())) {
+		return nil
+	}
+	return errors.New("must use https")
+}
+
+// NewPKCEVerifier genera el code_verifier de PKCE (RFC 7636)
+func NewPKCEVerifier() (string, error) {
+	raw, err := RandomSecret(32)
+	if err != nil {
+		return "", err
+	}
+	defer raw.Destroy()
+	return base64.RawURLEncoding.EncodeToString(raw.Bytes()), nil
+}
+
+// PKCEChallenge es el code_challenge S256 de un code_verifier
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// NewOIDCNonce genera el state o el nonce de un login
+func NewOIDCNonce() (string, error) {
+	raw, err := RandomSecret(32)
+	if err != nil {
+		return "", err
+	}
+	defer raw.Destroy()
+	return base64.RawURLEncoding.EncodeToString(raw.Bytes()), nil
+}
+
+// OIDCProvider habla con el proveedor. Guarda el documento de discovery y las
+// claves del JWKS entre logins.
+type OIDCProvider struct {
+	cfg    OIDCConfig
+	client *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]JWK
+	keysFetchedAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewOIDCProvider crea el cliente. client nil usa uno con timeout de 10 segundos.
+func NewOIDCProvider(cfg OIDCConfig, client *http.Client) *OIDCProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCProvider{cfg: cfg, client: client}
+}
+
+// Config devuelve la configuración del proveedor
+func (p *OIDCProvider) Config() OIDCConfig {
+	return p.cfg
+}
+
+// AuthorizationURL es la URL del proveedor a la que se manda el navegador
+func (p *OIDCProvider) AuthorizationURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(d.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid authorization_endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange canjea el code por el ID token en el token endpoint
+func (p *OIDCProvider) Exchange(ctx context.Context, code, codeVerifier string) (string, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.doJSON(req, &body)
+	if err != nil {
+		return "", fmt.Errorf("token endpoint: %w", err)
+	}
+	if status != http.StatusOK {
+		return "", fmt.Errorf("token endpoint answered %d: %s %s", status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token endpoint answered without id_token")
+	}
+	return body.IDToken, nil
+}
+
+// VerifyIDToken comprueba la firma con el JWKS del proveedor, el emisor, la
+// audiencia, las fechas y el nonce del login, y devuelve la identidad
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw, nonce string) (*ExternalIdentity, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(raw, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.verificationKey(ctx, kid, t.Method.Alg())
+	},
+		jwt.WithValidMethods(oidcSigningAlgs),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(oidcClockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCInvalidToken, err)
+	}
+
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCInvalidToken)
+	}
+	// Con varias audiencias el token tiene que ir dirigido a nosotros (azp)
+	if aud, _ := claims.GetAudience(); len(aud) > 1 {
+		if azp, _ := claims["azp"].(string); azp != p.cfg.ClientID {
+			return nil, fmt.Errorf("%w: azp mismatch", ErrOIDCInvalidToken)
+		}
+	}
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, fmt.Errorf("%w: missing sub", ErrOIDCInvalidToken)
+	}
+
+	identity := &ExternalIdentity{
+		Issuer:        d.Issuer,
+		Subject:       subject,
+		EmailVerified: claimBool(claims["email_verified"]),
+		Groups:        claimStrings(claims[p.cfg.GroupsClaim]),
+	}
+	identity.Email, _ = claims["email"].(string)
+	identity.Username, _ = claims["preferred_username"].(string)
+	if identity.Username == "" {
+		identity.Username, _ = claims["name"].(string)
+	}
+	for _, method := range claimStrings(claims["amr"]) {
+		if slices.Contains(oidcMFAMethods, method) {
+			identity.MFA = true
+		}
+	}
+	return identity, nil
+}
+
+// IsAdmin dice si los grupos dan permisos de admin, ver AdminFromGroups
+func (p *OIDCProvider) IsAdmin(groups []string) (admin, managed bool) {
+	return AdminFromGroups(groups, p.cfg.AdminGroups)
+}
+
+// claimBool acepta true y "true": algunos proveedores mandan email_verified como texto
+func claimBool(value any) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+// claimStrings acepta una lista de textos o un texto suelto
+func claimStrings(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				items = append(items, s)
+			}
+		}
+		return items
+	}
+	return nil
+}
+
+func (p *OIDCProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var d oidcDiscovery
+	status, err := p.doJSON(req, &d)
+	if err != nil {
+		return nil, fmt.Errorf("OIDC discovery: %w", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("OIDC discovery answered %d", status)
+	}
+	// El documento tiene que ser del mismo emisor que firma los tokens
+	if strings.TrimSuffix(d.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("OIDC discovery issuer %q does not match %q", d.Issuer, p.cfg.Issuer)
+	}
+	for _, endpoint := range []string{d.AuthorizationEndpoint, d.TokenEndpoint, d.JWKSURI} {
+		if err := requireSecureURL(endpoint); err != nil {
+			return nil, fmt.Errorf("OIDC discovery endpoint %q: %w", endpoint, err)
+		}
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// verificationKey busca la clave del kid; si no la conoce vuelve a pedir el JWKS,
+// que es lo que pasa cuando el proveedor rota sus claves
+func (p *OIDCProvider) verificationKey(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key, ok := p.findKey(kid)
+	if !ok && time.Since(p.keysFetchedAt) > oidcJWKSRefreshInterval {
+		if err := p.fetchKeys(ctx); err != nil {
+			return nil, err
+		}
+		key, ok = p.findKey(kid)
+	}
+	if !ok {
+		return nil, ErrUnknownJWTKey
+	}
+	if key.Alg != "" && key.Alg != alg {
+		return nil, fmt.Errorf("key %q is for %s, token uses %s", kid, key.Alg, alg)
+	}
+	return key.PublicKey()
+}
+
+// findKey busca por kid; un token sin kid solo vale si el JWKS tiene una clave
+func (p *OIDCProvider) findKey(kid string) (JWK, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func (p *OIDCProvider) fetchKeys(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.discovery.JWKSURI, nil)
+	if err != nil {
+		return err
+	}
+	var jwks JWKS
+	status, err := p.doJSON(req, &jwks)
+	if err != nil {
+		return fmt.Errorf("OIDC JWKS: %w", err)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("OIDC JWKS answered %d", status)
+	}
+	keys := make(map[string]JWK, len(jwks.Keys))
+	for _, key := range jwks.Keys {
+		if key.Use == "" || key.Use == "sig" {
+			keys[key.Kid] = key
+		}
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	return nil
+}
+
+// doJSON hace la petición y decodifica la respuesta, sea cual sea el código
+func (p *OIDCProvider) doJSON(req *http.Request, out any) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxResponseBytes))
+	if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(data, out); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, fmt.Errorf("invalid JSON response: %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+var (
+	oidcMu       sync.RWMutex
+	oidcProvider *OIDCProvider
+)
+
+// ConfigureOIDC crea el proveedor del entorno, o ninguno si OIDC no está activado
+func ConfigureOIDC() error {
+	cfg, ok, err := OIDCConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		SetOIDCProvider(nil)
+		return nil
+	}
+	SetOIDCProvider(NewOIDCProvider(cfg, nil))
+	return nil
+}
+
+// SetOIDCProvider cambia el proveedor que usa la aplicación
+func SetOIDCProvider(p *OIDCProvider) {
+	oidcMu.Lock()
+	defer oidcMu.Unlock()
+	oidcProvider = p
+}
+
+// GetOIDCProvider devuelve el proveedor configurado o ErrOIDCDisabled
+func GetOIDCProvider() (*OIDCProvider, error) {
+	oidcMu.RLock()
+	defer oidcMu.RUnlock()
+	if oidcProvider == nil {
+		return nil, ErrOIDCDisabled
+	}
+	return oidcProvider, nil
+}
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIdP es un proveedor OIDC local: discovery, JWKS y token endpoint con PKCE
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu     sync.Mutex
+	kid    string
+	key    ed25519.PrivateKey
+	keys   map[string]ed25519.PublicKey
+	codes  map[string]mockGrant
+	secret string
+}
+
+type mockGrant struct {
+	challenge   string
+	redirectURI string
+	claims      jwt.MapClaims
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	idp := &mockIdP{t: t, keys: map[string]ed25519.PublicKey{}, codes: map[string]mockGrant{}, secret: "s3cret"}
+	idp.rotate("idp1")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		jwks := JWKS{}
+		for kid, public := range idp.keys {
+			jwks.Keys = append(jwks.Keys, JWK{Kty: "OKP", Crv: "Ed25519", Kid: kid, Use: "sig", Alg: "EdDSA", X: base64.RawURLEncoding.EncodeToString(public)})
+		}
+		json.NewEncoder(w).Encode(jwks)
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// rotate cambia la clave de firma; la anterior se sigue publicando
+func (idp *mockIdP) rotate(kid string) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.kid, idp.key = kid, private
+	idp.keys[kid] = public
+}
+
+func (idp *mockIdP) config() OIDCConfig {
+	return OIDCConfig{
+		Issuer:       idp.server.URL,
+		ClientID:     "vault",
+		ClientSecret: idp.secret,
+		RedirectURL:  "http://localhost:3000/oidc/callback",
+		Scopes:       []string{"openid", "email"},
+		GroupsClaim:  "groups",
+		AdminGroups:  []string{"vault-admins"},
+	}
+}
+
+// authorize hace de la pantalla de login del proveedor: devuelve el code para la
+// URL de autorización con los claims del usuario que "inició sesión"
+func (idp *mockIdP) authorize(authURL string, claims jwt.MapClaims) string {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("response_type") != "code" {
+		idp.t.Fatalf("unexpected authorization request %s", authURL)
+	}
+	full := jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"aud":   q.Get("client_id"),
+		"nonce": q.Get("nonce"),
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+	code := "code-" + rand.Text()
+	idp.mu.Lock()
+	idp.codes[code] = mockGrant{challenge: q.Get("code_challenge"), redirectURI: q.Get("redirect_uri"), claims: full}
+	idp.mu.Unlock()
+	return code
+}
+
+func (idp *mockIdP) sign(claims jwt.MapClaims) string {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = idp.kid
+	raw, err := token.SignedString(idp.key)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return raw
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(code string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": code})
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		fail("invalid_request")
+		return
+	}
+	if user, pass, _ := r.BasicAuth(); user != "vault" || pass != idp.secret {
+		fail("invalid_client")
+		return
+	}
+	idp.mu.Lock()
+	grant, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+	if !ok || grant.redirectURI != r.PostForm.Get("redirect_uri") || PKCEChallenge(r.PostForm.Get("code_verifier")) != grant.challenge {
+		fail("invalid_grant")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": idp.sign(grant.claims)})
+}
+
+// login recorre el flujo entero y devuelve el ID token y el nonce esperado
+func login(t *testing.T, p *OIDCProvider, idp *mockIdP, claims jwt.MapClaims) (string, string) {
+	t.Helper()
+	ctx := context.Background()
+	state, _ := NewOIDCNonce()
+	nonce, _ := NewOIDCNonce()
+	verifier, err := NewPKCEVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := p.AuthorizationURL(ctx, state, nonce, PKCEChallenge(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, idp.server.URL+"/authorize?") || !strings.Contains(authURL, "state="+state) {
+		t.Fatalf("unexpected authorization URL %s", authURL)
+	}
+	code := idp.authorize(authURL, claims)
+	raw, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw, nonce
+}
+
+func TestOIDCLoginFlow(t *testing.T) {
+	idp := newMockIdP(t)
+	p := NewOIDCProvider(idp.config(), idp.server.Client())
+
+	raw, nonce := login(t, p, idp, jwt.MapClaims{
+		"sub":                "user-42",
+		"email":              "ana@example.com",
+		"email_verified":     "true",
+		"preferred_username": "ana",
+		"groups":             []string{"staff", "vault-admins"},
+		"amr":                []string{"pwd", "otp"},
+	})
+	identity, err := p.VerifyIDToken(context.Background(), raw, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Issuer != idp.server.URL || identity.Subject != "user-42" || identity.Email != "ana@example.com" ||
+		!identity.EmailVerified || identity.Username != "ana" || !identity.MFA {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+	if admin, managed := p.IsAdmin(identity.Groups); !admin || !managed {
+		t.Errorf("vault-admins must map to admin, got %v %v", admin, managed)
+	}
+	if admin, _ := p.IsAdmin([]string{"staff"}); admin {
+		t.Error("staff must not be admin")
+	}
+
+	if _, err := p.VerifyIDToken(context.Background(), raw, "other-nonce"); !errors.Is(err, ErrOIDCInvalidToken) {
+		t.Errorf("expected a nonce mismatch, got %v", err)
+	}
+}
+
+func TestOIDCExchangeRequiresVerifier(t *testing.T) {
+	idp := newMockIdP(t)
+	p := NewOIDCProvider(idp.config(), idp.server.Client())
+
+	verifier, _ := NewPKCEVerifier()
+	authURL, err := p.AuthorizationURL(context.Background(), "state", "nonce", PKCEChallenge(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := idp.authorize(authURL, jwt.MapClaims{"sub": "user-42"})
+	other, _ := NewPKCEVerifier()
+	if _, err := p.Exchange(context.Background(), code, other); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("expected invalid_grant with a wrong code_verifier, got %v", err)
+	}
+}
+
+func TestOIDCRejectsInvalidTokens(t *testing.T) {
+	idp := newMockIdP(t)
+	p := NewOIDCProvider(idp.config(), idp.server.Client())
+	base := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   idp.server.URL,
+			"aud":   "vault",
+			"sub":   "user-42",
+			"nonce": "n",
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(time.Minute).Unix(),
+		}
+	}
+	if _, err := p.VerifyIDToken(context.Background(), idp.sign(base()), "n"); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+
+	cases := map[string]func(jwt.MapClaims){
+		"other audience":    func(c jwt.MapClaims) { c["aud"] = "another-app" },
+		"other issuer":      func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"expired":           func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"no expiry":         func(c jwt.MapClaims) { delete(c, "exp") },
+		"no subject":        func(c jwt.MapClaims) { delete(c, "sub") },
+		"foreign azp":       func(c jwt.MapClaims) { c["aud"] = []string{"vault", "another-app"}; c["azp"] = "another-app" },
+		"issued in future":  func(c jwt.MapClaims) { c["iat"] = time.Now().Add(time.Hour).Unix() },
+		"missing the nonce": func(c jwt.MapClaims) { delete(c, "nonce") },
+	}
+	for name, mutate := range cases {
+		claims := base()
+		mutate(claims)
+		if _, err := p.VerifyIDToken(context.Background(), idp.sign(claims), "n"); !errors.Is(err, ErrOIDCInvalidToken) {
+			t.Errorf("%s: expected ErrOIDCInvalidToken, got %v", name, err)
+		}
+	}
+
+	// El client secret no puede firmar identidades, ni vale un token sin firma
+	hs, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, base()).SignedString([]byte(idp.secret))
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, base()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	for _, raw := range []string{hs, none} {
+		if _, err := p.VerifyIDToken(context.Background(), raw, "n"); !errors.Is(err, ErrOIDCInvalidToken) {
+			t.Errorf("expected unsigned or HMAC token to be rejected, got %v", err)
+		}
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	idp := newMockIdP(t)
+	p := NewOIDCProvider(idp.config(), idp.server.Client())
+	claims := jwt.MapClaims{
+		"iss": idp.server.URL, "aud": "vault", "sub": "user-42", "nonce": "n",
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+	if _, err := p.VerifyIDToken(context.Background(), idp.sign(claims), "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	idp.rotate("idp2")
+	rotated := idp.sign(claims)
+	// Recién descargado el JWKS no se vuelve a pedir en cada kid desconocido
+	if _, err := p.VerifyIDToken(context.Background(), rotated, "n"); err == nil {
+		t.Error("expected the new kid to be unknown until the refresh interval passes")
+	}
+	p.keysFetchedAt = time.Time{}
+	if _, err := p.VerifyIDToken(context.Background(), rotated, "n"); err != nil {
+		t.Errorf("expected the rotated key to be fetched, got %v", err)
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	idp := newMockIdP(t)
+	cfg := idp.config()
+	cfg.Issuer = strings.Replace(idp.server.URL, "127.0.0.1", "localhost", 1)
+	p := NewOIDCProvider(cfg, idp.server.Client())
+	if _, err := p.AuthorizationURL(context.Background(), "s", "n", "c"); err == nil {
+		t.Error("expected the discovery issuer to be checked")
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// Ejemplo del apéndice B del RFC 7636
+	if got := PKCEChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("PKCEChallenge = %q", got)
+	}
+}
+
+func TestOIDCConfigFromEnv(t *testing.T) {
+	t.Setenv("OIDC_ISSUER", "")
+	if _, ok, err := OIDCConfigFromEnv(); ok || err != nil {
+		t.Errorf("OIDC must be off without OIDC_ISSUER, got %v %v", ok, err)
+	}
+
+	t.Setenv("OIDC_ISSUER", "https://idp.example.com/")
+	t.Setenv("OIDC_CLIENT_ID", "vault")
+	t.Setenv("OIDC_ADMIN_GROUPS", "admins, vault-admins")
+	cfg, ok, err := OIDCConfigFromEnv()
+	if err != nil || !ok {
+		t.Fatalf("unexpected %v %v", ok, err)
+	}
+	if cfg.Issuer != "https://idp.example.com" || len(cfg.AdminGroups) != 2 || cfg.GroupsClaim != "groups" || cfg.Scopes[0] != "openid" {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	t.Setenv("OIDC_ISSUER", "http://idp.example.com")
+	if _, _, err := OIDCConfigFromEnv(); err == nil {
+		t.Error("expected plain http issuers to be rejected")
+	}
+}
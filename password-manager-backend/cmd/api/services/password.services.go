@@ -1,20 +1,152 @@
 package services
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// HashPassword recibe una contraseña en texto plano y devuelve su hash
-func HashPassword(password string) (string, error) {
-	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
+// Argon2Params son los costes de Argon2id. Se guardan en el propio hash (formato
+// PHC), así que cambiarlos no rompe los hashes existentes: se rehashean al hacer login.
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+var errInvalidHash = errors.New("invalid argon2id hash")
+
+// CurrentArgon2Params lee los costes de ARGON2_MEMORY, ARGON2_ITERATIONS y
+// ARGON2_PARALLELISM. Por defecto 64 MiB, 3 pasadas y 2 hilos.
+func CurrentArgon2Params() Argon2Params {
+	return Argon2Params{
+		Memory:      uint32(envIntMax("ARGON2_MEMORY", 65536, math.MaxUint32)),
+		Iterations:  uint32(envIntMax("ARGON2_ITERATIONS", 3, math.MaxUint32)),
+		Parallelism: uint8(envIntMax("ARGON2_PARALLELISM", 2, math.MaxUint8)),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func envInt(name string, fallback int) int {
+	return envIntMax(name, fallback, math.MaxInt)
+}
+
+// envIntMax es envInt para valores que no caben en un int: por encima de max se
+// usa fallback en vez de truncarlos al convertir
+func envIntMax(name string, fallback, max int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil || value < 1 || value > max {
+		return fallback
+	}
+	return value
+}
+
+// HashPassword recibe una contraseña en texto plano y devuelve su hash Argon2id en formato PHC
+func HashPassword(password *Secret) (string, error) {
+	p := CurrentArgon2Params()
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
-	return string(hashed), nil
+	key := argon2.IDKey(password.Bytes(), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
 }
 
-// CheckPassword compara la contraseña en texto plano con el hash
-func CheckPassword(password string, hashed string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password))
+// CheckPassword compara la contraseña en texto plano con el hash, detectando el
+// algoritmo por su prefijo (Argon2id o los bcrypt antiguos). Una contraseña vacía
+// nunca es válida.
+func CheckPassword(password *Secret, hashed string) bool {
+	if password.IsEmpty() {
+		return false
+	}
+	if strings.HasPrefix(hashed, "$argon2id$") {
+		p, salt, key, err := decodeArgon2Hash(hashed)
+		if err != nil {
+			return false
+		}
+		other := argon2.IDKey(password.Bytes(), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+		return subtle.ConstantTimeCompare(key, other) == 1
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hashed), password.Bytes())
 	return err == nil
 }
+
+// dummyPasswordHash es un hash con los costes actuales que no corresponde a nadie
+var dummyPasswordHash = sync.OnceValue(func() string {
+	secret, err := RandomSecret(32)
+	if err != nil {
+		return ""
+	}
+	defer secret.Destroy()
+	hash, _ := HashPassword(secret)
+	return hash
+})
+
+// CheckDummyPassword hace el mismo trabajo que CheckPassword contra un hash que no
+// es de nadie. Se usa cuando el email no existe para que el login tarde lo mismo
+// y no delate qué cuentas están registradas.
+func CheckDummyPassword(password *Secret) {
+	CheckPassword(password, dummyPasswordHash())
+}
+
+// NeedsRehash indica si el hash es bcrypt o usa unos costes distintos a los actuales
+func NeedsRehash(hashed string) bool {
+	p, _, _, err := decodeArgon2Hash(hashed)
+	if err != nil {
+		return true
+	}
+	current := CurrentArgon2Params()
+	return p.Memory != current.Memory || p.Iterations != current.Iterations ||
+		p.Parallelism != current.Parallelism || p.KeyLength != current.KeyLength
+}
+
+// decodeArgon2Hash separa un hash "$argon2id$v=19$m=..,t=..,p=..$salt$key"
+func decodeArgon2Hash(hashed string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return p, nil, nil, errInvalidHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errInvalidHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return p, nil, nil, errInvalidHash
+	}
+	if p.Memory == 0 || p.Iterations == 0 || p.Parallelism == 0 {
+		return p, nil, nil, errInvalidHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, errInvalidHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, errInvalidHash
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+	return p, salt, key, nil
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func setFastArgon2(t *testing.T) {
+	t.Setenv("ARGON2_MEMORY", "1024")
+	t.Setenv("ARGON2_ITERATIONS", "1")
+	t.Setenv("ARGON2_PARALLELISM", "1")
+}
+
+func TestHashPasswordArgon2id(t *testing.T) {
+	setFastArgon2(t)
+
+	hashed, err := HashPassword(SecretFromString("12345678"))
+	if err != nil {
+		t.Fatalf("HashPassword returned error: %v", err)
+	}
+	if !strings.HasPrefix(hashed, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("unexpected PHC string: %s", hashed)
+	}
+	if !CheckPassword(SecretFromString("12345678"), hashed) {
+		t.Error("expected the password to match")
+	}
+	if CheckPassword(SecretFromString("87654321"), hashed) {
+		t.Error("expected a different password not to match")
+	}
+	if NeedsRehash(hashed) {
+		t.Error("a hash with the current parameters should not need a rehash")
+	}
+}
+
+func TestCheckPasswordLegacyBcrypt(t *testing.T) {
+	setFastArgon2(t)
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("12345678"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !CheckPassword(SecretFromString("12345678"), string(legacy)) {
+		t.Error("expected bcrypt hashes to keep working")
+	}
+	if !NeedsRehash(string(legacy)) {
+		t.Error("bcrypt hashes should be upgraded")
+	}
+}
+
+func TestNeedsRehashOutdatedParams(t *testing.T) {
+	setFastArgon2(t)
+
+	hashed, err := HashPassword(SecretFromString("12345678"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("ARGON2_ITERATIONS", "2")
+	if !NeedsRehash(hashed) {
+		t.Error("expected hashes with old parameters to need a rehash")
+	}
+	// El hash antiguo se sigue pudiendo verificar con sus propios parámetros
+	if !CheckPassword(SecretFromString("12345678"), hashed) {
+		t.Error("expected old hashes to keep verifying")
+	}
+}
+
+func TestCurrentArgon2ParamsOutOfRange(t *testing.T) {
+	// 300 se truncaría a 44 hilos al pasarlo a uint8: se ignora
+	t.Setenv("ARGON2_PARALLELISM", "300")
+	if p := CurrentArgon2Params(); p.Parallelism != 2 {
+		t.Errorf("expected default parallelism 2, got %d", p.Parallelism)
+	}
+	t.Setenv("ARGON2_PARALLELISM", "255")
+	if p := CurrentArgon2Params(); p.Parallelism != 255 {
+		t.Errorf("expected parallelism 255, got %d", p.Parallelism)
+	}
+}
@@ -0,0 +1,88 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	passwordResetTokenBytes  = 32
+	passwordResetTokenPrefix = "prt_"
+	// Tiempo mínimo entre dos correos de restablecer contraseña a la misma cuenta
+	passwordResetCooldown = time.Minute
+)
+
+// PasswordResetTTL es la vida del enlace de restablecer contraseña
+// (PASSWORD_RESET_TTL, por defecto 30m)
+func PasswordResetTTL() time.Duration {
+	return envDuration("PASSWORD_RESET_TTL", 30*time.Minute)
+}
+
+// PasswordResetCooldown evita que alguien llene de correos el buzón de otro
+func PasswordResetCooldown() time.Duration {
+	return passwordResetCooldown
+}
+
+// NewPasswordResetToken genera un token de un solo uso y el hash que se guarda
+func NewPasswordResetToken() (string, []byte, error) {
+	raw, err := RandomSecret(passwordResetTokenBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	defer raw.Destroy()
+	token := passwordResetTokenPrefix + base64.RawURLEncoding.EncodeToString(raw.Bytes())
+	hash := sha256.Sum256([]byte(token))
+	return token, hash[:], nil
+}
+
+// HashPasswordResetToken calcula el hash con el que se busca un token
+func HashPasswordResetToken(token *Secret) []byte {
+	sum := sha256.Sum256(token.Bytes())
+	return sum[:]
+}
+
+// PasswordResetLink es el enlace del correo: PASSWORD_RESET_URL (la página del
+// frontend) con el token en ?token=
+func PasswordResetLink(token string) string {
+	base := os.Getenv("PASSWORD_RESET_URL")
+	if base == "" {
+		base = "http://localhost:3000/reset-password"
+	}
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+// PasswordResetMail es el correo con el enlace
+func PasswordResetMail(to, token string) Mail {
+	return Mail{
+		To:      to,
+		Subject: "Restablecer tu contraseña",
+		Body: fmt.Sprintf(`Alguien ha pedido restablecer la contraseña de tu cuenta.
+
+Para elegir una nueva entra en este enlace antes de %d minutos:
+%s
+
+Si no has sido tú, ignora este correo: tu contraseña no cambia.
+`, int(PasswordResetTTL().Minutes()), PasswordResetLink(token)),
+	}
+}
+
+// ZeroKnowledgeResetMail explica a un usuario zero-knowledge por qué no hay enlace:
+// el servidor no puede descifrar su bóveda con una contraseña nueva
+func ZeroKnowledgeResetMail(to string) Mail {
+	return Mail{
+		To:      to,
+		Subject: "Restablecer tu contraseña",
+		Body: `Alguien ha pedido restablecer la contraseña de tu cuenta.
+
+Tu bóveda está cifrada con tu contraseña maestra y el servidor no puede
+descifrarla, así que no se puede restablecer por correo. Si la has olvidado,
+usa tu kit de recuperación o un contacto de emergencia.
+
+Si no has sido tú, ignora este correo.
+`,
+	}
+}
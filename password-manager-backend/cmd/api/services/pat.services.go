@@ -0,0 +1,92 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Tokens de acceso personal (PAT) para scripts y CI. Llevan un prefijo propio para
+// distinguirlos de un JWT sin tener que decodificarlos.
+const (
+	patBytes  = 32
+	patPrefix = "pat_"
+
+	// Caducidad por defecto y máxima de un PAT
+	patDefaultTTL = 90 * 24 * time.Hour
+	patMaxTTL     = 365 * 24 * time.Hour
+)
+
+// Permisos de un PAT. Una sesión normal los tiene todos; un PAT solo los que se le
+// dieron al crearlo.
+const (
+	ScopeNotesRead  = "notes:read"
+	ScopeNotesWrite = "notes:write"
+	ScopeUsersRead  = "users:read"
+	ScopeUsersWrite = "users:write"
+)
+
+var patScopes = []string{ScopeNotesRead, ScopeNotesWrite, ScopeUsersRead, ScopeUsersWrite}
+
+var ErrInvalidScope = errors.New("invalid scope")
+
+// IsPersonalAccessToken indica si el token presentado es un PAT y no un JWT
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, patPrefix)
+}
+
+// NewPersonalAccessToken genera un PAT aleatorio y el hash que se guarda. Como el
+// refresh token, solo se enseña una vez.
+func NewPersonalAccessToken() (string, []byte, error) {
+	raw, err := RandomSecret(patBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	defer raw.Destroy()
+	token := patPrefix + base64.RawURLEncoding.EncodeToString(raw.Bytes())
+	return token, HashPersonalAccessToken(token), nil
+}
+
+// HashPersonalAccessToken calcula el hash con el que se busca un PAT
+func HashPersonalAccessToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// NormalizeScopes valida los permisos pedidos y los devuelve ordenados y sin repetir
+func NormalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, ErrInvalidScope
+	}
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !slices.Contains(patScopes, scope) {
+			return nil, ErrInvalidScope
+		}
+		normalized = append(normalized, scope)
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized), nil
+}
+
+// HasScopes indica si granted incluye todos los permisos required
+func HasScopes(granted, required []string) bool {
+	for _, scope := range required {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// PersonalAccessTokenTTL convierte los días pedidos en la vida del PAT: 0 es el valor
+// por defecto (90 días) y nunca pasa de un año
+func PersonalAccessTokenTTL(days int) time.Duration {
+	if days <= 0 {
+		return patDefaultTTL
+	}
+	return min(time.Duration(days)*24*time.Hour, patMaxTTL)
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestNewPersonalAccessToken(t *testing.T) {
+	token, hash, err := NewPersonalAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPersonalAccessToken(token) {
+		t.Errorf("expected %q to be recognized as a PAT", token)
+	}
+	if !bytes.Equal(hash, HashPersonalAccessToken(token)) {
+		t.Error("hash does not match the token")
+	}
+	other, _, _ := NewPersonalAccessToken()
+	if token == other {
+		t.Error("expected different tokens")
+	}
+	if IsPersonalAccessToken("eyJhbGciOiJIUzI1NiJ9.e30.sig") {
+		t.Error("a JWT must not be taken for a PAT")
+	}
+}
+
+func TestNormalizeScopes(t *testing.T) {
+	scopes, err := NormalizeScopes([]string{ScopeNotesWrite, ScopeNotesRead, ScopeNotesWrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(scopes, []string{ScopeNotesRead, ScopeNotesWrite}) {
+		t.Errorf("unexpected scopes %v", scopes)
+	}
+	for _, bad := range [][]string{nil, {}, {"notes:admin"}, {ScopeNotesRead, ""}} {
+		if _, err := NormalizeScopes(bad); !errors.Is(err, ErrInvalidScope) {
+			t.Errorf("expected ErrInvalidScope for %v, got %v", bad, err)
+		}
+	}
+}
+
+func TestHasScopes(t *testing.T) {
+	granted := []string{ScopeNotesRead, ScopeUsersRead}
+	if !HasScopes(granted, []string{ScopeNotesRead}) {
+		t.Error("expected notes:read to be granted")
+	}
+	if HasScopes(granted, []string{ScopeNotesRead, ScopeNotesWrite}) {
+		t.Error("notes:write was not granted")
+	}
+}
+
+func TestPersonalAccessTokenTTL(t *testing.T) {
+	if got := PersonalAccessTokenTTL(0); got != 90*24*time.Hour {
+		t.Errorf("default TTL = %v", got)
+	}
+	if got := PersonalAccessTokenTTL(7); got != 7*24*time.Hour {
+		t.Errorf("TTL for 7 days = %v", got)
+	}
+	if got := PersonalAccessTokenTTL(10000); got != 365*24*time.Hour {
+		t.Errorf("TTL should be capped at a year, got %v", got)
+	}
+}
@@ -0,0 +1,53 @@
+package services
+
+import "slices"
+
+// Permisos de los roles, ver la tabla permissions. notes:read y notes:write son
+// los mismos nombres que los permisos de los PAT: con un PAT hace falta tener las dos cosas.
+const (
+	PermNotesRead             = "notes:read"
+	PermNotesWrite            = "notes:write"
+	PermUsersView             = "users:view"
+	PermUsersViewCredentials  = "users:view_credentials"
+	PermUsersUpdate           = "users:update"
+	PermUsersDelete           = "users:delete"
+	PermUsersDisable          = "users:disable"
+	PermUsersResetCredentials = "users:reset_credentials"
+	PermSessionsRevoke        = "sessions:revoke"
+	PermLockoutsView          = "lockouts:view"
+	PermRolesView             = "roles:view"
+	PermRolesAssign           = "roles:assign"
+	PermAuditView             = "audit:view"
+	PermOwnersManage          = "owners:manage"
+)
+
+// Roles de serie
+const (
+	RoleOwner   = "owner"
+	RoleAdmin   = "admin"
+	RoleAuditor = "auditor"
+	RoleUser    = "user"
+)
+
+// HasPermissions indica si los permisos concedidos incluyen todos los pedidos
+func HasPermissions(granted []string, required ...string) bool {
+	for _, permission := range required {
+		if !slices.Contains(granted, permission) {
+			return false
+		}
+	}
+	return true
+}
+
+// MissingPermissions devuelve los permisos de target que no están en granted. Nadie
+// puede dar, quitar ni tocar lo que no tiene: así un admin no se hace owner ni
+// borra a uno.
+func MissingPermissions(granted, target []string) []string {
+	var missing []string
+	for _, permission := range target {
+		if !slices.Contains(granted, permission) {
+			missing = append(missing, permission)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,33 @@
+package services
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestHasPermissions(t *testing.T) {
+	granted := []string{PermUsersView, PermLockoutsView}
+	if !HasPermissions(granted, PermUsersView) || !HasPermissions(granted, PermUsersView, PermLockoutsView) {
+		t.Error("expected the granted permissions to be enough")
+	}
+	if !HasPermissions(granted) {
+		t.Error("nothing required must always pass")
+	}
+	if HasPermissions(granted, PermUsersView, PermUsersDelete) || HasPermissions(nil, PermNotesRead) {
+		t.Error("expected a missing permission to fail")
+	}
+}
+
+func TestMissingPermissions(t *testing.T) {
+	admin := []string{PermUsersView, PermUsersUpdate, PermUsersDelete, PermRolesAssign}
+	owner := append(slices.Clone(admin), PermOwnersManage)
+	if missing := MissingPermissions(admin, owner); !slices.Equal(missing, []string{PermOwnersManage}) {
+		t.Errorf("an admin must not manage an owner, missing %v", missing)
+	}
+	if missing := MissingPermissions(owner, admin); len(missing) != 0 {
+		t.Errorf("an owner can manage an admin, missing %v", missing)
+	}
+	if missing := MissingPermissions(admin, nil); len(missing) != 0 {
+		t.Errorf("a user without roles has nothing to protect, missing %v", missing)
+	}
+}
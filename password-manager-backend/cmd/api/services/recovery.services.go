@@ -0,0 +1,62 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+)
+
+const recoverySecretLength = 32
+
+// ErrInvalidRecoveryShares se devuelve siempre igual, falte un trozo o sobre uno
+// falso, para no dar pistas sobre qué parte está mal
+var ErrInvalidRecoveryShares = errors.New("invalid recovery shares")
+
+// NewRecoveryKit genera un secreto aleatorio y lo reparte en `shares` trozos de los
+// que hacen falta `threshold`. Devuelve los trozos codificados, que solo se enseñan
+// una vez, y el hash que se guarda para comprobar la reconstrucción.
+func NewRecoveryKit(shares, threshold int) ([]string, string, error) {
+	secret, err := RandomSecret(recoverySecretLength)
+	if err != nil {
+		return nil, "", err
+	}
+	defer secret.Destroy()
+	parts, err := SplitSecret(secret.Bytes(), shares, threshold)
+	if err != nil {
+		return nil, "", err
+	}
+
+	encoded := make([]string, len(parts))
+	for i, part := range parts {
+		encoded[i] = EncodeShare(part)
+	}
+	return encoded, hashRecoverySecret(secret.Bytes()), nil
+}
+
+// VerifyRecoveryShares reconstruye el secreto con los trozos y lo compara con el hash guardado
+func VerifyRecoveryShares(encoded []string, secretHash string) error {
+	parts := make([][]byte, 0, len(encoded))
+	for _, e := range encoded {
+		part, err := DecodeShare(e)
+		if err != nil {
+			return ErrInvalidRecoveryShares
+		}
+		parts = append(parts, part)
+	}
+	secret, err := CombineShares(parts)
+	defer clear(secret)
+	if err != nil || len(secret) != recoverySecretLength {
+		return ErrInvalidRecoveryShares
+	}
+	if subtle.ConstantTimeCompare([]byte(hashRecoverySecret(secret)), []byte(secretHash)) != 1 {
+		return ErrInvalidRecoveryShares
+	}
+	return nil
+}
+
+// El secreto tiene 256 bits aleatorios, así que basta con SHA-256 (no hace falta un KDF lento)
+func hashRecoverySecret(secret []byte) string {
+	sum := sha256.Sum256(secret)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"time"
+)
+
+const (
+	refreshTokenBytes  = 32
+	refreshTokenPrefix = "rt_"
+)
+
+// AccessTokenTTL es la vida de los JWT de acceso (ACCESS_TOKEN_TTL, por defecto 15m).
+// Son cortos porque no se pueden revocar uno a uno: para seguir se usa el refresh token.
+func AccessTokenTTL() time.Duration {
+	return envDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+}
+
+// RefreshTokenTTL es la vida de cada refresh token (REFRESH_TOKEN_TTL, por defecto 720h)
+func RefreshTokenTTL() time.Duration {
+	return envDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// NewTokenFamily genera el identificador de una sesión nueva. Viaja en el claim
+// "sid" del JWT y agrupa todos los refresh tokens que salen de un mismo login.
+func NewTokenFamily() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// NewRefreshToken genera un refresh token aleatorio y el hash que se guarda en la
+// base de datos. El token solo se enseña una vez, en la respuesta.
+func NewRefreshToken() (string, []byte, error) {
+	raw, err := RandomSecret(refreshTokenBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	defer raw.Destroy()
+	token := refreshTokenPrefix + base64.RawURLEncoding.EncodeToString(raw.Bytes())
+	hash := sha256.Sum256([]byte(token))
+	return token, hash[:], nil
+}
+
+// HashRefreshToken calcula el hash con el que se busca un refresh token. Tiene 256
+// bits aleatorios, así que basta con SHA-256.
+func HashRefreshToken(token *Secret) []byte {
+	sum := sha256.Sum256(token.Bytes())
+	return sum[:]
+}
@@ -0,0 +1,46 @@
+package services
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRefreshToken(t *testing.T) {
+	token, hash, err := NewRefreshToken()
+	if err != nil {
+		t.Fatalf("NewRefreshToken returned error: %v", err)
+	}
+	if !strings.HasPrefix(token, refreshTokenPrefix) {
+		t.Errorf("expected %q prefix, got %q", refreshTokenPrefix, token)
+	}
+	if !bytes.Equal(hash, HashRefreshToken(SecretFromString(token))) {
+		t.Error("stored hash does not match the token")
+	}
+
+	other, otherHash, err := NewRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == token || bytes.Equal(hash, otherHash) {
+		t.Error("expected different tokens")
+	}
+}
+
+func TestTokenTTLs(t *testing.T) {
+	t.Setenv("ACCESS_TOKEN_TTL", "")
+	t.Setenv("REFRESH_TOKEN_TTL", "")
+	if AccessTokenTTL() != 15*time.Minute || RefreshTokenTTL() != 720*time.Hour {
+		t.Error("unexpected default TTLs")
+	}
+
+	t.Setenv("ACCESS_TOKEN_TTL", "5m")
+	t.Setenv("REFRESH_TOKEN_TTL", "-1h")
+	if AccessTokenTTL() != 5*time.Minute {
+		t.Errorf("expected 5m, got %v", AccessTokenTTL())
+	}
+	if RefreshTokenTTL() != 720*time.Hour {
+		t.Errorf("negative TTL must fall back to the default, got %v", RefreshTokenTTL())
+	}
+}
@@ -0,0 +1,568 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Esquemas de SCIM 2.0 (RFC 7643 y 7644)
+const (
+	SCIMSchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMSchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMSchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMSchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMSchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SCIMSchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	// SCIMContentType es el tipo de las respuestas SCIM
+	SCIMContentType = "application/scim+json"
+	// SCIMMinTokenLength es la longitud mínima de SCIM_TOKEN
+	SCIMMinTokenLength = 32
+	// DefaultSCIMCount es el tamaño de página si el IdP no manda count
+	DefaultSCIMCount = MaxPageSize
+)
+
+var (
+	ErrSCIMDisabled     = errors.New("SCIM provisioning is not configured")
+	ErrSCIMInvalidToken = errors.New("invalid SCIM token")
+	ErrSCIMInvalidPage  = errors.New("startIndex and count must be integers")
+	// Estos tres llevan el scimType de la respuesta de error, ver SCIMErrorType
+	ErrSCIMInvalidFilter = errors.New("invalid filter")
+	ErrSCIMInvalidPath   = errors.New("invalid path")
+	ErrSCIMInvalidValue  = errors.New("invalid value")
+)
+
+var (
+	scimMu        sync.RWMutex
+	scimTokenHash []byte
+)
+
+// ConfigureSCIM lee SCIM_TOKEN, el bearer token con el que entra el IdP. Sin él
+// SCIM queda desactivado; uno demasiado corto no deja arrancar.
+func ConfigureSCIM() error {
+	token := strings.TrimSpace(os.Getenv("SCIM_TOKEN"))
+	if token != "" && len(token) < SCIMMinTokenLength {
+		return fmt.Errorf("SCIM_TOKEN must be at least %d characters", SCIMMinTokenLength)
+	}
+	SetSCIMToken(token)
+	return nil
+}
+
+// SetSCIMToken cambia el token de SCIM; vacío lo desactiva. Solo se guarda su hash.
+func SetSCIMToken(token string) {
+	scimMu.Lock()
+	defer scimMu.Unlock()
+	if token == "" {
+		scimTokenHash = nil
+		return
+	}
+	hash := sha256.Sum256([]byte(token))
+	scimTokenHash = hash[:]
+}
+
+// SCIMEnabled indica si hay un token de SCIM configurado
+func SCIMEnabled() bool {
+	scimMu.RLock()
+	defer scimMu.RUnlock()
+	return scimTokenHash != nil
+}
+
+// CheckSCIMToken comprueba el bearer token del IdP en tiempo constante
+func CheckSCIMToken(token string) error {
+	scimMu.RLock()
+	defer scimMu.RUnlock()
+	if scimTokenHash == nil {
+		return ErrSCIMDisabled
+	}
+	hash := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare(hash[:], scimTokenHash) != 1 {
+		return ErrSCIMInvalidToken
+	}
+	return nil
+}
+
+// SCIMError es el cuerpo de las respuestas de error de SCIM
+type SCIMError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+// NewSCIMError crea una respuesta de error; scimType puede ir vacío
+func NewSCIMError(status int, scimType, detail string) SCIMError {
+	return SCIMError{
+		Schemas:  []string{SCIMSchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+// SCIMErrorType es el scimType de los errores de filtros y PATCH
+func SCIMErrorType(err error) string {
+	switch {
+	case errors.Is(err, ErrSCIMInvalidFilter):
+		return "invalidFilter"
+	case errors.Is(err, ErrSCIMInvalidPath):
+		return "invalidPath"
+	default:
+		return "invalidValue"
+	}
+}
+
+// SCIMListResponse es una página de un listado
+type SCIMListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    any      `json:"Resources"`
+}
+
+// SCIMMeta son los metadatos de un recurso
+type SCIMMeta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+}
+
+// SCIMRef apunta a otro recurso: los miembros de un grupo o los grupos de un usuario
+type SCIMRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SCIMUser es el recurso User. Solo se guardan userName, el email principal,
+// externalId y active; el resto de atributos que mande el IdP se ignoran.
+type SCIMUser struct {
+	Schemas     []string    `json:"schemas"`
+	Id          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []SCIMEmail `json:"emails,omitempty"`
+	// nil al crear es true
+	Active *bool `json:"active,omitempty"`
+	// Solo lectura: se cambian desde los grupos
+	Groups []SCIMRef `json:"groups,omitempty"`
+	Meta   *SCIMMeta `json:"meta,omitempty"`
+}
+
+// PrimaryEmail es el email marcado como principal o, si no hay ninguno, el primero
+func (u *SCIMUser) PrimaryEmail() string {
+	for _, email := range u.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(u.Emails) > 0 {
+		return u.Emails[0].Value
+	}
+	return ""
+}
+
+// IsActive indica si la cuenta está activa; sin active lo está
+func (u *SCIMUser) IsActive() bool {
+	return u.Active == nil || *u.Active
+}
+
+// Validate comprueba lo que necesita una cuenta: nombre y un email válido
+func (u *SCIMUser) Validate() error {
+	u.UserName = strings.TrimSpace(u.UserName)
+	if u.UserName == "" || utf8.RuneCountInString(u.UserName) > 255 {
+		return fmt.Errorf("%w: userName is required and must be at most 255 characters", ErrSCIMInvalidValue)
+	}
+	email := u.PrimaryEmail()
+	if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+		return fmt.Errorf("%w: a valid primary email is required", ErrSCIMInvalidValue)
+	}
+	return nil
+}
+
+// SCIMGroup es el recurso Group; cada grupo es un rol
+type SCIMGroup struct {
+	Schemas     []string  `json:"schemas"`
+	Id          string    `json:"id,omitempty"`
+	DisplayName string    `json:"displayName"`
+	Members     []SCIMRef `json:"members,omitempty"`
+	Meta        *SCIMMeta `json:"meta,omitempty"`
+}
+
+// ValidSCIMGroupName comprueba que el nombre vale como nombre de rol: hasta 32
+// caracteres, sin comas (los roles se listan separados por comas) ni de control
+func ValidSCIMGroupName(name string) error {
+	if name == "" || utf8.RuneCountInString(name) > 32 || strings.TrimSpace(name) != name {
+		return fmt.Errorf("%w: displayName must have between 1 and 32 characters", ErrSCIMInvalidValue)
+	}
+	if strings.ContainsFunc(name, func(r rune) bool { return r == ',' || unicode.IsControl(r) }) {
+		return fmt.Errorf("%w: displayName cannot contain commas", ErrSCIMInvalidValue)
+	}
+	return nil
+}
+
+// SCIMPage es una página de un listado SCIM: StartIndex empieza en 1
+type SCIMPage struct {
+	StartIndex int
+	Count      int
+}
+
+// Offset es el OFFSET de SQL de la página
+func (p SCIMPage) Offset() int {
+	return p.StartIndex - 1
+}
+
+// ParseSCIMPage lee startIndex y count. Como dice la RFC, un startIndex menor que 1
+// vale 1 y un count negativo vale 0; count se recorta a MaxPageSize.
+func ParseSCIMPage(startIndex, count string) (SCIMPage, error) {
+	p := SCIMPage{StartIndex: 1, Count: DefaultSCIMCount}
+	if startIndex != "" {
+		n, err := strconv.Atoi(startIndex)
+		if err != nil {
+			return SCIMPage{}, ErrSCIMInvalidPage
+		}
+		p.StartIndex = max(n, 1)
+	}
+	if count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return SCIMPage{}, ErrSCIMInvalidPage
+		}
+		p.Count = min(max(n, 0), MaxPageSize)
+	}
+	return p, nil
+}
+
+// SCIMComparison es una comparación de un filtro. Attribute va en minúsculas y con
+// los filtros de valor aplanados: members[value eq "1"] es members.value eq "1".
+type SCIMComparison struct {
+	Attribute string
+	Operator  string
+	// string, bool o float64; nil con pr
+	Value any
+}
+
+// SCIMFilter son comparaciones unidas con and; vacío no filtra
+type SCIMFilter []SCIMComparison
+
+var (
+	scimValueRe      = `("(?:[^"\\]|\\.)*"|true|false|-?\d+(?:\.\d+)?)`
+	scimComparisonRe = regexp.MustCompile(`^([A-Za-z][\w.:-]*)\s+([A-Za-z]{2})\s+` + scimValueRe)
+	scimValuePathRe  = regexp.MustCompile(`^([A-Za-z][\w:-]*)\[\s*([A-Za-z]\w*)\s+([A-Za-z]{2})\s+` + scimValueRe + `\s*\]`)
+	scimPresentRe    = regexp.MustCompile(`^([A-Za-z][\w.:-]*)\s+(?i:pr)\b`)
+	scimAndRe        = regexp.MustCompile(`^(?i:and)\s+`)
+	scimOperators    = []string{"eq", "ne", "co", "sw", "ew"}
+)
+
+// ParseSCIMFilter lee el parámetro filter. Admite comparaciones eq, ne, co, sw, ew
+// y pr unidas con and, que es lo que usan los IdP; or, not y los paréntesis no.
+func ParseSCIMFilter(filter string) (SCIMFilter, error) {
+	var result SCIMFilter
+	rest := strings.TrimSpace(filter)
+	for rest != "" {
+		if len(result) > 0 {
+			and := scimAndRe.FindString(rest)
+			if and == "" {
+				return nil, fmt.Errorf("%w: expected and at %q", ErrSCIMInvalidFilter, rest)
+			}
+			rest = rest[len(and):]
+		}
+		comparison, n, err := parseSCIMComparison(rest)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, comparison)
+		rest = strings.TrimSpace(rest[n:])
+	}
+	return result, nil
+}
+
+// parseSCIMComparison lee la comparación del principio de text y cuánto ocupa
+func parseSCIMComparison(text string) (SCIMComparison, int, error) {
+	var attribute, operator, value string
+	var n int
+	if m := scimValuePathRe.FindStringSubmatch(text); m != nil {
+		attribute, operator, value, n = m[1]+"."+m[2], m[3], m[4], len(m[0])
+	} else if m := scimComparisonRe.FindStringSubmatch(text); m != nil {
+		attribute, operator, value, n = m[1], m[2], m[3], len(m[0])
+	} else if m := scimPresentRe.FindStringSubmatch(text); m != nil {
+		return SCIMComparison{Attribute: strings.ToLower(m[1]), Operator: "pr"}, len(m[0]), nil
+	} else {
+		return SCIMComparison{}, 0, fmt.Errorf("%w: cannot parse %q", ErrSCIMInvalidFilter, text)
+	}
+
+	operator = strings.ToLower(operator)
+	if !slices.Contains(scimOperators, operator) {
+		return SCIMComparison{}, 0, fmt.Errorf("%w: unsupported operator %s", ErrSCIMInvalidFilter, operator)
+	}
+	var decoded any
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		return SCIMComparison{}, 0, fmt.Errorf("%w: invalid value %s", ErrSCIMInvalidFilter, value)
+	}
+	if _, ok := decoded.(string); !ok && operator != "eq" && operator != "ne" {
+		return SCIMComparison{}, 0, fmt.Errorf("%w: %s needs a string", ErrSCIMInvalidFilter, operator)
+	}
+	return SCIMComparison{Attribute: strings.ToLower(attribute), Operator: operator, Value: decoded}, n, nil
+}
+
+// SQL convierte el filtro en una condición WHERE. columns da la expresión SQL de
+// cada atributo que se puede filtrar; los demás son ErrSCIMInvalidFilter.
+func (f SCIMFilter) SQL(columns map[string]string) (string, []any, error) {
+	var conditions []string
+	var args []any
+	for _, comparison := range f {
+		column, ok := columns[comparison.Attribute]
+		if !ok {
+			return "", nil, fmt.Errorf("%w: cannot filter by %s", ErrSCIMInvalidFilter, comparison.Attribute)
+		}
+		text, _ := comparison.Value.(string)
+		switch comparison.Operator {
+		case "pr":
+			conditions = append(conditions, column+" IS NOT NULL")
+			continue
+		case "eq":
+			conditions = append(conditions, column+" = ?")
+			args = append(args, comparison.Value)
+			continue
+		case "ne":
+			conditions = append(conditions, "NOT ("+column+" <=> ?)")
+			args = append(args, comparison.Value)
+			continue
+		case "co":
+			args = append(args, "%"+likeEscaper.Replace(text)+"%")
+		case "sw":
+			args = append(args, likeEscaper.Replace(text)+"%")
+		case "ew":
+			args = append(args, "%"+likeEscaper.Replace(text))
+		}
+		conditions = append(conditions, column+" LIKE ?")
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// SCIMPatchOperation es una operación de un PATCH. op y path no distinguen
+// mayúsculas: Azure AD manda Replace y Add.
+type SCIMPatchOperation struct {
+	Op    string          `json:"op" binding:"required"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// SCIMPatchRequest es el cuerpo de un PATCH
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations" binding:"required,min=1,dive"`
+}
+
+// ApplySCIMUserPatch aplica las operaciones a user. Los atributos que no se
+// guardan (name, title, extensiones...) se ignoran.
+func ApplySCIMUserPatch(user *SCIMUser, operations []SCIMPatchOperation) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		switch op {
+		case "add", "replace":
+			if operation.Path == "" {
+				// Sin path, value es un objeto con los atributos
+				var attributes map[string]json.RawMessage
+				if err := json.Unmarshal(operation.Value, &attributes); err != nil {
+					return fmt.Errorf("%w: value must be an object without path", ErrSCIMInvalidValue)
+				}
+				for path, value := range attributes {
+					if err := setSCIMUserAttribute(user, path, value); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if err := setSCIMUserAttribute(user, operation.Path, operation.Value); err != nil {
+				return err
+			}
+		case "remove":
+			switch strings.ToLower(operation.Path) {
+			case "externalid":
+				user.ExternalID = ""
+			case "":
+				return fmt.Errorf("%w: remove needs a path", ErrSCIMInvalidPath)
+			case "username", "emails", "active":
+				return fmt.Errorf("%w: %s cannot be removed", ErrSCIMInvalidValue, operation.Path)
+			}
+		default:
+			return fmt.Errorf("%w: unknown op %s", ErrSCIMInvalidValue, operation.Op)
+		}
+	}
+	return nil
+}
+
+// setSCIMUserAttribute cambia un atributo de user
+func setSCIMUserAttribute(user *SCIMUser, path string, value json.RawMessage) error {
+	switch lower := strings.ToLower(path); {
+	case lower == "active":
+		active, err := decodeSCIMBool(value)
+		if err != nil {
+			return err
+		}
+		user.Active = &active
+	case lower == "username":
+		return decodeSCIMString(value, &user.UserName)
+	case lower == "externalid":
+		return decodeSCIMString(value, &user.ExternalID)
+	case lower == "emails":
+		var emails []SCIMEmail
+		if err := json.Unmarshal(value, &emails); err != nil || len(emails) == 0 {
+			return fmt.Errorf("%w: emails must be a non-empty list", ErrSCIMInvalidValue)
+		}
+		user.Emails = emails
+	case lower == "emails.value" || strings.HasPrefix(lower, "emails[") && strings.HasSuffix(lower, "].value"):
+		// Solo se guarda un email: emails[type eq "work"].value cambia el principal
+		var email string
+		if err := decodeSCIMString(value, &email); err != nil {
+			return err
+		}
+		user.Emails = []SCIMEmail{{Value: email, Type: "work", Primary: true}}
+	}
+	return nil
+}
+
+// ApplySCIMGroupPatch aplica las operaciones a group: cambiar displayName y
+// añadir, quitar o sustituir miembros
+func ApplySCIMGroupPatch(group *SCIMGroup, operations []SCIMPatchOperation) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return fmt.Errorf("%w: unknown op %s", ErrSCIMInvalidValue, operation.Op)
+		}
+		if operation.Path == "" {
+			if op == "remove" {
+				return fmt.Errorf("%w: remove needs a path", ErrSCIMInvalidPath)
+			}
+			var attributes map[string]json.RawMessage
+			if err := json.Unmarshal(operation.Value, &attributes); err != nil {
+				return fmt.Errorf("%w: value must be an object without path", ErrSCIMInvalidValue)
+			}
+			for path, value := range attributes {
+				if err := patchSCIMGroupAttribute(group, op, path, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := patchSCIMGroupAttribute(group, op, operation.Path, operation.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchSCIMGroupAttribute aplica una operación sobre un atributo de group
+func patchSCIMGroupAttribute(group *SCIMGroup, op, path string, value json.RawMessage) error {
+	lower := strings.ToLower(path)
+	switch {
+	case lower == "displayname":
+		if op == "remove" {
+			return fmt.Errorf("%w: displayName cannot be removed", ErrSCIMInvalidValue)
+		}
+		return decodeSCIMString(value, &group.DisplayName)
+	case lower == "members":
+		var members []SCIMRef
+		if len(value) > 0 && string(value) != "null" {
+			if err := json.Unmarshal(value, &members); err != nil {
+				return fmt.Errorf("%w: members must be a list", ErrSCIMInvalidValue)
+			}
+		}
+		switch op {
+		case "replace":
+			group.Members = []SCIMRef{}
+			fallthrough
+		case "add":
+			for _, member := range members {
+				if !slices.ContainsFunc(group.Members, func(m SCIMRef) bool { return m.Value == member.Value }) {
+					group.Members = append(group.Members, member)
+				}
+			}
+		case "remove":
+			// Sin value se quitan todos
+			if members == nil {
+				group.Members = []SCIMRef{}
+			}
+			for _, member := range members {
+				removeSCIMMember(group, member.Value)
+			}
+		}
+	case strings.HasPrefix(lower, "members["):
+		// members[value eq "12"]
+		filter, err := ParseSCIMFilter(path)
+		if err != nil || len(filter) != 1 || filter[0].Attribute != "members.value" || filter[0].Operator != "eq" {
+			return fmt.Errorf("%w: %s", ErrSCIMInvalidPath, path)
+		}
+		id, ok := filter[0].Value.(string)
+		if !ok || op != "remove" {
+			return fmt.Errorf("%w: only remove is supported on %s", ErrSCIMInvalidPath, path)
+		}
+		removeSCIMMember(group, id)
+	}
+	return nil
+}
+
+// removeSCIMMember quita un miembro del grupo
+func removeSCIMMember(group *SCIMGroup, id string) {
+	group.Members = slices.DeleteFunc(group.Members, func(m SCIMRef) bool { return m.Value == id })
+}
+
+// decodeSCIMString lee un valor de texto
+func decodeSCIMString(value json.RawMessage, target *string) error {
+	if err := json.Unmarshal(value, target); err != nil {
+		return fmt.Errorf("%w: expected a string", ErrSCIMInvalidValue)
+	}
+	return nil
+}
+
+// decodeSCIMBool lee un booleano. Azure AD manda "True" y "False" como texto.
+func decodeSCIMBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var text string
+	if err := json.Unmarshal(value, &text); err == nil {
+		if b, err := strconv.ParseBool(text); err == nil {
+			return b, nil
+		}
+	}
+	return false, fmt.Errorf("%w: expected a boolean", ErrSCIMInvalidValue)
+}
+
+// SCIMServiceProviderConfig describe lo que admite este servidor SCIM
+func SCIMServiceProviderConfig() map[string]any {
+	return map[string]any{
+		"schemas":        []string{SCIMSchemaServiceProviderConfig},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]any{"supported": true, "maxResults": MaxPageSize},
+		"changePassword": map[string]bool{"supported": false},
+		"sort":           map[string]bool{"supported": false},
+		"etag":           map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]any{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "El token de SCIM_TOKEN en la cabecera Authorization",
+			"primary":     true,
+		}},
+	}
+}
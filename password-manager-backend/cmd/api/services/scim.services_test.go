@@ -0,0 +1,201 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheckSCIMToken(t *testing.T) {
+	defer SetSCIMToken("")
+
+	SetSCIMToken("")
+	if SCIMEnabled() {
+		t.Error("SCIM should be disabled without a token")
+	}
+	if err := CheckSCIMToken("anything"); !errors.Is(err, ErrSCIMDisabled) {
+		t.Fatalf("expected ErrSCIMDisabled, got %v", err)
+	}
+	token := strings.Repeat("s", SCIMMinTokenLength)
+	SetSCIMToken(token)
+	if err := CheckSCIMToken(token); err != nil {
+		t.Fatalf("valid token rejected: %v", err)
+	}
+	if err := CheckSCIMToken(token + "x"); !errors.Is(err, ErrSCIMInvalidToken) {
+		t.Errorf("expected ErrSCIMInvalidToken, got %v", err)
+	}
+
+	t.Setenv("SCIM_TOKEN", "short")
+	if err := ConfigureSCIM(); err == nil {
+		t.Error("expected an error with a short SCIM_TOKEN")
+	}
+}
+
+func TestParseSCIMPage(t *testing.T) {
+	cases := []struct {
+		startIndex, count string
+		want              SCIMPage
+	}{
+		{"", "", SCIMPage{StartIndex: 1, Count: DefaultSCIMCount}},
+		{"0", "-5", SCIMPage{StartIndex: 1, Count: 0}},
+		{"21", "10", SCIMPage{StartIndex: 21, Count: 10}},
+		{"1", "5000", SCIMPage{StartIndex: 1, Count: MaxPageSize}},
+	}
+	for _, c := range cases {
+		got, err := ParseSCIMPage(c.startIndex, c.count)
+		if err != nil {
+			t.Fatalf("ParseSCIMPage(%q, %q): %v", c.startIndex, c.count, err)
+		}
+		if got != c.want {
+			t.Errorf("ParseSCIMPage(%q, %q) = %+v, want %+v", c.startIndex, c.count, got, c.want)
+		}
+	}
+	if got := (SCIMPage{StartIndex: 21, Count: 10}).Offset(); got != 20 {
+		t.Errorf("expected offset 20, got %d", got)
+	}
+	if _, err := ParseSCIMPage("x", ""); !errors.Is(err, ErrSCIMInvalidPage) {
+		t.Errorf("expected ErrSCIMInvalidPage, got %v", err)
+	}
+}
+
+func TestParseSCIMFilter(t *testing.T) {
+	cases := map[string]SCIMFilter{
+		``:                         nil,
+		`userName eq "alice"`:      {{Attribute: "username", Operator: "eq", Value: "alice"}},
+		`userName Eq "say \"hi\""`: {{Attribute: "username", Operator: "eq", Value: `say "hi"`}},
+		`active eq false`:          {{Attribute: "active", Operator: "eq", Value: false}},
+		`externalId pr`:            {{Attribute: "externalid", Operator: "pr"}},
+		`emails[type eq "work"]`:   {{Attribute: "emails.type", Operator: "eq", Value: "work"}},
+		`userName sw "a" and emails.value co "@corp"`: {
+			{Attribute: "username", Operator: "sw", Value: "a"},
+			{Attribute: "emails.value", Operator: "co", Value: "@corp"},
+		},
+	}
+	for filter, want := range cases {
+		got, err := ParseSCIMFilter(filter)
+		if err != nil {
+			t.Fatalf("ParseSCIMFilter(%q): %v", filter, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseSCIMFilter(%q) = %+v, want %+v", filter, got, want)
+		}
+	}
+	for _, bad := range []string{
+		`userName`,
+		`userName eq alice`,
+		`userName gt "a"`,
+		`userName co true`,
+		`userName eq "a" or userName eq "b"`,
+		`(userName eq "a")`,
+	} {
+		if _, err := ParseSCIMFilter(bad); !errors.Is(err, ErrSCIMInvalidFilter) {
+			t.Errorf("ParseSCIMFilter(%q): expected ErrSCIMInvalidFilter, got %v", bad, err)
+		}
+	}
+}
+
+func TestSCIMFilterSQL(t *testing.T) {
+	columns := map[string]string{"username": "username", "active": "(disabled_at IS NULL)"}
+
+	filter, _ := ParseSCIMFilter(`userName co "a_b" and active eq true`)
+	where, args, err := filter.SQL(columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if where != "username LIKE ? AND (disabled_at IS NULL) = ?" {
+		t.Errorf("unexpected condition %q", where)
+	}
+	if !reflect.DeepEqual(args, []any{`%a\_b%`, true}) {
+		t.Errorf("unexpected args %v", args)
+	}
+
+	filter, _ = ParseSCIMFilter(`title eq "boss"`)
+	if _, _, err := filter.SQL(columns); !errors.Is(err, ErrSCIMInvalidFilter) {
+		t.Errorf("expected ErrSCIMInvalidFilter for an unknown attribute, got %v", err)
+	}
+}
+
+func patchOperations(t *testing.T, body string) []SCIMPatchOperation {
+	t.Helper()
+	var req SCIMPatchRequest
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		t.Fatal(err)
+	}
+	return req.Operations
+}
+
+func TestApplySCIMUserPatch(t *testing.T) {
+	user := SCIMUser{UserName: "alice", ExternalID: "00u1", Emails: []SCIMEmail{{Value: "alice@example.com", Primary: true}}}
+
+	// Como lo manda Azure AD: op con mayúscula, active como texto y atributos sin path
+	err := ApplySCIMUserPatch(&user, patchOperations(t, `{"Operations": [
+		{"op": "Replace", "path": "active", "value": "False"},
+		{"op": "replace", "value": {"userName": "alice.smith", "name.givenName": "Alice"}},
+		{"op": "add", "path": "emails[type eq \"work\"].value", "value": "alice.smith@example.com"},
+		{"op": "remove", "path": "externalId"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.IsActive() {
+		t.Error("expected the user to be inactive")
+	}
+	if user.UserName != "alice.smith" || user.PrimaryEmail() != "alice.smith@example.com" || user.ExternalID != "" {
+		t.Errorf("unexpected user %+v", user)
+	}
+	if err := user.Validate(); err != nil {
+		t.Errorf("patched user should be valid: %v", err)
+	}
+
+	for _, bad := range []string{
+		`{"Operations": [{"op": "replace", "path": "active", "value": "maybe"}]}`,
+		`{"Operations": [{"op": "remove", "path": "userName"}]}`,
+		`{"Operations": [{"op": "move", "path": "userName", "value": "x"}]}`,
+	} {
+		if err := ApplySCIMUserPatch(&user, patchOperations(t, bad)); !errors.Is(err, ErrSCIMInvalidValue) {
+			t.Errorf("%s: expected ErrSCIMInvalidValue, got %v", bad, err)
+		}
+	}
+}
+
+func TestApplySCIMGroupPatch(t *testing.T) {
+	group := SCIMGroup{DisplayName: "finance", Members: []SCIMRef{{Value: "1"}, {Value: "2"}}}
+
+	err := ApplySCIMGroupPatch(&group, patchOperations(t, `{"Operations": [
+		{"op": "add", "path": "members", "value": [{"value": "2"}, {"value": "3"}]},
+		{"op": "remove", "path": "members[value eq \"1\"]"},
+		{"op": "Remove", "path": "members", "value": [{"value": "3"}]},
+		{"op": "replace", "value": {"displayName": "finance-eu"}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.DisplayName != "finance-eu" || !reflect.DeepEqual(group.Members, []SCIMRef{{Value: "2"}}) {
+		t.Errorf("unexpected group %+v", group)
+	}
+
+	err = ApplySCIMGroupPatch(&group, patchOperations(t, `{"Operations": [{"op": "remove", "path": "members"}]}`))
+	if err != nil || len(group.Members) != 0 {
+		t.Errorf("remove without value should empty the group: %v %+v", err, group.Members)
+	}
+
+	err = ApplySCIMGroupPatch(&group, patchOperations(t, `{"Operations": [{"op": "replace", "path": "members[value eq \"2\"]", "value": []}]}`))
+	if !errors.Is(err, ErrSCIMInvalidPath) {
+		t.Errorf("expected ErrSCIMInvalidPath, got %v", err)
+	}
+}
+
+func TestValidSCIMGroupName(t *testing.T) {
+	for _, name := range []string{"finance", "Finance Team (EU)"} {
+		if err := ValidSCIMGroupName(name); err != nil {
+			t.Errorf("ValidSCIMGroupName(%q): %v", name, err)
+		}
+	}
+	for _, name := range []string{"", " padded", "a,b", strings.Repeat("x", 33), "tab\tname"} {
+		if err := ValidSCIMGroupName(name); err == nil {
+			t.Errorf("ValidSCIMGroupName(%q): expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"unicode/utf8"
+)
+
+// Secret guarda material sensible (contraseñas, claves) fuera del heap de Go: en
+// memoria propia bloqueada con mlock para que no acabe en swap, que se pone a cero
+// con Destroy. Al imprimirlo con fmt o serializarlo a JSON sale "[REDACTED]".
+//
+// Un Secret no es seguro para usarlo desde varias goroutines a la vez, y después de
+// Destroy Bytes devuelve nil. Si nadie llama a Destroy lo hace el recolector.
+type Secret struct {
+	buf    []byte // región reservada entera, puede ser mayor que el secreto
+	length int
+	mapped bool // la región viene de mmap y hay que liberarla a mano
+	locked bool
+}
+
+const redactedSecret = "[REDACTED]"
+
+var errNotJSONString = errors.New("secret must be a JSON string")
+
+func newSecret(length int) *Secret {
+	s := &Secret{}
+	s.init(length)
+	return s
+}
+
+// init reserva la memoria para un secreto de length bytes. s tiene que ser el
+// inicio de su propia reserva (un *Secret), por el finalizer.
+func (s *Secret) init(length int) {
+	s.Destroy()
+	s.length = length
+	s.buf, s.mapped, s.locked = allocSecretMemory(length)
+	runtime.SetFinalizer(s, (*Secret).Destroy)
+}
+
+// NewSecret copia b a memoria protegida y pone b a cero: el llamador no debe
+// seguir usándolo
+func NewSecret(b []byte) *Secret {
+	s := newSecret(len(b))
+	copy(s.buf, b)
+	clear(b)
+	return s
+}
+
+// SecretFromString crea un Secret a partir de un string. El string original no se
+// puede borrar, así que solo tiene sentido para valores que ya estaban en memoria
+// (variables de entorno, tests).
+func SecretFromString(value string) *Secret {
+	s := newSecret(len(value))
+	copy(s.buf, value)
+	return s
+}
+
+// RandomSecret genera n bytes aleatorios directamente en memoria protegida
+func RandomSecret(n int) (*Secret, error) {
+	s := newSecret(n)
+	if _, err := rand.Read(s.buf[:n]); err != nil {
+		s.Destroy()
+		return nil, err
+	}
+	return s, nil
+}
+
+// secretFromBase64 decodifica base64 directamente en memoria protegida
+func secretFromBase64(encoded []byte) (*Secret, error) {
+	s := newSecret(base64.StdEncoding.DecodedLen(len(encoded)))
+	n, err := base64.StdEncoding.Decode(s.buf, encoded)
+	if err != nil {
+		s.Destroy()
+		return nil, err
+	}
+	s.length = n
+	return s, nil
+}
+
+// Bytes da acceso al contenido sin copiarlo. No hay que guardarlo más allá de la
+// vida del Secret.
+func (s *Secret) Bytes() []byte {
+	if s == nil || s.buf == nil {
+		return nil
+	}
+	return s.buf[:s.length]
+}
+
+// Len es la longitud en bytes
+func (s *Secret) Len() int {
+	if s == nil || s.buf == nil {
+		return 0
+	}
+	return s.length
+}
+
+// RuneCount es la longitud en caracteres, para validar contraseñas igual que antes
+func (s *Secret) RuneCount() int {
+	return utf8.RuneCount(s.Bytes())
+}
+
+// IsEmpty indica si no hay secreto (nil, vacío o destruido)
+func (s *Secret) IsEmpty() bool {
+	return s.Len() == 0
+}
+
+// Equal compara en tiempo constante
+func (s *Secret) Equal(other *Secret) bool {
+	return subtle.ConstantTimeCompare(s.Bytes(), other.Bytes()) == 1
+}
+
+// Destroy pone la memoria a cero y la libera. Se puede llamar varias veces y sobre nil.
+func (s *Secret) Destroy() {
+	if s == nil || s.buf == nil {
+		return
+	}
+	clear(s.buf)
+	if s.mapped {
+		freeSecretMemory(s.buf, s.locked)
+	}
+	s.buf, s.length = nil, 0
+	runtime.SetFinalizer(s, nil)
+}
+
+func (s *Secret) String() string   { return redactedSecret }
+func (s *Secret) GoString() string { return redactedSecret }
+
+// Format hace que %v, %+v, %#v, %s, %q, %x... salgan todos censurados
+func (s *Secret) Format(f fmt.State, verb rune) {
+	f.Write([]byte(redactedSecret))
+}
+
+func (s *Secret) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redactedSecret + `"`), nil
+}
+
+// UnmarshalJSON lee un string JSON directamente a memoria protegida, así las
+// contraseñas de los bodies no pasan por un string de Go. Los campos de las
+// peticiones tienen que ser *Secret.
+func (s *Secret) UnmarshalJSON(data []byte) error {
+	if len(data) < 2 || data[0] != '"' || data[len(data)-1] != '"' {
+		return errNotJSONString
+	}
+	raw := data[1 : len(data)-1]
+	if containsByte(raw, '\\') {
+		// Con secuencias de escape dejamos que las resuelva encoding/json
+		var unescaped string
+		if err := json.Unmarshal(data, &unescaped); err != nil {
+			return err
+		}
+		s.init(len(unescaped))
+		copy(s.buf, unescaped)
+		return nil
+	}
+	s.init(len(raw))
+	copy(s.buf, raw)
+	return nil
+}
+
+func containsByte(b []byte, c byte) bool {
+	for _, x := range b {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
+
+// Exposed envuelve el secreto para una respuesta JSON que sí debe llevar el valor
+// (por ejemplo /notes/:id/secret). Escribe el JSON sin pasar por un string.
+func (s *Secret) Exposed() ExposedSecret {
+	return ExposedSecret{secret: s}
+}
+
+type ExposedSecret struct {
+	secret *Secret
+}
+
+func (e ExposedSecret) MarshalJSON() ([]byte, error) {
+	const hex = "0123456789abcdef"
+	value := e.secret.Bytes()
+	out := make([]byte, 0, len(value)+2)
+	out = append(out, '"')
+	for _, c := range value {
+		switch {
+		case c == '"' || c == '\\':
+			out = append(out, '\\', c)
+		case c < 0x20 || c == '<' || c == '>' || c == '&':
+			out = append(out, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xf])
+		default:
+			out = append(out, c)
+		}
+	}
+	return append(out, '"'), nil
+}
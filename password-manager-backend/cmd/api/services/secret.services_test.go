@@ -0,0 +1,133 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSecretIsRedacted(t *testing.T) {
+	s := SecretFromString("hunter2-hunter2")
+	defer s.Destroy()
+
+	body := struct {
+		Email    string
+		Password *Secret
+	}{Email: "a@b.com", Password: s}
+
+	for _, format := range []string{"%v", "%+v", "%#v", "%s", "%q", "%x"} {
+		if out := fmt.Sprintf(format, body); strings.Contains(out, "hunter2") {
+			t.Errorf("%s leaks the secret: %s", format, out)
+		}
+		if out := fmt.Sprintf(format, s); strings.Contains(out, "hunter2") || strings.Contains(out, "68756e746572") {
+			t.Errorf("%s leaks the secret: %s", format, out)
+		}
+	}
+
+	out, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "hunter2") || !strings.Contains(string(out), redactedSecret) {
+		t.Errorf("json leaks the secret: %s", out)
+	}
+}
+
+func TestSecretUnmarshalJSON(t *testing.T) {
+	cases := map[string]string{
+		`"s3cr3t-p4ss"`:            "s3cr3t-p4ss",
+		`"with \"quotes\" and \\"`: `with "quotes" and \`,
+		`"ñandú"`:                  "ñandú",
+	}
+	for input, want := range cases {
+		var body struct {
+			Password *Secret `json:"password"`
+		}
+		if err := json.Unmarshal([]byte(`{"password":`+input+`}`), &body); err != nil {
+			t.Fatalf("Unmarshal(%s) returned error: %v", input, err)
+		}
+		if got := string(body.Password.Bytes()); got != want {
+			t.Errorf("Unmarshal(%s) = %q, want %q", input, got, want)
+		}
+		body.Password.Destroy()
+	}
+
+	var body struct {
+		Password *Secret `json:"password"`
+	}
+	if err := json.Unmarshal([]byte(`{"password":123}`), &body); err == nil {
+		t.Error("expected error for a non string secret")
+	}
+}
+
+func TestSecretExposedEscapes(t *testing.T) {
+	s := SecretFromString("a\"b\\c<d>\n")
+	defer s.Destroy()
+
+	out, err := json.Marshal(map[string]any{"password": s.Exposed()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]string
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatalf("Exposed produced invalid JSON %s: %v", out, err)
+	}
+	if decoded["password"] != "a\"b\\c<d>\n" {
+		t.Errorf("expected the original value, got %q", decoded["password"])
+	}
+}
+
+func TestSecretDestroy(t *testing.T) {
+	// Una región de mmap ya no se puede leer después de Destroy, así que
+	// comprobamos el borrado con memoria normal
+	buf := []byte("s3cr3t-p4ss")
+	s := &Secret{buf: buf, length: len(buf)}
+	s.Destroy()
+
+	for _, b := range buf {
+		if b != 0 {
+			t.Fatal("secret memory was not zeroed")
+		}
+	}
+	if !s.IsEmpty() || s.Bytes() != nil {
+		t.Error("a destroyed secret must be empty")
+	}
+	s.Destroy() // dos veces no falla
+	var nilSecret *Secret
+	nilSecret.Destroy()
+}
+
+func TestNewSecretClearsInput(t *testing.T) {
+	input := []byte("s3cr3t-p4ss")
+	s := NewSecret(input)
+	defer s.Destroy()
+
+	if string(s.Bytes()) != "s3cr3t-p4ss" {
+		t.Errorf("unexpected secret %q", s.Bytes())
+	}
+	for _, b := range input {
+		if b != 0 {
+			t.Fatal("NewSecret must zero its input")
+		}
+	}
+}
+
+func TestSecretEqual(t *testing.T) {
+	a := SecretFromString("same")
+	b := SecretFromString("same")
+	c := SecretFromString("other")
+	defer a.Destroy()
+	defer b.Destroy()
+	defer c.Destroy()
+
+	if !a.Equal(b) {
+		t.Error("expected equal secrets")
+	}
+	if a.Equal(c) || a.Equal(nil) {
+		t.Error("expected different secrets")
+	}
+	if a.RuneCount() != 4 || SecretFromString("ñandú").RuneCount() != 5 {
+		t.Error("unexpected rune count")
+	}
+}
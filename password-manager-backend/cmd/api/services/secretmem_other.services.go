@@ -0,0 +1,10 @@
+//go:build !unix
+
+package services
+
+// Sin mmap/mlock usamos memoria normal; Destroy la sigue poniendo a cero
+func allocSecretMemory(length int) (buf []byte, mapped bool, locked bool) {
+	return make([]byte, length), false, false
+}
+
+func freeSecretMemory(buf []byte, locked bool) {}
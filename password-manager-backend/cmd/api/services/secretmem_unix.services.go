@@ -0,0 +1,40 @@
+//go:build unix
+
+package services
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+var mlockWarning sync.Once
+
+// allocSecretMemory reserva páginas con mmap fuera del heap y las bloquea con mlock.
+// Si el límite RLIMIT_MEMLOCK no da para más se usan sin bloquear, avisando una vez.
+func allocSecretMemory(length int) (buf []byte, mapped bool, locked bool) {
+	pageSize := os.Getpagesize()
+	size := (max(length, 1) + pageSize - 1) / pageSize * pageSize
+
+	buf, err := unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return make([]byte, length), false, false
+	}
+	if err := unix.Mlock(buf); err != nil {
+		mlockWarning.Do(func() {
+			log.Printf("⚠️ mlock failed (%v), secrets may be swapped to disk; raise RLIMIT_MEMLOCK", err)
+		})
+		return buf[:length:size], true, false
+	}
+	return buf[:length:size], true, true
+}
+
+func freeSecretMemory(buf []byte, locked bool) {
+	buf = buf[:cap(buf)]
+	if locked {
+		unix.Munlock(buf)
+	}
+	unix.Munmap(buf)
+}
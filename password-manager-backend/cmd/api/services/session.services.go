@@ -0,0 +1,148 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Modo sesión por cookies: el JWT va en una cookie HttpOnly que JavaScript no puede
+// leer, y las peticiones que cambian algo llevan además el token CSRF de la cookie
+// legible en la cabecera X-CSRF-Token (double-submit). Otra web puede hacer que el
+// navegador envíe las cookies, pero no puede leerlas para copiar el token.
+const (
+	SessionCookieName = "pm_session"
+	RefreshCookieName = "pm_refresh"
+	CSRFCookieName    = "pm_csrf"
+	CSRFHeaderName    = "X-CSRF-Token"
+
+	// El refresh token solo se envía a las rutas que lo usan
+	RefreshCookiePath = "/api/v1/users/auth"
+)
+
+var (
+	ErrMissingBearer = errors.New("missing bearer token")
+	ErrInvalidBearer = errors.New("authorization header must be Bearer <token>")
+)
+
+// ParseBearerToken extrae el token de una cabecera "Authorization: Bearer <token>".
+// El esquema no distingue mayúsculas, como dice el RFC 6750.
+func ParseBearerToken(header string) (string, error) {
+	header = strings.TrimSpace(header)
+	if header == "" {
+		return "", ErrMissingBearer
+	}
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", ErrInvalidBearer
+	}
+	token = strings.TrimSpace(token)
+	if token == "" || strings.ContainsAny(token, " \t") {
+		return "", ErrInvalidBearer
+	}
+	return token, nil
+}
+
+// CookieSecure indica si las cookies de sesión llevan Secure (COOKIE_SECURE, por
+// defecto sí). Los navegadores las aceptan en http://localhost igualmente.
+func CookieSecure() bool {
+	return os.Getenv("COOKIE_SECURE") != "false"
+}
+
+// NewCSRFToken genera el token CSRF de una sesión por cookies
+func NewCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ValidCSRF compara en tiempo constante el token de la cabecera con el de la cookie
+func ValidCSRF(cookie, header string) bool {
+	if cookie == "" || header == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
+
+// IsSafeMethod indica si el método HTTP no cambia nada y no necesita CSRF
+func IsSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// SessionTouchInterval es cada cuánto se guarda como mucho la última actividad de
+// una sesión, para no escribir en la base de datos en cada petición
+const SessionTouchInterval = time.Minute
+
+// maxUserAgentLength es lo que se guarda del User-Agent de una sesión
+const maxUserAgentLength = 512
+
+// TruncateUserAgent recorta el User-Agent a lo que cabe en la tabla de sesiones
+func TruncateUserAgent(userAgent string) string {
+	if len(userAgent) <= maxUserAgentLength {
+		return userAgent
+	}
+	// Sin cortar una runa UTF-8 por la mitad
+	cut := maxUserAgentLength
+	for cut > 0 && !utf8.RuneStart(userAgent[cut]) {
+		cut--
+	}
+	return userAgent[:cut]
+}
+
+// DeviceName resume el User-Agent en un nombre legible, p. ej. "Firefox en Linux".
+// El orden importa: Edge y Opera también dicen Chrome, y Chrome también dice Safari.
+func DeviceName(userAgent string) string {
+	browsers := []struct{ token, name string }{
+		{"Edg", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	systems := []struct{ token, name string }{
+		{"Windows", "Windows"},
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"CrOS", "ChromeOS"},
+		{"Macintosh", "macOS"},
+		{"Linux", "Linux"},
+	}
+	var browser, system string
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, s := range systems {
+		if strings.Contains(userAgent, s.token) {
+			system = s.name
+			break
+		}
+	}
+	switch {
+	case browser != "" && system != "":
+		return browser + " en " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return "Dispositivo desconocido"
+	}
+}
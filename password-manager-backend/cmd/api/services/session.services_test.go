@@ -0,0 +1,73 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBearerToken(t *testing.T) {
+	cases := []struct {
+		header string
+		token  string
+		err    error
+	}{
+		{"Bearer abc.def.ghi", "abc.def.ghi", nil},
+		{"bearer abc.def.ghi", "abc.def.ghi", nil},
+		{"  Bearer   abc.def.ghi  ", "abc.def.ghi", nil},
+		{"", "", ErrMissingBearer},
+		{"abc.def.ghi", "", ErrInvalidBearer},
+		{"Basic dXNlcjpwYXNz", "", ErrInvalidBearer},
+		{"Bearer ", "", ErrInvalidBearer},
+		{"Bearer abc def", "", ErrInvalidBearer},
+	}
+	for _, tc := range cases {
+		token, err := ParseBearerToken(tc.header)
+		if token != tc.token || err != tc.err {
+			t.Errorf("ParseBearerToken(%q) = %q, %v; want %q, %v", tc.header, token, err, tc.token, tc.err)
+		}
+	}
+}
+
+func TestValidCSRF(t *testing.T) {
+	token, err := NewCSRFToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ValidCSRF(token, token) {
+		t.Error("expected matching tokens to be valid")
+	}
+	if ValidCSRF(token, token+"x") || ValidCSRF("", "") || ValidCSRF(token, "") {
+		t.Error("expected mismatching or empty tokens to be invalid")
+	}
+}
+
+func TestDeviceName(t *testing.T) {
+	cases := []struct {
+		userAgent string
+		want      string
+	}{
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36", "Chrome en Windows"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36 Edg/126.0.0.0", "Edge en Windows"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0", "Firefox en Linux"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_5) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.5 Safari/605.1.15", "Safari en macOS"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/126.0 Mobile/15E148 Safari/604.1", "Chrome en iPhone"},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Mobile Safari/537.36", "Chrome en Android"},
+		{"curl/8.8.0", "curl"},
+		{"", "Dispositivo desconocido"},
+	}
+	for _, tc := range cases {
+		if got := DeviceName(tc.userAgent); got != tc.want {
+			t.Errorf("DeviceName(%q) = %q, want %q", tc.userAgent, got, tc.want)
+		}
+	}
+}
+
+func TestTruncateUserAgent(t *testing.T) {
+	long := strings.Repeat("a", 511) + "ñ"
+	if got := TruncateUserAgent(long); got != strings.Repeat("a", 511) {
+		t.Errorf("expected the cut before the multi-byte rune, got %d bytes", len(got))
+	}
+	if got := TruncateUserAgent("curl/8.8.0"); got != "curl/8.8.0" {
+		t.Errorf("short user agents must not change, got %q", got)
+	}
+}
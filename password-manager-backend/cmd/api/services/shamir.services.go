@@ -0,0 +1,160 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Reparto de secretos de Shamir sobre GF(256): el secreto se parte en M trozos y
+// con N cualesquiera de ellos se reconstruye, con menos no se sabe nada de él.
+// Cada byte del secreto es el término independiente de un polinomio aleatorio de
+// grado N-1 y cada trozo es ese polinomio evaluado en un x distinto.
+
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+)
+
+func init() {
+	// Tablas de logaritmos con generador 3 y el polinomio de AES (x^8+x^4+x^3+x+1)
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfLog[x] = byte(i)
+		x = gfMulSlow(x, 3)
+	}
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+func gfMulSlow(a, b byte) byte {
+	var p byte
+	for b > 0 {
+		if b&1 == 1 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return p
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// SplitSecret parte el secreto en `shares` trozos de los que hacen falta `threshold`
+func SplitSecret(secret []byte, shares, threshold int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("secret must not be empty")
+	}
+	if threshold < 2 || shares < threshold || shares > 255 {
+		return nil, errors.New("threshold must be at least 2 and not greater than shares (max 255)")
+	}
+
+	// Cada trozo es [x, y1, y2, ...] con x de 1 a shares
+	out := make([][]byte, shares)
+	for i := range out {
+		out[i] = make([]byte, len(secret)+1)
+		out[i][0] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold)
+	for b, s := range secret {
+		coefficients[0] = s
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, err
+		}
+		for i := range out {
+			x := out[i][0]
+			// Horner: ((a_{n-1}*x + a_{n-2})*x + ...)*x + a_0
+			var y byte
+			for c := threshold - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coefficients[c]
+			}
+			out[i][b+1] = y
+		}
+	}
+	return out, nil
+}
+
+// CombineShares reconstruye el secreto interpolando en x=0. Con menos trozos de los
+// necesarios devuelve bytes que no son el secreto, por eso hay que verificarlo aparte.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least two shares are required")
+	}
+	length := len(shares[0])
+	if length < 2 {
+		return nil, errors.New("invalid share")
+	}
+	seen := make(map[byte]bool)
+	for _, share := range shares {
+		if len(share) != length {
+			return nil, errors.New("shares have different lengths")
+		}
+		if share[0] == 0 || seen[share[0]] {
+			return nil, errors.New("duplicate or invalid share index")
+		}
+		seen[share[0]] = true
+	}
+
+	secret := make([]byte, length-1)
+	for b := range secret {
+		var value byte
+		for i, si := range shares {
+			// Base de Lagrange en 0: prod x_j / (x_j - x_i), en GF(256) la resta es xor
+			basis := byte(1)
+			for j, sj := range shares {
+				if i == j {
+					continue
+				}
+				basis = gfMul(basis, gfDiv(sj[0], sj[0]^si[0]))
+			}
+			value ^= gfMul(si[b+1], basis)
+		}
+		secret[b] = value
+	}
+	return secret, nil
+}
+
+// EncodeShare convierte un trozo a texto imprimible: "<índice>-<hex>"
+func EncodeShare(share []byte) string {
+	return fmt.Sprintf("%d-%s", share[0], hex.EncodeToString(share[1:]))
+}
+
+// DecodeShare es la inversa de EncodeShare
+func DecodeShare(encoded string) ([]byte, error) {
+	index, data, ok := strings.Cut(strings.TrimSpace(encoded), "-")
+	if !ok {
+		return nil, errors.New("invalid share format")
+	}
+	x, err := strconv.Atoi(index)
+	if err != nil || x < 1 || x > 255 {
+		return nil, errors.New("invalid share index")
+	}
+	y, err := hex.DecodeString(strings.ToLower(data))
+	if err != nil || len(y) == 0 {
+		return nil, errors.New("invalid share data")
+	}
+	return append([]byte{byte(x)}, y...), nil
+}
@@ -0,0 +1,93 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestSplitAndCombineShares(t *testing.T) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		t.Fatal(err)
+	}
+
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("SplitSecret returned error: %v", err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("expected 5 shares, got %d", len(shares))
+	}
+
+	// Cualquier combinación de 3 trozos reconstruye el secreto
+	for _, combo := range [][]int{{0, 1, 2}, {0, 2, 4}, {4, 3, 1}, {1, 2, 3, 4}} {
+		var subset [][]byte
+		for _, i := range combo {
+			encoded := EncodeShare(shares[i])
+			decoded, err := DecodeShare(encoded)
+			if err != nil {
+				t.Fatalf("DecodeShare(%q) returned error: %v", encoded, err)
+			}
+			subset = append(subset, decoded)
+		}
+		got, err := CombineShares(subset)
+		if err != nil {
+			t.Fatalf("CombineShares%v returned error: %v", combo, err)
+		}
+		if !bytes.Equal(got, secret) {
+			t.Errorf("CombineShares%v did not rebuild the secret", combo)
+		}
+	}
+
+	// Con menos del umbral sale otra cosa
+	got, err := CombineShares(shares[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(got, secret) {
+		t.Error("two shares should not be enough to rebuild the secret")
+	}
+}
+
+func TestSplitSecretRejectsBadThreshold(t *testing.T) {
+	if _, err := SplitSecret([]byte("secret"), 3, 4); err == nil {
+		t.Error("expected error when threshold is greater than shares")
+	}
+	if _, err := SplitSecret([]byte("secret"), 3, 1); err == nil {
+		t.Error("expected error when threshold is 1")
+	}
+}
+
+func TestCombineSharesRejectsDuplicates(t *testing.T) {
+	shares, err := SplitSecret([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CombineShares([][]byte{shares[0], shares[0]}); err == nil {
+		t.Error("expected error for duplicated shares")
+	}
+}
+
+func TestRecoveryKit(t *testing.T) {
+	shares, hash, err := NewRecoveryKit(5, 3)
+	if err != nil {
+		t.Fatalf("NewRecoveryKit returned error: %v", err)
+	}
+
+	if err := VerifyRecoveryShares([]string{shares[4], shares[0], shares[2]}, hash); err != nil {
+		t.Errorf("expected valid shares, got %v", err)
+	}
+	if err := VerifyRecoveryShares(shares[:2], hash); err != ErrInvalidRecoveryShares {
+		t.Errorf("expected ErrInvalidRecoveryShares with two shares, got %v", err)
+	}
+
+	// Un trozo de otro kit no sirve
+	other, _, err := NewRecoveryKit(5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyRecoveryShares([]string{shares[0], shares[1], other[2]}, hash); err != ErrInvalidRecoveryShares {
+		t.Errorf("expected ErrInvalidRecoveryShares with a foreign share, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"strings"
+	"time"
+)
+
+// Limitador de intentos de contraseña (login, segundo factor, verify-password y
+// kits de recuperación).
+// Se cuentan los fallos por cuenta y por IP: los primeros son gratis, después
+// cada intento tiene que esperar el doble que el anterior y al llegar a
+// MaxAttempts la clave queda bloqueada LockoutDuration.
+const (
+	throttleFreeAttempts = 3
+	throttleBaseDelay    = time.Second
+	// Sin fallos durante este tiempo el contador vuelve a empezar
+	throttleWindow = 24 * time.Hour
+)
+
+// Ámbitos del limitador. La cuenta de login va por email normalizado, exista o
+// no, para que el bloqueo no delate qué emails están registrados.
+const (
+	ThrottleScopeLogin    = "login"
+	ThrottleScopeMFA      = "mfa"
+	ThrottleScopeNote     = "note"
+	ThrottleScopeRecovery = "recovery"
+	ThrottleScopeIP       = "ip"
+)
+
+// ThrottlePolicy son los límites de un ámbito
+type ThrottlePolicy struct {
+	MaxAttempts     int
+	LockoutDuration time.Duration
+}
+
+// ThrottleState es lo que se sabe de una clave: fallos seguidos, hace cuánto fue
+// el último y cuánto le queda de bloqueo
+type ThrottleState struct {
+	Failures         int
+	SinceLastFailure time.Duration
+	LockedFor        time.Duration
+}
+
+// AccountThrottlePolicy son los límites por cuenta: LOGIN_MAX_ATTEMPTS fallos
+// (10 por defecto) bloquean LOGIN_LOCKOUT_DURATION (15m por defecto)
+func AccountThrottlePolicy() ThrottlePolicy {
+	return ThrottlePolicy{
+		MaxAttempts:     envInt("LOGIN_MAX_ATTEMPTS", 10),
+		LockoutDuration: envDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+	}
+}
+
+// IPThrottlePolicy son los límites por IP, más altos porque detrás de una IP
+// puede haber muchos usuarios (LOGIN_IP_MAX_ATTEMPTS, 100 por defecto)
+func IPThrottlePolicy() ThrottlePolicy {
+	return ThrottlePolicy{
+		MaxAttempts:     envInt("LOGIN_IP_MAX_ATTEMPTS", 100),
+		LockoutDuration: envDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+	}
+}
+
+// ThrottlePolicyFor devuelve los límites de un ámbito
+func ThrottlePolicyFor(scope string) ThrottlePolicy {
+	if scope == ThrottleScopeIP {
+		return IPThrottlePolicy()
+	}
+	return AccountThrottlePolicy()
+}
+
+// ThrottleWindow es el tiempo sin fallos tras el que se olvida una clave
+func ThrottleWindow() time.Duration {
+	return throttleWindow
+}
+
+// NormalizeThrottleSubject deja el email (o lo que identifique la clave) en una
+// forma única, para que "Ana@x.com" y "ana@x.com " cuenten juntos
+func NormalizeThrottleSubject(subject string) string {
+	return strings.ToLower(strings.TrimSpace(subject))
+}
+
+// ThrottleBackoff es la espera que impone haber fallado failures veces seguidas:
+// nada al principio y luego 1s, 2s, 4s... sin pasar del bloqueo
+func ThrottleBackoff(p ThrottlePolicy, failures int) time.Duration {
+	if failures < throttleFreeAttempts {
+		return 0
+	}
+	delay := throttleBaseDelay
+	for i := throttleFreeAttempts; i < failures && delay < p.LockoutDuration; i++ {
+		delay *= 2
+	}
+	return min(delay, p.LockoutDuration)
+}
+
+// ThrottleRetryAfter es lo que falta para poder volver a intentarlo
+func ThrottleRetryAfter(p ThrottlePolicy, s ThrottleState) time.Duration {
+	if s.SinceLastFailure >= throttleWindow {
+		return s.LockedFor
+	}
+	return max(s.LockedFor, ThrottleBackoff(p, s.Failures)-s.SinceLastFailure, 0)
+}
+
+// ThrottleNextFailure cuenta un fallo más. Devuelve los fallos acumulados y, si
+// toca bloquear, durante cuánto; cada MaxAttempts fallos se vuelve a bloquear.
+func ThrottleNextFailure(p ThrottlePolicy, s ThrottleState) (int, time.Duration) {
+	failures := s.Failures + 1
+	if s.SinceLastFailure >= throttleWindow {
+		failures = 1
+	}
+	if failures%p.MaxAttempts == 0 {
+		return failures, p.LockoutDuration
+	}
+	return failures, 0
+}
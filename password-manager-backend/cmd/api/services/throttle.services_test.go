@@ -0,0 +1,66 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+var testPolicy = ThrottlePolicy{MaxAttempts: 5, LockoutDuration: 10 * time.Second}
+
+func TestThrottleBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		0: 0,
+		2: 0,
+		3: time.Second,
+		4: 2 * time.Second,
+		5: 4 * time.Second,
+		6: 8 * time.Second,
+		// Nunca más que el bloqueo
+		7:  10 * time.Second,
+		50: 10 * time.Second,
+	}
+	for failures, want := range cases {
+		if got := ThrottleBackoff(testPolicy, failures); got != want {
+			t.Errorf("backoff after %d failures = %v, want %v", failures, got, want)
+		}
+	}
+}
+
+func TestThrottleRetryAfter(t *testing.T) {
+	if got := ThrottleRetryAfter(testPolicy, ThrottleState{Failures: 4, SinceLastFailure: 500 * time.Millisecond}); got != 1500*time.Millisecond {
+		t.Errorf("expected to wait the rest of the backoff, got %v", got)
+	}
+	if got := ThrottleRetryAfter(testPolicy, ThrottleState{Failures: 4, SinceLastFailure: time.Minute}); got != 0 {
+		t.Errorf("backoff already elapsed, got %v", got)
+	}
+	if got := ThrottleRetryAfter(testPolicy, ThrottleState{Failures: 5, SinceLastFailure: time.Minute, LockedFor: 3 * time.Minute}); got != 3*time.Minute {
+		t.Errorf("a lockout wins over the backoff, got %v", got)
+	}
+	if got := ThrottleRetryAfter(testPolicy, ThrottleState{Failures: 9, SinceLastFailure: 48 * time.Hour}); got != 0 {
+		t.Errorf("old failures are forgotten, got %v", got)
+	}
+}
+
+func TestThrottleNextFailure(t *testing.T) {
+	state := ThrottleState{}
+	for i := 1; i < testPolicy.MaxAttempts; i++ {
+		failures, lock := ThrottleNextFailure(testPolicy, state)
+		if failures != i || lock != 0 {
+			t.Fatalf("failure %d: got %d failures, lock %v", i, failures, lock)
+		}
+		state.Failures = failures
+	}
+	if failures, lock := ThrottleNextFailure(testPolicy, state); failures != 5 || lock != testPolicy.LockoutDuration {
+		t.Errorf("expected a lockout at %d failures, got %d failures, lock %v", testPolicy.MaxAttempts, failures, lock)
+	}
+	// Tras un día sin fallos se empieza de cero
+	if failures, _ := ThrottleNextFailure(testPolicy, ThrottleState{Failures: 4, SinceLastFailure: 25 * time.Hour}); failures != 1 {
+		t.Errorf("expected the counter to restart, got %d", failures)
+	}
+}
+
+func TestNormalizeThrottleSubject(t *testing.T) {
+	if NormalizeThrottleSubject(" Ana@Example.com ") != "ana@example.com" {
+		t.Error("expected a lowercase, trimmed subject")
+	}
+}
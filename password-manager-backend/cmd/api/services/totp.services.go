@@ -0,0 +1,129 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// TOTP según el RFC 6238 con los parámetros que entienden todas las apps de
+// autenticación: HMAC-SHA1, 6 dígitos y pasos de 30 segundos.
+const (
+	TOTPSecretSize = 20
+	totpDigits     = 6
+	totpPeriod     = 30
+	totpSkew       = 1 // pasos de margen a cada lado por relojes desajustados
+
+	recoveryCodeBytes = 10 // 80 bits, se pueden guardar con un hash rápido
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFARequired indica si las rutas que tocan credenciales exigen una sesión abierta
+// con segundo factor. Es la política por defecto; MFA_REQUIRED=false la desactiva
+// para desarrollo.
+func MFARequired() bool {
+	return os.Getenv("MFA_REQUIRED") != "false"
+}
+
+// NewTOTPSecret genera el secreto compartido con la app de autenticación
+func NewTOTPSecret() (*Secret, error) {
+	return RandomSecret(TOTPSecretSize)
+}
+
+// EncodeTOTPSecret es el secreto en base32, como se teclea en la app
+func EncodeTOTPSecret(secret *Secret) string {
+	return totpEncoding.EncodeToString(secret.Bytes())
+}
+
+// TOTPProvisioningURI genera la URI otpauth:// que se enseña como código QR
+func TOTPProvisioningURI(secret *Secret, issuer, account string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", EncodeTOTPSecret(secret))
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// TOTPIssuer es el nombre con el que aparece la cuenta en la app (TOTP_ISSUER)
+func TOTPIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Password Manager"
+}
+
+// totpStep es el número de periodo de 30 segundos de un instante
+func totpStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// totpCode calcula el código de un paso (RFC 4226, truncado dinámico)
+func totpCode(secret []byte, step int64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+// ValidateTOTP comprueba un código con un paso de margen. Devuelve el paso que
+// encajó, que tiene que ser posterior a lastStep para que un código no sirva dos veces.
+func ValidateTOTP(secret *Secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+	current := totpStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if hmac.Equal([]byte(totpCode(secret.Bytes(), step, totpDigits)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateMFARecoveryCodes crea códigos de un solo uso para entrar sin la app,
+// con la forma xxxx-xxxx-xxxx-xxxx
+func GenerateMFARecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, n)
+	for i := range codes {
+		raw := make([]byte, recoveryCodeBytes)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		encoded := strings.ToLower(totpEncoding.EncodeToString(raw))
+		codes[i] = encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16]
+	}
+	return codes, nil
+}
+
+// HashMFARecoveryCode normaliza el código (sin guiones ni mayúsculas) y calcula su hash
+func HashMFARecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
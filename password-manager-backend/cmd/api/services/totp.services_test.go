@@ -0,0 +1,96 @@
+package services
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Vectores del apéndice B del RFC 6238 (SHA1), truncados a 6 dígitos
+func TestTOTPCodeRFC6238(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	cases := map[int64]string{
+		59:         "94287082",
+		1111111109: "07081804",
+		1111111111: "14050471",
+		1234567890: "89005924",
+		2000000000: "69279037",
+	}
+	for unix, want := range cases {
+		if got := totpCode(secret, totpStep(time.Unix(unix, 0)), 8); got != want {
+			t.Errorf("totpCode at %d = %s, want %s", unix, got, want)
+		}
+		if got := totpCode(secret, totpStep(time.Unix(unix, 0)), 6); got != want[2:] {
+			t.Errorf("6 digit totpCode at %d = %s, want %s", unix, got, want[2:])
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret := SecretFromString("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	code := totpCode(secret.Bytes(), totpStep(now), totpDigits)
+
+	step, ok := ValidateTOTP(secret, code, now, 0)
+	if !ok || step != totpStep(now) {
+		t.Fatalf("expected current code to be valid, got %d %v", step, ok)
+	}
+	// Un paso de desfase se acepta, dos no
+	if _, ok := ValidateTOTP(secret, code, now.Add(totpPeriod*time.Second), 0); !ok {
+		t.Error("expected code from the previous step to be valid")
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(2*totpPeriod*time.Second), 0); ok {
+		t.Error("expected code from two steps ago to be rejected")
+	}
+	// Un código ya usado no vuelve a valer
+	if _, ok := ValidateTOTP(secret, code, now, step); ok {
+		t.Error("expected replayed code to be rejected")
+	}
+	if _, ok := ValidateTOTP(secret, "12345", now, 0); ok {
+		t.Error("expected short code to be rejected")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	secret := SecretFromString("12345678901234567890")
+	uri := TOTPProvisioningURI(secret, "Password Manager", "ana@example.com")
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Scheme != "otpauth" || u.Host != "totp" {
+		t.Errorf("unexpected uri %s", uri)
+	}
+	if u.Path != "/Password Manager:ana@example.com" {
+		t.Errorf("unexpected label %q", u.Path)
+	}
+	q := u.Query()
+	if q.Get("secret") != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" || q.Get("issuer") != "Password Manager" || q.Get("digits") != "6" {
+		t.Errorf("unexpected parameters %v", q)
+	}
+}
+
+func TestMFARecoveryCodes(t *testing.T) {
+	codes, err := GenerateMFARecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, code := range codes {
+		if len(code) != 19 || strings.Count(code, "-") != 3 {
+			t.Errorf("unexpected code format %q", code)
+		}
+		if seen[code] {
+			t.Errorf("duplicated code %q", code)
+		}
+		seen[code] = true
+	}
+
+	// Se aceptan sin guiones y en mayúsculas
+	code := codes[0]
+	if HashMFARecoveryCode(code) != HashMFARecoveryCode(strings.ToUpper(strings.ReplaceAll(code, "-", ""))) {
+		t.Error("expected normalized codes to have the same hash")
+	}
+}
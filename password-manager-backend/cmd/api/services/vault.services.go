@@ -0,0 +1,114 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// Algoritmos de derivación que aceptamos para las bóvedas zero-knowledge.
+// El cliente deriva la clave de la bóveda a partir de la contraseña maestra y
+// solo nos manda un hash de autenticación, nunca la contraseña ni la clave.
+const (
+	KdfArgon2id     = "argon2id"
+	KdfPBKDF2SHA256 = "pbkdf2-sha256"
+)
+
+// Mínimos por debajo de los cuales rechazamos los parámetros del cliente
+const (
+	minArgon2Iterations  = 2
+	minArgon2MemoryKiB   = 19456
+	minPBKDF2Iterations  = 600000
+	minKdfSaltBytes      = 16
+	maxKdfSaltBytes      = 64
+	maxEncStringLength   = 1024
+	encStringNonceLength = 12
+	encStringTagLength   = 16
+)
+
+// KdfParams son los parámetros de derivación que guarda el servidor por usuario
+type KdfParams struct {
+	Algorithm   string `json:"algorithm" binding:"required,oneof=argon2id pbkdf2-sha256"`
+	Salt        string `json:"salt" binding:"required,base64"`
+	Iterations  int    `json:"iterations" binding:"required,min=1"`
+	Memory      int    `json:"memory,omitempty"`      // KiB, solo argon2id
+	Parallelism int    `json:"parallelism,omitempty"` // solo argon2id
+}
+
+// DefaultKdfParams son los parámetros recomendados para cuentas nuevas
+func DefaultKdfParams() KdfParams {
+	return KdfParams{
+		Algorithm:   KdfArgon2id,
+		Iterations:  3,
+		Memory:      65536,
+		Parallelism: 4,
+	}
+}
+
+// Validate comprueba que los parámetros sean lo bastante costosos
+func (p KdfParams) Validate() error {
+	salt, err := base64.StdEncoding.DecodeString(p.Salt)
+	if err != nil || len(salt) < minKdfSaltBytes || len(salt) > maxKdfSaltBytes {
+		return errors.New("kdf salt must be between 16 and 64 bytes encoded in base64")
+	}
+	switch p.Algorithm {
+	case KdfArgon2id:
+		if p.Iterations < minArgon2Iterations || p.Memory < minArgon2MemoryKiB || p.Parallelism < 1 {
+			return errors.New("argon2id parameters are too weak")
+		}
+	case KdfPBKDF2SHA256:
+		if p.Iterations < minPBKDF2Iterations {
+			return errors.New("pbkdf2-sha256 needs at least 600000 iterations")
+		}
+	default:
+		return errors.New("unsupported kdf algorithm")
+	}
+	return nil
+}
+
+// FakeKdfParams devuelve parámetros deterministas para un email que no tiene
+// bóveda zero-knowledge, así /prelogin no permite saber qué cuentas existen.
+// Falla si no hay ni secreto de prelogin ni clave maestra v1: sin clave la sal
+// sería predecible.
+func FakeKdfParams(email string) (KdfParams, error) {
+	raw, _ := GetKey(KeyNamePrelogin)
+	secret := NewSecret(append([]byte(nil), raw...))
+	if secret.IsEmpty() {
+		// Sin secreto propio usamos la clave maestra v1 para que la sal no sea predecible
+		var err error
+		if secret, err = masterKey(1); err != nil {
+			return KdfParams{}, err
+		}
+	}
+	defer secret.Destroy()
+	mac := hmac.New(sha256.New, secret.Bytes())
+	mac.Write([]byte("prelogin:" + strings.ToLower(strings.TrimSpace(email))))
+
+	params := DefaultKdfParams()
+	params.Salt = base64.StdEncoding.EncodeToString(mac.Sum(nil)[:minKdfSaltBytes])
+	return params, nil
+}
+
+// ValidEncString comprueba la forma de un campo cifrado por el cliente:
+// "zk1.<nonce base64>.<ciphertext base64>". El servidor no puede descifrarlo,
+// solo se asegura de no guardar texto en claro por error.
+func ValidEncString(s string) bool {
+	if len(s) > maxEncStringLength {
+		return false
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 || parts[0] != "zk1" {
+		return false
+	}
+	nonce, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(nonce) != encStringNonceLength {
+		return false
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil || len(ciphertext) < encStringTagLength {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestValidEncString(t *testing.T) {
+	nonce := base64.StdEncoding.EncodeToString(make([]byte, 12))
+	ciphertext := base64.StdEncoding.EncodeToString(make([]byte, 32))
+
+	cases := map[string]bool{
+		"zk1." + nonce + "." + ciphertext:                true,
+		"plain text title":                               false,
+		"zk2." + nonce + "." + ciphertext:                false,
+		"zk1." + nonce + ".short":                        false,
+		"zk1.AAAA." + ciphertext:                         false,
+		"zk1." + nonce + "." + strings.Repeat("A", 2000): false,
+	}
+	for input, want := range cases {
+		if got := ValidEncString(input); got != want {
+			t.Errorf("ValidEncString(%.30q) = %v, want %v", input, got, want)
+		}
+	}
+}
+
+func TestKdfParamsValidate(t *testing.T) {
+	params := DefaultKdfParams()
+	params.Salt = base64.StdEncoding.EncodeToString(make([]byte, 16))
+	if err := params.Validate(); err != nil {
+		t.Fatalf("default params should be valid: %v", err)
+	}
+
+	weak := params
+	weak.Memory = 1024
+	if err := weak.Validate(); err == nil {
+		t.Error("expected error for weak argon2id memory")
+	}
+
+	pbkdf2 := KdfParams{Algorithm: KdfPBKDF2SHA256, Salt: params.Salt, Iterations: 100000}
+	if err := pbkdf2.Validate(); err == nil {
+		t.Error("expected error for too few pbkdf2 iterations")
+	}
+}
+
+func TestFakeKdfParamsIsDeterministic(t *testing.T) {
+	t.Setenv("PRELOGIN_SECRET", "test-secret")
+
+	a, err := FakeKdfParams("Someone@Example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := FakeKdfParams("someone@example.com")
+	c, _ := FakeKdfParams("other@example.com")
+
+	if a.Salt != b.Salt {
+		t.Error("expected the same salt for the same email")
+	}
+	if a.Salt == c.Salt {
+		t.Error("expected different salts for different emails")
+	}
+	if err := a.Validate(); err != nil {
+		t.Errorf("fake params should look like real ones: %v", err)
+	}
+}
+
+func TestFakeKdfParamsWithoutKey(t *testing.T) {
+	t.Setenv("PRELOGIN_SECRET", "")
+	t.Setenv("ENCRYPTION_KEY_V1", "")
+
+	// Sin clave el HMAC daría siempre la misma sal para cada email
+	if _, err := FakeKdfParams("someone@example.com"); err == nil {
+		t.Error("expected an error without a prelogin secret or master key")
+	}
+}
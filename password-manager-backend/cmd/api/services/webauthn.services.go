@@ -0,0 +1,135 @@
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Passkeys (WebAuthn). Se piden siempre como credencial residente (para entrar sin
+// escribir el email) y con verificación de usuario (PIN o biometría), así que una
+// passkey cuenta como segundo factor por sí sola.
+const (
+	passkeyUserHandleSize = 32
+	passkeyCeremonyTTL    = 5 * time.Minute
+)
+
+// ErrPasskeyCloned indica que el contador de firmas de la passkey no avanzó: puede
+// haber una copia de la clave privada en otro dispositivo
+var ErrPasskeyCloned = errors.New("passkey sign count did not increase, the authenticator may be cloned")
+
+// PasskeyUser adapta un usuario a la interfaz webauthn.User. Handle es un valor
+// aleatorio por usuario: el id de la base de datos nunca llega al autenticador.
+type PasskeyUser struct {
+	Id          int
+	Handle      []byte
+	Name        string
+	DisplayName string
+	Credentials []webauthn.Credential
+}
+
+func (u *PasskeyUser) WebAuthnID() []byte                         { return u.Handle }
+func (u *PasskeyUser) WebAuthnName() string                       { return u.Name }
+func (u *PasskeyUser) WebAuthnDisplayName() string                { return u.DisplayName }
+func (u *PasskeyUser) WebAuthnCredentials() []webauthn.Credential { return u.Credentials }
+
+// NewPasskeyUserHandle genera el identificador opaco del usuario para sus passkeys
+func NewPasskeyUserHandle() ([]byte, error) {
+	handle := make([]byte, passkeyUserHandleSize)
+	if _, err := rand.Read(handle); err != nil {
+		return nil, err
+	}
+	return handle, nil
+}
+
+// PasskeyCeremonyTTL es lo que dura un registro o login con passkey a medias
+func PasskeyCeremonyTTL() time.Duration {
+	return passkeyCeremonyTTL
+}
+
+// NewWebAuthn configura el relying party. WEBAUTHN_RP_ID es el dominio del frontend
+// (por defecto localhost) y WEBAUTHN_RP_ORIGINS la lista de orígenes separados por comas.
+func NewWebAuthn() (*webauthn.WebAuthn, error) {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		rpID = "localhost"
+	}
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("WEBAUTHN_RP_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		origins = []string{"http://localhost:3000"}
+	}
+
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: passkeyCeremonyTTL, TimeoutUVD: passkeyCeremonyTTL}
+	return webauthn.New(&webauthn.Config{
+		RPID: rpID,
+		// El mismo nombre con el que aparece la cuenta en la app de autenticación
+		RPDisplayName: TOTPIssuer(),
+		RPOrigins:     origins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+		Timeouts: webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+}
+
+// BeginPasskeyRegistration genera las opciones para navigator.credentials.create.
+// Las passkeys que ya tiene el usuario se excluyen para no registrar dos veces el mismo autenticador.
+func BeginPasskeyRegistration(wa *webauthn.WebAuthn, user *PasskeyUser) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	return wa.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.Credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+}
+
+// FinishPasskeyRegistration valida la respuesta del autenticador y devuelve la credencial a guardar
+func FinishPasskeyRegistration(wa *webauthn.WebAuthn, user *PasskeyUser, session *webauthn.SessionData, response []byte) (*webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, err
+	}
+	return wa.CreateCredential(user, *session, parsed)
+}
+
+// BeginPasskeyLogin genera las opciones para navigator.credentials.get sin lista de
+// credenciales: el autenticador ofrece las passkeys que tenga para este dominio
+func BeginPasskeyLogin(wa *webauthn.WebAuthn) (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	return wa.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+}
+
+// FinishPasskeyLogin verifica la firma del autenticador. lookup busca al usuario por el
+// user handle que devuelve la passkey. La credencial devuelta trae el contador
+// actualizado; si no avanzó se devuelve ErrPasskeyCloned junto a ella.
+func FinishPasskeyLogin(wa *webauthn.WebAuthn, session *webauthn.SessionData, response []byte, lookup func(rawID, userHandle []byte) (*PasskeyUser, error)) (*PasskeyUser, *webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, nil, err
+	}
+	var user *PasskeyUser
+	handler := func(rawID, userHandle []byte) (webauthn.User, error) {
+		found, err := lookup(rawID, userHandle)
+		if err != nil {
+			return nil, err
+		}
+		user = found
+		return found, nil
+	}
+	_, credential, err := wa.ValidatePasskeyLogin(handler, *session, parsed)
+	if err != nil {
+		return nil, nil, err
+	}
+	if credential.Authenticator.CloneWarning {
+		return user, credential, ErrPasskeyCloned
+	}
+	return user, credential, nil
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+)
+
+const testOrigin = "http://localhost:3000"
+
+// softAuthenticator es un autenticador de software con una passkey ES256 residente
+type softAuthenticator struct {
+	t            *testing.T
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{t: t, key: key, credentialID: id}
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (a *softAuthenticator) clientData(ceremony, challenge string) []byte {
+	data, _ := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": challenge,
+		"origin":    testOrigin,
+	})
+	return data
+}
+
+// authData construye los datos del autenticador: hash del rp id, flags (UP y UV) y contador
+func (a *softAuthenticator) authData(rpID string, extra byte, attested []byte) []byte {
+	rpHash := sha256.Sum256([]byte(rpID))
+	var buf bytes.Buffer
+	buf.Write(rpHash[:])
+	buf.WriteByte(0x01 | 0x04 | extra)
+	binary.Write(&buf, binary.BigEndian, a.signCount)
+	buf.Write(attested)
+	return buf.Bytes()
+}
+
+// create responde a navigator.credentials.create con atestación "none"
+func (a *softAuthenticator) create(options *protocol.CredentialCreation) []byte {
+	opts := options.Response
+	a.userHandle = opts.User.ID.(protocol.URLEncodedBase64)
+
+	coseKey, err := webauthncbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: a.key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	var attested bytes.Buffer
+	attested.Write(make([]byte, 16)) // AAGUID
+	binary.Write(&attested, binary.BigEndian, uint16(len(a.credentialID)))
+	attested.Write(a.credentialID)
+	attested.Write(coseKey)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(opts.RelyingParty.ID, 0x40, attested.Bytes()),
+	})
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(a.clientData("webauthn.create", opts.Challenge.String())),
+			"attestationObject": b64(attestation),
+		},
+	})
+	return body
+}
+
+// get responde a navigator.credentials.get firmando authData || sha256(clientDataJSON)
+func (a *softAuthenticator) get(options *protocol.CredentialAssertion, rpID string) []byte {
+	a.signCount++
+	clientData := a.clientData("webauthn.get", options.Response.Challenge.String())
+	authData := a.authData(rpID, 0, nil)
+	clientHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		a.t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(signature),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+	return body
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	t.Setenv("WEBAUTHN_RP_ID", "localhost")
+	t.Setenv("WEBAUTHN_RP_ORIGINS", testOrigin)
+	wa, err := NewWebAuthn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle, err := NewPasskeyUserHandle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &PasskeyUser{Id: 1, Handle: handle, Name: "ana@example.com", DisplayName: "ana"}
+	authenticator := newSoftAuthenticator(t)
+
+	// Registro
+	creation, session, err := BeginPasskeyRegistration(wa, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creation.Response.AuthenticatorSelection.ResidentKey != protocol.ResidentKeyRequirementRequired {
+		t.Error("expected a discoverable credential to be required")
+	}
+	credential, err := FinishPasskeyRegistration(wa, user, session, authenticator.create(creation))
+	if err != nil {
+		t.Fatalf("registration failed: %v", err)
+	}
+	if !bytes.Equal(credential.ID, authenticator.credentialID) || !credential.Flags.UserVerified {
+		t.Fatalf("unexpected credential %+v", credential)
+	}
+	user.Credentials = append(user.Credentials, *credential)
+
+	lookup := func(rawID, userHandle []byte) (*PasskeyUser, error) {
+		if !bytes.Equal(userHandle, user.Handle) {
+			return nil, errors.New("unknown user handle")
+		}
+		return user, nil
+	}
+
+	// Login sin email: el usuario sale del user handle de la passkey
+	assertion, session, err := BeginPasskeyLogin(wa)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assertion.Response.AllowedCredentials) != 0 {
+		t.Error("expected a discoverable login without allowed credentials")
+	}
+	response := authenticator.get(assertion, wa.Config.RPID)
+	found, used, err := FinishPasskeyLogin(wa, session, response, lookup)
+	if err != nil {
+		t.Fatalf("login failed: %v", err)
+	}
+	if found.Id != user.Id || used.Authenticator.SignCount != 1 {
+		t.Fatalf("unexpected login result: user %d sign count %d", found.Id, used.Authenticator.SignCount)
+	}
+	user.Credentials[0] = *used
+
+	// Una firma de otro reto no vale
+	_, other, _ := BeginPasskeyLogin(wa)
+	if _, _, err := FinishPasskeyLogin(wa, other, response, lookup); err == nil {
+		t.Error("expected assertion for another challenge to be rejected")
+	}
+
+	// Un contador que no avanza delata un autenticador clonado
+	assertion, session, _ = BeginPasskeyLogin(wa)
+	authenticator.signCount = 0
+	if _, _, err := FinishPasskeyLogin(wa, session, authenticator.get(assertion, wa.Config.RPID), lookup); !errors.Is(err, ErrPasskeyCloned) {
+		t.Errorf("expected ErrPasskeyCloned, got %v", err)
+	}
+}
+
+func TestPasskeyWrongOrigin(t *testing.T) {
+	t.Setenv("WEBAUTHN_RP_ID", "localhost")
+	t.Setenv("WEBAUTHN_RP_ORIGINS", "https://vault.example.com")
+	wa, err := NewWebAuthn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle, _ := NewPasskeyUserHandle()
+	user := &PasskeyUser{Id: 1, Handle: handle, Name: "ana@example.com", DisplayName: "ana"}
+	authenticator := newSoftAuthenticator(t)
+
+	creation, session, err := BeginPasskeyRegistration(wa, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FinishPasskeyRegistration(wa, user, session, authenticator.create(creation)); err == nil {
+		t.Error("expected registration from another origin to be rejected")
+	}
+}
@@ -0,0 +1,5 @@
+ALTER TABLE notes
+    ADD COLUMN password VARCHAR(255) NULL AFTER username,
+    DROP COLUMN key_version,
+    DROP COLUMN password_nonce,
+    DROP COLUMN password_ciphertext;
@@ -0,0 +1,7 @@
+-- Las contraseñas de las notas pasan a guardarse cifradas (AES-256-GCM) en vez de con bcrypt.
+-- Los hashes antiguos no se pueden descifrar, así que se descartan.
+ALTER TABLE notes
+    ADD COLUMN password_ciphertext BLOB NULL AFTER username,
+    ADD COLUMN password_nonce VARBINARY(24) NULL AFTER password_ciphertext,
+    ADD COLUMN key_version INT NULL AFTER password_nonce,
+    DROP COLUMN password;
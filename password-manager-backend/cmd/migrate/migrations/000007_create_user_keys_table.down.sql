@@ -0,0 +1,5 @@
+ALTER TABLE notes
+    DROP FOREIGN KEY fk_notes_user_key,
+    DROP COLUMN user_key_id;
+
+DROP TABLE IF EXISTS user_keys;
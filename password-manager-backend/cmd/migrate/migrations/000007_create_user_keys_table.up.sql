@@ -0,0 +1,15 @@
+-- Clave de datos de cada usuario, envuelta (cifrada) con la clave maestra del servidor
+CREATE TABLE IF NOT EXISTS user_keys (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL UNIQUE,
+    wrapped_key VARBINARY(128) NOT NULL,
+    nonce VARBINARY(24) NOT NULL,
+    master_key_version INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Las notas nuevas se cifran con la clave del dueño; NULL = cifrada con la clave maestra
+ALTER TABLE notes
+    ADD COLUMN user_key_id INT NULL AFTER key_version,
+    ADD CONSTRAINT fk_notes_user_key FOREIGN KEY (user_key_id) REFERENCES user_keys(id) ON DELETE SET NULL;
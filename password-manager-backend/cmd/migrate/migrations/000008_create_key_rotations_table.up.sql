@@ -0,0 +1,11 @@
+-- Progreso de las rotaciones de clave maestra (cmd/rotate-keys), para poder reanudarlas
+CREATE TABLE IF NOT EXISTS key_rotations (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    target_version INT NOT NULL UNIQUE,
+    phase VARCHAR(32) NOT NULL,
+    last_id INT NOT NULL DEFAULT 0,
+    processed INT NOT NULL DEFAULT 0,
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP NULL
+);
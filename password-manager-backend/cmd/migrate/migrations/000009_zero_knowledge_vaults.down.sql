@@ -0,0 +1,12 @@
+ALTER TABLE notes
+    DROP COLUMN client_encrypted,
+    MODIFY note_text VARCHAR(255) NOT NULL,
+    MODIFY username VARCHAR(255);
+
+ALTER TABLE users
+    DROP COLUMN kdf_parallelism,
+    DROP COLUMN kdf_memory,
+    DROP COLUMN kdf_iterations,
+    DROP COLUMN kdf_salt,
+    DROP COLUMN kdf_algorithm,
+    DROP COLUMN vault_mode;
@@ -0,0 +1,15 @@
+-- Bóvedas zero-knowledge: el cliente deriva la clave con estos parámetros y solo
+-- envía un hash de autenticación; las notas llegan cifradas y se guardan tal cual
+ALTER TABLE users
+    ADD COLUMN vault_mode VARCHAR(16) NOT NULL DEFAULT 'server',
+    ADD COLUMN kdf_algorithm VARCHAR(32) NULL,
+    ADD COLUMN kdf_salt VARCHAR(128) NULL,
+    ADD COLUMN kdf_iterations INT NULL,
+    ADD COLUMN kdf_memory INT NULL,
+    ADD COLUMN kdf_parallelism INT NULL;
+
+-- Los blobs cifrados ocupan más que el texto en claro
+ALTER TABLE notes
+    MODIFY note_text VARCHAR(1024) NOT NULL,
+    MODIFY username VARCHAR(1024),
+    ADD COLUMN client_encrypted BOOLEAN NOT NULL DEFAULT FALSE AFTER user_key_id;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS recovery_kits;
+
+ALTER TABLE users
+    DROP COLUMN token_version;
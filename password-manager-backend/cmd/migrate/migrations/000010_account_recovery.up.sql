@@ -0,0 +1,14 @@
+-- Se sube al cambiar la contraseña por recuperación: los tokens con una versión distinta dejan de valer
+ALTER TABLE users
+    ADD COLUMN token_version INT NOT NULL DEFAULT 0;
+
+-- Kit de recuperación: el secreto se reparte en trozos de Shamir que guarda el usuario,
+-- aquí solo queda su hash para comprobar la reconstrucción
+CREATE TABLE IF NOT EXISTS recovery_kits (
+    user_id INT NOT NULL PRIMARY KEY,
+    secret_hash CHAR(64) NOT NULL,
+    threshold INT NOT NULL,
+    total_shares INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,18 @@
+-- Contactos de emergencia: el dueño (grantor) nombra a otro usuario (grantee) que
+-- puede pedir acceso a sus notas; si el dueño no lo rechaza en wait_days se concede
+CREATE TABLE IF NOT EXISTS emergency_access (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    grantor_id INT NOT NULL,
+    grantee_id INT NOT NULL,
+    access_type VARCHAR(16) NOT NULL,
+    wait_days INT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'invited',
+    requested_at TIMESTAMP NULL,
+    granted_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_emergency_pair (grantor_id, grantee_id),
+    KEY idx_emergency_status (status, requested_at),
+    FOREIGN KEY (grantor_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (grantee_id) REFERENCES users(id) ON DELETE CASCADE
+);
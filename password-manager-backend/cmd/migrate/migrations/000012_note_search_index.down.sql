@@ -0,0 +1,8 @@
+-- Ojo: los username y url cifrados se pierden, solo quedan los de filas sin migrar
+DROP TABLE IF EXISTS note_search_index;
+
+ALTER TABLE notes
+    DROP COLUMN url_nonce,
+    DROP COLUMN url_ciphertext,
+    DROP COLUMN username_nonce,
+    DROP COLUMN username_ciphertext;
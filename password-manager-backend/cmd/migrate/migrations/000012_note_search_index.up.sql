@@ -0,0 +1,18 @@
+-- username y url se cifran con la clave de datos del dueño. La columna username
+-- solo conserva los blobs de las bóvedas zero-knowledge y las filas sin migrar.
+ALTER TABLE notes
+    ADD COLUMN username_ciphertext BLOB NULL AFTER username,
+    ADD COLUMN username_nonce VARBINARY(24) NULL AFTER username_ciphertext,
+    ADD COLUMN url_ciphertext BLOB NULL AFTER username_nonce,
+    ADD COLUMN url_nonce VARBINARY(24) NULL AFTER url_ciphertext;
+
+-- Índices ciegos (HMAC) para buscar por username, email y dominio sin texto en claro
+CREATE TABLE IF NOT EXISTS note_search_index (
+    note_id INT NOT NULL,
+    user_id INT NOT NULL,
+    field VARCHAR(16) NOT NULL,
+    term BINARY(16) NOT NULL,
+    PRIMARY KEY (note_id, field, term),
+    KEY idx_note_search_term (user_id, term),
+    FOREIGN KEY (note_id) REFERENCES notes(id) ON DELETE CASCADE
+);
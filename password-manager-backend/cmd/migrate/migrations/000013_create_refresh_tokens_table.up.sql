@@ -0,0 +1,18 @@
+-- Refresh tokens rotativos: cada login abre una familia (family_id) y cada refresh
+-- gasta el token y crea el siguiente de la misma familia. Solo se guarda el hash.
+-- Si llega un token ya usado se revoca la familia entera.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    family_id CHAR(32) NOT NULL,
+    token_hash BINARY(32) NOT NULL,
+    token_version INT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_refresh_token_hash (token_hash),
+    KEY idx_refresh_family (family_id),
+    KEY idx_refresh_expires (expires_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
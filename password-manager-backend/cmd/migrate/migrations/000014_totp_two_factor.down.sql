@@ -0,0 +1,9 @@
+ALTER TABLE refresh_tokens DROP COLUMN mfa;
+
+DROP TABLE IF EXISTS mfa_recovery_codes;
+
+ALTER TABLE users
+    DROP COLUMN totp_last_step,
+    DROP COLUMN totp_enabled,
+    DROP COLUMN totp_secret_nonce,
+    DROP COLUMN totp_secret_ciphertext;
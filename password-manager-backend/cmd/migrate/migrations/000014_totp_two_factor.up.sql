@@ -0,0 +1,23 @@
+-- Segundo factor TOTP. El secreto se cifra con la clave de datos del usuario y
+-- solo cuenta como activo tras confirmar el primer código (totp_enabled).
+-- totp_last_step guarda el último paso aceptado para que un código no valga dos veces.
+ALTER TABLE users
+    ADD COLUMN totp_secret_ciphertext VARBINARY(64) NULL,
+    ADD COLUMN totp_secret_nonce VARBINARY(24) NULL,
+    ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- Códigos de recuperación de un solo uso, solo se guarda su hash
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_mfa_recovery_code (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Las sesiones recuerdan si se abrieron con segundo factor
+ALTER TABLE refresh_tokens
+    ADD COLUMN mfa BOOLEAN NOT NULL DEFAULT FALSE AFTER token_version;
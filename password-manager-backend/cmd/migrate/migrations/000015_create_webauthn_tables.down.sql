@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS webauthn_sessions;
+DROP TABLE IF EXISTS webauthn_credentials;
+
+ALTER TABLE users
+    DROP INDEX uq_users_webauthn_handle,
+    DROP COLUMN webauthn_handle;
@@ -0,0 +1,35 @@
+-- Identificador opaco del usuario para sus passkeys (user handle de WebAuthn)
+ALTER TABLE users
+    ADD COLUMN webauthn_handle BINARY(32) NULL,
+    ADD UNIQUE KEY uq_users_webauthn_handle (webauthn_handle);
+
+-- Passkeys registradas. Solo se guarda la clave pública; sign_count detecta
+-- autenticadores clonados y clone_warning bloquea la passkey si ocurre.
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    credential_id VARBINARY(255) NOT NULL,
+    public_key BLOB NOT NULL,
+    attestation_type VARCHAR(32) NOT NULL,
+    aaguid VARBINARY(16) NULL,
+    transports VARCHAR(255) NOT NULL DEFAULT '',
+    flags TINYINT UNSIGNED NOT NULL,
+    sign_count INT UNSIGNED NOT NULL DEFAULT 0,
+    clone_warning BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMP NULL,
+    UNIQUE KEY uq_webauthn_credential_id (credential_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Retos pendientes de las ceremonias de registro y login. Cada uno se gasta al usarlo.
+CREATE TABLE IF NOT EXISTS webauthn_sessions (
+    id CHAR(32) NOT NULL PRIMARY KEY,
+    user_id INT NULL,
+    ceremony VARCHAR(16) NOT NULL,
+    data JSON NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    KEY idx_webauthn_sessions_expires (expires_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
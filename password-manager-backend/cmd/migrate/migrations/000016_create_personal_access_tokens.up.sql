@@ -0,0 +1,20 @@
+-- Tokens de acceso personal para scripts y CI. Solo se guarda el hash del token.
+-- token_version es la del usuario al crearlo: un cambio de contraseña o una
+-- recuperación de cuenta los invalida igual que a las sesiones. mfa dice si la
+-- sesión que lo creó tenía segundo factor.
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    name VARCHAR(64) NOT NULL,
+    token_hash BINARY(32) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    token_version INT NOT NULL,
+    mfa BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    UNIQUE KEY uq_personal_access_tokens_hash (token_hash),
+    KEY idx_personal_access_tokens_expires (expires_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
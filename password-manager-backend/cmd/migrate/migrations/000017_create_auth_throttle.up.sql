@@ -0,0 +1,12 @@
+-- Fallos de contraseña por cuenta y por IP para el limitador de intentos.
+-- subject es el email normalizado, el id de usuario o la IP según el ámbito.
+CREATE TABLE IF NOT EXISTS auth_throttle (
+    scope VARCHAR(16) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    failures INT NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    locked_until TIMESTAMP NULL,
+    PRIMARY KEY (scope, subject),
+    KEY idx_auth_throttle_locked (locked_until),
+    KEY idx_auth_throttle_last_failure (last_failure_at)
+);
@@ -0,0 +1,13 @@
+-- Enlaces de restablecer contraseña. Solo se guarda el hash del token; used_at
+-- lo gasta y cualquier token nuevo o un restablecimiento invalida los anteriores.
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    token_hash BINARY(32) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    UNIQUE KEY uq_password_reset_tokens_hash (token_hash),
+    KEY idx_password_reset_tokens_user (user_id, created_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,21 @@
+-- Email demostrado por el usuario. Las cuentas que ya existían se dan por verificadas.
+ALTER TABLE users
+    ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE users SET email_verified = TRUE;
+
+-- Enlaces de verificación de email. purpose es verify (el email de la cuenta) o
+-- change (email nuevo pendiente de confirmar, guardado en email). Solo se guarda
+-- el hash del token y un token nuevo del mismo tipo invalida los anteriores.
+CREATE TABLE IF NOT EXISTS email_verifications (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    purpose VARCHAR(16) NOT NULL,
+    token_hash BINARY(32) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP NULL,
+    UNIQUE KEY uq_email_verifications_hash (token_hash),
+    KEY idx_email_verifications_user (user_id, purpose, created_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,15 @@
+-- Sesiones abiertas con su dispositivo. id es el family_id de sus refresh tokens
+-- (el claim sid del JWT): la sesión sigue abierta mientras la familia tenga algún
+-- token sin revocar ni caducar.
+CREATE TABLE IF NOT EXISTS sessions (
+    id CHAR(32) NOT NULL PRIMARY KEY,
+    user_id INT NOT NULL,
+    device_name VARCHAR(64) NOT NULL,
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_sessions_user (user_id, last_seen_at),
+    KEY idx_sessions_last_seen (last_seen_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
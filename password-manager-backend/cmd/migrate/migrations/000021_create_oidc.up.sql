@@ -0,0 +1,25 @@
+-- Logins OIDC a medias: el state que vuelve del proveedor, el nonce que tiene que
+-- traer el ID token y el code_verifier de PKCE. Cada uno se gasta al usarlo.
+-- link_user_id marca un enlace pedido desde una sesión: la identidad que vuelva se
+-- ata a ese usuario en vez de abrir sesión. Nunca se enlaza por email.
+CREATE TABLE IF NOT EXISTS oidc_logins (
+    state CHAR(43) NOT NULL PRIMARY KEY,
+    nonce CHAR(43) NOT NULL,
+    code_verifier CHAR(43) NOT NULL,
+    link_user_id INT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    KEY idx_oidc_logins_expires (expires_at),
+    FOREIGN KEY (link_user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Cuentas de un proveedor de identidad enlazadas a un usuario (emisor + sub)
+CREATE TABLE IF NOT EXISTS user_identities (
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    user_id INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP NULL,
+    PRIMARY KEY (issuer, subject),
+    KEY idx_user_identities_user (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,12 @@
+ALTER TABLE users
+    ADD COLUMN admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Vuelven a ser admin los que tenían el rol admin u owner
+UPDATE users SET admin = TRUE WHERE id IN (
+    SELECT ur.user_id FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE r.name IN ('owner', 'admin')
+);
+
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS permissions;
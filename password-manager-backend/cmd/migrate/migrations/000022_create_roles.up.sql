@@ -0,0 +1,81 @@
+-- Control de acceso por roles: sustituye a users.admin. Un usuario puede tener
+-- varios roles y sus permisos se suman.
+CREATE TABLE IF NOT EXISTS permissions (
+    name VARCHAR(64) NOT NULL PRIMARY KEY,
+    description VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+    id INT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(32) NOT NULL UNIQUE,
+    description VARCHAR(255) NOT NULL,
+    -- Los roles de serie no se pueden borrar
+    built_in BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INT NOT NULL,
+    permission VARCHAR(64) NOT NULL,
+    PRIMARY KEY (role_id, permission),
+    FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+    FOREIGN KEY (permission) REFERENCES permissions(name) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INT NOT NULL,
+    role_id INT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role_id),
+    KEY idx_user_roles_role (role_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+);
+
+INSERT INTO permissions (name, description) VALUES
+('notes:read', 'Leer sus propias notas'),
+('notes:write', 'Crear, editar y borrar sus propias notas'),
+('users:view', 'Ver los demás usuarios'),
+('users:view_credentials', 'Ver el hash de la contraseña de los demás usuarios'),
+('users:update', 'Editar los demás usuarios'),
+('users:delete', 'Borrar los demás usuarios'),
+('lockouts:view', 'Ver las cuentas bloqueadas por fallos de login'),
+('roles:view', 'Ver los roles y sus permisos'),
+('roles:assign', 'Dar y quitar roles con permisos que ya se tienen'),
+('audit:view', 'Ver el registro de auditoría'),
+('owners:manage', 'Dar y quitar el rol owner y gestionar a sus usuarios');
+
+INSERT INTO roles (name, description, built_in) VALUES
+('owner', 'Dueño de la instalación: todos los permisos', TRUE),
+('admin', 'Administra usuarios y roles salvo los owner', TRUE),
+('auditor', 'Solo lectura de usuarios, bloqueos y auditoría', TRUE),
+('user', 'Usuario normal con su bóveda', TRUE);
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.name FROM roles r JOIN permissions p WHERE r.name = 'owner';
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.name FROM roles r JOIN permissions p
+WHERE r.name = 'admin' AND p.name IN ('users:view', 'users:view_credentials', 'users:update', 'users:delete', 'lockouts:view', 'roles:view', 'roles:assign', 'audit:view');
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.name FROM roles r JOIN permissions p
+WHERE r.name = 'auditor' AND p.name IN ('users:view', 'lockouts:view', 'roles:view', 'audit:view');
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.name FROM roles r JOIN permissions p
+WHERE r.name = 'user' AND p.name IN ('notes:read', 'notes:write');
+
+-- Todos son user, los admin pasan a admin y el admin más antiguo es el owner. Sin
+-- admins el owner es el usuario más antiguo: si no, el próximo registro lo sería.
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.id, r.id FROM users u JOIN roles r ON r.name = 'user';
+
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.id, r.id FROM users u JOIN roles r ON r.name = 'admin' WHERE u.admin = TRUE;
+
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.id, r.id FROM users u JOIN roles r ON r.name = 'owner'
+ORDER BY u.admin DESC, u.id LIMIT 1;
+
+ALTER TABLE users
+    DROP COLUMN admin;
@@ -0,0 +1,7 @@
+DELETE FROM permissions WHERE name IN ('users:disable', 'sessions:revoke', 'users:reset_credentials');
+
+DROP TABLE IF EXISTS audit_log;
+
+ALTER TABLE users
+    DROP COLUMN must_change_password,
+    DROP COLUMN disabled_at;
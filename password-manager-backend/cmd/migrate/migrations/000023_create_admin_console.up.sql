@@ -0,0 +1,29 @@
+-- Consola de administración: cuentas desactivadas, cambio de contraseña obligado y
+-- registro de auditoría de lo que hace cada administrador
+ALTER TABLE users
+    ADD COLUMN disabled_at TIMESTAMP NULL DEFAULT NULL,
+    -- El próximo login con contraseña obliga a cambiarla antes de abrir sesión
+    ADD COLUMN must_change_password BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Sin claves foráneas: el registro sobrevive a que se borre el usuario o el admin
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    actor_id INT NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    target_user_id INT NULL,
+    details JSON NULL,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    KEY idx_audit_log_actor (actor_id, id),
+    KEY idx_audit_log_target (target_user_id, id),
+    KEY idx_audit_log_action (action, id)
+);
+
+INSERT INTO permissions (name, description) VALUES
+('users:disable', 'Desactivar y reactivar las cuentas de los demás usuarios'),
+('sessions:revoke', 'Cerrar todas las sesiones de los demás usuarios'),
+('users:reset_credentials', 'Obligar a cambiar la contraseña y quitar el 2FA de los demás usuarios');
+
+INSERT INTO role_permissions (role_id, permission)
+SELECT r.id, p.name FROM roles r JOIN permissions p
+WHERE r.name IN ('owner', 'admin') AND p.name IN ('users:disable', 'sessions:revoke', 'users:reset_credentials');
@@ -0,0 +1,3 @@
+ALTER TABLE users
+    DROP INDEX uq_users_scim_external_id,
+    DROP COLUMN scim_external_id;
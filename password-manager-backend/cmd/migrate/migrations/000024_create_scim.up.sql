@@ -0,0 +1,5 @@
+-- Aprovisionamiento SCIM 2.0: el IdP identifica a sus usuarios con su propio id
+-- (externalId). Los grupos SCIM son los roles, así que no hace falta otra tabla.
+ALTER TABLE users
+    ADD COLUMN scim_external_id VARCHAR(255) NULL DEFAULT NULL,
+    ADD UNIQUE KEY uq_users_scim_external_id (scim_external_id);
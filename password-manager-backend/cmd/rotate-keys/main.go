@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"password-manager-backend/cmd/api/models"
+	"password-manager-backend/cmd/api/services"
+	"password-manager-backend/internal/database"
+
+	_ "github.com/joho/godotenv/autoload" // carga automática del .env
+)
+
+// Rotación de la clave maestra:
+//
+//  1. go run cmd/rotate-keys/main.go generate -> imprime una clave nueva
+//  2. Añadirla como ENCRYPTION_KEY_V<n> y poner ENCRYPTION_KEY_VERSION=<n> en la API
+//     y aquí. La API sigue descifrando con las versiones anteriores mientras dure.
+//  3. go run cmd/rotate-keys/main.go run -> re-envuelve las claves de los usuarios
+//     por lotes. Si se corta, volver a lanzarlo continúa donde se quedó.
+//  4. Cuando status marque la rotación como terminada se puede retirar la clave vieja.
+//
+// encrypt-notes cifra el username y la url de las notas creadas antes de los índices
+// ciegos y genera sus índices. Se puede relanzar: solo toca las que faltan.
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	if err := services.ConfigureKeyProvider(); err != nil {
+		log.Fatalf("❌ Key provider error: %v", err)
+	}
+
+	switch os.Args[1] {
+	case "generate":
+		generate()
+	case "run":
+		run(os.Args[2:])
+	case "status":
+		status()
+	case "encrypt-notes":
+		encryptNotes(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	log.Fatal("Usage: rotate-keys generate | run [-batch 100] | status | encrypt-notes [-batch 100]")
+}
+
+func generate() {
+	key, err := services.GenerateMasterKey()
+	if err != nil {
+		log.Fatalf("❌ Could not generate key: %v", err)
+	}
+	next := services.CurrentKeyVersion() + 1
+	fmt.Printf("ENCRYPTION_KEY_V%d=%s\n", next, key)
+	fmt.Printf("ENCRYPTION_KEY_VERSION=%d\n", next)
+}
+
+func run(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	batchSize := fs.Int("batch", 100, "rows per batch")
+	fs.Parse(args)
+
+	if *batchSize < 1 {
+		log.Fatal("❌ -batch must be greater than 0")
+	}
+
+	target := services.CurrentKeyVersion()
+	if !services.HasMasterKey(target) {
+		log.Fatalf("❌ Master key version %d is not configured", target)
+	}
+
+	db := database.New()
+	defer db.Close()
+
+	rotationModel := models.KeyRotationModel{DB: db.DB()}
+	rotation, err := rotationModel.GetOrStart(target)
+	if err != nil {
+		log.Fatalf("❌ Could not start rotation: %v", err)
+	}
+	if rotation.Phase == models.RotationPhaseDone {
+		log.Printf("✅ Rotation to version %d already completed", target)
+		return
+	}
+	log.Printf("🔑 Rotating to master key version %d (phase=%s, last_id=%d, processed=%d)",
+		target, rotation.Phase, rotation.LastId, rotation.Processed)
+
+	for rotation.Phase != models.RotationPhaseDone {
+		phase := rotation.Phase
+		n, err := rotationModel.RunBatch(rotation, *batchSize)
+		if err != nil {
+			log.Fatalf("❌ Batch failed in phase %s after id %d: %v", phase, rotation.LastId, err)
+		}
+		if n > 0 {
+			log.Printf("   %s: %d rows checked, up to id %d (%d updated in total)", phase, n, rotation.LastId, rotation.Processed)
+		}
+	}
+
+	log.Printf("✅ Rotation to version %d completed, %d rows updated", target, rotation.Processed)
+}
+
+func status() {
+	db := database.New()
+	defer db.Close()
+
+	rotationModel := models.KeyRotationModel{DB: db.DB()}
+	rotations, err := rotationModel.GetAll()
+	if err != nil {
+		log.Fatalf("❌ Could not read rotations: %v", err)
+	}
+	fmt.Printf("Current key version: %d\n", services.CurrentKeyVersion())
+	for _, r := range rotations {
+		state := "in progress"
+		if r.CompletedAt != nil {
+			state = "completed " + r.CompletedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("v%d\t%s\tphase=%s\tlast_id=%d\tupdated=%d\n", r.TargetVersion, state, r.Phase, r.LastId, r.Processed)
+	}
+}
+
+func encryptNotes(args []string) {
+	fs := flag.NewFlagSet("encrypt-notes", flag.ExitOnError)
+	batchSize := fs.Int("batch", 100, "rows per batch")
+	fs.Parse(args)
+
+	if *batchSize < 1 {
+		log.Fatal("❌ -batch must be greater than 0")
+	}
+
+	db := database.New()
+	defer db.Close()
+
+	notesModel := models.NotesModel{DB: db.DB()}
+	lastID, total := 0, 0
+	for {
+		next, n, err := notesModel.EncryptLegacyFields(lastID, *batchSize)
+		if err != nil {
+			log.Fatalf("❌ Batch failed after id %d: %v", lastID, err)
+		}
+		if n == 0 {
+			break
+		}
+		lastID, total = next, total+n
+		log.Printf("   notes: encrypted up to id %d (%d in total)", lastID, total)
+	}
+
+	log.Printf("✅ %d notes encrypted and indexed", total)
+}
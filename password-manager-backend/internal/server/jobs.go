@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"time"
+
+	"password-manager-backend/cmd/api/models"
+)
+
+// every ejecuta fn cada interval en segundo plano hasta que se cancela ctx. Un
+// error se registra como "Error <name>" y la tarea sigue en la siguiente vuelta.
+func every(ctx context.Context, interval time.Duration, name string, fn func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := fn(); err != nil {
+					log.Printf("Error %s: %v", name, err)
+				}
+			}
+		}
+	}()
+}
+
+// startEmergencyExpiry concede periódicamente las peticiones de acceso de emergencia
+// cuya espera ha terminado sin que el dueño las rechace. EMERGENCY_CHECK_INTERVAL
+// acepta cualquier duración de Go (por defecto 1m).
+func (s *Server) startEmergencyExpiry() {
+	interval, err := time.ParseDuration(os.Getenv("EMERGENCY_CHECK_INTERVAL"))
+	if err != nil || interval <= 0 {
+		interval = time.Minute
+	}
+
+	emergencyModel := models.EmergencyAccessModel{DB: s.db.DB()}
+	every(s.jobs, interval, "granting expired emergency requests", func() error {
+		granted, err := emergencyModel.GrantExpired()
+		if granted > 0 {
+			log.Printf("Granted %d emergency access requests after their waiting period", granted)
+		}
+		return err
+	})
+}
+
+// startRefreshTokenCleanup borra cada hora los refresh tokens caducados
+func (s *Server) startRefreshTokenCleanup() {
+	refreshModel := models.RefreshTokenModel{DB: s.db.DB()}
+	every(s.jobs, time.Hour, "deleting expired refresh tokens", func() error {
+		_, err := refreshModel.DeleteExpired()
+		return err
+	})
+}
+
+// startWebAuthnSessionCleanup borra cada hora los retos de passkeys que nadie usó
+func (s *Server) startWebAuthnSessionCleanup() {
+	webauthnModel := models.WebAuthnModel{DB: s.db.DB()}
+	every(s.jobs, time.Hour, "deleting expired passkey sessions", func() error {
+		_, err := webauthnModel.DeleteExpiredSessions()
+		return err
+	})
+}
+
+// startPersonalAccessTokenCleanup borra cada hora los tokens de acceso personal caducados o revocados
+func (s *Server) startPersonalAccessTokenCleanup() {
+	patModel := models.PersonalAccessTokenModel{DB: s.db.DB()}
+	every(s.jobs, time.Hour, "deleting expired personal access tokens", func() error {
+		_, err := patModel.DeleteExpired()
+		return err
+	})
+}
+
+// startThrottleCleanup borra cada hora los contadores de intentos que ya no bloquean nada
+func (s *Server) startThrottleCleanup() {
+	throttleModel := models.ThrottleModel{DB: s.db.DB()}
+	every(s.jobs, time.Hour, "deleting old login attempts", func() error {
+		_, err := throttleModel.DeleteExpired()
+		return err
+	})
+}
+
+// startPasswordResetCleanup borra cada hora los enlaces de restablecer contraseña caducados
+func (s *Server) startPasswordResetCleanup() {
+	resetModel := models.PasswordResetModel{DB: s.db.DB()}
+	every(s.jobs, time.Hour, "deleting expired password reset tokens", func() error {
+		_, err := resetModel.DeleteExpired()
+		return err
+	})
+}
+
+// startEmailVerificationCleanup borra cada hora los enlaces de verificación de email caducados
+func (s *Server) startEmailVerificationCleanup() {
+	verificationModel := models.EmailVerificationModel{DB: s.db.DB()}
+	every(s.jobs, time.Hour, "deleting expired email verification tokens", func() error {
+		_, err := verificationModel.DeleteExpired()
+		return err
+	})
+}
+
+// startSessionCleanup borra cada hora las sesiones cuyos refresh tokens ya caducaron
+func (s *Server) startSessionCleanup() {
+	sessionModel := models.SessionModel{DB: s.db.DB()}
+	every(s.jobs, time.Hour, "deleting expired sessions", func() error {
+		_, err := sessionModel.DeleteExpired()
+		return err
+	})
+}
+
+// startOIDCLoginCleanup borra cada hora los logins con SSO que nunca volvieron del proveedor
+func (s *Server) startOIDCLoginCleanup() {
+	oidcModel := models.OIDCModel{DB: s.db.DB()}
+	every(s.jobs, time.Hour, "deleting expired OIDC logins", func() error {
+		_, err := oidcModel.DeleteExpiredLogins()
+		return err
+	})
+}
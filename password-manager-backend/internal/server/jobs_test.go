@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEveryKeepsRunningAfterErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := make(chan int, 3)
+	n := 0
+	every(ctx, time.Millisecond, "running test job", func() error {
+		n++
+		if n > 3 {
+			return nil
+		}
+		calls <- n
+		return errors.New("boom")
+	})
+
+	for want := 1; want <= 3; want++ {
+		select {
+		case got := <-calls:
+			if got != want {
+				t.Fatalf("expected call %d, got %d", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("job stopped after %d calls", want-1)
+		}
+	}
+}
+
+func TestEveryStopsWhenCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := make(chan struct{}, 100)
+	every(ctx, time.Millisecond, "running test job", func() error {
+		calls <- struct{}{}
+		return nil
+	})
+
+	<-calls
+	cancel()
+	// Puede quedar una vuelta en marcha al cancelar; después no debe haber más
+	time.Sleep(10 * time.Millisecond)
+	for len(calls) > 0 {
+		<-calls
+	}
+	time.Sleep(20 * time.Millisecond)
+	if len(calls) > 0 {
+		t.Errorf("job kept running after cancel: %d more calls", len(calls))
+	}
+}
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"log"
 	"net/http"
 	"password-manager-backend/cmd/api/routes"
+	"password-manager-backend/cmd/api/services"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -21,7 +23,7 @@ func (s *Server) RegisterRoutes() http.Handler {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"}, // Aquí pones el origen de tu frontend
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowHeaders:     []string{"Authorization", "Content-Type", "Accept"},
+		AllowHeaders:     []string{"Authorization", "Content-Type", "Accept", "X-CSRF-Token"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 	}))
@@ -29,14 +31,23 @@ func (s *Server) RegisterRoutes() http.Handler {
 	// Rutas de Swagger
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
+	// Claves públicas de los JWT para que otros servicios verifiquen los tokens
+	r.GET("/.well-known/jwks.json", s.jwksHandler)
+
 	v1 := r.Group("/api/v1")
 	{
 		v1.GET("/", s.HelloWorldHandler)
 		v1.GET("/health", s.healthHandler)
 		routes.UserRoutes(v1, s.db.DB())
 		routes.NotesRoutes(v1, s.db.DB())
+		routes.EmergencyRoutes(v1, s.db.DB())
+		routes.RecoveryRoutes(v1, s.db.DB())
+		routes.AdminRoutes(v1, s.db.DB())
 	}
 
+	// Aprovisionamiento desde el IdP
+	routes.SCIMRoutes(&r.RouterGroup, s.db.DB())
+
 	return r
 }
 
@@ -50,3 +61,15 @@ func (s *Server) HelloWorldHandler(c *gin.Context) {
 func (s *Server) healthHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, s.db.Health())
 }
+
+func (s *Server) jwksHandler(c *gin.Context) {
+	keys, err := services.CurrentJWTKeySet()
+	if err != nil {
+		log.Printf("Error loading JWT keys: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "JWT keys unavailable"})
+		return
+	}
+	// Los verificadores pueden cachearlo: una clave nueva se publica antes de firmar con ella
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, keys.JWKS())
+}
@@ -1,9 +1,15 @@
 package server
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"net/http/httptest"
+	"password-manager-backend/cmd/api/services"
 	"testing"
 )
 
@@ -30,3 +36,39 @@ func TestHelloWorldHandler(t *testing.T) {
 		t.Errorf("Handler returned unexpected body: got %v want %v", rr.Body.String(), expected)
 	}
 }
+
+func TestJWKSHandler(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KEY_PROVIDER", "env")
+	t.Setenv("JWT_KEY_ID", "test")
+	t.Setenv("JWT_VERIFY_KEY_IDS", "")
+	t.Setenv(services.JWTPrivateKeyName("test"), base64.StdEncoding.EncodeToString(der))
+	if err := services.ConfigureJWTKeys(); err != nil {
+		t.Fatal(err)
+	}
+	defer services.SetJWTKeySet(nil)
+
+	s := &Server{}
+	r := gin.New()
+	r.GET("/.well-known/jwks.json", s.jwksHandler)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	var jwks services.JWKS
+	if err := json.Unmarshal(rr.Body.Bytes(), &jwks); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != "test" || jwks.Keys[0].Alg != "EdDSA" {
+		t.Errorf("unexpected JWKS %s", rr.Body.String())
+	}
+}
@@ -1,7 +1,9 @@
 package server
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"strconv"
@@ -9,20 +11,53 @@ import (
 
 	_ "github.com/joho/godotenv/autoload"
 
+	"password-manager-backend/cmd/api/services"
 	"password-manager-backend/internal/database"
 )
 
 type Server struct {
 	port int
 	db   database.Service
+	// jobs se cancela al apagar el servidor y para las tareas periódicas
+	jobs context.Context
 }
 
 func NewServer() *http.Server { // Añadido el param db para poderlo usar
 	port, _ := strconv.Atoi(os.Getenv("PORT"))
+
+	// Sin proveedor de claves o sin clave de firma no tiene sentido arrancar
+	if err := services.ConfigureKeyProvider(); err != nil {
+		log.Fatalf("Key provider error: %v", err)
+	}
+	if err := services.ConfigureJWTKeys(); err != nil {
+		log.Fatalf("JWT signing key error: %v", err)
+	}
+	services.ConfigureMailer()
+	// OIDC es opcional, pero si está a medio configurar mejor no arrancar
+	if err := services.ConfigureOIDC(); err != nil {
+		log.Fatalf("OIDC configuration error: %v", err)
+	}
+	if err := services.ConfigureLDAP(); err != nil {
+		log.Fatalf("LDAP configuration error: %v", err)
+	}
+	if err := services.ConfigureSCIM(); err != nil {
+		log.Fatalf("SCIM configuration error: %v", err)
+	}
+	jobs, stopJobs := context.WithCancel(context.Background())
 	NewServer := &Server{
 		port: port,
 		db:   database.New(),
+		jobs: jobs,
 	}
+	NewServer.startEmergencyExpiry()
+	NewServer.startRefreshTokenCleanup()
+	NewServer.startWebAuthnSessionCleanup()
+	NewServer.startPersonalAccessTokenCleanup()
+	NewServer.startThrottleCleanup()
+	NewServer.startPasswordResetCleanup()
+	NewServer.startEmailVerificationCleanup()
+	NewServer.startSessionCleanup()
+	NewServer.startOIDCLoginCleanup()
 
 	// Declare Server config
 	server := &http.Server{
@@ -32,6 +67,7 @@ func NewServer() *http.Server { // Añadido el param db para poderlo usar
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 	}
+	server.RegisterOnShutdown(stopJobs)
 
 	return server
 }
@@ -10,13 +10,15 @@ import { cookieService } from "./services/cookie.service";
 import SwaggerPage from "./pages/SwaggerPage";
 import AdminPage from "./pages/AdminPage";
 import NotesPage from "./pages/NotesPage";
+import ResetPasswordPage from "./pages/ResetPasswordPage";
+import VerifyEmailPage from "./pages/VerifyEmailPage";
+import OidcCallbackPage from "./pages/OidcCallbackPage";
 
 const App: React.FC = () => {
   const [hasToken, setHasToken] = useState<boolean | null>(null);
 
   useEffect(() => {
-    const token = cookieService.getToken();
-    setHasToken(!!token);
+    setHasToken(cookieService.hasSession());
   }, []);
 
   if (hasToken === null) {
@@ -39,11 +41,15 @@ const App: React.FC = () => {
             <Route path="/obsidian" element={<ObsidianNotesDisplay />} />
             <Route path="/apidocs" element={<SwaggerPage />} />
             <Route path="/admin" element={<AdminPage />} />
+            <Route path="/verify-email" element={<VerifyEmailPage />} />
           </Routes>
         </>
       ) : (
         <Routes>
           <Route path="/login" element={<AuthPanel />} />
+          <Route path="/reset-password" element={<ResetPasswordPage />} />
+          <Route path="/verify-email" element={<VerifyEmailPage />} />
+          <Route path="/oidc/callback" element={<OidcCallbackPage />} />
           {/* Si no hay token, cualquier ruta redirige a login */}
           <Route path="*" element={<Navigate to="/login" replace />} />
         </Routes>
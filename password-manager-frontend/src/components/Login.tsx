@@ -1,35 +1,133 @@
-import React, { useState } from "react";
+import React, { useEffect, useState } from "react";
 import { TextField, Button, Box, Typography, Alert } from "@mui/material";
-import { loginUser } from "../services/api.service";
-import type { LoginRequest } from "../models/LoginRequest.models";
+import { beginOidcLogin, changeRequiredPassword, forgotPassword, getAuthMethods, loginUser, loginWithPasskey, resendVerification, verifyMfa } from "../services/api.service";
+import type { AuthMethods, LoginRequest, LoginResponse, PasswordChangeChallenge } from "../models/LoginRequest.models";
 import { cookieService } from "../services/cookie.service"
 
 const Login: React.FC = () => {
     const [email, setEmail] = useState("");
     const [password, setPassword] = useState("");
     const [error, setError] = useState<string | null>(null);
+    const [info, setInfo] = useState<string | null>(null);
     const [loading, setLoading] = useState(false);
+    // Token del primer paso cuando la cuenta tiene 2FA
+    const [mfaToken, setMfaToken] = useState<string | null>(null);
+    const [code, setCode] = useState("");
+    // Token del login cuando un administrador ha obligado a cambiar la contraseña
+    const [changeToken, setChangeToken] = useState<string | null>(null);
+    const [newPassword, setNewPassword] = useState("");
+    // La contraseña era correcta pero el email aún no está verificado
+    const [unverified, setUnverified] = useState(false);
+    // Mientras no se sepa, se enseña el formulario de contraseña como siempre
+    const [methods, setMethods] = useState<AuthMethods>({ password: true, oidc: false });
+
+    useEffect(() => {
+        getAuthMethods().then(setMethods).catch(() => {});
+    }, []);
+
+    const startSession = (data: LoginResponse | PasswordChangeChallenge) => {
+        if ("password_change_required" in data) {
+            setMfaToken(null);
+            setChangeToken(data.password_change_token);
+            setInfo("Tienes que elegir una contraseña nueva antes de entrar");
+            return;
+        }
+        cookieService.setCsrfToken(data.csrf_token);
+        cookieService.setUser(data.user);
+        // recarga la app para que App lea el token
+        window.location.href = "/";
+    };
+
+    const handleForgot = async () => {
+        setError(null);
+        setInfo(null);
+        if (!email) {
+            setError("Escribe tu email para recibir el enlace");
+            return;
+        }
+        try {
+            await forgotPassword(email);
+            setInfo("Si el email está registrado, te hemos enviado un enlace para restablecer la contraseña");
+        } catch (err: unknown) {
+            setError(err instanceof Error ? err.message : "Error desconocido");
+        }
+    };
+
+    const handleResend = async () => {
+        setError(null);
+        setInfo(null);
+        try {
+            await resendVerification(email);
+            setUnverified(false);
+            setInfo("Te hemos enviado otro enlace para verificar el email");
+        } catch (err: unknown) {
+            setError(err instanceof Error ? err.message : "Error desconocido");
+        }
+    };
+
+    const handlePasskey = async () => {
+        setLoading(true);
+        setError(null);
+        try {
+            startSession(await loginWithPasskey());
+        } catch (err: unknown) {
+            if (err instanceof Error) {
+                setError(err.message);
+            } else {
+                setError("Error desconocido");
+            }
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    const handleOidc = async () => {
+        setLoading(true);
+        setError(null);
+        try {
+            window.location.href = await beginOidcLogin();
+        } catch (err: unknown) {
+            setError(err instanceof Error ? err.message : "Error desconocido");
+            setLoading(false);
+        }
+    };
 
     const handleSubmit = async (e: React.FormEvent) => {
         e.preventDefault();
         setLoading(true);
         setError(null);
+        setUnverified(false);
         try {
+            if (changeToken) {
+                await changeRequiredPassword(changeToken, newPassword);
+                setChangeToken(null);
+                setPassword("");
+                setNewPassword("");
+                setInfo("Contraseña cambiada, entra con la nueva");
+                return;
+            }
+            if (mfaToken) {
+                // Los códigos de recuperación llevan guiones, los de la app son 6 dígitos
+                const isRecovery = code.includes("-");
+                startSession(await verifyMfa({
+                    mfa_token: mfaToken,
+                    ...(isRecovery ? { recovery_code: code } : { code }),
+                }));
+                return;
+            }
+
             const requestData: LoginRequest = { email, password };
             const data = await loginUser(requestData);
-
-            cookieService.setToken(data.token);
-            cookieService.setUser(data.user);
-            // Aquí podrías redirigir al dashboard
-            console.log("Login exitoso", data);
-            cookieService.setToken(data.token);
-            cookieService.setUser(data.user);
-            // recarga la app para que App lea el token
-            window.location.href = "/";
+            if ("mfa_required" in data) {
+                setMfaToken(data.mfa_token);
+                return;
+            }
+            startSession(data);
 
         } catch (err: unknown) {
             // Type guard
             if (err instanceof Error) {
+                setUnverified(err.message === "Email not verified");
                 setError(err.message);
             } else {
                 setError("Error desconocido");
@@ -57,6 +155,11 @@ const Login: React.FC = () => {
                     {error}
                 </Alert>
             )}
+            {info && (
+                <Alert severity="info" sx={{ mb: 2 }}>
+                    {info}
+                </Alert>
+            )}
 
             <Box
                 component="form"
@@ -67,25 +170,73 @@ const Login: React.FC = () => {
                 width="100%"
                 maxWidth={400}
             >
-                <TextField
-                    label="Email"
-                    type="email"
-                    value={email}
-                    required
-                    onChange={(e) => setEmail(e.target.value)}
-                />
-
-                <TextField
-                    label="Password"
-                    type="password"
-                    value={password}
-                    required
-                    onChange={(e) => setPassword(e.target.value)}
-                />
-
-                <Button variant="contained" color="primary" type="submit" disabled={loading}>
-                    {loading ? "Cargando..." : "Login"}
-                </Button>
+                {changeToken ? (
+                    <TextField
+                        label="Contraseña nueva"
+                        type="password"
+                        value={newPassword}
+                        required
+                        autoFocus
+                        autoComplete="new-password"
+                        onChange={(e) => setNewPassword(e.target.value)}
+                    />
+                ) : mfaToken ? (
+                    <TextField
+                        label="Código de verificación o de recuperación"
+                        value={code}
+                        required
+                        autoFocus
+                        autoComplete="one-time-code"
+                        onChange={(e) => setCode(e.target.value.trim())}
+                    />
+                ) : methods.password && (
+                    <>
+                        <TextField
+                            label="Email"
+                            type="email"
+                            value={email}
+                            required
+                            onChange={(e) => setEmail(e.target.value)}
+                        />
+
+                        <TextField
+                            label="Password"
+                            type="password"
+                            value={password}
+                            required
+                            onChange={(e) => setPassword(e.target.value)}
+                        />
+                    </>
+                )}
+
+                {(changeToken || mfaToken || methods.password) && (
+                    <Button variant="contained" color="primary" type="submit" disabled={loading}>
+                        {loading ? "Cargando..." : changeToken ? "Cambiar contraseña" : mfaToken ? "Verificar" : "Login"}
+                    </Button>
+                )}
+
+                {!mfaToken && !changeToken && (
+                    <>
+                        {methods.oidc && (
+                            <Button variant="contained" color="secondary" onClick={handleOidc} disabled={loading}>
+                                Entrar con {methods.oidc_name}
+                            </Button>
+                        )}
+                        <Button variant="outlined" onClick={handlePasskey} disabled={loading}>
+                            Entrar con passkey
+                        </Button>
+                        {methods.password && (
+                            <Button variant="text" onClick={handleForgot} disabled={loading}>
+                                ¿Has olvidado la contraseña?
+                            </Button>
+                        )}
+                        {unverified && (
+                            <Button variant="text" onClick={handleResend} disabled={loading}>
+                                Reenviar el enlace de verificación
+                            </Button>
+                        )}
+                    </>
+                )}
             </Box>
         </Box>
     );
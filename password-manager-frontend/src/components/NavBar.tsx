@@ -31,7 +31,7 @@ const Navbar: React.FC = () => {
     { label: "API Docs", path: "/apidocs" },
   ];
 
-  if (user?.admin) {
+  if (user?.permissions?.includes("users:view")) {
     tabs.push({ label: "Admin Panel", path: "/admin" });
   }
 
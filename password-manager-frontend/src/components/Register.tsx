@@ -22,7 +22,7 @@ const Register: React.FC = () => {
       const data = await registerUser(requestData);
 
       console.log("Registro exitoso", data);
-      setSuccess("¡Registrado correctamente!");
+      setSuccess("¡Registrado correctamente! Revisa tu correo para verificar el email");
       setEmail("");
       setUsername("");
       setPassword("");
@@ -0,0 +1,66 @@
+import React, { useEffect, useState } from "react";
+import { Alert, Box, Button, List, ListItem, ListItemText, Typography } from "@mui/material";
+import { getSessions, revokeOtherSessions, revokeSession } from "../services/api.service";
+import type { Session } from "../models/Session.model";
+
+// Dispositivos con sesión abierta; cada uno se puede cerrar por separado
+const SessionsList: React.FC = () => {
+    const [sessions, setSessions] = useState<Session[]>([]);
+    const [error, setError] = useState<string | null>(null);
+
+    const load = async () => {
+        try {
+            setSessions(await getSessions());
+        } catch (err: unknown) {
+            setError(err instanceof Error ? err.message : "Error desconocido");
+        }
+    };
+
+    useEffect(() => {
+        load();
+    }, []);
+
+    const run = async (action: () => Promise<void>) => {
+        setError(null);
+        try {
+            await action();
+            await load();
+        } catch (err: unknown) {
+            setError(err instanceof Error ? err.message : "Error desconocido");
+        }
+    };
+
+    return (
+        <Box mt={4} width="100%" maxWidth={500}>
+            <Typography variant="h6">Sesiones abiertas</Typography>
+            {error && <Alert severity="error" sx={{ my: 1 }}>{error}</Alert>}
+            <List dense>
+                {sessions.map((s) => (
+                    <ListItem
+                        key={s.id}
+                        secondaryAction={
+                            !s.current && (
+                                <Button size="small" color="error" onClick={() => run(() => revokeSession(s.id))}>
+                                    Cerrar
+                                </Button>
+                            )
+                        }
+                    >
+                        <ListItemText
+                            primary={s.current ? `${s.device_name} (esta sesión)` : s.device_name}
+                            secondary={`${s.ip} · última actividad ${new Date(s.last_seen_at).toLocaleString()}`}
+                            title={s.user_agent}
+                        />
+                    </ListItem>
+                ))}
+            </List>
+            {sessions.some((s) => !s.current) && (
+                <Button variant="outlined" color="error" onClick={() => run(revokeOtherSessions)}>
+                    Cerrar las demás sesiones
+                </Button>
+            )}
+        </Box>
+    );
+};
+
+export default SessionsList;
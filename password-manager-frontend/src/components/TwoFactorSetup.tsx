@@ -0,0 +1,88 @@
+import React, { useState } from "react";
+import { Box, Typography, Button, TextField, Alert } from "@mui/material";
+import { enrollTotp, confirmTotp } from "../services/api.service";
+import type { TotpEnrollResponse } from "../models/LoginRequest.models";
+
+interface TwoFactorSetupProps {
+    onEnabled: () => void;
+}
+
+// Alta del 2FA: se añade el secreto a la app de autenticación, se confirma con el
+// primer código y se enseñan los códigos de recuperación una sola vez
+const TwoFactorSetup: React.FC<TwoFactorSetupProps> = ({ onEnabled }) => {
+    const [enrollment, setEnrollment] = useState<TotpEnrollResponse | null>(null);
+    const [code, setCode] = useState("");
+    const [recoveryCodes, setRecoveryCodes] = useState<string[] | null>(null);
+    const [error, setError] = useState<string | null>(null);
+    const [loading, setLoading] = useState(false);
+
+    const run = async (action: () => Promise<void>) => {
+        setError(null);
+        setLoading(true);
+        try {
+            await action();
+        } catch (err: unknown) {
+            if (err instanceof Error) setError(err.message);
+            else setError("Error desconocido");
+        } finally {
+            setLoading(false);
+        }
+    };
+
+    const handleStart = () => run(async () => setEnrollment(await enrollTotp()));
+
+    const handleConfirm = () => run(async () => {
+        const data = await confirmTotp(code);
+        setRecoveryCodes(data.recovery_codes);
+    });
+
+    if (recoveryCodes) {
+        return (
+            <Box mt={3} maxWidth={400}>
+                <Alert severity="success" sx={{ mb: 2 }}>
+                    2FA activado. Guarda estos códigos: cada uno sirve una vez si pierdes la app y no se volverán a mostrar.
+                </Alert>
+                <Box component="pre" sx={{ fontFamily: "monospace", backgroundColor: "#fff", p: 2 }}>
+                    {recoveryCodes.join("\n")}
+                </Box>
+                <Button variant="contained" onClick={onEnabled}>
+                    Los he guardado
+                </Button>
+            </Box>
+        );
+    }
+
+    return (
+        <Box mt={3} display="flex" flexDirection="column" gap={2} maxWidth={400}>
+            <Typography variant="h6">Verificación en dos pasos</Typography>
+            {error && <Alert severity="error">{error}</Alert>}
+
+            {!enrollment ? (
+                <Button variant="outlined" disabled={loading} onClick={handleStart}>
+                    Activar 2FA
+                </Button>
+            ) : (
+                <>
+                    <Typography variant="body2">
+                        Añade esta clave a tu app de autenticación (o abre el enlace en el móvil) e introduce el código que genera.
+                    </Typography>
+                    <Typography variant="body1" sx={{ fontFamily: "monospace", wordBreak: "break-all" }}>
+                        {enrollment.secret}
+                    </Typography>
+                    <a href={enrollment.otpauth_uri}>Abrir en la app de autenticación</a>
+                    <TextField
+                        label="Código"
+                        value={code}
+                        autoComplete="one-time-code"
+                        onChange={(e) => setCode(e.target.value.trim())}
+                    />
+                    <Button variant="contained" disabled={loading || code.length === 0} onClick={handleConfirm}>
+                        Confirmar
+                    </Button>
+                </>
+            )}
+        </Box>
+    );
+};
+
+export default TwoFactorSetup;
@@ -19,7 +19,7 @@ const UsersTable: React.FC<UsersTableProps> = ({ users, onEdit, onDelete }) => (
                 <TableCell sx={{ color: "#fff" }}>Nombre</TableCell>
                 <TableCell sx={{ color: "#fff" }}>Email</TableCell>
                 <TableCell sx={{ color: "#fff" }}>Contraseña</TableCell>
-                <TableCell sx={{ color: "#fff" }}>Admin</TableCell>
+                <TableCell sx={{ color: "#fff" }}>Roles</TableCell>
                 <TableCell sx={{ color: "#fff" }}>Acciones</TableCell>
             </TableRow>
         </TableHead>
@@ -31,7 +31,7 @@ const UsersTable: React.FC<UsersTableProps> = ({ users, onEdit, onDelete }) => (
                     <TableCell>{user.username ?? ""}</TableCell>
                     <TableCell>{user.email ?? ""}</TableCell>
                     <TableCell>{user.password ?? ""}</TableCell>
-                    <TableCell>{String(user.admin ?? false)}</TableCell>
+                    <TableCell>{(user.roles ?? []).join(", ")}</TableCell>
                     <TableCell>
                         <Button variant="contained" color="primary" sx={{ mr: 1 }} onClick={() => onEdit(user)}>Editar</Button>
                         <Button variant="contained" color="error" onClick={() => onDelete(Number(user.id))}>Eliminar</Button>
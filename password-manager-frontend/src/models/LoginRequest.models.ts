@@ -3,9 +3,59 @@ import type { User } from "./User.model";
 export interface LoginRequest {
   email: string;
   password: string;
+  session_mode?: "token" | "cookie";
 }
 
+// En modo cookie los tokens no vienen en el body, solo el token CSRF
 export interface LoginResponse {
-  token: string;
+  token?: string;
+  refresh_token?: string;
+  csrf_token?: string;
+  expires_in: number;
   user: User
+}
+
+// Con 2FA activo el login no abre sesión: devuelve el token para el segundo paso
+export interface MfaChallenge {
+  mfa_required: true;
+  mfa_token: string;
+  expires_in: number;
+}
+
+// Un administrador ha obligado a cambiar la contraseña: el login no abre sesión
+export interface PasswordChangeChallenge {
+  password_change_required: true;
+  password_change_token: string;
+  expires_in: number;
+}
+
+// Formas de entrar que acepta el despliegue
+export interface AuthMethods {
+  password: boolean;
+  oidc: boolean;
+  oidc_name?: string;
+}
+
+export interface MfaLoginRequest {
+  mfa_token: string;
+  code?: string;
+  recovery_code?: string;
+  session_mode?: "token" | "cookie";
+}
+
+export interface TotpEnrollResponse {
+  secret: string;
+  otpauth_uri: string;
+}
+
+// Al confirmar el 2FA la API abre una sesión nueva y enseña los códigos una sola vez
+export interface TotpConfirmResponse extends RefreshResponse {
+  recovery_codes: string[];
+}
+
+export interface RefreshResponse {
+  token?: string;
+  refresh_token?: string;
+  csrf_token?: string;
+  expires_in: number;
 }
\ No newline at end of file
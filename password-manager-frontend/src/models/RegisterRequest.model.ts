@@ -9,5 +9,5 @@ export interface RegisterResponse {
   email: string;
   username: string;
   icon: string;
-  admin: boolean;
+  roles: string[];
 }
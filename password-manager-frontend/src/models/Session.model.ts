@@ -0,0 +1,10 @@
+// Sesión abierta en un dispositivo, ver GET /users/me/sessions
+export interface Session {
+  id: string;
+  device_name: string;
+  user_agent: string;
+  ip: string;
+  created_at: string;
+  last_seen_at: string;
+  current: boolean;
+}
@@ -3,6 +3,12 @@ export interface User {
   email: string;
   username: string;
   icon: string;
-  admin: boolean;
+  roles: string[];
+  // Solo en /users/me
+  permissions?: string[];
   password: string;
+  totp_enabled: boolean;
+  email_verified?: boolean;
+  disabled?: boolean;
+  must_change_password?: boolean;
 };
\ No newline at end of file
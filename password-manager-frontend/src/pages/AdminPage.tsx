@@ -38,8 +38,11 @@ const AdminPage: React.FC = () => {
   const handleSaveEdit = async () => {
     if (!editUser) return;
     try {
-      await updateUser(editUser.id, { username: editUsername, email: editEmail });
-      setUsers(prev => prev.map(u => u.id === editUser.id ? { ...u, username: editUsername, email: editEmail } : u));
+      const res = await updateUser(editUser.id, { username: editUsername, email: editEmail });
+      // Un email nuevo no cambia hasta que se confirme desde él
+      const email = res.email_change_pending ? editUser.email : editEmail;
+      setUsers(prev => prev.map(u => u.id === editUser.id ? { ...u, username: editUsername, email } : u));
+      if (res.email_change_pending) alert(res.message);
     } catch (err) {
       if (err instanceof Error) alert("Error al actualizar usuario: " + err.message);
     } finally {
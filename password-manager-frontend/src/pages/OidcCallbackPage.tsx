@@ -0,0 +1,110 @@
+import React, { useEffect, useRef, useState } from "react";
+import { Alert, Box, Button, CircularProgress, TextField, Typography } from "@mui/material";
+import { useSearchParams } from "react-router-dom";
+import { finishOidcLogin, verifyMfa } from "../services/api.service";
+import type { LoginResponse } from "../models/LoginRequest.models";
+import { cookieService } from "../services/cookie.service";
+
+// Vuelta del proveedor de SSO: /oidc/callback?code=...&state=...
+const OidcCallbackPage: React.FC = () => {
+  const [searchParams] = useSearchParams();
+  const code = searchParams.get("code") ?? "";
+  const state = searchParams.get("state") ?? "";
+  // El proveedor puede volver con ?error=access_denied si el usuario cancela
+  const providerError = searchParams.get("error_description") ?? searchParams.get("error");
+  const [error, setError] = useState<string | null>(providerError);
+  const [mfaToken, setMfaToken] = useState<string | null>(null);
+  const [mfaCode, setMfaCode] = useState("");
+  const [loading, setLoading] = useState(false);
+  // El code solo vale una vez: en modo estricto React monta dos veces
+  const sent = useRef(false);
+
+  const startSession = (data: LoginResponse) => {
+    cookieService.setCsrfToken(data.csrf_token);
+    cookieService.setUser(data.user);
+    window.location.href = "/";
+  };
+
+  useEffect(() => {
+    if (!code || !state || sent.current) return;
+    sent.current = true;
+    finishOidcLogin(code, state)
+      .then((data) => {
+        if ("mfa_required" in data) {
+          setMfaToken(data.mfa_token);
+          return;
+        }
+        startSession(data);
+      })
+      .catch((err: unknown) => setError(err instanceof Error ? err.message : "Error desconocido"));
+  }, [code, state]);
+
+  const handleMfa = async (e: React.FormEvent) => {
+    e.preventDefault();
+    if (!mfaToken) return;
+    setLoading(true);
+    setError(null);
+    try {
+      const isRecovery = mfaCode.includes("-");
+      const data = await verifyMfa({
+        mfa_token: mfaToken,
+        ...(isRecovery ? { recovery_code: mfaCode } : { code: mfaCode }),
+      });
+      // El cambio de contraseña obligado se hace desde el login con contraseña
+      if ("password_change_required" in data) {
+        setError("Tienes que cambiar la contraseña: entra con tu email y tu contraseña");
+        return;
+      }
+      startSession(data);
+    } catch (err: unknown) {
+      setError(err instanceof Error ? err.message : "Error desconocido");
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <Box display="flex" flexDirection="column" alignItems="center" justifyContent="center" minHeight="100vh" px={2}>
+      <Typography variant="h4" mb={3}>
+        Login con SSO
+      </Typography>
+
+      {error && (
+        <Alert severity="error" sx={{ mb: 2 }}>
+          {error}
+        </Alert>
+      )}
+
+      {mfaToken ? (
+        <Box component="form" onSubmit={handleMfa} display="flex" flexDirection="column" gap={2} width="100%" maxWidth={400}>
+          <TextField
+            label="Código de verificación o de recuperación"
+            value={mfaCode}
+            required
+            autoFocus
+            autoComplete="one-time-code"
+            onChange={(e) => setMfaCode(e.target.value.trim())}
+          />
+          <Button variant="contained" type="submit" disabled={loading}>
+            {loading ? "Cargando..." : "Verificar"}
+          </Button>
+        </Box>
+      ) : error || !code || !state ? (
+        <>
+          {!error && (
+            <Alert severity="warning" sx={{ mb: 2 }}>
+              El enlace no es válido
+            </Alert>
+          )}
+          <Button variant="contained" href="/login">
+            Volver al login
+          </Button>
+        </>
+      ) : (
+        <CircularProgress />
+      )}
+    </Box>
+  );
+};
+
+export default OidcCallbackPage;
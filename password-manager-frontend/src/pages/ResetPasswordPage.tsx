@@ -0,0 +1,85 @@
+import React, { useState } from "react";
+import { Alert, Box, Button, TextField, Typography } from "@mui/material";
+import { useSearchParams } from "react-router-dom";
+import { resetPassword } from "../services/api.service";
+
+// Página del enlace del correo: /reset-password?token=...
+const ResetPasswordPage: React.FC = () => {
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get("token") ?? "";
+  const [password, setPassword] = useState("");
+  const [repeat, setRepeat] = useState("");
+  const [error, setError] = useState<string | null>(null);
+  const [done, setDone] = useState(false);
+  const [loading, setLoading] = useState(false);
+
+  const handleSubmit = async (e: React.FormEvent) => {
+    e.preventDefault();
+    setError(null);
+    if (password !== repeat) {
+      setError("Las contraseñas no coinciden");
+      return;
+    }
+    setLoading(true);
+    try {
+      await resetPassword(token, password);
+      setDone(true);
+    } catch (err: unknown) {
+      setError(err instanceof Error ? err.message : "Error desconocido");
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <Box display="flex" flexDirection="column" alignItems="center" justifyContent="center" minHeight="100vh" px={2}>
+      <Typography variant="h4" mb={3}>
+        Restablecer contraseña
+      </Typography>
+
+      {error && (
+        <Alert severity="error" sx={{ mb: 2 }}>
+          {error}
+        </Alert>
+      )}
+
+      {done ? (
+        <>
+          <Alert severity="success" sx={{ mb: 2 }}>
+            Contraseña cambiada. Se han cerrado todas tus sesiones.
+          </Alert>
+          <Button variant="contained" href="/login">
+            Ir al login
+          </Button>
+        </>
+      ) : !token ? (
+        <Alert severity="warning">El enlace no es válido</Alert>
+      ) : (
+        <Box component="form" onSubmit={handleSubmit} display="flex" flexDirection="column" gap={2} width="100%" maxWidth={400}>
+          <TextField
+            label="Contraseña nueva"
+            type="password"
+            value={password}
+            required
+            autoComplete="new-password"
+            inputProps={{ minLength: 8, maxLength: 64 }}
+            onChange={(e) => setPassword(e.target.value)}
+          />
+          <TextField
+            label="Repite la contraseña"
+            type="password"
+            value={repeat}
+            required
+            autoComplete="new-password"
+            onChange={(e) => setRepeat(e.target.value)}
+          />
+          <Button variant="contained" type="submit" disabled={loading}>
+            {loading ? "Cargando..." : "Cambiar contraseña"}
+          </Button>
+        </Box>
+      )}
+    </Box>
+  );
+};
+
+export default ResetPasswordPage;
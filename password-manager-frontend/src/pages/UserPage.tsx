@@ -1,8 +1,9 @@
 import React, { useState, useEffect } from "react";
 import { Box, Typography, Avatar, CircularProgress, Button, TextField, Alert } from "@mui/material";
-import { getMe, updateUser } from "../services/api.service";
+import { getMe, updateUser, logoutUser, registerPasskey } from "../services/api.service";
 import type { User } from "../models/User.model";
-import { cookieService } from "../services/cookie.service";
+import TwoFactorSetup from "../components/TwoFactorSetup";
+import SessionsList from "../components/SessionsList";
 
 const UserPage: React.FC = () => {
   const [user, setUser] = useState<User | null>(null);
@@ -31,20 +32,41 @@ const UserPage: React.FC = () => {
     fetchUser();
   }, []);
 
-  const handleLogout = () => {
-    cookieService.clearAuth();
+  const handleLogout = async () => {
+    await logoutUser().catch(() => undefined);
     window.location.reload();
   };
 
+  const handleAddPasskey = async () => {
+    setError(null);
+    setSuccess(null);
+    const name = window.prompt("Nombre para la passkey", "Mi llave de seguridad");
+    if (!name) return;
+    try {
+      await registerPasskey(name);
+      setSuccess("Passkey registrada");
+    } catch (err: unknown) {
+      if (err instanceof Error) setError(err.message);
+      else setError("Error desconocido");
+    }
+  };
+
   const handleSave = async () => {
     if (!user) return;
     setError(null);
     setSuccess(null);
     try {
       setSaving(true);
-      await updateUser(user.id, { username, email });
-      setUser({ ...user, username, email });
-      setSuccess("¡Datos actualizados correctamente!");
+      const res = await updateUser(user.id, { username, email });
+      if (res.email_change_pending) {
+        // El email sigue siendo el anterior hasta que se confirme el nuevo
+        setUser({ ...user, username });
+        setEmail(user.email);
+        setSuccess(`Te hemos enviado un enlace a ${res.email_change_pending} para confirmar el cambio de email`);
+      } else {
+        setUser({ ...user, username, email });
+        setSuccess("¡Datos actualizados correctamente!");
+      }
     } catch (err: unknown) {
       if (err instanceof Error) setError(err.message);
       else setError("Error desconocido");
@@ -87,7 +109,7 @@ const UserPage: React.FC = () => {
       />
 
       <Typography variant="body2" color="textSecondary" mt={2}>
-        {user.admin ? "Admin" : "Usuario normal"}
+        {user.roles?.length ? user.roles.join(", ") : "Sin roles"}
       </Typography>
       <Typography variant="body1">Contraseña: {user.password}</Typography>
 
@@ -108,7 +130,17 @@ const UserPage: React.FC = () => {
         >
           Cerrar sesión
         </Button>
+
+        <Button variant="outlined" onClick={handleAddPasskey}>
+          Añadir passkey
+        </Button>
       </Box>
+
+      {!user.totp_enabled && (
+        <TwoFactorSetup onEnabled={() => setUser({ ...user, totp_enabled: true })} />
+      )}
+
+      <SessionsList />
     </Box>
   );
 };
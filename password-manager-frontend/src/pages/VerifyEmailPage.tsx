@@ -0,0 +1,49 @@
+import React, { useEffect, useRef, useState } from "react";
+import { Alert, Box, Button, CircularProgress, Typography } from "@mui/material";
+import { useSearchParams } from "react-router-dom";
+import { verifyEmail } from "../services/api.service";
+
+// Página del enlace del correo: /verify-email?token=...
+const VerifyEmailPage: React.FC = () => {
+  const [searchParams] = useSearchParams();
+  const token = searchParams.get("token") ?? "";
+  const [error, setError] = useState<string | null>(null);
+  const [email, setEmail] = useState<string | null>(null);
+  // El token solo vale una vez: en modo estricto React monta dos veces
+  const sent = useRef(false);
+
+  useEffect(() => {
+    if (!token || sent.current) return;
+    sent.current = true;
+    verifyEmail(token)
+      .then((res) => setEmail(res.email))
+      .catch((err: unknown) => setError(err instanceof Error ? err.message : "Error desconocido"));
+  }, [token]);
+
+  return (
+    <Box display="flex" flexDirection="column" alignItems="center" justifyContent="center" minHeight="100vh" px={2}>
+      <Typography variant="h4" mb={3}>
+        Verificar email
+      </Typography>
+
+      {!token ? (
+        <Alert severity="warning">El enlace no es válido</Alert>
+      ) : error ? (
+        <Alert severity="error">{error}</Alert>
+      ) : email ? (
+        <>
+          <Alert severity="success" sx={{ mb: 2 }}>
+            {email} verificado
+          </Alert>
+          <Button variant="contained" href="/">
+            Continuar
+          </Button>
+        </>
+      ) : (
+        <CircularProgress />
+      )}
+    </Box>
+  );
+};
+
+export default VerifyEmailPage;
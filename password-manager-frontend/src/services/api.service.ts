@@ -1,23 +1,88 @@
 // src/services/api.services.ts
-import type { LoginRequest, LoginResponse } from "../models/LoginRequest.models";
+import type {
+  AuthMethods,
+  LoginRequest,
+  LoginResponse,
+  MfaChallenge,
+  MfaLoginRequest,
+  PasswordChangeChallenge,
+  RefreshResponse,
+  TotpConfirmResponse,
+  TotpEnrollResponse,
+} from "../models/LoginRequest.models";
 import type { RegisterRequest, RegisterResponse } from "../models/RegisterRequest.model";
 import type { User } from "../models/User.model";
 import type { Note } from '../models/Notes.model';
+import type { Session } from "../models/Session.model";
 import { cookieService } from "./cookie.service";
+import { assertionToJSON, creationToJSON, toCreationOptions, toRequestOptions } from "./passkey.service";
 
 const API_BASE = "http://localhost:8000/api/v1";
 
-// Función helper para crear headers con token
-function authHeader(token?: string) {
-  if (!token) throw new Error("No token found");
-  return { Authorization: token };
+// Función helper para crear headers de sesión. El token va en una cookie HttpOnly
+// que pone la API, así que aquí solo se añade el token CSRF en las peticiones que
+// cambian algo.
+function authHeader(method: string = "GET", headers?: HeadersInit): Headers {
+  const result = new Headers(headers);
+  if (!["GET", "HEAD", "OPTIONS"].includes(method.toUpperCase())) {
+    const csrf = cookieService.getCsrfToken();
+    if (!csrf) throw new Error("No session found");
+    result.set("X-CSRF-Token", csrf);
+  }
+  return result;
+}
+
+// Renueva la sesión con el refresh token de su cookie. Cada refresh token sirve una
+// sola vez y la API deja el siguiente en la cookie.
+let refreshing: Promise<boolean> | null = null;
+
+export function refreshSession(): Promise<boolean> {
+  // Varias peticiones pueden caducar a la vez: todas esperan al mismo refresh
+  if (!refreshing) {
+    refreshing = (async () => {
+      const res = await fetch(`${API_BASE}/users/auth/refresh`, {
+        method: "POST",
+        headers: authHeader("POST"),
+        credentials: "include",
+      });
+      if (!res.ok) {
+        cookieService.clearAuth();
+        return false;
+      }
+
+      const data: RefreshResponse = await res.json();
+      cookieService.setCsrfToken(data.csrf_token);
+      return true;
+    })()
+      .catch(() => false)
+      .finally(() => {
+        refreshing = null;
+      });
+  }
+  return refreshing;
+}
+
+// fetch con la sesión; si el token ha caducado la renueva y repite la petición una vez
+async function authFetch(url: string, init: RequestInit = {}): Promise<Response> {
+  const send = () =>
+    fetch(url, {
+      ...init,
+      headers: authHeader(init.method, init.headers),
+      credentials: "include",
+    });
+
+  const res = await send();
+  if (res.status === 401 && (await refreshSession())) {
+    return send();
+  }
+  return res;
 }
 
-export async function loginUser(data: LoginRequest): Promise<LoginResponse> {
+export async function loginUser(data: LoginRequest): Promise<LoginResponse | MfaChallenge | PasswordChangeChallenge> {
   const res = await fetch(`${API_BASE}/users/auth/login`, {
     method: "POST",
     headers: { "Content-Type": "application/json" },
-    body: JSON.stringify(data),
+    body: JSON.stringify({ ...data, session_mode: "cookie" }),
     credentials: "include",
   });
 
@@ -29,6 +94,230 @@ export async function loginUser(data: LoginRequest): Promise<LoginResponse> {
   return res.json();
 }
 
+// Segundo paso del login con el código de la app o uno de recuperación
+export async function verifyMfa(data: MfaLoginRequest): Promise<LoginResponse | PasswordChangeChallenge> {
+  const res = await fetch(`${API_BASE}/users/auth/mfa`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ ...data, session_mode: "cookie" }),
+    credentials: "include",
+  });
+
+  if (!res.ok) {
+    const errorData = await res.json();
+    throw new Error(errorData.error || "Código incorrecto");
+  }
+
+  return res.json();
+}
+
+export async function enrollTotp(): Promise<TotpEnrollResponse> {
+  const res = await authFetch(`${API_BASE}/users/2fa/enroll`, {
+    method: "POST",
+    credentials: "include",
+  });
+
+  if (!res.ok) {
+    const err = await res.json();
+    throw new Error(err.error || "Error activando el 2FA");
+  }
+
+  return res.json();
+}
+
+export async function confirmTotp(code: string): Promise<TotpConfirmResponse> {
+  const res = await authFetch(`${API_BASE}/users/2fa/confirm`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ code }),
+    credentials: "include",
+  });
+
+  if (!res.ok) {
+    const err = await res.json();
+    throw new Error(err.error || "Código incorrecto");
+  }
+
+  const data: TotpConfirmResponse = await res.json();
+  // La sesión anterior queda cerrada, la nueva trae su propio CSRF
+  cookieService.setCsrfToken(data.csrf_token);
+  return data;
+}
+
+// Pide el correo con el enlace para restablecer la contraseña. La API responde
+// igual exista o no el email.
+export async function forgotPassword(email: string): Promise<void> {
+  const res = await fetch(`${API_BASE}/users/auth/forgot-password`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ email }),
+  });
+  if (!res.ok) {
+    const err = await res.json();
+    throw new Error(err.error || "Error pidiendo el enlace");
+  }
+}
+
+export async function resetPassword(token: string, newPassword: string): Promise<void> {
+  const res = await fetch(`${API_BASE}/users/auth/reset-password`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ token, new_password: newPassword }),
+  });
+  if (!res.ok) {
+    const err = await res.json();
+    throw new Error(err.error || "Error restableciendo la contraseña");
+  }
+}
+
+// Elige la contraseña nueva cuando el login devuelve password_change_required
+export async function changeRequiredPassword(token: string, newPassword: string): Promise<void> {
+  const res = await fetch(`${API_BASE}/users/auth/change-password`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ password_change_token: token, new_password: newPassword }),
+  });
+  if (!res.ok) {
+    const err = await res.json();
+    throw new Error(err.error || "Error cambiando la contraseña");
+  }
+}
+
+// Confirma el email con el token del enlace del correo
+export async function verifyEmail(token: string): Promise<{ message: string; email: string }> {
+  const res = await fetch(`${API_BASE}/users/auth/verify-email`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ token }),
+  });
+  if (!res.ok) {
+    const err = await res.json();
+    throw new Error(err.error || "Error verificando el email");
+  }
+  return res.json();
+}
+
+export async function resendVerification(email: string): Promise<void> {
+  const res = await fetch(`${API_BASE}/users/auth/resend-verification`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ email }),
+  });
+  if (!res.ok) {
+    const err = await res.json();
+    throw new Error(err.error || "Error reenviando el enlace");
+  }
+}
+
+// Login con passkey: el autenticador elige la cuenta, no hace falta el email
+export async function loginWithPasskey(): Promise<LoginResponse> {
+  const begin = await fetch(`${API_BASE}/users/auth/passkey/begin`, {
+    method: "POST",
+    credentials: "include",
+  });
+  if (!begin.ok) {
+    const err = await begin.json();
+    throw new Error(err.error || "Error iniciando el login con passkey");
+  }
+  const { session_id, options } = await begin.json();
+
+  const credential = await navigator.credentials.get(toRequestOptions(options));
+  if (!credential) throw new Error("No se seleccionó ninguna passkey");
+
+  const res = await fetch(`${API_BASE}/users/auth/passkey/finish`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({
+      session_id,
+      credential: assertionToJSON(credential as PublicKeyCredential),
+      session_mode: "cookie",
+    }),
+    credentials: "include",
+  });
+  if (!res.ok) {
+    const err = await res.json();
+    throw new Error(err.error || "Passkey no válida");
+  }
+  return res.json();
+}
+
+export async function getAuthMethods(): Promise<AuthMethods> {
+  const res = await fetch(`${API_BASE}/users/auth/methods`);
+  if (!res.ok) throw new Error("Error cargando las formas de login");
+  return res.json();
+}
+
+// Login con SSO: la API devuelve la URL del proveedor y deja una cookie con el state
+export async function beginOidcLogin(): Promise<string> {
+  const res = await fetch(`${API_BASE}/users/auth/oidc/begin`, {
+    method: "POST",
+    credentials: "include",
+  });
+  if (!res.ok) {
+    const err = await res.json();
+    throw new Error(err.error || "Error iniciando el login con SSO");
+  }
+  const { authorization_url } = await res.json();
+  return authorization_url;
+}
+
+// El proveedor vuelve a /oidc/callback con code y state; la API los canjea
+export async function finishOidcLogin(code: string, state: string): Promise<LoginResponse | MfaChallenge> {
+  const res = await fetch(`${API_BASE}/users/auth/oidc/callback`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ code, state, session_mode: "cookie" }),
+    credentials: "include",
+  });
+  if (!res.ok) {
+    const err = await res.json();
+    throw new Error(err.error || "Error en el login con SSO");
+  }
+  return res.json();
+}
+
+export async function registerPasskey(name: string): Promise<void> {
+  const begin = await authFetch(`${API_BASE}/users/passkeys/register/begin`, {
+    method: "POST",
+    credentials: "include",
+  });
+  if (!begin.ok) {
+    const err = await begin.json();
+    throw new Error(err.error || "Error registrando la passkey");
+  }
+  const { session_id, options } = await begin.json();
+
+  const credential = await navigator.credentials.create(toCreationOptions(options));
+  if (!credential) throw new Error("No se creó ninguna passkey");
+
+  const res = await authFetch(`${API_BASE}/users/passkeys/register/finish`, {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({
+      session_id,
+      name,
+      credential: creationToJSON(credential as PublicKeyCredential),
+    }),
+    credentials: "include",
+  });
+  if (!res.ok) {
+    const err = await res.json();
+    throw new Error(err.error || "Error registrando la passkey");
+  }
+}
+
+export async function logoutUser(): Promise<void> {
+  // Aunque falle, la sesión local se borra igualmente
+  try {
+    await authFetch(`${API_BASE}/users/auth/logout`, {
+      method: "POST",
+      credentials: "include",
+    });
+  } finally {
+    cookieService.clearAuth();
+  }
+}
+
 export async function registerUser(data: RegisterRequest): Promise<RegisterResponse> {
   const res = await fetch(`${API_BASE}/users/auth/register`, {
     method: "POST",
@@ -46,11 +335,7 @@ export async function registerUser(data: RegisterRequest): Promise<RegisterRespo
 }
 
 export async function getMe(): Promise<User> {
-  const token = cookieService.getToken();
-  if (!token) throw new Error("No token found");
-
-  const res = await fetch(`${API_BASE}/users/me`, {
-    headers: authHeader(token),
+  const res = await authFetch(`${API_BASE}/users/me`, {
     credentials: "include",
   });
 
@@ -62,13 +347,11 @@ export async function getMe(): Promise<User> {
   return res.json();
 }
 
-export async function updateUser(id: number, data: Partial<User>): Promise<{ message: string }> {
-  const token = cookieService.getToken();
-  if (!token) throw new Error("No token found");
-
-  const res = await fetch(`${API_BASE}/users/${id}`, {
+// Si cambia el email, email_change_pending es el nuevo: se guarda al abrir el enlace del correo
+export async function updateUser(id: number, data: Partial<User>): Promise<{ message: string; email_change_pending?: string }> {
+  const res = await authFetch(`${API_BASE}/users/${id}`, {
     method: "PUT",
-    headers: { "Content-Type": "application/json", ...authHeader(token) },
+    headers: { "Content-Type": "application/json" },
     body: JSON.stringify(data),
     credentials: "include",
   });
@@ -82,11 +365,7 @@ export async function updateUser(id: number, data: Partial<User>): Promise<{ mes
 }
 
 export async function getAllUsers(): Promise<User[]> {
-  const token = cookieService.getToken();
-  if (!token) throw new Error("No token found");
-
-  const res = await fetch(`${API_BASE}/users/`, {
-    headers: authHeader(token),
+  const res = await authFetch(`${API_BASE}/users/`, {
     credentials: "include",
   });
 
@@ -99,12 +378,8 @@ export async function getAllUsers(): Promise<User[]> {
 }
 
 export async function deleteUser(id: number): Promise<void> {
-  const token = cookieService.getToken();
-  if (!token) throw new Error("No token found");
-
-  const res = await fetch(`${API_BASE}/users/${id}`, {
+  const res = await authFetch(`${API_BASE}/users/${id}`, {
     method: "DELETE",
-    headers: authHeader(token),
     credentials: "include",
   });
 
@@ -114,13 +389,48 @@ export async function deleteUser(id: number): Promise<void> {
   }
 }
 
+// SESIONES
+export async function getSessions(): Promise<Session[]> {
+  const res = await authFetch(`${API_BASE}/users/me/sessions`, {
+    credentials: "include",
+  });
+
+  if (!res.ok) {
+    const err = await res.json();
+    throw new Error(err.error || "Error cargando las sesiones");
+  }
+
+  return res.json();
+}
+
+export async function revokeSession(id: string): Promise<void> {
+  const res = await authFetch(`${API_BASE}/users/me/sessions/${id}`, {
+    method: "DELETE",
+    credentials: "include",
+  });
+
+  if (!res.ok) {
+    const err = await res.json();
+    throw new Error(err.error || "Error cerrando la sesión");
+  }
+}
+
+// Cierra todas las sesiones menos la actual
+export async function revokeOtherSessions(): Promise<void> {
+  const res = await authFetch(`${API_BASE}/users/me/sessions`, {
+    method: "DELETE",
+    credentials: "include",
+  });
+
+  if (!res.ok) {
+    const err = await res.json();
+    throw new Error(err.error || "Error cerrando las sesiones");
+  }
+}
+
 // NOTES
 export async function getMyNotes(): Promise<Note[]> {
-  const token = cookieService.getToken();
-  if (!token) throw new Error("No token found");
-
-  const res = await fetch(`${API_BASE}/notes/my`, {
-    headers: authHeader(token),
+  const res = await authFetch(`${API_BASE}/notes/my`, {
     credentials: "include",
   });
 
@@ -133,11 +443,7 @@ export async function getMyNotes(): Promise<Note[]> {
 }
 
 export async function getNoteById(id: number): Promise<Note> {
-  const token = cookieService.getToken();
-  if (!token) throw new Error("No token found");
-
-  const res = await fetch(`${API_BASE}/notes/${id}`, {
-    headers: authHeader(token),
+  const res = await authFetch(`${API_BASE}/notes/${id}`, {
     credentials: "include",
   });
 
@@ -150,12 +456,9 @@ export async function getNoteById(id: number): Promise<Note> {
 }
 
 export async function createNote(noteText: string, username: string, password: string): Promise<Note> {
-  const token = cookieService.getToken();
-  if (!token) throw new Error("No token found");
-
-  const res = await fetch(`${API_BASE}/notes/`, {
+  const res = await authFetch(`${API_BASE}/notes/`, {
     method: "POST",
-    headers: { "Content-Type": "application/json", ...authHeader(token) },
+    headers: { "Content-Type": "application/json" },
     body: JSON.stringify({ note_text: noteText, username, password }),
     credentials: "include",
   });
@@ -169,12 +472,9 @@ export async function createNote(noteText: string, username: string, password: s
 }
 
 export async function updateNote(id: number, noteText: string, username: string, password: string): Promise<Note> {
-  const token = cookieService.getToken();
-  if (!token) throw new Error("No token found");
-
-  const res = await fetch(`${API_BASE}/notes/${id}`, {
+  const res = await authFetch(`${API_BASE}/notes/${id}`, {
     method: "PUT",
-    headers: { "Content-Type": "application/json", ...authHeader(token) },
+    headers: { "Content-Type": "application/json" },
     body: JSON.stringify({ note_text: noteText, username, password }),
     credentials: "include",
   });
@@ -188,12 +488,8 @@ export async function updateNote(id: number, noteText: string, username: string,
 }
 
 export async function deleteNote(id: number): Promise<void> {
-  const token = cookieService.getToken();
-  if (!token) throw new Error("No token found");
-
-  const res = await fetch(`${API_BASE}/notes/${id}`, {
+  const res = await authFetch(`${API_BASE}/notes/${id}`, {
     method: "DELETE",
-    headers: authHeader(token),
     credentials: "include",
   });
 
@@ -204,12 +500,9 @@ export async function deleteNote(id: number): Promise<void> {
 }
 
 export async function verifyNotePassword(noteId: number, password: string): Promise<boolean> {
-  const token = cookieService.getToken();
-  if (!token) throw new Error("No token found");
-
-  const res = await fetch(`${API_BASE}/notes/verify-password`, {
+  const res = await authFetch(`${API_BASE}/notes/verify-password`, {
     method: "POST",
-    headers: { "Content-Type": "application/json", ...authHeader(token) },
+    headers: { "Content-Type": "application/json" },
     body: JSON.stringify({ note_id: noteId, password }),
     credentials: "include",
   });
@@ -223,11 +516,7 @@ export async function verifyNotePassword(noteId: number, password: string): Prom
 }
 
 export async function getMyNotesSortedByPassword(order: "ASC" | "DESC" = "ASC"): Promise<Note[]> {
-  const token = cookieService.getToken();
-  if (!token) throw new Error("No token found");
-
-  const res = await fetch(`${API_BASE}/notes/sorted-password?order=${order}`, {
-    headers: authHeader(token),
+  const res = await authFetch(`${API_BASE}/notes/sorted-password?order=${order}`, {
     credentials: "include",
   });
 
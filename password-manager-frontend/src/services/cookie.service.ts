@@ -20,7 +20,7 @@ export const cookieService = {
     },
 
     // Guardar usuario (objeto JSON)
-    setUser: (user: object, days: number = 1) => {
+    setUser: (user: object, days: number = 30) => {
         cookieService.setCookie("user", JSON.stringify(user), days);
     },
 
@@ -30,19 +30,25 @@ export const cookieService = {
         return userCookie ? JSON.parse(userCookie) : null;
     },
 
-    // Guardar token
-    setToken: (token: string, days: number = 1) => {
-        cookieService.setCookie("token", token, days);
+    // Guardar el token CSRF de la sesión. El JWT nunca pasa por aquí: va en una
+    // cookie HttpOnly de la API que JavaScript no puede leer.
+    setCsrfToken: (token?: string, days: number = 30) => {
+        if (token) cookieService.setCookie("csrf", token, days);
     },
 
-    // Leer token
-    getToken: (): string | null => {
-        return cookieService.getCookie("token");
+    // Leer token CSRF (la cookie pm_csrf de la API si es del mismo host)
+    getCsrfToken: (): string | null => {
+        return cookieService.getCookie("pm_csrf") ?? cookieService.getCookie("csrf");
     },
 
-    // Eliminar token y usuario
+    // Hay sesión si tenemos usuario y token CSRF
+    hasSession: (): boolean => {
+        return !!cookieService.getUser() && !!cookieService.getCsrfToken();
+    },
+
+    // Eliminar sesión y usuario
     clearAuth: () => {
-        cookieService.deleteCookie("token");
+        cookieService.deleteCookie("csrf");
         cookieService.deleteCookie("user");
     }
 };
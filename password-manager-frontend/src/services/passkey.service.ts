@@ -0,0 +1,85 @@
+// src/services/passkey.service.ts
+// La API habla WebAuthn en JSON con los binarios en base64url; el navegador los
+// quiere como ArrayBuffer. Aquí se traducen en los dos sentidos.
+
+function fromBase64url(value: string): ArrayBuffer {
+  const base64 = value.replace(/-/g, "+").replace(/_/g, "/");
+  const padded = base64 + "=".repeat((4 - (base64.length % 4)) % 4);
+  const binary = atob(padded);
+  const bytes = new Uint8Array(binary.length);
+  for (let i = 0; i < binary.length; i++) bytes[i] = binary.charCodeAt(i);
+  return bytes.buffer;
+}
+
+function toBase64url(buffer: ArrayBuffer | null): string | undefined {
+  if (!buffer) return undefined;
+  let binary = "";
+  new Uint8Array(buffer).forEach((b) => (binary += String.fromCharCode(b)));
+  return btoa(binary).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+interface CredentialDescriptorJSON {
+  type: PublicKeyCredentialType;
+  id: string;
+  transports?: AuthenticatorTransport[];
+}
+
+function toDescriptors(list?: CredentialDescriptorJSON[]): PublicKeyCredentialDescriptor[] | undefined {
+  return list?.map((c) => ({ ...c, id: fromBase64url(c.id) }));
+}
+
+// Opciones de registro que devuelve /users/passkeys/register/begin
+// eslint-disable-next-line @typescript-eslint/no-explicit-any
+export function toCreationOptions(options: any): CredentialCreationOptions {
+  const publicKey = options.publicKey;
+  return {
+    publicKey: {
+      ...publicKey,
+      challenge: fromBase64url(publicKey.challenge),
+      user: { ...publicKey.user, id: fromBase64url(publicKey.user.id) },
+      excludeCredentials: toDescriptors(publicKey.excludeCredentials),
+    },
+  };
+}
+
+// Opciones de login que devuelve /users/auth/passkey/begin
+// eslint-disable-next-line @typescript-eslint/no-explicit-any
+export function toRequestOptions(options: any): CredentialRequestOptions {
+  const publicKey = options.publicKey;
+  return {
+    publicKey: {
+      ...publicKey,
+      challenge: fromBase64url(publicKey.challenge),
+      allowCredentials: toDescriptors(publicKey.allowCredentials),
+    },
+  };
+}
+
+export function creationToJSON(credential: PublicKeyCredential) {
+  const response = credential.response as AuthenticatorAttestationResponse;
+  return {
+    id: credential.id,
+    rawId: toBase64url(credential.rawId),
+    type: credential.type,
+    response: {
+      clientDataJSON: toBase64url(response.clientDataJSON),
+      attestationObject: toBase64url(response.attestationObject),
+      transports: response.getTransports?.() ?? [],
+    },
+  };
+}
+
+export function assertionToJSON(credential: PublicKeyCredential) {
+  const response = credential.response as AuthenticatorAssertionResponse;
+  return {
+    id: credential.id,
+    rawId: toBase64url(credential.rawId),
+    type: credential.type,
+    response: {
+      clientDataJSON: toBase64url(response.clientDataJSON),
+      authenticatorData: toBase64url(response.authenticatorData),
+      signature: toBase64url(response.signature),
+      userHandle: toBase64url(response.userHandle),
+    },
+  };
+}